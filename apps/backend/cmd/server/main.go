@@ -4,15 +4,28 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/namlabs/obsfly/backend/internal/annotations"
 	"github.com/namlabs/obsfly/backend/internal/api"
+	"github.com/namlabs/obsfly/backend/internal/archive"
 	"github.com/namlabs/obsfly/backend/internal/generator"
+	"github.com/namlabs/obsfly/backend/internal/ingest"
+	"github.com/namlabs/obsfly/backend/internal/logmetrics"
+	"github.com/namlabs/obsfly/backend/internal/logpatterns"
+	"github.com/namlabs/obsfly/backend/internal/pipelines"
+	"github.com/namlabs/obsfly/backend/internal/provisioning"
+	"github.com/namlabs/obsfly/backend/internal/recording"
+	"github.com/namlabs/obsfly/backend/internal/redaction"
+	"github.com/namlabs/obsfly/backend/internal/reports"
+	"github.com/namlabs/obsfly/backend/internal/snapshots"
 	"github.com/namlabs/obsfly/backend/internal/store"
 )
 
@@ -58,6 +71,54 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	// Setup Ingestion with log pipelines, redaction and pattern mining
+	enableLogPipelines := os.Getenv("ENABLE_LOG_PIPELINES")
+	if enableLogPipelines == "" {
+		enableLogPipelines = "true"
+	}
+
+	var processor *pipelines.Processor
+	if enableLogPipelines == "true" {
+		processor = pipelines.NewProcessor(s, 30*time.Second)
+		if err := processor.Load(ctx); err != nil {
+			log.Printf("Warning: Could not load log pipelines: %v", err)
+		}
+	} else {
+		log.Println("Log pipelines disabled (ENABLE_LOG_PIPELINES=false)")
+	}
+
+	enableRedaction := os.Getenv("ENABLE_REDACTION")
+	if enableRedaction == "" {
+		enableRedaction = "true"
+	}
+
+	var redactor *redaction.Redactor
+	if enableRedaction == "true" {
+		redactor = redaction.RedactorFromEnv(s)
+		if err := redactor.Load(ctx); err != nil {
+			log.Printf("Warning: Could not load redaction configs: %v", err)
+		}
+		go redactor.Start(ctx)
+	} else {
+		log.Println("Redaction disabled (ENABLE_REDACTION=false)")
+	}
+
+	enableLogPatterns := os.Getenv("ENABLE_LOG_PATTERNS")
+	if enableLogPatterns == "" {
+		enableLogPatterns = "true"
+	}
+
+	var miner *logpatterns.Miner
+	if enableLogPatterns == "true" {
+		miner = logpatterns.NewMiner(s)
+		if err := miner.Load(ctx); err != nil {
+			log.Printf("Warning: Could not load log patterns: %v", err)
+		}
+	} else {
+		log.Println("Log pattern mining disabled (ENABLE_LOG_PATTERNS=false)")
+	}
+	ingester := ingest.NewIngester(s, processor, redactor, miner)
+
 	// Start Embedded Data Generator (if enabled and in dev mode)
 	env := os.Getenv("ENV")
 	if env == "" {
@@ -98,22 +159,130 @@ func main() {
 			cfg.Traces.SlowThresholdMs = 500
 		}
 
-		dataGen := generator.NewDataGenerator(cfg, s)
+		dataGen := generator.NewDataGenerator(cfg, s, ingester)
 		go dataGen.Start(ctx)
 	} else {
 		log.Printf("Data generator disabled (ENV=%s, ENABLE_DATA_GENERATOR=%s)", env, enableGenerator)
 	}
 
+	// Start Recording Rule Evaluator; with several backend instances, enable
+	// it on one only
+	enableRecordingRules := os.Getenv("ENABLE_RECORDING_RULES")
+	if enableRecordingRules == "" {
+		enableRecordingRules = "true"
+	}
+
+	if enableRecordingRules == "true" {
+		evaluator := recording.NewEvaluator(s, 15*time.Second)
+		go evaluator.Start(ctx)
+	} else {
+		log.Println("Recording rule evaluator disabled (ENABLE_RECORDING_RULES=false)")
+	}
+
+	// Start Log Metric Evaluator
+	enableLogMetrics := os.Getenv("ENABLE_LOG_METRICS")
+	if enableLogMetrics == "" {
+		enableLogMetrics = "true"
+	}
+
+	if enableLogMetrics == "true" {
+		logMetricEvaluator := logmetrics.NewEvaluator(s, 15*time.Second)
+		go logMetricEvaluator.Start(ctx)
+	} else {
+		log.Println("Log metric evaluator disabled (ENABLE_LOG_METRICS=false)")
+	}
+
+	// Start Log Archiver, when an archive bucket is configured
+	enableLogArchive := os.Getenv("ENABLE_LOG_ARCHIVE")
+	if enableLogArchive == "" {
+		enableLogArchive = "true"
+	}
+
+	// The API archives and rehydrates on demand with the same archiver
+	logArchiver := archive.NewArchiver(s, archive.ConfigFromEnv(), time.Hour)
+	if enableLogArchive == "true" && logArchiver.Enabled() {
+		go logArchiver.Start(ctx)
+	} else if enableLogArchive == "true" {
+		log.Println("Log archiver disabled (ARCHIVE_S3_URL not set)")
+	} else {
+		log.Println("Log archiver disabled (ENABLE_LOG_ARCHIVE=false)")
+	}
+
+	// Start Deploy Annotation Detector
+	enableDeployAnnotations := os.Getenv("ENABLE_DEPLOY_ANNOTATIONS")
+	if enableDeployAnnotations == "" {
+		enableDeployAnnotations = "true"
+	}
+
+	if enableDeployAnnotations == "true" {
+		detector := annotations.NewDetector(s, time.Minute)
+		go detector.Start(ctx)
+	} else {
+		log.Println("Deploy annotation detector disabled (ENABLE_DEPLOY_ANNOTATIONS=false)")
+	}
+
+	// Start Log Anomaly Detector
+	enableLogAnomalies := os.Getenv("ENABLE_LOG_ANOMALIES")
+	if enableLogAnomalies == "" {
+		enableLogAnomalies = "true"
+	}
+
+	if enableLogAnomalies == "true" {
+		anomalyDetector := logpatterns.NewDetector(s, logpatterns.AlerterFromEnv(s), time.Minute)
+		go anomalyDetector.Start(ctx)
+	} else {
+		log.Println("Log anomaly detector disabled (ENABLE_LOG_ANOMALIES=false)")
+	}
+
+	// Start Report Scheduler
+	enableReports := os.Getenv("ENABLE_REPORT_SCHEDULER")
+	if enableReports == "" {
+		enableReports = "true"
+	}
+
+	// The API runs reports on demand with the same runner
+	reportRunner := reports.NewRunner(s, reports.NewDeliverer(reports.SMTPConfigFromEnv()))
+	if enableReports == "true" {
+		scheduler := reports.NewScheduler(s, reportRunner, 30*time.Second)
+		go scheduler.Start(ctx)
+	} else {
+		log.Println("Report scheduler disabled (ENABLE_REPORT_SCHEDULER=false)")
+	}
+
+	// Start Snapshot Cleaner (expired snapshots are never served, this
+	// only reclaims their storage)
+	snapshotCleaner := snapshots.NewCleaner(s, time.Hour)
+	go snapshotCleaner.Start(ctx)
+
+	// Start Dashboard Directory Sync (dashboards-as-code)
+	if syncDir := os.Getenv("DASHBOARDS_SYNC_DIR"); syncDir != "" {
+		var syncAccountId uint64 = 1
+		if aid := os.Getenv("DASHBOARDS_SYNC_ACCOUNT_ID"); aid != "" {
+			parsed, err := strconv.ParseUint(aid, 10, 64)
+			if err != nil {
+				log.Fatalf("Invalid DASHBOARDS_SYNC_ACCOUNT_ID: %v", err)
+			}
+			syncAccountId = parsed
+		}
+
+		syncer := provisioning.NewSyncer(s, syncDir, syncAccountId, 10*time.Second)
+		go syncer.Start(ctx)
+	}
+
 	// Setup API
 	r := chi.NewRouter()
-	h := api.NewHandler(s)
+	h := api.NewHandler(s, reportRunner, logArchiver)
 	h.RegisterRoutes(r)
 
 	// Start Server
 	srv := &http.Server{
 		Addr:    ":8080",
 		Handler: r,
+		// Requests share the server's context, so long-lived streams such
+		// as live log tails end when the server shuts down
+		BaseContext: func(net.Listener) context.Context { return ctx },
 	}
+	srv.RegisterOnShutdown(cancel)
 
 	go func() {
 		log.Println("Starting server on :8080")
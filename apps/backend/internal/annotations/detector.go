@@ -0,0 +1,77 @@
+package annotations
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/namlabs/obsfly/backend/internal/store"
+)
+
+// versionLookback is how far back version changes are detected. A deploy
+// is recognized as long as the old and new versions both reported within it.
+const versionLookback = time.Hour
+
+// Detector periodically turns ServiceVersion changes into deploy annotations.
+type Detector struct {
+	store   *store.Store
+	tick    time.Duration
+	emitted map[string]time.Time // annotation ID -> rollout end already saved
+}
+
+func NewDetector(st *store.Store, tick time.Duration) *Detector {
+	return &Detector{
+		store:   st,
+		tick:    tick,
+		emitted: make(map[string]time.Time),
+	}
+}
+
+func (d *Detector) Start(ctx context.Context) {
+	ticker := time.NewTicker(d.tick)
+	defer ticker.Stop()
+
+	fmt.Printf("Starting deploy annotation detector (tick %s)\n", d.tick)
+
+	for {
+		select {
+		case <-ctx.Done():
+			fmt.Println("Deploy annotation detector shutting down")
+			return
+		case <-ticker.C:
+			if err := d.detect(ctx); err != nil {
+				fmt.Printf("Error detecting version changes: %v\n", err)
+			}
+		}
+	}
+}
+
+// detect saves new deploys, and deploys whose rollout reached more hosts
+// since they were last saved. Annotation IDs are deterministic, so saving
+// again after a restart replaces rather than duplicates.
+func (d *Detector) detect(ctx context.Context) error {
+	deploys, err := d.store.DetectVersionChanges(ctx, versionLookback)
+	if err != nil {
+		return err
+	}
+
+	for i := range deploys {
+		deploy := &deploys[i]
+		if end, ok := d.emitted[deploy.AnnotationId]; ok && !deploy.EndTime.After(end) {
+			continue
+		}
+		if err := d.store.SaveAnnotation(ctx, deploy); err != nil {
+			return err
+		}
+		d.emitted[deploy.AnnotationId] = deploy.EndTime
+	}
+
+	// Forget deploys that left the lookback window
+	cutoff := time.Now().Add(-2 * versionLookback)
+	for id, end := range d.emitted {
+		if end.Before(cutoff) {
+			delete(d.emitted, id)
+		}
+	}
+	return nil
+}
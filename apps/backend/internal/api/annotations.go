@@ -0,0 +1,168 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/namlabs/obsfly/backend/internal/store"
+)
+
+// ========== ANNOTATION HANDLERS ==========
+
+// ListAnnotations returns annotations overlapping a time range, given as
+// from/to (RFC 3339) or as the last N minutes. service, host, type and tags
+// (comma-separated) narrow the result.
+func (h *Handler) ListAnnotations(w http.ResponseWriter, r *http.Request) {
+	accountId, minutesAgo := getQueryParams(r)
+	q := r.URL.Query()
+
+	filter := store.AnnotationFilter{
+		AccountId: accountId,
+		To:        time.Now(),
+		Services:  splitParam(q.Get("service")),
+		Hosts:     splitParam(q.Get("host")),
+		Types:     splitParam(q.Get("type")),
+		Tags:      splitParam(q.Get("tags")),
+	}
+	filter.From = filter.To.Add(-time.Duration(minutesAgo) * time.Minute)
+	if from := q.Get("from"); from != "" {
+		parsed, err := time.Parse(time.RFC3339, from)
+		if err != nil {
+			http.Error(w, "from must be an RFC 3339 time", http.StatusBadRequest)
+			return
+		}
+		filter.From = parsed
+	}
+	if to := q.Get("to"); to != "" {
+		parsed, err := time.Parse(time.RFC3339, to)
+		if err != nil {
+			http.Error(w, "to must be an RFC 3339 time", http.StatusBadRequest)
+			return
+		}
+		filter.To = parsed
+	}
+	if limit, err := strconv.Atoi(q.Get("limit")); err == nil {
+		filter.Limit = limit
+	}
+
+	data, err := h.store.ListAnnotations(r.Context(), filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(data)
+}
+
+func (h *Handler) CreateAnnotation(w http.ResponseWriter, r *http.Request) {
+	var annotation store.Annotation
+	if err := json.NewDecoder(r.Body).Decode(&annotation); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Set default account if not provided
+	if annotation.AccountId == 0 {
+		annotation.AccountId = 1
+	}
+	annotation.AnnotationId = generateUUID()
+	annotation.Source = store.AnnotationSourceAPI
+
+	if err := annotation.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.store.SaveAnnotation(r.Context(), &annotation); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(annotation)
+}
+
+func (h *Handler) DeleteAnnotation(w http.ResponseWriter, r *http.Request) {
+	annotationId := chi.URLParam(r, "annotationId")
+	accountId, _ := getQueryParams(r)
+
+	if err := h.store.DeleteAnnotation(r.Context(), accountId, annotationId); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// alertmanagerPayload is the body of an Alertmanager webhook notification
+type alertmanagerPayload struct {
+	Alerts []struct {
+		Status      string            `json:"status"` // firing, resolved
+		Labels      map[string]string `json:"labels"`
+		Annotations map[string]string `json:"annotations"`
+		StartsAt    time.Time         `json:"startsAt"`
+		EndsAt      time.Time         `json:"endsAt"`
+		Fingerprint string            `json:"fingerprint"`
+	} `json:"alerts"`
+}
+
+// AlertmanagerWebhook records alert transitions sent by an Alertmanager
+// webhook receiver as annotations
+func (h *Handler) AlertmanagerWebhook(w http.ResponseWriter, r *http.Request) {
+	accountId, _ := getQueryParams(r)
+
+	var payload alertmanagerPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	for _, alert := range payload.Alerts {
+		summary := alert.Annotations["summary"]
+		if summary == "" {
+			summary = alert.Annotations["description"]
+		}
+		transition := store.AlertTransition{
+			AccountId:   accountId,
+			AlertName:   alert.Labels["alertname"],
+			Fingerprint: alert.Fingerprint,
+			Status:      alert.Status,
+			Severity:    alert.Labels["severity"],
+			ServiceName: firstLabel(alert.Labels, "service_name", "service", "job"),
+			HostName:    firstLabel(alert.Labels, "host_name", "host", "instance"),
+			Summary:     summary,
+			StartsAt:    alert.StartsAt,
+			EndsAt:      alert.EndsAt,
+			Labels:      alert.Labels,
+		}
+		if err := h.store.RecordAlertTransition(r.Context(), transition); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func firstLabel(labels map[string]string, keys ...string) string {
+	for _, key := range keys {
+		if v := labels[key]; v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// splitParam splits a comma-separated query parameter
+func splitParam(value string) []string {
+	var values []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
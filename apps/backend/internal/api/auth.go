@@ -0,0 +1,119 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/namlabs/obsfly/backend/internal/license"
+)
+
+// userIdKey holds the authenticated user ID in a request context
+type userIdKey struct{}
+
+// authenticate identifies the user a request is made for and stores it in
+// the request context. A bearer token (a session token or API key) is
+// checked against the license server. Without one, the X-User-Id header
+// is taken from trusted proxies (TRUSTED_PROXIES), which authenticate
+// users themselves, and ignored from anyone else. Other requests are
+// anonymous.
+func (h *Handler) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var userId uint64
+		if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			if h.users == nil {
+				http.Error(w, "token authentication requires a license server", http.StatusUnauthorized)
+				return
+			}
+			var err error
+			userId, err = h.users.Authenticate(r.Context(), token)
+			if errors.Is(err, license.ErrInvalidCredentials) {
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+		} else if value := r.Header.Get("X-User-Id"); value != "" && h.fromTrustedProxy(r) {
+			parsed, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				http.Error(w, "X-User-Id must be a user ID", http.StatusBadRequest)
+				return
+			}
+			userId = parsed
+		}
+
+		if userId != 0 {
+			r = r.WithContext(context.WithValue(r.Context(), userIdKey{}, userId))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// fromTrustedProxy reports whether a request comes directly from a
+// trusted proxy. Forwarding headers are not consulted, since any client
+// can set them.
+func (h *Handler) fromTrustedProxy(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, network := range h.trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// trustedProxiesFromEnv parses TRUSTED_PROXIES, a comma-separated list of
+// addresses and CIDR ranges
+func trustedProxiesFromEnv() []*net.IPNet {
+	var networks []*net.IPNet
+	for _, entry := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			log.Printf("Ignoring invalid TRUSTED_PROXIES entry %q: %v", entry, err)
+			continue
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+// getUserId returns the authenticated user of a request, or 0 for
+// anonymous requests
+func getUserId(r *http.Request) uint64 {
+	userId, _ := r.Context().Value(userIdKey{}).(uint64)
+	return userId
+}
+
+// requestAuthor names the authenticated user as the author of a change, by
+// user ID, or "" for anonymous requests. Clients cannot name the author.
+func requestAuthor(r *http.Request) string {
+	if userId := getUserId(r); userId != 0 {
+		return strconv.FormatUint(userId, 10)
+	}
+	return ""
+}
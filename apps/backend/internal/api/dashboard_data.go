@@ -0,0 +1,60 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/namlabs/obsfly/backend/internal/store"
+)
+
+// maxRenderConcurrency caps the concurrency a client may ask for
+const maxRenderConcurrency = 16
+
+// ========== DASHBOARD DATA HANDLERS ==========
+
+// GetDashboardData renders every widget of a dashboard in one request.
+// time_range (or minutes) overrides the widgets' own time ranges, and
+// variables are selected with var-<name> query parameters.
+func (h *Handler) GetDashboardData(w http.ResponseWriter, r *http.Request) {
+	dashboardId := chi.URLParam(r, "dashboardId")
+	accountId, _ := getQueryParams(r)
+
+	dashboard, _, ok := h.authorizeDashboard(w, r, accountId, dashboardId, store.DashboardViewer)
+	if !ok {
+		return
+	}
+
+	q := r.URL.Query()
+	req := store.DashboardDataRequest{
+		AccountId: accountId,
+		TimeRange: q.Get("time_range"),
+		Interval:  q.Get("interval"),
+		Variables: getVariableSelection(r),
+	}
+	if req.TimeRange == "" && q.Get("minutes") != "" {
+		if mins, err := strconv.Atoi(q.Get("minutes")); err == nil && mins > 0 {
+			req.TimeRange = strconv.Itoa(mins) + "m"
+		}
+	}
+	if c := q.Get("concurrency"); c != "" {
+		if parsed, err := strconv.Atoi(c); err == nil && parsed > 0 {
+			req.Concurrency = min(parsed, maxRenderConcurrency)
+		}
+	}
+
+	data, err := h.store.RenderDashboard(r.Context(), dashboard, req)
+	var verr *store.ValidationError
+	if errors.As(err, &verr) {
+		writeValidationError(w, verr)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(data)
+}
@@ -0,0 +1,155 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/namlabs/obsfly/backend/internal/provisioning"
+	"github.com/namlabs/obsfly/backend/internal/store"
+)
+
+// ========== DASHBOARDS-AS-CODE HANDLERS ==========
+
+// ExportDashboard writes one dashboard as YAML (default) or JSON
+func (h *Handler) ExportDashboard(w http.ResponseWriter, r *http.Request) {
+	dashboardId := chi.URLParam(r, "dashboardId")
+	accountId, _ := getQueryParams(r)
+
+	format, ok := exportFormat(w, r)
+	if !ok {
+		return
+	}
+
+	dashboard, _, ok := h.authorizeDashboard(w, r, accountId, dashboardId, store.DashboardViewer)
+	if !ok {
+		return
+	}
+
+	data, err := provisioning.EncodeDashboard(provisioning.FromDashboard(dashboard), format)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeExport(w, dashboard.Slug, format, data)
+}
+
+// ExportDashboards writes every dashboard of the account the user can view,
+// ordered by slug.
+// YAML output is a multi-document stream, JSON output an array.
+func (h *Handler) ExportDashboards(w http.ResponseWriter, r *http.Request) {
+	accountId, _ := getQueryParams(r)
+
+	format, ok := exportFormat(w, r)
+	if !ok {
+		return
+	}
+
+	dashboards, err := h.store.ListDashboards(r.Context(), accountId)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	dashboards, ok = h.filterDashboards(w, r, accountId, dashboards)
+	if !ok {
+		return
+	}
+
+	files := make([]provisioning.DashboardFile, 0, len(dashboards))
+	for i := range dashboards {
+		files = append(files, provisioning.FromDashboard(&dashboards[i]))
+	}
+
+	data, err := provisioning.Encode(files, format)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeExport(w, "dashboards", format, data)
+}
+
+// ImportDashboards creates or updates dashboards by slug from a YAML or
+// JSON body. Unchanged dashboards do not get a new version; dry_run=true
+// only reports what would happen. Updating a dashboard requires editor
+// permission on it.
+func (h *Handler) ImportDashboards(w http.ResponseWriter, r *http.Request) {
+	accountId, _ := getQueryParams(r)
+
+	principal, ok := h.dashboardPrincipal(w, r)
+	if !ok {
+		return
+	}
+	if !h.checkCanCreateDashboards(w, principal, accountId, "import") {
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = provisioning.FormatYAML
+		if strings.Contains(r.Header.Get("Content-Type"), "json") {
+			format = provisioning.FormatJSON
+		}
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxImportBytes))
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	files, err := provisioning.Decode(body, format)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	opts := provisioning.ImportOptions{
+		Author:    requestAuthor(r),
+		DryRun:    r.URL.Query().Get("dry_run") == "true",
+		Principal: &principal,
+	}
+	results := make([]provisioning.ImportResult, 0, len(files))
+	for _, file := range files {
+		result, err := provisioning.Import(r.Context(), h.store, accountId, file, opts)
+		if err != nil {
+			result.Action = provisioning.ActionFailed
+			result.Error = err.Error()
+
+			var verr *store.ValidationError
+			if !errors.As(err, &verr) && !errors.Is(err, provisioning.ErrReadOnly) && !errors.Is(err, provisioning.ErrForbidden) {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		results = append(results, result)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
+
+// exportFormat reads the format query parameter, defaulting to YAML
+func exportFormat(w http.ResponseWriter, r *http.Request) (string, bool) {
+	format := r.URL.Query().Get("format")
+	switch format {
+	case "", "yml", provisioning.FormatYAML:
+		return provisioning.FormatYAML, true
+	case provisioning.FormatJSON:
+		return provisioning.FormatJSON, true
+	}
+	http.Error(w, "format must be yaml or json", http.StatusBadRequest)
+	return "", false
+}
+
+func writeExport(w http.ResponseWriter, name, format string, data []byte) {
+	if format == provisioning.FormatJSON {
+		w.Header().Set("Content-Type", "application/json")
+	} else {
+		w.Header().Set("Content-Type", "application/yaml")
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, name, format))
+	w.Write(data)
+}
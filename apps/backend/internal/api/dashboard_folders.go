@@ -0,0 +1,239 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/namlabs/obsfly/backend/internal/store"
+)
+
+// ========== DASHBOARD FOLDER HANDLERS ==========
+
+// ListFolders returns the folder tree with dashboard counts
+func (h *Handler) ListFolders(w http.ResponseWriter, r *http.Request) {
+	accountId, _ := getQueryParams(r)
+
+	data, err := h.store.GetFolderTree(r.Context(), accountId)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(data)
+}
+
+func (h *Handler) GetFolder(w http.ResponseWriter, r *http.Request) {
+	folderId := chi.URLParam(r, "folderId")
+	accountId, _ := getQueryParams(r)
+
+	folder, err := h.store.GetFolder(r.Context(), accountId, folderId)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if folder == nil {
+		http.Error(w, "folder not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(folder)
+}
+
+func (h *Handler) CreateFolder(w http.ResponseWriter, r *http.Request) {
+	var folder store.DashboardFolder
+	if err := json.NewDecoder(r.Body).Decode(&folder); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Set default account if not provided
+	if folder.AccountId == 0 {
+		folder.AccountId = 1
+	}
+	folder.FolderId = generateUUID()
+	folder.CreatedBy = getUserId(r)
+
+	h.saveFolder(w, r, &folder, http.StatusCreated)
+}
+
+// UpdateFolder renames a folder or moves it under another parent
+func (h *Handler) UpdateFolder(w http.ResponseWriter, r *http.Request) {
+	folderId := chi.URLParam(r, "folderId")
+
+	var folder store.DashboardFolder
+	if err := json.NewDecoder(r.Body).Decode(&folder); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	folder.FolderId = folderId
+
+	// Set default account if not provided
+	if folder.AccountId == 0 {
+		folder.AccountId = 1
+	}
+
+	existing, err := h.store.GetFolder(r.Context(), folder.AccountId, folderId)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if existing == nil {
+		http.Error(w, "folder not found", http.StatusNotFound)
+		return
+	}
+
+	h.saveFolder(w, r, &folder, http.StatusOK)
+}
+
+func (h *Handler) saveFolder(w http.ResponseWriter, r *http.Request, folder *store.DashboardFolder, status int) {
+	err := h.store.SaveFolder(r.Context(), folder)
+	var verr *store.ValidationError
+	if errors.As(err, &verr) {
+		writeValidationError(w, verr)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(folder)
+}
+
+// DeleteFolder deletes an empty folder; folders that still hold dashboards
+// or subfolders are rejected with 409
+func (h *Handler) DeleteFolder(w http.ResponseWriter, r *http.Request) {
+	folderId := chi.URLParam(r, "folderId")
+	accountId, _ := getQueryParams(r)
+
+	err := h.store.DeleteFolder(r.Context(), accountId, folderId)
+	if errors.Is(err, store.ErrFolderNotEmpty) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ========== DASHBOARD STAR AND RECENTLY VIEWED HANDLERS ==========
+
+func (h *Handler) StarDashboard(w http.ResponseWriter, r *http.Request) {
+	h.setDashboardStarred(w, r, true)
+}
+
+func (h *Handler) UnstarDashboard(w http.ResponseWriter, r *http.Request) {
+	h.setDashboardStarred(w, r, false)
+}
+
+func (h *Handler) setDashboardStarred(w http.ResponseWriter, r *http.Request, starred bool) {
+	dashboardId := chi.URLParam(r, "dashboardId")
+	accountId, _ := getQueryParams(r)
+
+	userId := getUserId(r)
+	if userId == 0 {
+		http.Error(w, "starring requires a user", http.StatusBadRequest)
+		return
+	}
+	if _, _, ok := h.authorizeDashboard(w, r, accountId, dashboardId, store.DashboardViewer); !ok {
+		return
+	}
+
+	if err := h.store.SetDashboardStarred(r.Context(), accountId, userId, dashboardId, starred); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetRecentDashboards returns the dashboards the user viewed most recently
+func (h *Handler) GetRecentDashboards(w http.ResponseWriter, r *http.Request) {
+	accountId, _ := getQueryParams(r)
+
+	userId := getUserId(r)
+	if userId == 0 {
+		http.Error(w, "recently viewed dashboards require a user", http.StatusBadRequest)
+		return
+	}
+
+	limit := 10
+	if l := r.URL.Query().Get("limit"); l != "" {
+		if parsed, err := strconv.Atoi(l); err == nil && parsed > 0 && parsed <= 50 {
+			limit = parsed
+		}
+	}
+
+	data, err := h.store.GetRecentlyViewedDashboards(r.Context(), accountId, userId, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// Dashboards the user lost access to since viewing them are left out
+	data, ok := h.filterDashboards(w, r, accountId, data)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(data)
+}
+
+// ListDashboardTags returns the tags in use with their dashboard counts
+func (h *Handler) ListDashboardTags(w http.ResponseWriter, r *http.Request) {
+	accountId, _ := getQueryParams(r)
+
+	data, err := h.store.ListDashboardTags(r.Context(), accountId)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(data)
+}
+
+// recordDashboardView logs a dashboard view in the background, so a slow
+// or failing access log never delays the dashboard itself
+func (h *Handler) recordDashboardView(r *http.Request, accountId uint64, dashboardId string) {
+	access := store.DashboardAccess{
+		DashboardId: dashboardId,
+		UserId:      getUserId(r),
+		AccountId:   accountId,
+		AccessedAt:  time.Now(),
+		IPAddress:   clientIP(r),
+		UserAgent:   r.UserAgent(),
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := h.store.RecordDashboardAccess(ctx, access); err != nil {
+			fmt.Printf("Error recording dashboard view: %v\n", err)
+		}
+	}()
+}
+
+// clientIP returns the first X-Forwarded-For address, or the peer address
+func clientIP(r *http.Request) net.IP {
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		first, _, _ := strings.Cut(forwarded, ",")
+		if ip := net.ParseIP(strings.TrimSpace(first)); ip != nil {
+			return ip
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
@@ -0,0 +1,76 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/namlabs/obsfly/backend/internal/grafana"
+	"github.com/namlabs/obsfly/backend/internal/store"
+)
+
+// maxImportBytes bounds the size of an uploaded dashboard
+const maxImportBytes = 10 << 20
+
+// ========== DASHBOARD IMPORT HANDLERS ==========
+
+// ImportGrafanaDashboard converts a Grafana dashboard JSON export and saves
+// it as a new dashboard. With dry_run=true the conversion is returned
+// without saving. Unknown metrics are reported but do not block the import.
+func (h *Handler) ImportGrafanaDashboard(w http.ResponseWriter, r *http.Request) {
+	accountId, _ := getQueryParams(r)
+
+	principal, ok := h.dashboardPrincipal(w, r)
+	if !ok {
+		return
+	}
+	if !h.checkCanCreateDashboards(w, principal, accountId, "import") {
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxImportBytes))
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	result, err := grafana.Convert(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	dashboard := result.Dashboard
+	dashboard.AccountId = accountId
+	dashboard.DashboardId = generateUUID()
+	dashboard.OwnerId = principal.UserId
+	dashboard.Author = requestAuthor(r)
+	dashboard.ChangeMessage = "Imported from Grafana"
+
+	if err := store.ValidateDashboardSchema(dashboard); err != nil {
+		var verr *store.ValidationError
+		if errors.As(err, &verr) {
+			writeValidationError(w, verr)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	result.UnknownMetrics, err = h.store.UnknownDashboardMetrics(r.Context(), dashboard)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if r.URL.Query().Get("dry_run") != "true" {
+		if err := h.store.SaveDashboard(r.Context(), dashboard); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,326 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/namlabs/obsfly/backend/internal/license"
+	"github.com/namlabs/obsfly/backend/internal/store"
+)
+
+// dashboardPermissionsResponse is the ACL of a dashboard
+type dashboardPermissionsResponse struct {
+	DashboardId string                      `json:"dashboard_id"`
+	OwnerId     uint64                      `json:"owner_id"`
+	Restricted  bool                        `json:"restricted"` // false while the dashboard is open to its account
+	Permissions []store.DashboardPermission `json:"permissions"`
+}
+
+// ========== DASHBOARD PERMISSION HANDLERS ==========
+
+// GetDashboardPermissions returns the ACL of a dashboard to its admins
+func (h *Handler) GetDashboardPermissions(w http.ResponseWriter, r *http.Request) {
+	dashboardId := chi.URLParam(r, "dashboardId")
+	accountId, _ := getQueryParams(r)
+
+	dashboard, _, ok := h.authorizeDashboard(w, r, accountId, dashboardId, store.DashboardAdmin)
+	if !ok {
+		return
+	}
+
+	entries, err := h.store.GetDashboardPermissions(r.Context(), accountId, dashboardId)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dashboardPermissionsResponse{
+		DashboardId: dashboardId,
+		OwnerId:     dashboard.OwnerId,
+		Restricted:  len(entries) > 0,
+		Permissions: entries,
+	})
+}
+
+// UpdateDashboardPermissions replaces the ACL of a dashboard. The body is
+// {"permissions": [{"principal_type", "principal_id", "permission"}]};
+// an empty list opens the dashboard to its whole account again.
+func (h *Handler) UpdateDashboardPermissions(w http.ResponseWriter, r *http.Request) {
+	dashboardId := chi.URLParam(r, "dashboardId")
+	accountId, _ := getQueryParams(r)
+
+	var req struct {
+		Permissions []store.DashboardPermission `json:"permissions"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	dashboard, principal, ok := h.authorizeDashboard(w, r, accountId, dashboardId, store.DashboardAdmin)
+	if !ok {
+		return
+	}
+	if principal.UserId == 0 {
+		http.Error(w, "changing dashboard permissions requires a user", http.StatusUnauthorized)
+		return
+	}
+
+	err := h.store.SetDashboardPermissions(r.Context(), accountId, dashboardId, req.Permissions, principal.UserId)
+	if err != nil {
+		var verr *store.ValidationError
+		if errors.As(err, &verr) {
+			writeValidationError(w, verr)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	entries, err := h.store.GetDashboardPermissions(r.Context(), accountId, dashboardId)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dashboardPermissionsResponse{
+		DashboardId: dashboardId,
+		OwnerId:     dashboard.OwnerId,
+		Restricted:  len(entries) > 0,
+		Permissions: entries,
+	})
+}
+
+// dashboardPrincipal identifies the requesting user and, when a license
+// server is configured, their role, teams and account-wide grants. It writes
+// a 401 for users the license server does not know and a 503 if it cannot
+// be reached.
+func (h *Handler) dashboardPrincipal(w http.ResponseWriter, r *http.Request) (store.DashboardPrincipal, bool) {
+	principal := store.DashboardPrincipal{UserId: getUserId(r)}
+	if principal.UserId == 0 || h.users == nil {
+		return principal, true
+	}
+
+	access, err := h.users.GetUserAccess(r.Context(), principal.UserId)
+	if errors.Is(err, license.ErrUserNotFound) {
+		http.Error(w, "unknown user", http.StatusUnauthorized)
+		return principal, false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return principal, false
+	}
+
+	principal.Role = access.Role
+	principal.TeamIds = access.Teams
+	if access.AccountId != nil {
+		principal.AccountId = *access.AccountId
+	}
+	for _, perm := range access.Permissions {
+		if grantsAllDashboards(perm, principal.AccountId) {
+			principal.Granted = maxDashboardLevel(principal.Granted, dashboardLevel(perm.Permission))
+		}
+	}
+	return principal, true
+}
+
+// grantsAllDashboards reports whether a license server grant covers every
+// dashboard of the user's account: grants on the account itself, and
+// dashboard grants without a resource ID. Dashboard IDs are UUIDs, so
+// single-dashboard grants live in the dashboard ACL instead.
+func grantsAllDashboards(perm license.ResourcePermission, accountId uint64) bool {
+	switch perm.ResourceType {
+	case "account":
+		return perm.ResourceId == nil || *perm.ResourceId == accountId
+	case "dashboard":
+		return perm.ResourceId == nil
+	}
+	return false
+}
+
+// dashboardLevel maps a license server permission to a dashboard level
+func dashboardLevel(permission string) string {
+	switch permission {
+	case "read":
+		return store.DashboardViewer
+	case "write", "delete":
+		return store.DashboardEditor
+	case "admin":
+		return store.DashboardAdmin
+	}
+	return ""
+}
+
+func maxDashboardLevel(a, b string) string {
+	if store.DashboardPermissionAllows(a, b) {
+		return a
+	}
+	return b
+}
+
+// authorizeDashboard loads a dashboard and checks that the requesting user
+// has at least the wanted level on it, writing a 404 or 403 response if not.
+// The dashboard's Permission is set to the user's level.
+func (h *Handler) authorizeDashboard(w http.ResponseWriter, r *http.Request, accountId uint64, dashboardId, want string) (*store.Dashboard, store.DashboardPrincipal, bool) {
+	principal, ok := h.dashboardPrincipal(w, r)
+	if !ok || !checkPrincipalAccount(w, principal, accountId) {
+		return nil, principal, false
+	}
+
+	dashboard, err := h.store.GetDashboard(r.Context(), accountId, dashboardId)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "dashboard not found", http.StatusNotFound)
+		return nil, principal, false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, principal, false
+	}
+
+	if !h.checkDashboardAccess(w, r, dashboard, principal, want) {
+		return nil, principal, false
+	}
+	return dashboard, principal, true
+}
+
+// checkDashboardAccess sets the dashboard's Permission and writes a 403
+// response if it is below the wanted level
+func (h *Handler) checkDashboardAccess(w http.ResponseWriter, r *http.Request, dashboard *store.Dashboard, principal store.DashboardPrincipal, want string) bool {
+	level, err := h.store.GetDashboardAccess(r.Context(), dashboard, principal)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	dashboard.Permission = level
+	if !store.DashboardPermissionAllows(level, want) {
+		status := http.StatusForbidden
+		if principal.UserId == 0 {
+			status = http.StatusUnauthorized
+		}
+		http.Error(w, fmt.Sprintf("%s permission on this dashboard is required", want), status)
+		return false
+	}
+	return true
+}
+
+// authorizeDashboardSave checks that the requesting user may save the
+// dashboard: editor on an existing dashboard, or a signed-in user with a
+// role above viewer for a new one. It returns the existing dashboard, nil
+// for a new one.
+func (h *Handler) authorizeDashboardSave(w http.ResponseWriter, r *http.Request, accountId uint64, dashboardId string) (*store.Dashboard, store.DashboardPrincipal, bool) {
+	principal, ok := h.dashboardPrincipal(w, r)
+	if !ok || !checkPrincipalAccount(w, principal, accountId) {
+		return nil, principal, false
+	}
+
+	existing, err := h.store.GetDashboard(r.Context(), accountId, dashboardId)
+	if errors.Is(err, sql.ErrNoRows) {
+		if !h.checkCanCreateDashboards(w, principal, accountId, "create") {
+			return nil, principal, false
+		}
+		return nil, principal, true
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, principal, false
+	}
+
+	if !h.checkDashboardAccess(w, r, existing, principal, store.DashboardEditor) || !checkDashboardWritable(w, existing) {
+		return nil, principal, false
+	}
+	return existing, principal, true
+}
+
+// checkCanCreateDashboards writes a 401 for anonymous requests and a 403
+// for users who may not add dashboards to the account: viewers and users
+// of other accounts. action names what is refused, e.g. "create".
+func (h *Handler) checkCanCreateDashboards(w http.ResponseWriter, principal store.DashboardPrincipal, accountId uint64, action string) bool {
+	if principal.UserId == 0 {
+		http.Error(w, fmt.Sprintf("a signed-in user is required to %s dashboards", action), http.StatusUnauthorized)
+		return false
+	}
+	if !checkPrincipalAccount(w, principal, accountId) {
+		return false
+	}
+	if principal.Role == "viewer" {
+		http.Error(w, fmt.Sprintf("your role cannot %s dashboards", action), http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// checkPrincipalAccount writes a 401 for anonymous requests and a 403 for
+// requests made for an account other than the user's own. Users whose
+// account is unknown, because there is no license server or the user has
+// none, only pass as super admins.
+func checkPrincipalAccount(w http.ResponseWriter, principal store.DashboardPrincipal, accountId uint64) bool {
+	if principal.UserId == 0 {
+		http.Error(w, "a signed-in user is required", http.StatusUnauthorized)
+		return false
+	}
+	if principal.AccountId == 0 && principal.Role == "super_admin" {
+		return true
+	}
+	if principal.AccountId != accountId {
+		http.Error(w, "you have no access to this account", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// filterDashboards keeps the dashboards the requesting user can view
+func (h *Handler) filterDashboards(w http.ResponseWriter, r *http.Request, accountId uint64, dashboards []store.Dashboard) ([]store.Dashboard, bool) {
+	principal, ok := h.dashboardPrincipal(w, r)
+	if !ok || !checkPrincipalAccount(w, principal, accountId) {
+		return nil, false
+	}
+	filtered, err := h.store.FilterDashboardsByAccess(r.Context(), accountId, dashboards, principal, store.DashboardViewer)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	return filtered, true
+}
+
+// expectedVersion returns the version an update was based on, from an
+// If-Match header ("3" or W/"3") or else the body's version. ok is false if
+// the client gave none.
+func expectedVersion(r *http.Request, bodyVersion uint32) (version uint32, ok bool, err error) {
+	if match := r.Header.Get("If-Match"); match != "" && match != "*" {
+		tag := strings.Trim(strings.TrimPrefix(match, "W/"), `"`)
+		parsed, err := strconv.ParseUint(tag, 10, 32)
+		if err != nil {
+			return 0, false, fmt.Errorf("If-Match must be a dashboard version")
+		}
+		return uint32(parsed), true, nil
+	}
+	if bodyVersion != 0 {
+		return bodyVersion, true, nil
+	}
+	return 0, false, nil
+}
+
+// writeVersionConflict responds with 409 and the current version, so the
+// client can reload and reapply its change
+func writeVersionConflict(w http.ResponseWriter, conflict *store.VersionConflictError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", versionETag(conflict.Current))
+	w.WriteHeader(http.StatusConflict)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":            conflict.Error(),
+		"expected_version": conflict.Expected,
+		"current_version":  conflict.Current,
+	})
+}
+
+// versionETag is the ETag of a dashboard version
+func versionETag(version uint32) string {
+	return strconv.Quote(strconv.FormatUint(uint64(version), 10))
+}
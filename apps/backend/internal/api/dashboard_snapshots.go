@@ -0,0 +1,148 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/namlabs/obsfly/backend/internal/store"
+)
+
+// createSnapshotRequest is the body of a snapshot creation request
+type createSnapshotRequest struct {
+	Name      string              `json:"name"`
+	TimeRange string              `json:"time_range"` // e.g., "1h"; widgets keep their own ranges when empty
+	To        time.Time           `json:"to"`         // end of the captured window, defaults to now
+	Interval  string              `json:"interval"`
+	Variables map[string][]string `json:"variables"`
+	ExpiresIn string              `json:"expires_in"` // e.g., "24h", "7d"; defaults to 7 days
+}
+
+// ========== DASHBOARD SNAPSHOT HANDLERS ==========
+
+// CreateDashboardSnapshot captures the rendered data of every widget and
+// returns the snapshot with its share token. The token is not shown again.
+func (h *Handler) CreateDashboardSnapshot(w http.ResponseWriter, r *http.Request) {
+	dashboardId := chi.URLParam(r, "dashboardId")
+	accountId, _ := getQueryParams(r)
+
+	var req createSnapshotRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Snapshots are public links, so sharing one takes editor permission
+	dashboard, _, ok := h.authorizeDashboard(w, r, accountId, dashboardId, store.DashboardEditor)
+	if !ok {
+		return
+	}
+
+	var expiresIn time.Duration
+	if req.ExpiresIn != "" {
+		var err error
+		expiresIn, err = parseExpiry(req.ExpiresIn)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	snapshot, err := h.store.CreateDashboardSnapshot(r.Context(), dashboard, store.CreateSnapshotRequest{
+		Name:      req.Name,
+		CreatedBy: requestAuthor(r),
+		ExpiresIn: expiresIn,
+		Render: store.DashboardDataRequest{
+			AccountId: accountId,
+			TimeRange: req.TimeRange,
+			Interval:  req.Interval,
+			To:        req.To,
+			Variables: req.Variables,
+		},
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"snapshot_id": snapshot.SnapshotId,
+		"token":       snapshot.Token,
+		"url":         "/api/snapshots/" + snapshot.Token,
+		"from":        snapshot.From,
+		"to":          snapshot.To,
+		"expires_at":  snapshot.ExpiresAt,
+	})
+}
+
+func (h *Handler) ListDashboardSnapshots(w http.ResponseWriter, r *http.Request) {
+	dashboardId := chi.URLParam(r, "dashboardId")
+	accountId, _ := getQueryParams(r)
+
+	if _, _, ok := h.authorizeDashboard(w, r, accountId, dashboardId, store.DashboardViewer); !ok {
+		return
+	}
+
+	data, err := h.store.ListDashboardSnapshots(r.Context(), accountId, dashboardId)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(data)
+}
+
+func (h *Handler) DeleteDashboardSnapshot(w http.ResponseWriter, r *http.Request) {
+	dashboardId := chi.URLParam(r, "dashboardId")
+	snapshotId := chi.URLParam(r, "snapshotId")
+	accountId, _ := getQueryParams(r)
+
+	if _, _, ok := h.authorizeDashboard(w, r, accountId, dashboardId, store.DashboardEditor); !ok {
+		return
+	}
+
+	if err := h.store.DeleteDashboardSnapshot(r.Context(), accountId, dashboardId, snapshotId); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetSnapshot serves a snapshot by its token, without authentication.
+// Unknown, deleted and expired tokens all get the same 404.
+func (h *Handler) GetSnapshot(w http.ResponseWriter, r *http.Request) {
+	token := chi.URLParam(r, "token")
+
+	snapshot, err := h.store.GetDashboardSnapshotByToken(r.Context(), token)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if snapshot == nil {
+		http.Error(w, "snapshot not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.Header().Set("X-Robots-Tag", "noindex")
+	json.NewEncoder(w).Encode(snapshot)
+}
+
+// parseExpiry accepts Go durations ("36h") as well as days ("7d")
+func parseExpiry(value string) (time.Duration, error) {
+	var days int
+	var unit string
+	if n, _ := fmt.Sscanf(value, "%d%s", &days, &unit); n == 2 && unit == "d" && days > 0 {
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid expires_in %q, expected e.g. 24h or 7d", value)
+	}
+	return d, nil
+}
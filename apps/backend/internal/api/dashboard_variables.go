@@ -0,0 +1,43 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/namlabs/obsfly/backend/internal/store"
+)
+
+// ========== DASHBOARD VARIABLE HANDLERS ==========
+
+// GetDashboardVariables resolves the options of every dashboard variable.
+// Selected values are passed as repeated var-<name> query parameters.
+func (h *Handler) GetDashboardVariables(w http.ResponseWriter, r *http.Request) {
+	dashboardId := chi.URLParam(r, "dashboardId")
+	accountId, _ := getQueryParams(r)
+
+	dashboard, _, ok := h.authorizeDashboard(w, r, accountId, dashboardId, store.DashboardViewer)
+	if !ok {
+		return
+	}
+
+	data, err := h.store.ResolveDashboardVariables(r.Context(), accountId, dashboard.Variables, getVariableSelection(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(data)
+}
+
+// getVariableSelection reads var-<name>=value query parameters
+func getVariableSelection(r *http.Request) map[string][]string {
+	selected := make(map[string][]string)
+	for key, values := range r.URL.Query() {
+		if name, ok := strings.CutPrefix(key, "var-"); ok && name != "" {
+			selected[name] = values
+		}
+	}
+	return selected
+}
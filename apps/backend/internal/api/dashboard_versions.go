@@ -0,0 +1,147 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/namlabs/obsfly/backend/internal/store"
+)
+
+// ========== DASHBOARD VERSION HANDLERS ==========
+
+func (h *Handler) ListDashboardVersions(w http.ResponseWriter, r *http.Request) {
+	dashboardId := chi.URLParam(r, "dashboardId")
+	accountId, _ := getQueryParams(r)
+
+	if _, _, ok := h.authorizeDashboard(w, r, accountId, dashboardId, store.DashboardViewer); !ok {
+		return
+	}
+
+	data, err := h.store.ListDashboardVersions(r.Context(), accountId, dashboardId)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(data)
+}
+
+func (h *Handler) GetDashboardVersion(w http.ResponseWriter, r *http.Request) {
+	dashboardId := chi.URLParam(r, "dashboardId")
+	accountId, _ := getQueryParams(r)
+
+	version, err := parseVersion(chi.URLParam(r, "version"))
+	if err != nil {
+		http.Error(w, "invalid version", http.StatusBadRequest)
+		return
+	}
+	if _, _, ok := h.authorizeDashboard(w, r, accountId, dashboardId, store.DashboardViewer); !ok {
+		return
+	}
+
+	data, err := h.store.GetDashboardVersion(r.Context(), accountId, dashboardId, version)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "dashboard version not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(data)
+}
+
+func (h *Handler) DiffDashboardVersions(w http.ResponseWriter, r *http.Request) {
+	dashboardId := chi.URLParam(r, "dashboardId")
+	accountId, _ := getQueryParams(r)
+
+	fromVersion, err := parseVersion(r.URL.Query().Get("from"))
+	if err != nil {
+		http.Error(w, "from and to versions are required", http.StatusBadRequest)
+		return
+	}
+	toVersion, err := parseVersion(r.URL.Query().Get("to"))
+	if err != nil {
+		http.Error(w, "from and to versions are required", http.StatusBadRequest)
+		return
+	}
+	if _, _, ok := h.authorizeDashboard(w, r, accountId, dashboardId, store.DashboardViewer); !ok {
+		return
+	}
+
+	data, err := h.store.DiffDashboardVersions(r.Context(), accountId, dashboardId, fromVersion, toVersion)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "dashboard version not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(data)
+}
+
+func (h *Handler) RestoreDashboardVersion(w http.ResponseWriter, r *http.Request) {
+	dashboardId := chi.URLParam(r, "dashboardId")
+	accountId, _ := getQueryParams(r)
+
+	version, err := parseVersion(chi.URLParam(r, "version"))
+	if err != nil {
+		http.Error(w, "invalid version", http.StatusBadRequest)
+		return
+	}
+
+	existing, _, ok := h.authorizeDashboard(w, r, accountId, dashboardId, store.DashboardEditor)
+	if !ok || !checkDashboardWritable(w, existing) {
+		return
+	}
+
+	// A restore replaces the latest version, so like an update it must name
+	// the version it is based on
+	expected, ok, err := expectedVersion(r, 0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !ok {
+		http.Error(w, "restoring a version requires the version it replaces, as If-Match", http.StatusPreconditionRequired)
+		return
+	}
+
+	data, err := h.store.RestoreDashboardVersion(r.Context(), accountId, dashboardId, version, expected, requestAuthor(r))
+	var conflict *store.VersionConflictError
+	if errors.As(err, &conflict) {
+		writeVersionConflict(w, conflict)
+		return
+	}
+	var verr *store.ValidationError
+	if errors.As(err, &verr) {
+		writeValidationError(w, verr)
+		return
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "dashboard version not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", versionETag(data.Version))
+	json.NewEncoder(w).Encode(data)
+}
+
+func parseVersion(v string) (uint32, error) {
+	parsed, err := strconv.ParseUint(v, 10, 32)
+	if err != nil {
+		return 0, err
+	}
+	return uint32(parsed), nil
+}
@@ -1,28 +1,51 @@
 package api
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"strconv"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"github.com/google/uuid"
+	"github.com/namlabs/obsfly/backend/internal/archive"
+	"github.com/namlabs/obsfly/backend/internal/license"
+	"github.com/namlabs/obsfly/backend/internal/logtail"
+	"github.com/namlabs/obsfly/backend/internal/reports"
 	"github.com/namlabs/obsfly/backend/internal/store"
 )
 
 type Handler struct {
-	store *store.Store
+	store        *store.Store
+	reportRunner *reports.Runner
+	users        *license.Client // nil without a license server; users then have no account, role or teams and no dashboard access
+	logTails     *logtail.Registry
+	archiver     *archive.Archiver
+
+	trustedProxies []*net.IPNet // may assert the user with X-User-Id
 }
 
-func NewHandler(store *store.Store) *Handler {
-	return &Handler{store: store}
+func NewHandler(store *store.Store, reportRunner *reports.Runner, archiver *archive.Archiver) *Handler {
+	return &Handler{
+		store:        store,
+		reportRunner: reportRunner,
+		users:        license.ClientFromEnv(),
+		logTails:     logtail.NewRegistry(maxTailsPerAccount),
+		archiver:     archiver,
+
+		trustedProxies: trustedProxiesFromEnv(),
+	}
 }
 
 func (h *Handler) RegisterRoutes(r chi.Router) {
 	r.Use(middleware.Logger)
 	r.Use(corsMiddleware) // Simple CORS for dev
+	r.Use(h.authenticate)
 
 	r.Get("/api/dashboard/summary", h.GetSummary)
 	r.Get("/api/dashboard/health", h.GetInfraHealth)
@@ -44,6 +67,13 @@ func (h *Handler) RegisterRoutes(r chi.Router) {
 	// Logs endpoints
 	r.Get("/api/logs", h.GetLogs)
 	r.Get("/api/logs/detail", h.GetLogDetail)
+	r.Get("/api/logs/tail", h.TailLogs)
+	r.Get("/api/logs/context", h.GetLogContext)
+	r.Get("/api/logs/patterns", h.GetTopLogPatterns)
+	r.Get("/api/logs/anomalies", h.GetLogAnomalies)
+	r.Get("/api/logs/facets", h.GetLogFacets)
+	r.Get("/api/logs/histogram", h.GetLogHistogram)
+	r.Get("/api/logs/{logId}", h.GetLogDetail)
 
 	// Profiling endpoints
 	r.Get("/api/profiling/profiles", h.GetProfiles)
@@ -62,10 +92,93 @@ func (h *Handler) RegisterRoutes(r chi.Router) {
 
 	// Dashboard management endpoints
 	r.Get("/api/dashboards", h.ListDashboards)
+	r.Get("/api/dashboards/recent", h.GetRecentDashboards)
+	r.Get("/api/dashboards/tags", h.ListDashboardTags)
 	r.Get("/api/dashboards/{dashboardId}", h.GetDashboard)
 	r.Post("/api/dashboards", h.SaveDashboard)
+	r.Post("/api/dashboards/import/grafana", h.ImportGrafanaDashboard)
+	r.Post("/api/dashboards/import", h.ImportDashboards)
+	r.Get("/api/dashboards/export", h.ExportDashboards)
+	r.Get("/api/dashboards/{dashboardId}/export", h.ExportDashboard)
 	r.Put("/api/dashboards/{dashboardId}", h.UpdateDashboard)
 	r.Delete("/api/dashboards/{dashboardId}", h.DeleteDashboard)
+	r.Get("/api/dashboards/{dashboardId}/variables", h.GetDashboardVariables)
+	r.Get("/api/dashboards/{dashboardId}/data", h.GetDashboardData)
+	r.Get("/api/dashboards/{dashboardId}/versions", h.ListDashboardVersions)
+	r.Get("/api/dashboards/{dashboardId}/versions/diff", h.DiffDashboardVersions)
+	r.Get("/api/dashboards/{dashboardId}/versions/{version}", h.GetDashboardVersion)
+	r.Post("/api/dashboards/{dashboardId}/versions/{version}/restore", h.RestoreDashboardVersion)
+	r.Get("/api/dashboards/{dashboardId}/permissions", h.GetDashboardPermissions)
+	r.Put("/api/dashboards/{dashboardId}/permissions", h.UpdateDashboardPermissions)
+	r.Put("/api/dashboards/{dashboardId}/star", h.StarDashboard)
+	r.Delete("/api/dashboards/{dashboardId}/star", h.UnstarDashboard)
+	r.Get("/api/dashboards/{dashboardId}/snapshots", h.ListDashboardSnapshots)
+	r.Post("/api/dashboards/{dashboardId}/snapshots", h.CreateDashboardSnapshot)
+	r.Delete("/api/dashboards/{dashboardId}/snapshots/{snapshotId}", h.DeleteDashboardSnapshot)
+
+	// Public snapshot endpoint, the token is the only credential
+	r.Get("/api/snapshots/{token}", h.GetSnapshot)
+
+	// Dashboard folder endpoints
+	r.Get("/api/folders", h.ListFolders)
+	r.Post("/api/folders", h.CreateFolder)
+	r.Get("/api/folders/{folderId}", h.GetFolder)
+	r.Put("/api/folders/{folderId}", h.UpdateFolder)
+	r.Delete("/api/folders/{folderId}", h.DeleteFolder)
+
+	// Scheduled report endpoints
+	r.Get("/api/reports", h.ListReports)
+	r.Post("/api/reports", h.CreateReport)
+	r.Get("/api/reports/{reportId}", h.GetReport)
+	r.Put("/api/reports/{reportId}", h.UpdateReport)
+	r.Delete("/api/reports/{reportId}", h.DeleteReport)
+	r.Get("/api/reports/{reportId}/runs", h.GetReportRuns)
+	r.Post("/api/reports/{reportId}/run", h.RunReport)
+	r.Get("/api/reports/{reportId}/preview", h.PreviewReport)
+
+	// Annotation endpoints
+	r.Get("/api/annotations", h.ListAnnotations)
+	r.Post("/api/annotations", h.CreateAnnotation)
+	r.Delete("/api/annotations/{annotationId}", h.DeleteAnnotation)
+	r.Post("/api/annotations/alertmanager", h.AlertmanagerWebhook)
+
+	// Recording rule endpoints
+	r.Get("/api/recording-rules", h.ListRecordingRules)
+	r.Post("/api/recording-rules", h.CreateRecordingRule)
+	r.Get("/api/recording-rules/{ruleId}", h.GetRecordingRule)
+	r.Put("/api/recording-rules/{ruleId}", h.UpdateRecordingRule)
+	r.Delete("/api/recording-rules/{ruleId}", h.DeleteRecordingRule)
+	r.Get("/api/recording-rules/{ruleId}/evaluations", h.GetRecordingRuleEvaluations)
+
+	// Log metric endpoints
+	r.Get("/api/log-metrics", h.ListLogMetrics)
+	r.Post("/api/log-metrics", h.CreateLogMetric)
+	r.Get("/api/log-metrics/{metricId}", h.GetLogMetric)
+	r.Put("/api/log-metrics/{metricId}", h.UpdateLogMetric)
+	r.Delete("/api/log-metrics/{metricId}", h.DeleteLogMetric)
+
+	// Log archive endpoints
+	r.Get("/api/log-archives", h.ListLogArchives)
+	r.Post("/api/log-archives", h.ArchiveLogs)
+	r.Get("/api/log-archives/rehydrations", h.ListLogRehydrations)
+	r.Post("/api/log-archives/rehydrations", h.CreateLogRehydration)
+	r.Get("/api/log-archives/rehydrations/{rehydrationId}", h.GetLogRehydration)
+	r.Delete("/api/log-archives/rehydrations/{rehydrationId}", h.DeleteLogRehydration)
+	r.Get("/api/log-archives/rehydrations/{rehydrationId}/logs", h.GetRehydratedLogs)
+
+	// Log pipeline endpoints
+	r.Get("/api/log-pipelines", h.ListLogPipelines)
+	r.Post("/api/log-pipelines", h.CreateLogPipeline)
+	r.Post("/api/log-pipelines/test", h.TestLogPipeline)
+	r.Get("/api/log-pipelines/{pipelineId}", h.GetLogPipeline)
+	r.Put("/api/log-pipelines/{pipelineId}", h.UpdateLogPipeline)
+	r.Delete("/api/log-pipelines/{pipelineId}", h.DeleteLogPipeline)
+	r.Post("/api/log-pipelines/{pipelineId}/test", h.TestSavedLogPipeline)
+
+	// Redaction endpoints
+	r.Get("/api/redaction", h.GetRedactionConfig)
+	r.Put("/api/redaction", h.UpdateRedactionConfig)
+	r.Get("/api/redaction/counts", h.GetRedactionCounts)
 
 	// Health check endpoint
 	r.Get("/health", h.HealthCheck)
@@ -74,8 +187,9 @@ func (h *Handler) RegisterRoutes(r chi.Router) {
 func corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-User-Id, If-Match")
+		w.Header().Set("Access-Control-Expose-Headers", "ETag")
 
 		if r.Method == "OPTIONS" {
 			w.WriteHeader(http.StatusOK)
@@ -269,8 +383,19 @@ func (h *Handler) QueryMetrics(w http.ResponseWriter, r *http.Request) {
 	if req.AccountId == 0 {
 		req.AccountId = 1
 	}
+	// Queries run with a dashboard's variables need view access to it
+	if req.DashboardId != "" {
+		if _, _, ok := h.authorizeDashboard(w, r, req.AccountId, req.DashboardId, store.DashboardViewer); !ok {
+			return
+		}
+	}
 
 	data, err := h.store.QueryMetrics(r.Context(), req)
+	var verr *store.ValidationError
+	if errors.As(err, &verr) {
+		writeValidationError(w, verr)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -281,14 +406,47 @@ func (h *Handler) QueryMetrics(w http.ResponseWriter, r *http.Request) {
 
 // ========== DASHBOARD HANDLERS ==========
 
+// ListDashboards lists and searches dashboards. q searches names,
+// descriptions, tags and widget titles; folder_id (with recursive=true for
+// subfolders), tag, owner_id and starred=true filter; sort is name or updated.
 func (h *Handler) ListDashboards(w http.ResponseWriter, r *http.Request) {
 	accountId, _ := getQueryParams(r)
+	q := r.URL.Query()
+
+	search := store.DashboardSearch{
+		AccountId: accountId,
+		UserId:    getUserId(r),
+		Query:     q.Get("q"),
+		FolderId:  q.Get("folder_id"),
+		Recursive: q.Get("recursive") == "true",
+		Starred:   q.Get("starred") == "true",
+		Sort:      q.Get("sort"),
+	}
+	for _, tag := range q["tag"] {
+		search.Tags = append(search.Tags, splitParam(tag)...)
+	}
+	if owner := q.Get("owner_id"); owner != "" {
+		parsed, err := strconv.ParseUint(owner, 10, 64)
+		if err != nil {
+			http.Error(w, "owner_id must be a user ID", http.StatusBadRequest)
+			return
+		}
+		search.OwnerId = parsed
+	}
+	if search.Starred && search.UserId == 0 {
+		http.Error(w, "starred=true requires a user", http.StatusBadRequest)
+		return
+	}
 
-	data, err := h.store.ListDashboards(r.Context(), accountId)
+	data, err := h.store.SearchDashboards(r.Context(), search)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	data, ok := h.filterDashboards(w, r, accountId, data)
+	if !ok {
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(data)
 }
@@ -297,19 +455,55 @@ func (h *Handler) GetDashboard(w http.ResponseWriter, r *http.Request) {
 	dashboardId := chi.URLParam(r, "dashboardId")
 	accountId, _ := getQueryParams(r)
 
-	data, err := h.store.GetDashboard(r.Context(), accountId, dashboardId)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	data, principal, ok := h.authorizeDashboard(w, r, accountId, dashboardId, store.DashboardViewer)
+	if !ok {
 		return
 	}
+
+	if principal.UserId != 0 {
+		var err error
+		if data.Starred, err = h.store.IsDashboardStarred(r.Context(), accountId, principal.UserId, dashboardId); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	h.recordDashboardView(r, accountId, dashboardId)
+
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", versionETag(data.Version))
 	json.NewEncoder(w).Encode(data)
 }
 
+// decodeDashboardRequest decodes a dashboard from a request body. Clients
+// that still send the legacy JSON-encoded config get it translated into
+// widgets and variables; a config that does not decode is rejected.
+func decodeDashboardRequest(r *http.Request) (store.Dashboard, error) {
+	var req struct {
+		store.Dashboard
+		Config *string `json:"config"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return req.Dashboard, errors.New("Invalid request body")
+	}
+	if req.Config == nil {
+		return req.Dashboard, nil
+	}
+	if len(req.Widgets) > 0 || len(req.Variables) > 0 {
+		return req.Dashboard, errors.New("send either widgets and variables or the legacy config, not both")
+	}
+	config, err := store.DecodeDashboardConfig(*req.Config)
+	if err != nil {
+		return req.Dashboard, fmt.Errorf("invalid config: %w", err)
+	}
+	req.Widgets = config.Widgets
+	req.Variables = config.Variables
+	return req.Dashboard, nil
+}
+
 func (h *Handler) SaveDashboard(w http.ResponseWriter, r *http.Request) {
-	var dashboard store.Dashboard
-	if err := json.NewDecoder(r.Body).Decode(&dashboard); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	dashboard, err := decodeDashboardRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
@@ -318,27 +512,41 @@ func (h *Handler) SaveDashboard(w http.ResponseWriter, r *http.Request) {
 		dashboard.AccountId = 1
 	}
 
-	// Generate UUID if not provided
+	// Generate UUID if not provided; new dashboards belong to their creator
 	if dashboard.DashboardId == "" {
 		dashboard.DashboardId = generateUUID()
 	}
+	existing, principal, ok := h.authorizeDashboardSave(w, r, dashboard.AccountId, dashboard.DashboardId)
+	if !ok {
+		return
+	}
+	if existing == nil {
+		dashboard.OwnerId = principal.UserId
+	} else {
+		dashboard.OwnerId = existing.OwnerId
+	}
+	dashboard.Source, dashboard.SourcePath = "", ""
+	dashboard.Author = requestAuthor(r)
 
-	err := h.store.SaveDashboard(r.Context(), &dashboard)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if !h.validateDashboard(w, r, &dashboard) {
+		return
+	}
+
+	if !h.saveDashboardIfUnchanged(w, r, &dashboard, existing) {
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", versionETag(dashboard.Version))
 	json.NewEncoder(w).Encode(dashboard)
 }
 
 func (h *Handler) UpdateDashboard(w http.ResponseWriter, r *http.Request) {
 	dashboardId := chi.URLParam(r, "dashboardId")
 
-	var dashboard store.Dashboard
-	if err := json.NewDecoder(r.Body).Decode(&dashboard); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	dashboard, err := decodeDashboardRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
@@ -349,20 +557,71 @@ func (h *Handler) UpdateDashboard(w http.ResponseWriter, r *http.Request) {
 		dashboard.AccountId = 1
 	}
 
-	err := h.store.SaveDashboard(r.Context(), &dashboard)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	existing, principal, ok := h.authorizeDashboardSave(w, r, dashboard.AccountId, dashboardId)
+	if !ok {
+		return
+	}
+	if existing == nil {
+		dashboard.OwnerId = principal.UserId
+	} else {
+		dashboard.OwnerId = existing.OwnerId
+	}
+	dashboard.Source, dashboard.SourcePath = "", ""
+	dashboard.Author = requestAuthor(r)
+
+	if !h.validateDashboard(w, r, &dashboard) {
+		return
+	}
+
+	if !h.saveDashboardIfUnchanged(w, r, &dashboard, existing) {
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", versionETag(dashboard.Version))
 	json.NewEncoder(w).Encode(dashboard)
 }
 
+// saveDashboardIfUnchanged saves a dashboard unless it changed since the
+// request read it, so concurrent edits are rejected rather than silently
+// overwritten. Updates of an existing dashboard must name the version they
+// were based on, with If-Match or the body's version, or get a 428; a new
+// dashboard is only created if no concurrent save created it first.
+func (h *Handler) saveDashboardIfUnchanged(w http.ResponseWriter, r *http.Request, dashboard, existing *store.Dashboard) bool {
+	version, ok, err := expectedVersion(r, dashboard.Version)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	if existing == nil {
+		version = 0
+	} else if !ok {
+		http.Error(w, "updating a dashboard requires the version it is based on, as If-Match or version", http.StatusPreconditionRequired)
+		return false
+	}
+
+	err = h.store.SaveDashboardIfVersion(r.Context(), dashboard, version)
+	var conflict *store.VersionConflictError
+	if errors.As(err, &conflict) {
+		writeVersionConflict(w, conflict)
+		return false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
 func (h *Handler) DeleteDashboard(w http.ResponseWriter, r *http.Request) {
 	dashboardId := chi.URLParam(r, "dashboardId")
 	accountId, _ := getQueryParams(r)
 
+	existing, _, ok := h.authorizeDashboard(w, r, accountId, dashboardId, store.DashboardEditor)
+	if !ok || !checkDashboardWritable(w, existing) {
+		return
+	}
+
 	err := h.store.DeleteDashboard(r.Context(), accountId, dashboardId)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -372,9 +631,59 @@ func (h *Handler) DeleteDashboard(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
-// Simple UUID generator (in production, use a proper UUID library)
+// checkDashboardWritable rejects changes to dashboards managed by directory
+// sync, writing a 403 response
+func checkDashboardWritable(w http.ResponseWriter, existing *store.Dashboard) bool {
+	if !existing.ReadOnly {
+		return true
+	}
+	http.Error(w, fmt.Sprintf("dashboard is synced from %s and is read-only", existing.SourcePath), http.StatusForbidden)
+	return false
+}
+
+// validateDashboard assigns IDs to new widgets and validates the dashboard,
+// writing a 422 response with the field errors if it is invalid. Metrics
+// without data are set as the dashboard's UnknownMetrics.
+func (h *Handler) validateDashboard(w http.ResponseWriter, r *http.Request, dashboard *store.Dashboard) bool {
+	for i := range dashboard.Widgets {
+		if dashboard.Widgets[i].WidgetId == "" {
+			dashboard.Widgets[i].WidgetId = generateUUID()
+		}
+	}
+
+	err := h.store.ValidateDashboard(r.Context(), dashboard)
+	var verr *store.ValidationError
+	if errors.As(err, &verr) {
+		writeValidationError(w, verr)
+		return false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+
+	// Metrics without data do not block the save, they are returned with it
+	dashboard.UnknownMetrics, err = h.store.UnknownDashboardMetrics(r.Context(), dashboard)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// writeValidationError responds with the structured field errors
+func writeValidationError(w http.ResponseWriter, verr *store.ValidationError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":  "validation failed",
+		"errors": verr.Errors,
+	})
+}
+
+// generateUUID returns a random UUID for new entities
 func generateUUID() string {
-	return fmt.Sprintf("%d", time.Now().UnixNano())
+	return uuid.NewString()
 }
 
 func (h *Handler) GetServiceTraces(w http.ResponseWriter, r *http.Request) {
@@ -476,16 +785,6 @@ func (h *Handler) GetAPMServices(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) GetLogs(w http.ResponseWriter, r *http.Request) {
 	accountId, minutesAgo := getQueryParams(r)
 
-	// Parse additional query parameters
-	serviceName := r.URL.Query().Get("service")
-	hostName := r.URL.Query().Get("host")
-	severity := r.URL.Query().Get("severity")
-	environment := r.URL.Query().Get("env")
-	namespace := r.URL.Query().Get("namespace")
-	pod := r.URL.Query().Get("pod")
-	search := r.URL.Query().Get("search")
-	traceId := r.URL.Query().Get("trace_id")
-
 	// Parse pagination
 	page := 1
 	if p := r.URL.Query().Get("page"); p != "" {
@@ -501,22 +800,27 @@ func (h *Handler) GetLogs(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	req := store.LogsListRequest{
-		AccountId:    accountId,
-		TimeRangeMin: minutesAgo,
-		ServiceName:  serviceName,
-		HostName:     hostName,
-		Severity:     severity,
-		Environment:  environment,
-		Namespace:    namespace,
-		Pod:          pod,
-		Search:       search,
-		TraceId:      traceId,
-		Page:         page,
-		PageSize:     pageSize,
+	req, err := getLogsFilter(r, accountId, minutesAgo)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req.Page = page
+	req.PageSize = pageSize
+	// cursor takes a next_cursor or prev_cursor from an earlier response and
+	// replaces page; count picks exact, approx or none for total_count
+	req.Cursor = r.URL.Query().Get("cursor")
+	req.Count = r.URL.Query().Get("count")
+	if !store.ValidLogCount(req.Count) {
+		http.Error(w, "count must be exact, approx or none", http.StatusBadRequest)
+		return
 	}
 
 	data, err := h.store.GetLogsList(r.Context(), req)
+	if errors.Is(err, store.ErrInvalidCursor) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -526,24 +830,46 @@ func (h *Handler) GetLogs(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(data)
 }
 
+// getLogsFilter reads the log filters shared by the logs endpoints. query
+// takes a log query (see store.LogQuery); it fails when that does not parse.
+func getLogsFilter(r *http.Request, accountId uint64, minutesAgo int) (store.LogsListRequest, error) {
+	q := r.URL.Query()
+	query, err := store.ParseLogQuery(q.Get("query"))
+	if err != nil {
+		return store.LogsListRequest{}, err
+	}
+	return store.LogsListRequest{
+		AccountId:    accountId,
+		TimeRangeMin: minutesAgo,
+		ServiceName:  q.Get("service"),
+		HostName:     q.Get("host"),
+		Severity:     q.Get("severity"),
+		Environment:  q.Get("env"),
+		Namespace:    q.Get("namespace"),
+		Pod:          q.Get("pod"),
+		Search:       q.Get("search"),
+		Query:        query,
+		TraceId:      q.Get("trace_id"),
+	}, nil
+}
+
+// GetLogDetail returns one log with all its fields. The log is identified
+// by id, or by the logId path segment of /api/logs/{logId} links; links made
+// before logs had IDs use timestamp and service instead.
 func (h *Handler) GetLogDetail(w http.ResponseWriter, r *http.Request) {
 	accountId, _ := getQueryParams(r)
 
-	timestampStr := r.URL.Query().Get("timestamp")
-	serviceName := r.URL.Query().Get("service")
-
-	if timestampStr == "" || serviceName == "" {
-		http.Error(w, "timestamp and service are required", http.StatusBadRequest)
+	ref, err := getLogRef(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	timestamp, err := time.Parse(time.RFC3339Nano, timestampStr)
-	if err != nil {
-		http.Error(w, "invalid timestamp format", http.StatusBadRequest)
+	data, err := h.store.GetLogDetail(r.Context(), accountId, ref)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "log not found", http.StatusNotFound)
 		return
 	}
-
-	data, err := h.store.GetLogDetail(r.Context(), accountId, timestamp, serviceName)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -553,6 +879,33 @@ func (h *Handler) GetLogDetail(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(data)
 }
 
+// getLogRef reads the log a request refers to: id (or the logId path
+// parameter) with optional timestamp and service, or both timestamp and
+// service when there is no id
+func getLogRef(r *http.Request) (store.LogRef, error) {
+	q := r.URL.Query()
+	ref := store.LogRef{
+		LogId:       q.Get("id"),
+		ServiceName: q.Get("service"),
+	}
+	if ref.LogId == "" {
+		ref.LogId = chi.URLParam(r, "logId")
+	}
+
+	if ts := q.Get("timestamp"); ts != "" {
+		timestamp, err := time.Parse(time.RFC3339Nano, ts)
+		if err != nil {
+			return ref, errors.New("invalid timestamp format")
+		}
+		ref.Timestamp = timestamp
+	}
+
+	if ref.LogId == "" && (ref.Timestamp.IsZero() || ref.ServiceName == "") {
+		return ref, errors.New("id, or timestamp and service, are required")
+	}
+	return ref, nil
+}
+
 // ========== PROFILING HANDLERS ==========
 
 func (h *Handler) GetProfiles(w http.ResponseWriter, r *http.Request) {
@@ -660,6 +1013,25 @@ func (h *Handler) GetNodeMetricsTimeSeries(w http.ResponseWriter, r *http.Reques
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+
+	// With annotations=true the series are wrapped together with the
+	// annotations overlapping the range
+	if r.URL.Query().Get("annotations") == "true" {
+		to := time.Now()
+		from := to.Add(-time.Duration(minutesAgo) * time.Minute)
+		annotations, err := h.store.GetNodeAnnotations(r.Context(), accountId, nodeId, from, to)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"series":      data,
+			"annotations": annotations,
+		})
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(data)
 }
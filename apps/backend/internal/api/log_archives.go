@@ -0,0 +1,262 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/namlabs/obsfly/backend/internal/archive"
+	"github.com/namlabs/obsfly/backend/internal/store"
+)
+
+const (
+	defaultLogArchiveDays      = 30
+	defaultLogRehydrationHours = 24
+)
+
+// ========== LOG ARCHIVE HANDLERS ==========
+
+// ListLogArchives lists the archived days of logs per service. from and to
+// are UTC days (YYYY-MM-DD) and default to the last 30 days; service
+// narrows the list to one service.
+func (h *Handler) ListLogArchives(w http.ResponseWriter, r *http.Request) {
+	accountId, _ := getQueryParams(r)
+	q := r.URL.Query()
+
+	to := time.Now().UTC()
+	if v := q.Get("to"); v != "" {
+		parsed, err := time.Parse(time.DateOnly, v)
+		if err != nil {
+			http.Error(w, "to must be a date (YYYY-MM-DD)", http.StatusBadRequest)
+			return
+		}
+		to = parsed
+	}
+	from := to.AddDate(0, 0, -defaultLogArchiveDays)
+	if v := q.Get("from"); v != "" {
+		parsed, err := time.Parse(time.DateOnly, v)
+		if err != nil {
+			http.Error(w, "from must be a date (YYYY-MM-DD)", http.StatusBadRequest)
+			return
+		}
+		from = parsed
+	}
+
+	archives, err := h.store.ListLogArchives(r.Context(), accountId, from, to, q.Get("service"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(archives)
+}
+
+// ArchiveLogs archives a day of logs now instead of waiting for the
+// archiver, replacing any earlier archive of the day
+func (h *Handler) ArchiveLogs(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		AccountId uint64 `json:"account_id"`
+		Date      string `json:"date"` // YYYY-MM-DD, UTC
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Set default account if not provided
+	if req.AccountId == 0 {
+		req.AccountId = 1
+	}
+	day, err := time.Parse(time.DateOnly, req.Date)
+	if err != nil {
+		http.Error(w, "date must be a date (YYYY-MM-DD)", http.StatusBadRequest)
+		return
+	}
+
+	archives, err := h.archiver.ArchiveDay(r.Context(), req.AccountId, day)
+	if errors.Is(err, archive.ErrNotConfigured) {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if errors.Is(err, archive.ErrDayNotEnded) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if archives == nil {
+		archives = []store.LogArchive{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(archives)
+}
+
+// ========== LOG REHYDRATION HANDLERS ==========
+
+func (h *Handler) ListLogRehydrations(w http.ResponseWriter, r *http.Request) {
+	accountId, _ := getQueryParams(r)
+
+	rehydrations, err := h.store.ListLogRehydrations(r.Context(), accountId)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rehydrations)
+}
+
+func (h *Handler) GetLogRehydration(w http.ResponseWriter, r *http.Request) {
+	rehydrationId := chi.URLParam(r, "rehydrationId")
+	accountId, _ := getQueryParams(r)
+
+	rehydration, err := h.store.GetLogRehydration(r.Context(), accountId, rehydrationId)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "log rehydration not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rehydration)
+}
+
+// CreateLogRehydration loads the archived logs of a time range, and
+// optionally one service, into a temporary table. Loading runs in the
+// background; the rehydration is returned with status loading and can be
+// queried once ready. It is dropped after expires_in_hours (24 by default,
+// up to a week).
+func (h *Handler) CreateLogRehydration(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		AccountId      uint64    `json:"account_id"`
+		ServiceName    string    `json:"service_name"`
+		From           time.Time `json:"from"`
+		To             time.Time `json:"to"`
+		ExpiresInHours int       `json:"expires_in_hours"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Set default account if not provided
+	if req.AccountId == 0 {
+		req.AccountId = 1
+	}
+	if req.ExpiresInHours == 0 {
+		req.ExpiresInHours = defaultLogRehydrationHours
+	}
+
+	rehydration := &store.LogRehydration{
+		RehydrationId: generateUUID(),
+		AccountId:     req.AccountId,
+		ServiceName:   req.ServiceName,
+		From:          req.From,
+		To:            req.To,
+		ExpiresAt:     time.Now().Add(time.Duration(req.ExpiresInHours) * time.Hour),
+	}
+	if err := rehydration.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err := h.archiver.Rehydrate(r.Context(), rehydration)
+	if errors.Is(err, archive.ErrNotConfigured) {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(rehydration)
+}
+
+// GetRehydratedLogs returns a page of a ready rehydration's logs, newest
+// first. It takes the filters of /api/logs, except for the time range.
+func (h *Handler) GetRehydratedLogs(w http.ResponseWriter, r *http.Request) {
+	rehydrationId := chi.URLParam(r, "rehydrationId")
+	accountId, minutesAgo := getQueryParams(r)
+
+	rehydration, err := h.store.GetLogRehydration(r.Context(), accountId, rehydrationId)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "log rehydration not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if rehydration.Status != store.LogRehydrationReady {
+		http.Error(w, "log rehydration is "+rehydration.Status, http.StatusConflict)
+		return
+	}
+
+	page := 1
+	if p := r.URL.Query().Get("page"); p != "" {
+		if parsed, err := strconv.Atoi(p); err == nil && parsed > 0 {
+			page = parsed
+		}
+	}
+
+	pageSize := 50
+	if ps := r.URL.Query().Get("page_size"); ps != "" {
+		if parsed, err := strconv.Atoi(ps); err == nil && parsed > 0 && parsed <= 1000 {
+			pageSize = parsed
+		}
+	}
+
+	req, err := getLogsFilter(r, accountId, minutesAgo)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req.Page = page
+	req.PageSize = pageSize
+
+	data, err := h.store.GetRehydratedLogs(r.Context(), rehydration, req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(data)
+}
+
+// DeleteLogRehydration drops a rehydration's logs before it expires
+func (h *Handler) DeleteLogRehydration(w http.ResponseWriter, r *http.Request) {
+	rehydrationId := chi.URLParam(r, "rehydrationId")
+	accountId, _ := getQueryParams(r)
+
+	rehydration, err := h.store.GetLogRehydration(r.Context(), accountId, rehydrationId)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "log rehydration not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.archiver.DropRehydration(r.Context(), rehydration); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
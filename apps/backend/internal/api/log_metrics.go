@@ -0,0 +1,139 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/namlabs/obsfly/backend/internal/store"
+)
+
+// ========== LOG METRIC HANDLERS ==========
+
+func (h *Handler) ListLogMetrics(w http.ResponseWriter, r *http.Request) {
+	accountId, _ := getQueryParams(r)
+
+	metrics, err := h.store.ListLogMetrics(r.Context(), accountId)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	states, err := h.store.GetLogMetricStates(r.Context(), accountId)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for i := range metrics {
+		if st, ok := states[metrics[i].MetricId]; ok {
+			metrics[i].Status = &st
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(metrics)
+}
+
+func (h *Handler) GetLogMetric(w http.ResponseWriter, r *http.Request) {
+	metricId := chi.URLParam(r, "metricId")
+	accountId, _ := getQueryParams(r)
+
+	metric, err := h.store.GetLogMetric(r.Context(), accountId, metricId)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "log metric not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	states, err := h.store.GetLogMetricStates(r.Context(), accountId)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if st, ok := states[metricId]; ok {
+		metric.Status = &st
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(metric)
+}
+
+func (h *Handler) CreateLogMetric(w http.ResponseWriter, r *http.Request) {
+	var metric store.LogMetric
+	if err := json.NewDecoder(r.Body).Decode(&metric); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Set default account if not provided
+	if metric.AccountId == 0 {
+		metric.AccountId = 1
+	}
+	metric.MetricId = generateUUID()
+
+	h.saveLogMetric(w, r, &metric)
+}
+
+func (h *Handler) UpdateLogMetric(w http.ResponseWriter, r *http.Request) {
+	metricId := chi.URLParam(r, "metricId")
+
+	var metric store.LogMetric
+	if err := json.NewDecoder(r.Body).Decode(&metric); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	metric.MetricId = metricId
+
+	// Set default account if not provided
+	if metric.AccountId == 0 {
+		metric.AccountId = 1
+	}
+
+	// Keep the original creation time
+	existing, err := h.store.GetLogMetric(r.Context(), metric.AccountId, metricId)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "log metric not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	metric.CreatedAt = existing.CreatedAt
+
+	h.saveLogMetric(w, r, &metric)
+}
+
+func (h *Handler) saveLogMetric(w http.ResponseWriter, r *http.Request, metric *store.LogMetric) {
+	metric.Status = nil
+	if err := metric.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.store.SaveLogMetric(r.Context(), metric); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(metric)
+}
+
+func (h *Handler) DeleteLogMetric(w http.ResponseWriter, r *http.Request) {
+	metricId := chi.URLParam(r, "metricId")
+	accountId, _ := getQueryParams(r)
+
+	if err := h.store.DeleteLogMetric(r.Context(), accountId, metricId); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/namlabs/obsfly/backend/internal/store"
+)
+
+// Bounds for the lines returned on each side of a log
+const (
+	defaultContextLines = 20
+	maxContextLines     = 500
+)
+
+// ========== LOG CONTEXT HANDLERS ==========
+
+// GetLogContext returns the lines around a log from the same container, pod
+// or host. The log is identified like in /api/logs/detail, by id. lines sets
+// how many lines to return on each side; before and after set them
+// separately.
+func (h *Handler) GetLogContext(w http.ResponseWriter, r *http.Request) {
+	accountId, _ := getQueryParams(r)
+	q := r.URL.Query()
+
+	ref, err := getLogRef(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	lines, ok := contextLines(w, q.Get("lines"), "lines", defaultContextLines)
+	if !ok {
+		return
+	}
+	before, ok := contextLines(w, q.Get("before"), "before", lines)
+	if !ok {
+		return
+	}
+	after, ok := contextLines(w, q.Get("after"), "after", lines)
+	if !ok {
+		return
+	}
+
+	data, err := h.store.GetLogContext(r.Context(), store.LogContextRequest{
+		AccountId: accountId,
+		Log:       ref,
+		Before:    before,
+		After:     after,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "log not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(data)
+}
+
+// contextLines parses a line count, writing a 400 when it is out of range
+func contextLines(w http.ResponseWriter, value, name string, fallback int) (int, bool) {
+	if value == "" {
+		return fallback, true
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed < 0 || parsed > maxContextLines {
+		http.Error(w, fmt.Sprintf("%s must be between 0 and %d", name, maxContextLines), http.StatusBadRequest)
+		return 0, false
+	}
+	return parsed, true
+}
@@ -0,0 +1,109 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/namlabs/obsfly/backend/internal/store"
+)
+
+// ========== LOG FACET HANDLERS ==========
+
+// maxLogHistogramBuckets bounds the buckets of a log histogram
+const maxLogHistogramBuckets = 1000
+
+// GetLogFacets returns the top values and estimated cardinality of log
+// fields under the same filters as /api/logs. fields takes a
+// comma-separated list of service, host, severity, env, namespace, pod,
+// container, source and attributes.<key>, defaulting to service, host,
+// severity, namespace and pod. The attribute_keys most common LogAttributes
+// keys are added (default 10, up to 50, 0 for none); limit sets the values
+// per field (default 10, up to 100).
+func (h *Handler) GetLogFacets(w http.ResponseWriter, r *http.Request) {
+	accountId, minutesAgo := getQueryParams(r)
+	q := r.URL.Query()
+
+	filter, err := getLogsFilter(r, accountId, minutesAgo)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	req := store.LogFacetsRequest{
+		LogsListRequest: filter,
+		Fields:          splitParam(q.Get("fields")),
+		Limit:           10,
+		AttributeKeys:   10,
+	}
+	for _, field := range req.Fields {
+		if err := store.ValidateLogFacetField(field); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if l := q.Get("limit"); l != "" {
+		if parsed, err := strconv.Atoi(l); err == nil && parsed > 0 && parsed <= 100 {
+			req.Limit = parsed
+		}
+	}
+	if k := q.Get("attribute_keys"); k != "" {
+		if parsed, err := strconv.Atoi(k); err == nil && parsed >= 0 && parsed <= 50 {
+			req.AttributeKeys = parsed
+		}
+	}
+
+	data, err := h.store.GetLogFacets(r.Context(), req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(data)
+}
+
+// GetLogHistogram returns the counts of logs per severity and time bucket,
+// under the same filters as /api/logs. step sets the bucket width in
+// seconds; without it the width is picked to make about buckets buckets
+// (default 60, up to 500).
+func (h *Handler) GetLogHistogram(w http.ResponseWriter, r *http.Request) {
+	accountId, minutesAgo := getQueryParams(r)
+	q := r.URL.Query()
+
+	req, err := getLogsFilter(r, accountId, minutesAgo)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	buckets := 60
+	if b := q.Get("buckets"); b != "" {
+		if parsed, err := strconv.Atoi(b); err == nil && parsed > 0 && parsed <= 500 {
+			buckets = parsed
+		}
+	}
+	step := store.LogHistogramStep(minutesAgo, buckets)
+	if st := q.Get("step"); st != "" {
+		parsed, err := strconv.Atoi(st)
+		if err != nil || parsed <= 0 {
+			http.Error(w, "step must be a positive number of seconds", http.StatusBadRequest)
+			return
+		}
+		if minutesAgo*60/parsed > maxLogHistogramBuckets {
+			http.Error(w, fmt.Sprintf("step is too small for the time range; at most %d buckets are allowed", maxLogHistogramBuckets), http.StatusBadRequest)
+			return
+		}
+		step = parsed
+	}
+
+	data, err := h.store.GetLogHistogram(r.Context(), req, step)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(data)
+}
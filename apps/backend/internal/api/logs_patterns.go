@@ -0,0 +1,79 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/namlabs/obsfly/backend/internal/store"
+)
+
+// ========== LOG PATTERN HANDLERS ==========
+
+// GetTopLogPatterns returns the patterns logged most in the time range, with
+// their template, examples, trend and change from the previous range. It
+// takes the same filters as /api/logs, plus limit (default 20, up to 200).
+func (h *Handler) GetTopLogPatterns(w http.ResponseWriter, r *http.Request) {
+	accountId, minutesAgo := getQueryParams(r)
+
+	limit := 20
+	if l := r.URL.Query().Get("limit"); l != "" {
+		if parsed, err := strconv.Atoi(l); err == nil && parsed > 0 && parsed <= 200 {
+			limit = parsed
+		}
+	}
+
+	req, err := getLogsFilter(r, accountId, minutesAgo)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	data, err := h.store.GetTopLogPatterns(r.Context(), req, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(data)
+}
+
+// GetLogAnomalies returns the log pattern anomalies detected in the time
+// range, newest first: patterns new to a service or version, and spikes and
+// drops in how often patterns are logged. service and kind take
+// comma-separated lists; limit defaults to 100, up to 1000.
+func (h *Handler) GetLogAnomalies(w http.ResponseWriter, r *http.Request) {
+	accountId, minutesAgo := getQueryParams(r)
+	q := r.URL.Query()
+
+	filter := store.LogAnomalyFilter{
+		AccountId: accountId,
+		From:      time.Now().Add(-time.Duration(minutesAgo) * time.Minute),
+		Services:  splitParam(q.Get("service")),
+		Kinds:     splitParam(q.Get("kind")),
+		Limit:     100,
+	}
+	for _, kind := range filter.Kinds {
+		if !store.ValidLogAnomalyKind(kind) {
+			http.Error(w, fmt.Sprintf("unknown anomaly kind %q", kind), http.StatusBadRequest)
+			return
+		}
+	}
+	if l := q.Get("limit"); l != "" {
+		if parsed, err := strconv.Atoi(l); err == nil && parsed > 0 && parsed <= 1000 {
+			filter.Limit = parsed
+		}
+	}
+
+	data, err := h.store.GetLogPatternAnomalies(r.Context(), filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(data)
+}
@@ -0,0 +1,187 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/namlabs/obsfly/backend/internal/pipelines"
+	"github.com/namlabs/obsfly/backend/internal/store"
+)
+
+// maxPipelineSamples bounds the samples of a pipeline test
+const maxPipelineSamples = 100
+
+// ========== LOG PIPELINE HANDLERS ==========
+
+func (h *Handler) ListLogPipelines(w http.ResponseWriter, r *http.Request) {
+	accountId, _ := getQueryParams(r)
+
+	data, err := h.store.ListLogPipelines(r.Context(), accountId)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(data)
+}
+
+func (h *Handler) GetLogPipeline(w http.ResponseWriter, r *http.Request) {
+	pipelineId := chi.URLParam(r, "pipelineId")
+	accountId, _ := getQueryParams(r)
+
+	pipeline, err := h.store.GetLogPipeline(r.Context(), accountId, pipelineId)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "log pipeline not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pipeline)
+}
+
+func (h *Handler) CreateLogPipeline(w http.ResponseWriter, r *http.Request) {
+	var pipeline store.LogPipeline
+	if err := json.NewDecoder(r.Body).Decode(&pipeline); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Set default account if not provided
+	if pipeline.AccountId == 0 {
+		pipeline.AccountId = 1
+	}
+	pipeline.PipelineId = generateUUID()
+
+	h.saveLogPipeline(w, r, &pipeline)
+}
+
+func (h *Handler) UpdateLogPipeline(w http.ResponseWriter, r *http.Request) {
+	pipelineId := chi.URLParam(r, "pipelineId")
+
+	var pipeline store.LogPipeline
+	if err := json.NewDecoder(r.Body).Decode(&pipeline); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	pipeline.PipelineId = pipelineId
+
+	// Set default account if not provided
+	if pipeline.AccountId == 0 {
+		pipeline.AccountId = 1
+	}
+
+	// Keep the original creation time
+	existing, err := h.store.GetLogPipeline(r.Context(), pipeline.AccountId, pipelineId)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "log pipeline not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	pipeline.CreatedAt = existing.CreatedAt
+
+	h.saveLogPipeline(w, r, &pipeline)
+}
+
+func (h *Handler) saveLogPipeline(w http.ResponseWriter, r *http.Request, pipeline *store.LogPipeline) {
+	if _, err := pipelines.Compile(*pipeline); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.store.SaveLogPipeline(r.Context(), pipeline); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pipeline)
+}
+
+func (h *Handler) DeleteLogPipeline(w http.ResponseWriter, r *http.Request) {
+	pipelineId := chi.URLParam(r, "pipelineId")
+	accountId, _ := getQueryParams(r)
+
+	if err := h.store.DeleteLogPipeline(r.Context(), accountId, pipelineId); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// logPipelineTestRequest carries the samples to test a pipeline on, and the
+// pipeline itself when testing one that is not saved
+type logPipelineTestRequest struct {
+	Pipeline *store.LogPipeline `json:"pipeline"`
+	Samples  []pipelines.Sample `json:"samples"`
+}
+
+// TestLogPipeline runs the pipeline in the request on sample logs and
+// returns what each step made of them. Nothing is stored.
+func (h *Handler) TestLogPipeline(w http.ResponseWriter, r *http.Request) {
+	var req logPipelineTestRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Pipeline == nil {
+		http.Error(w, "pipeline is required", http.StatusBadRequest)
+		return
+	}
+	h.testLogPipeline(w, *req.Pipeline, req.Samples)
+}
+
+// TestSavedLogPipeline runs a saved pipeline on sample logs
+func (h *Handler) TestSavedLogPipeline(w http.ResponseWriter, r *http.Request) {
+	pipelineId := chi.URLParam(r, "pipelineId")
+	accountId, _ := getQueryParams(r)
+
+	var req logPipelineTestRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	pipeline, err := h.store.GetLogPipeline(r.Context(), accountId, pipelineId)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "log pipeline not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.testLogPipeline(w, *pipeline, req.Samples)
+}
+
+func (h *Handler) testLogPipeline(w http.ResponseWriter, config store.LogPipeline, samples []pipelines.Sample) {
+	if len(samples) == 0 {
+		http.Error(w, "at least one sample is required", http.StatusBadRequest)
+		return
+	}
+	if len(samples) > maxPipelineSamples {
+		http.Error(w, "too many samples", http.StatusBadRequest)
+		return
+	}
+
+	pipeline, err := pipelines.Compile(config)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"results": pipeline.Test(samples),
+	})
+}
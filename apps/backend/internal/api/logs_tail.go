@@ -0,0 +1,110 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/namlabs/obsfly/backend/internal/logtail"
+)
+
+// maxTailsPerAccount bounds the live tails an account can have open
+const maxTailsPerAccount = 10
+
+// ========== LOG TAIL HANDLERS ==========
+
+// TailLogs streams newly ingested logs as Server-Sent Events. It takes the
+// same filters as /api/logs, plus rate (lines per second sent, up to 1000)
+// and sample_threshold (matching lines per second above which lines are
+// sampled). Each log event carries its timestamp as the event ID, so a
+// reconnecting EventSource resumes where it left off; since= does the same
+// for other clients. A stats event every few seconds reports sampling and
+// dropped lines and keeps the connection alive.
+func (h *Handler) TailLogs(w http.ResponseWriter, r *http.Request) {
+	accountId, _ := getQueryParams(r)
+	q := r.URL.Query()
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	filter, err := getLogsFilter(r, accountId, 0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var opts logtail.Options
+	if v := q.Get("rate"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed <= 0 || parsed > logtail.MaxRate {
+			http.Error(w, fmt.Sprintf("rate must be between 1 and %d", logtail.MaxRate), http.StatusBadRequest)
+			return
+		}
+		opts.MaxRate = parsed
+	}
+	if v := q.Get("sample_threshold"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed <= 0 {
+			http.Error(w, "sample_threshold must be a positive number", http.StatusBadRequest)
+			return
+		}
+		opts.SampleThreshold = parsed
+	}
+
+	var start time.Time
+	resume := r.Header.Get("Last-Event-ID")
+	if resume == "" {
+		resume = q.Get("since")
+	}
+	if resume != "" {
+		parsed, err := time.Parse(time.RFC3339Nano, resume)
+		if err != nil {
+			http.Error(w, "since must be an RFC 3339 timestamp", http.StatusBadRequest)
+			return
+		}
+		start = parsed
+	}
+
+	if !h.logTails.Acquire(accountId) {
+		http.Error(w, fmt.Sprintf("at most %d live tails can be open per account", maxTailsPerAccount), http.StatusTooManyRequests)
+		return
+	}
+	defer h.logTails.Release(accountId)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // stop proxies from buffering the stream
+	fmt.Fprint(w, "retry: 3000\n\n")
+	flusher.Flush()
+
+	tail := logtail.New(h.store, filter, start, opts)
+	err = tail.Run(r.Context(), func(event logtail.Event) error {
+		var payload interface{} = event.Stats
+		if event.Log != nil {
+			payload = event.Log
+			if _, err := fmt.Fprintf(w, "id: %s\n", event.Log.Timestamp.Format(time.RFC3339Nano)); err != nil {
+				return err
+			}
+		}
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	})
+	if err != nil && r.Context().Err() == nil {
+		data, _ := json.Marshal(map[string]string{"error": err.Error()})
+		fmt.Fprintf(w, "event: error\ndata: %s\n\n", data)
+		flusher.Flush()
+	}
+}
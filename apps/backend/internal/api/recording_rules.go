@@ -0,0 +1,165 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/namlabs/obsfly/backend/internal/store"
+)
+
+// ========== RECORDING RULE HANDLERS ==========
+
+func (h *Handler) ListRecordingRules(w http.ResponseWriter, r *http.Request) {
+	accountId, _ := getQueryParams(r)
+
+	rules, err := h.store.ListRecordingRules(r.Context(), accountId)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	statuses, err := h.store.GetRecordingRuleStatuses(r.Context(), accountId)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for i := range rules {
+		rules[i].Status = recordingRuleStatus(statuses, &rules[i])
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rules)
+}
+
+func (h *Handler) GetRecordingRule(w http.ResponseWriter, r *http.Request) {
+	ruleId := chi.URLParam(r, "ruleId")
+	accountId, _ := getQueryParams(r)
+
+	rule, err := h.store.GetRecordingRule(r.Context(), accountId, ruleId)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "recording rule not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	statuses, err := h.store.GetRecordingRuleStatuses(r.Context(), accountId)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	rule.Status = recordingRuleStatus(statuses, rule)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rule)
+}
+
+func (h *Handler) CreateRecordingRule(w http.ResponseWriter, r *http.Request) {
+	var rule store.RecordingRule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Set default account if not provided
+	if rule.AccountId == 0 {
+		rule.AccountId = 1
+	}
+	rule.RuleId = generateUUID()
+
+	h.saveRecordingRule(w, r, &rule)
+}
+
+func (h *Handler) UpdateRecordingRule(w http.ResponseWriter, r *http.Request) {
+	ruleId := chi.URLParam(r, "ruleId")
+
+	var rule store.RecordingRule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	rule.RuleId = ruleId
+
+	// Set default account if not provided
+	if rule.AccountId == 0 {
+		rule.AccountId = 1
+	}
+
+	// Keep the original creation time
+	existing, err := h.store.GetRecordingRule(r.Context(), rule.AccountId, ruleId)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "recording rule not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	rule.CreatedAt = existing.CreatedAt
+
+	h.saveRecordingRule(w, r, &rule)
+}
+
+func (h *Handler) saveRecordingRule(w http.ResponseWriter, r *http.Request, rule *store.RecordingRule) {
+	if err := rule.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.store.SaveRecordingRule(r.Context(), rule); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rule)
+}
+
+func (h *Handler) DeleteRecordingRule(w http.ResponseWriter, r *http.Request) {
+	ruleId := chi.URLParam(r, "ruleId")
+	accountId, _ := getQueryParams(r)
+
+	err := h.store.DeleteRecordingRule(r.Context(), accountId, ruleId)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) GetRecordingRuleEvaluations(w http.ResponseWriter, r *http.Request) {
+	ruleId := chi.URLParam(r, "ruleId")
+	accountId, _ := getQueryParams(r)
+
+	limit := 100
+	if l := r.URL.Query().Get("limit"); l != "" {
+		if parsed, err := strconv.Atoi(l); err == nil && parsed > 0 && parsed <= 1000 {
+			limit = parsed
+		}
+	}
+
+	data, err := h.store.GetRecordingRuleEvaluations(r.Context(), accountId, ruleId, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(data)
+}
+
+// recordingRuleStatus returns the rule's status, or a pending status for
+// rules that have not been evaluated yet
+func recordingRuleStatus(statuses map[string]store.RecordingRuleStatus, rule *store.RecordingRule) *store.RecordingRuleStatus {
+	if st, ok := statuses[rule.RuleId]; ok {
+		return &st
+	}
+	return &store.RecordingRuleStatus{Health: "pending"}
+}
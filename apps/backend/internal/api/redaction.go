@@ -0,0 +1,70 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/namlabs/obsfly/backend/internal/redaction"
+	"github.com/namlabs/obsfly/backend/internal/store"
+)
+
+// ========== REDACTION HANDLERS ==========
+
+func (h *Handler) GetRedactionConfig(w http.ResponseWriter, r *http.Request) {
+	accountId, _ := getQueryParams(r)
+
+	cfg, err := h.store.GetRedactionConfig(r.Context(), accountId)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cfg)
+}
+
+func (h *Handler) UpdateRedactionConfig(w http.ResponseWriter, r *http.Request) {
+	var cfg store.RedactionConfig
+	if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Set default account if not provided
+	if cfg.AccountId == 0 {
+		cfg.AccountId = 1
+	}
+	if cfg.Mode == "" {
+		cfg.Mode = store.RedactionMask
+	}
+	if cfg.Rules == nil {
+		cfg.Rules = []store.RedactionRule{}
+	}
+
+	if _, err := redaction.Compile(cfg, nil); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.store.SaveRedactionConfig(r.Context(), &cfg); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cfg)
+}
+
+// GetRedactionCounts returns how many values each rule redacted from each
+// service over the last minutes
+func (h *Handler) GetRedactionCounts(w http.ResponseWriter, r *http.Request) {
+	accountId, minutes := getQueryParams(r)
+	services := splitParam(r.URL.Query().Get("service"))
+
+	data, err := h.store.GetRedactionCounts(r.Context(), accountId, minutes, services)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(data)
+}
@@ -0,0 +1,278 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/namlabs/obsfly/backend/internal/reports"
+	"github.com/namlabs/obsfly/backend/internal/store"
+)
+
+// ========== REPORT SCHEDULE HANDLERS ==========
+
+func (h *Handler) ListReports(w http.ResponseWriter, r *http.Request) {
+	accountId, _ := getQueryParams(r)
+
+	data, err := h.store.ListReportSchedules(r.Context(), accountId)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	data, ok := h.filterReports(w, r, accountId, data)
+	if !ok {
+		return
+	}
+
+	statuses, err := h.store.GetReportStatuses(r.Context(), accountId)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for i := range data {
+		data[i].Status = reportStatus(statuses, &data[i])
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(data)
+}
+
+func (h *Handler) GetReport(w http.ResponseWriter, r *http.Request) {
+	reportId := chi.URLParam(r, "reportId")
+	accountId, _ := getQueryParams(r)
+
+	report, ok := h.authorizeReport(w, r, accountId, reportId, store.DashboardViewer)
+	if !ok {
+		return
+	}
+
+	statuses, err := h.store.GetReportStatuses(r.Context(), accountId)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	report.Status = reportStatus(statuses, report)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+func (h *Handler) CreateReport(w http.ResponseWriter, r *http.Request) {
+	var report store.ReportSchedule
+	if err := json.NewDecoder(r.Body).Decode(&report); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Set default account if not provided
+	if report.AccountId == 0 {
+		report.AccountId = 1
+	}
+	report.ReportId = generateUUID()
+	report.CreatedBy = requestAuthor(r)
+
+	h.saveReport(w, r, &report)
+}
+
+func (h *Handler) UpdateReport(w http.ResponseWriter, r *http.Request) {
+	reportId := chi.URLParam(r, "reportId")
+
+	var report store.ReportSchedule
+	if err := json.NewDecoder(r.Body).Decode(&report); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	report.ReportId = reportId
+
+	// Set default account if not provided
+	if report.AccountId == 0 {
+		report.AccountId = 1
+	}
+
+	// Changing a report needs edit access to the dashboard it sends; the
+	// dashboard it is moved to is checked in saveReport
+	existing, ok := h.authorizeReport(w, r, report.AccountId, reportId, store.DashboardEditor)
+	if !ok {
+		return
+	}
+
+	// Keep the original creation time and author
+	report.CreatedAt = existing.CreatedAt
+	report.CreatedBy = existing.CreatedBy
+
+	h.saveReport(w, r, &report)
+}
+
+func (h *Handler) saveReport(w http.ResponseWriter, r *http.Request, report *store.ReportSchedule) {
+	if err := reports.Validate(report); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Reports send the dashboard's data out, so they need view access
+	if _, _, ok := h.authorizeDashboard(w, r, report.AccountId, report.DashboardId, store.DashboardViewer); !ok {
+		return
+	}
+
+	if err := h.store.SaveReportSchedule(r.Context(), report); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	report.Status = &store.ReportStatus{Health: "pending", NextRunAt: reports.NextRun(report, time.Now())}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+func (h *Handler) DeleteReport(w http.ResponseWriter, r *http.Request) {
+	reportId := chi.URLParam(r, "reportId")
+	accountId, _ := getQueryParams(r)
+
+	if _, ok := h.authorizeReport(w, r, accountId, reportId, store.DashboardEditor); !ok {
+		return
+	}
+
+	if err := h.store.DeleteReportSchedule(r.Context(), accountId, reportId); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetReportRuns returns the run history of a report, newest first
+func (h *Handler) GetReportRuns(w http.ResponseWriter, r *http.Request) {
+	reportId := chi.URLParam(r, "reportId")
+	accountId, _ := getQueryParams(r)
+
+	limit := 100
+	if l := r.URL.Query().Get("limit"); l != "" {
+		if parsed, err := strconv.Atoi(l); err == nil && parsed > 0 && parsed <= 1000 {
+			limit = parsed
+		}
+	}
+
+	if _, ok := h.authorizeReport(w, r, accountId, reportId, store.DashboardViewer); !ok {
+		return
+	}
+
+	data, err := h.store.GetReportRuns(r.Context(), accountId, reportId, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(data)
+}
+
+// RunReport renders and delivers a report right away. The run is recorded
+// in the history like scheduled runs; a failed run is returned with 502.
+func (h *Handler) RunReport(w http.ResponseWriter, r *http.Request) {
+	reportId := chi.URLParam(r, "reportId")
+	accountId, _ := getQueryParams(r)
+
+	report, ok := h.authorizeReport(w, r, accountId, reportId, store.DashboardViewer)
+	if !ok {
+		return
+	}
+
+	run := h.reportRunner.Run(r.Context(), report, store.ReportTriggerManual, time.Now())
+
+	w.Header().Set("Content-Type", "application/json")
+	if !run.Success {
+		w.WriteHeader(http.StatusBadGateway)
+	}
+	json.NewEncoder(w).Encode(run)
+}
+
+// PreviewReport renders a report and returns the file without delivering
+// it. format overrides the report's format.
+func (h *Handler) PreviewReport(w http.ResponseWriter, r *http.Request) {
+	reportId := chi.URLParam(r, "reportId")
+	accountId, _ := getQueryParams(r)
+
+	report, ok := h.authorizeReport(w, r, accountId, reportId, store.DashboardViewer)
+	if !ok {
+		return
+	}
+	if format := r.URL.Query().Get("format"); format != "" {
+		report.Format = format
+		if err := report.Validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	artifact, _, _, err := h.reportRunner.Render(r.Context(), report, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", artifact.ContentType)
+	w.Header().Set("Content-Disposition", "inline; filename=\""+artifact.Filename+"\"")
+	w.Write(artifact.Data)
+}
+
+// authorizeReport loads a report and checks the requesting user has at
+// least the wanted level on its dashboard
+func (h *Handler) authorizeReport(w http.ResponseWriter, r *http.Request, accountId uint64, reportId, want string) (*store.ReportSchedule, bool) {
+	report, err := h.store.GetReportSchedule(r.Context(), accountId, reportId)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "report not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	if _, _, ok := h.authorizeDashboard(w, r, accountId, report.DashboardId, want); !ok {
+		return nil, false
+	}
+	return report, true
+}
+
+// filterReports keeps the reports of dashboards the requesting user can view
+func (h *Handler) filterReports(w http.ResponseWriter, r *http.Request, accountId uint64, schedules []store.ReportSchedule) ([]store.ReportSchedule, bool) {
+	dashboards, err := h.store.ListDashboards(r.Context(), accountId)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	dashboards, ok := h.filterDashboards(w, r, accountId, dashboards)
+	if !ok {
+		return nil, false
+	}
+	visible := make(map[string]bool, len(dashboards))
+	for _, d := range dashboards {
+		visible[d.DashboardId] = true
+	}
+
+	filtered := make([]store.ReportSchedule, 0, len(schedules))
+	for _, report := range schedules {
+		if visible[report.DashboardId] {
+			filtered = append(filtered, report)
+		}
+	}
+	return filtered, true
+}
+
+// reportStatus returns the report's status with its next run, or a pending
+// status for reports that have not run yet
+func reportStatus(statuses map[string]store.ReportStatus, report *store.ReportSchedule) *store.ReportStatus {
+	st, ok := statuses[report.ReportId]
+	if !ok {
+		st = store.ReportStatus{Health: "pending"}
+	}
+	if !report.Paused {
+		base := time.Now()
+		if st.LastScheduledFor.After(base) {
+			base = st.LastScheduledFor
+		}
+		st.NextRunAt = reports.NextRun(report, base)
+	}
+	return &st
+}
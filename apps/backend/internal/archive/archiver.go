@@ -0,0 +1,188 @@
+package archive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/namlabs/obsfly/backend/internal/store"
+)
+
+// rehydrationTimeout bounds how long loading a rehydration may take
+const rehydrationTimeout = time.Hour
+
+var (
+	// ErrNotConfigured is returned when no archive bucket is configured
+	ErrNotConfigured = errors.New("log archiving is not configured")
+	// ErrDayNotEnded is returned when archiving a day that has not ended
+	ErrDayNotEnded = errors.New("only days that have ended can be archived")
+)
+
+// Archiver periodically exports each account's logs of complete days to
+// object storage before their retention removes them, and drops
+// rehydrated logs once they expire. Days are recorded in the archive
+// catalog once exported, so they are not exported twice.
+type Archiver struct {
+	store *store.Store
+	cfg   Config
+	tick  time.Duration
+}
+
+func NewArchiver(st *store.Store, cfg Config, tick time.Duration) *Archiver {
+	return &Archiver{
+		store: st,
+		cfg:   cfg,
+		tick:  tick,
+	}
+}
+
+// Enabled reports whether the archiver has a bucket to write to
+func (a *Archiver) Enabled() bool {
+	return a.cfg.Enabled()
+}
+
+func (a *Archiver) Start(ctx context.Context) {
+	ticker := time.NewTicker(a.tick)
+	defer ticker.Stop()
+
+	fmt.Printf("Starting log archiver (tick %s)\n", a.tick)
+
+	a.run(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			fmt.Println("Log archiver shutting down")
+			return
+		case <-ticker.C:
+			a.run(ctx)
+		}
+	}
+}
+
+func (a *Archiver) run(ctx context.Context) {
+	if err := a.archivePending(ctx); err != nil {
+		fmt.Printf("Error archiving logs: %v\n", err)
+	}
+	if err := a.expireRehydrations(ctx); err != nil {
+		fmt.Printf("Error expiring log rehydrations: %v\n", err)
+	}
+}
+
+// archivePending archives the days of the lookback window that have ended
+// and have not been archived yet
+func (a *Archiver) archivePending(ctx context.Context) error {
+	end := lastArchivableDay(time.Now(), a.cfg.Delay).AddDate(0, 0, 1)
+	start := end.AddDate(0, 0, -a.cfg.Lookback)
+	days, err := a.store.GetPendingLogArchiveDays(ctx, start, end)
+	if err != nil {
+		return err
+	}
+	for _, d := range days {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		archives, err := a.store.ArchiveLogDay(ctx, a.cfg.Target, a.cfg.Format, d.AccountId, d.Date)
+		if err != nil {
+			fmt.Printf("Error archiving logs of account %d on %s: %v\n", d.AccountId, d.Date.Format("2006-01-02"), err)
+			continue
+		}
+		var rows uint64
+		for _, archived := range archives {
+			rows += archived.Rows
+		}
+		fmt.Printf("Archived %d logs of account %d on %s\n", rows, d.AccountId, d.Date.Format("2006-01-02"))
+	}
+	return nil
+}
+
+// lastArchivableDay returns the last UTC day that ended at least delay ago
+func lastArchivableDay(now time.Time, delay time.Duration) time.Time {
+	t := now.UTC().Add(-delay)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, -1)
+}
+
+// ArchiveDay archives an account's logs of a UTC day now, replacing any
+// earlier archive of it. The day must have ended.
+func (a *Archiver) ArchiveDay(ctx context.Context, accountId uint64, day time.Time) ([]store.LogArchive, error) {
+	if !a.Enabled() {
+		return nil, ErrNotConfigured
+	}
+	if day.After(lastArchivableDay(time.Now(), 0)) {
+		return nil, ErrDayNotEnded
+	}
+	return a.store.ArchiveLogDay(ctx, a.cfg.Target, a.cfg.Format, accountId, day)
+}
+
+// Rehydrate saves a new rehydration and loads its logs from the archives
+// in the background. Its status turns ready or failed once loaded.
+func (a *Archiver) Rehydrate(ctx context.Context, r *store.LogRehydration) error {
+	if !a.Enabled() {
+		return ErrNotConfigured
+	}
+	r.TableName = store.LogRehydrationTable(r.RehydrationId)
+	r.Status = store.LogRehydrationLoading
+	if err := a.store.SaveLogRehydration(ctx, r); err != nil {
+		return err
+	}
+
+	loading := *r
+	go a.load(&loading)
+	return nil
+}
+
+func (a *Archiver) load(r *store.LogRehydration) {
+	ctx, cancel := context.WithTimeout(context.Background(), rehydrationTimeout)
+	defer cancel()
+
+	rows, err := a.store.LoadLogRehydration(ctx, a.cfg.Target, r)
+
+	// Dropped while loading
+	if current, getErr := a.store.GetLogRehydration(ctx, r.AccountId, r.RehydrationId); getErr == nil && current.Status == store.LogRehydrationExpired {
+		if dropErr := a.store.DropLogRehydrationTable(ctx, r); dropErr != nil {
+			fmt.Printf("Error dropping rehydration table %s: %v\n", r.TableName, dropErr)
+		}
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error rehydrating logs for %s: %v\n", r.RehydrationId, err)
+		if dropErr := a.store.DropLogRehydrationTable(ctx, r); dropErr != nil {
+			fmt.Printf("Error dropping rehydration table %s: %v\n", r.TableName, dropErr)
+		}
+		r.Status = store.LogRehydrationFailed
+		r.Error = err.Error()
+	} else {
+		r.Status = store.LogRehydrationReady
+		r.Rows = rows
+	}
+	if err := a.store.SaveLogRehydration(ctx, r); err != nil {
+		fmt.Printf("Error saving rehydration %s: %v\n", r.RehydrationId, err)
+	}
+}
+
+// DropRehydration drops a rehydration's logs and marks it expired
+func (a *Archiver) DropRehydration(ctx context.Context, r *store.LogRehydration) error {
+	if err := a.store.DropLogRehydrationTable(ctx, r); err != nil {
+		return err
+	}
+	r.Status = store.LogRehydrationExpired
+	return a.store.SaveLogRehydration(ctx, r)
+}
+
+// expireRehydrations drops the rehydrations past their expiry
+func (a *Archiver) expireRehydrations(ctx context.Context) error {
+	expired, err := a.store.ListExpiredLogRehydrations(ctx)
+	if err != nil {
+		return err
+	}
+	for i := range expired {
+		r := &expired[i]
+		if r.Status == store.LogRehydrationLoading && time.Since(r.CreatedAt) < rehydrationTimeout {
+			continue // still being loaded; dropped on a later tick
+		}
+		if err := a.DropRehydration(ctx, r); err != nil {
+			fmt.Printf("Error expiring rehydration %s: %v\n", r.RehydrationId, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package archive
+
+import (
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/namlabs/obsfly/backend/internal/store"
+)
+
+// Config is where and how logs are archived
+type Config struct {
+	Target   store.LogArchiveTarget
+	Format   string        // store.LogArchiveNDJSON or store.LogArchiveParquet
+	Delay    time.Duration // how long after a day ends it is archived, for late logs
+	Lookback int           // days back to look for unarchived logs; keep below the retention
+}
+
+// ConfigFromEnv reads ARCHIVE_S3_URL, ARCHIVE_S3_ACCESS_KEY_ID,
+// ARCHIVE_S3_SECRET_ACCESS_KEY, ARCHIVE_FORMAT, ARCHIVE_DELAY and
+// ARCHIVE_LOOKBACK_DAYS
+func ConfigFromEnv() Config {
+	cfg := Config{
+		Target: store.LogArchiveTarget{
+			URL:             os.Getenv("ARCHIVE_S3_URL"),
+			AccessKeyId:     os.Getenv("ARCHIVE_S3_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("ARCHIVE_S3_SECRET_ACCESS_KEY"),
+		},
+		Format:   store.LogArchiveNDJSON,
+		Delay:    time.Hour,
+		Lookback: 7,
+	}
+	if format := os.Getenv("ARCHIVE_FORMAT"); store.ValidLogArchiveFormat(format) {
+		cfg.Format = format
+	}
+	if delay, err := time.ParseDuration(os.Getenv("ARCHIVE_DELAY")); err == nil && delay >= 0 {
+		cfg.Delay = delay
+	}
+	if days, err := strconv.Atoi(os.Getenv("ARCHIVE_LOOKBACK_DAYS")); err == nil && days > 0 {
+		cfg.Lookback = days
+	}
+	return cfg
+}
+
+// Enabled reports whether an archive bucket is configured
+func (c Config) Enabled() bool {
+	return c.Target.URL != ""
+}
@@ -6,19 +6,22 @@ import (
 	"math/rand"
 	"time"
 
+	"github.com/namlabs/obsfly/backend/internal/ingest"
 	"github.com/namlabs/obsfly/backend/internal/store"
 )
 
 type DataGenerator struct {
 	config *Config
 	store  *store.Store
+	ingest *ingest.Ingester
 	nodes  []Node
 }
 
-func NewDataGenerator(cfg *Config, st *store.Store) *DataGenerator {
+func NewDataGenerator(cfg *Config, st *store.Store, ing *ingest.Ingester) *DataGenerator {
 	return &DataGenerator{
 		config: cfg,
 		store:  st,
+		ingest: ing,
 		nodes:  GenerateNodes(cfg),
 	}
 }
@@ -69,12 +72,12 @@ func (dg *DataGenerator) generateBatch(ctx context.Context) error {
 		return fmt.Errorf("failed to insert metrics: %w", err)
 	}
 	if len(logs) > 0 {
-		if err := dg.store.InsertLogs(ctx, logs); err != nil {
+		if err := dg.ingest.Logs(ctx, logs); err != nil {
 			return fmt.Errorf("failed to insert logs: %w", err)
 		}
 	}
 	if len(traces) > 0 {
-		if err := dg.store.InsertTraces(ctx, traces); err != nil {
+		if err := dg.ingest.Traces(ctx, traces); err != nil {
 			return fmt.Errorf("failed to insert traces: %w", err)
 		}
 	}
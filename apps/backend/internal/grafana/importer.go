@@ -0,0 +1,627 @@
+// Package grafana converts Grafana dashboard JSON into obsfly dashboards.
+package grafana
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/namlabs/obsfly/backend/internal/store"
+)
+
+const (
+	gridColumns   = 24 // Grafana and obsfly share a 24 column grid
+	defaultWidth  = 12
+	defaultHeight = 8
+)
+
+var (
+	relativeTimePattern = regexp.MustCompile(`^(?:now-)?([1-9][0-9]*)([smhdwMy])$`)
+	intervalPattern     = regexp.MustCompile(`^[1-9][0-9]*[smh]$`)
+	variableNamePattern = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_]*$`)
+	labelValuesPattern  = regexp.MustCompile(`^label_values\(\s*(?:(.+?)\s*,\s*)?([a-zA-Z_][a-zA-Z0-9_]*)\s*\)$`)
+	metricsPattern      = regexp.MustCompile(`^metrics\((.*)\)$`)
+)
+
+// Result is a converted dashboard together with everything that could not
+// be translated exactly
+type Result struct {
+	Dashboard      *store.Dashboard   `json:"dashboard"`
+	ImportedPanels int                `json:"imported_panels"`
+	PartialPanels  []PanelReport      `json:"partial_panels"`
+	SkippedPanels  []PanelReport      `json:"skipped_panels"`
+	Warnings       []string           `json:"warnings"`
+	UnknownMetrics []store.FieldError `json:"unknown_metrics,omitempty"`
+}
+
+// PanelReport explains why a panel was only partially translated or skipped
+type PanelReport struct {
+	PanelId  int      `json:"panel_id"`
+	Title    string   `json:"title"`
+	Type     string   `json:"type"`
+	WidgetId string   `json:"widget_id,omitempty"`
+	Issues   []string `json:"issues"`
+}
+
+type grafanaDashboard struct {
+	Title       string         `json:"title"`
+	Description string         `json:"description"`
+	Tags        []string       `json:"tags"`
+	Refresh     interface{}    `json:"refresh"` // string, or false when disabled
+	Time        grafanaTime    `json:"time"`
+	Panels      []grafanaPanel `json:"panels"`
+	Rows        []interface{}  `json:"rows"` // pre-5.0 schema
+	Templating  struct {
+		List []grafanaVariable `json:"list"`
+	} `json:"templating"`
+}
+
+type grafanaTime struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type grafanaPanel struct {
+	Id          int                    `json:"id"`
+	Type        string                 `json:"type"`
+	Title       string                 `json:"title"`
+	Datasource  interface{}            `json:"datasource"`
+	GridPos     grafanaGridPos         `json:"gridPos"`
+	Targets     []grafanaTarget        `json:"targets"`
+	FieldConfig grafanaFieldConfig     `json:"fieldConfig"`
+	Options     map[string]interface{} `json:"options"`
+	TimeFrom    string                 `json:"timeFrom"`
+	Panels      []grafanaPanel         `json:"panels"` // collapsed rows
+
+	// Legacy graph and singlestat settings
+	Bars       bool            `json:"bars"`
+	Stack      bool            `json:"stack"`
+	Legend     *grafanaLegend  `json:"legend"`
+	Thresholds json.RawMessage `json:"thresholds"`
+	Format     string          `json:"format"`
+	Decimals   *int            `json:"decimals"`
+}
+
+type grafanaGridPos struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+	W int `json:"w"`
+	H int `json:"h"`
+}
+
+type grafanaTarget struct {
+	RefId        string      `json:"refId"`
+	Expr         string      `json:"expr"`
+	LegendFormat string      `json:"legendFormat"`
+	Hide         bool        `json:"hide"`
+	Datasource   interface{} `json:"datasource"`
+}
+
+type grafanaFieldConfig struct {
+	Defaults struct {
+		Unit       string                 `json:"unit"`
+		Decimals   *int                   `json:"decimals"`
+		Min        *float64               `json:"min"`
+		Max        *float64               `json:"max"`
+		Thresholds *grafanaThresholds     `json:"thresholds"`
+		Color      map[string]interface{} `json:"color"`
+		Custom     map[string]interface{} `json:"custom"`
+	} `json:"defaults"`
+}
+
+type grafanaThresholds struct {
+	Mode  string `json:"mode"`
+	Steps []struct {
+		Color string   `json:"color"`
+		Value *float64 `json:"value"` // null for the base step
+	} `json:"steps"`
+}
+
+type grafanaLegend struct {
+	Show bool `json:"show"`
+}
+
+type grafanaVariable struct {
+	Name    string      `json:"name"`
+	Label   string      `json:"label"`
+	Type    string      `json:"type"`
+	Query   interface{} `json:"query"` // string, or an object with a query field
+	Current struct {
+		Value interface{} `json:"value"` // string or []string
+	} `json:"current"`
+	Multi      bool   `json:"multi"`
+	IncludeAll bool   `json:"includeAll"`
+	AllValue   string `json:"allValue"`
+}
+
+// Convert translates a Grafana dashboard, either bare or wrapped as
+// {"dashboard": ...} like the Grafana HTTP API returns it
+func Convert(data []byte) (*Result, error) {
+	var wrapper struct {
+		Dashboard *grafanaDashboard `json:"dashboard"`
+	}
+	var gd grafanaDashboard
+	if err := json.Unmarshal(data, &wrapper); err == nil && wrapper.Dashboard != nil {
+		gd = *wrapper.Dashboard
+	} else if err := json.Unmarshal(data, &gd); err != nil {
+		return nil, fmt.Errorf("invalid Grafana dashboard JSON: %w", err)
+	}
+
+	if len(gd.Panels) == 0 && len(gd.Rows) > 0 {
+		return nil, fmt.Errorf("the pre-5.0 rows layout is not supported, re-export the dashboard from a current Grafana version")
+	}
+
+	result := &Result{
+		Dashboard: &store.Dashboard{
+			Name:        gd.Title,
+			Description: gd.Description,
+			Tags:        gd.Tags,
+			Widgets:     []store.DashboardWidget{},
+		},
+		PartialPanels: []PanelReport{},
+		SkippedPanels: []PanelReport{},
+		Warnings:      []string{},
+	}
+	if result.Dashboard.Name == "" {
+		result.Dashboard.Name = "Imported Grafana dashboard"
+	}
+
+	variables := make(map[string]bool)
+	for _, gv := range gd.Templating.List {
+		if v, ok := convertVariable(gv, result); ok {
+			result.Dashboard.Variables = append(result.Dashboard.Variables, v)
+			variables[v.Name] = true
+		}
+	}
+
+	timeConfig := store.TimeConfig{}
+	if tr, ok := relativeTimeRange(gd.Time.From); ok && (gd.Time.To == "" || gd.Time.To == "now") {
+		timeConfig.TimeRange = tr
+	} else if gd.Time.From != "" {
+		result.Warnings = append(result.Warnings, fmt.Sprintf("dashboard time range %s to %s is not relative to now, widgets use the default range", gd.Time.From, gd.Time.To))
+	}
+	if refresh, ok := gd.Refresh.(string); ok && refresh != "" {
+		if intervalPattern.MatchString(refresh) {
+			timeConfig.RefreshInterval = refresh
+		} else {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("refresh interval %q is not supported", refresh))
+		}
+	}
+
+	widgetIds := make(map[string]bool)
+	for _, panel := range flattenPanels(gd.Panels) {
+		convertPanel(panel, timeConfig, variables, widgetIds, result)
+	}
+	resolveOverlaps(result.Dashboard.Widgets)
+
+	return result, nil
+}
+
+// flattenPanels lifts panels out of collapsed rows and drops the rows
+func flattenPanels(panels []grafanaPanel) []grafanaPanel {
+	var flat []grafanaPanel
+	for _, p := range panels {
+		if p.Type == "row" {
+			flat = append(flat, flattenPanels(p.Panels)...)
+			continue
+		}
+		flat = append(flat, p)
+	}
+	sort.SliceStable(flat, func(i, j int) bool {
+		if flat[i].GridPos.Y != flat[j].GridPos.Y {
+			return flat[i].GridPos.Y < flat[j].GridPos.Y
+		}
+		return flat[i].GridPos.X < flat[j].GridPos.X
+	})
+	return flat
+}
+
+func convertPanel(panel grafanaPanel, timeConfig store.TimeConfig, variables map[string]bool, widgetIds map[string]bool, result *Result) {
+	report := PanelReport{PanelId: panel.Id, Title: panel.Title, Type: panel.Type}
+
+	widget := store.DashboardWidget{
+		Title:      panel.Title,
+		TimeConfig: timeConfig,
+		Layout:     convertLayout(panel.GridPos, &report),
+	}
+
+	switch panel.Type {
+	case "timeseries", "graph":
+		widget.WidgetType = "chart"
+		widget.Visualization.ChartType = "line"
+		if panel.Bars || customString(panel.FieldConfig.Defaults.Custom, "drawStyle") == "bars" {
+			widget.Visualization.ChartType = "bar"
+		} else if fill, ok := panel.FieldConfig.Defaults.Custom["fillOpacity"].(float64); ok && fill > 0 {
+			widget.Visualization.ChartType = "area"
+		}
+	case "barchart", "bargauge":
+		widget.WidgetType = "chart"
+		widget.Visualization.ChartType = "bar"
+	case "stat", "singlestat":
+		widget.WidgetType = "metric"
+	case "gauge":
+		widget.WidgetType = "chart"
+		widget.Visualization.ChartType = "gauge"
+	case "table", "table-old":
+		widget.WidgetType = "table"
+	case "heatmap":
+		widget.WidgetType = "chart"
+		widget.Visualization.ChartType = "heatmap"
+	default:
+		report.Issues = append(report.Issues, fmt.Sprintf("panel type %q is not supported", panel.Type))
+		result.SkippedPanels = append(result.SkippedPanels, report)
+		return
+	}
+
+	if panel.TimeFrom != "" {
+		if tr, ok := relativeTimeRange(panel.TimeFrom); ok {
+			widget.TimeConfig.TimeRange = tr
+		} else {
+			report.Issues = append(report.Issues, fmt.Sprintf("relative time %q is not supported", panel.TimeFrom))
+		}
+	}
+
+	convertVisualization(panel, &widget.Visualization, &report)
+
+	t := &translator{variables: variables}
+	for i, target := range panel.Targets {
+		if target.Hide {
+			continue
+		}
+		refId := target.RefId
+		if refId == "" {
+			refId = string(rune('A' + i%26))
+		}
+		if ds := datasourceType(target.Datasource, panel.Datasource); ds != "" && ds != "prometheus" {
+			t.issue("target %s: %s datasource is not supported", refId, ds)
+			continue
+		}
+		if strings.TrimSpace(target.Expr) == "" {
+			t.issue("target %s: has no PromQL expression", refId)
+			continue
+		}
+		t.translateTarget(refId, target.Expr, target.LegendFormat)
+	}
+	report.Issues = append(report.Issues, t.issues...)
+
+	if len(t.queries) == 0 {
+		if len(report.Issues) == 0 {
+			report.Issues = append(report.Issues, "panel has no queries")
+		}
+		result.SkippedPanels = append(result.SkippedPanels, report)
+		return
+	}
+	widget.Queries = t.queries
+
+	widget.WidgetId = fmt.Sprintf("panel-%d", panel.Id)
+	if panel.Id == 0 || widgetIds[widget.WidgetId] {
+		widget.WidgetId = fmt.Sprintf("panel-%d", len(result.Dashboard.Widgets)+1)
+		for n := len(result.Dashboard.Widgets) + 2; widgetIds[widget.WidgetId]; n++ {
+			widget.WidgetId = fmt.Sprintf("panel-%d", n)
+		}
+	}
+	widgetIds[widget.WidgetId] = true
+	report.WidgetId = widget.WidgetId
+
+	result.Dashboard.Widgets = append(result.Dashboard.Widgets, widget)
+	result.ImportedPanels++
+	if len(report.Issues) > 0 {
+		result.PartialPanels = append(result.PartialPanels, report)
+	}
+}
+
+func convertLayout(pos grafanaGridPos, report *PanelReport) store.WidgetLayout {
+	layout := store.WidgetLayout{X: pos.X, Y: pos.Y, W: pos.W, H: pos.H}
+	if layout.W <= 0 || layout.H <= 0 {
+		report.Issues = append(report.Issues, "panel has no grid position, a default size is used")
+		layout.W, layout.H = defaultWidth, defaultHeight
+	}
+	if layout.X < 0 {
+		layout.X = 0
+	}
+	if layout.Y < 0 {
+		layout.Y = 0
+	}
+	if layout.W > gridColumns {
+		layout.W = gridColumns
+	}
+	if layout.X+layout.W > gridColumns {
+		layout.X = gridColumns - layout.W
+	}
+	return layout
+}
+
+func convertVisualization(panel grafanaPanel, vis *store.VisualizationConfig, report *PanelReport) {
+	defaults := panel.FieldConfig.Defaults
+
+	vis.Unit = defaults.Unit
+	if vis.Unit == "" {
+		vis.Unit = panel.Format
+	}
+	if defaults.Decimals != nil {
+		vis.Decimals = *defaults.Decimals
+	} else if panel.Decimals != nil {
+		vis.Decimals = *panel.Decimals
+	}
+
+	if color, ok := defaults.Color["fixedColor"].(string); ok && color != "" {
+		vis.Colors = []string{color}
+	}
+	if mode, ok := defaults.Color["mode"].(string); ok && strings.HasPrefix(mode, "palette-") {
+		vis.ColorPalette = strings.TrimPrefix(mode, "palette-")
+	}
+
+	vis.Stacked = panel.Stack
+	if stacking, ok := defaults.Custom["stacking"].(map[string]interface{}); ok {
+		if mode, _ := stacking["mode"].(string); mode != "" && mode != "none" {
+			vis.Stacked = true
+		}
+	}
+
+	vis.ShowLegend = true
+	if panel.Legend != nil {
+		vis.ShowLegend = panel.Legend.Show
+	}
+	if legend, ok := panel.Options["legend"].(map[string]interface{}); ok {
+		if show, ok := legend["showLegend"].(bool); ok {
+			vis.ShowLegend = show
+		}
+		if mode, _ := legend["displayMode"].(string); mode == "hidden" {
+			vis.ShowLegend = false
+		}
+	}
+
+	if defaults.Thresholds != nil {
+		if defaults.Thresholds.Mode == "percentage" {
+			report.Issues = append(report.Issues, "percentage thresholds were imported as absolute values")
+		}
+		for _, step := range defaults.Thresholds.Steps {
+			if step.Value == nil {
+				continue // base color below the first threshold
+			}
+			vis.Thresholds = append(vis.Thresholds, store.Threshold{Value: *step.Value, Color: step.Color})
+		}
+	} else if len(panel.Thresholds) > 0 {
+		vis.Thresholds = legacyThresholds(panel.Thresholds)
+	}
+
+	custom := map[string]interface{}{}
+	if defaults.Min != nil {
+		custom["min"] = *defaults.Min
+	}
+	if defaults.Max != nil {
+		custom["max"] = *defaults.Max
+	}
+	if reduce, ok := panel.Options["reduceOptions"].(map[string]interface{}); ok {
+		if calcs, ok := reduce["calcs"].([]interface{}); ok && len(calcs) > 0 {
+			custom["reduce"] = calcs[0]
+		}
+	}
+	if len(custom) > 0 {
+		vis.CustomConfig = custom
+	}
+}
+
+// legacyThresholds reads graph panel thresholds ([{value, fillColor}]) or
+// singlestat thresholds ("50,80")
+func legacyThresholds(raw json.RawMessage) []store.Threshold {
+	var thresholds []store.Threshold
+
+	var graph []struct {
+		Value     float64 `json:"value"`
+		FillColor string  `json:"fillColor"`
+		ColorMode string  `json:"colorMode"`
+	}
+	if err := json.Unmarshal(raw, &graph); err == nil {
+		for _, g := range graph {
+			color := g.FillColor
+			if color == "" || g.ColorMode != "custom" {
+				color = g.ColorMode
+			}
+			thresholds = append(thresholds, store.Threshold{Value: g.Value, Color: color})
+		}
+		return thresholds
+	}
+
+	var single string
+	if err := json.Unmarshal(raw, &single); err == nil {
+		for _, part := range strings.Split(single, ",") {
+			if v, err := strconv.ParseFloat(strings.TrimSpace(part), 64); err == nil {
+				thresholds = append(thresholds, store.Threshold{Value: v})
+			}
+		}
+	}
+	return thresholds
+}
+
+// convertVariable translates a templating variable, recording a warning
+// for variables that have no equivalent
+func convertVariable(gv grafanaVariable, result *Result) (store.DashboardVariable, bool) {
+	warn := func(format string, args ...interface{}) {
+		result.Warnings = append(result.Warnings, fmt.Sprintf("variable %s: ", gv.Name)+fmt.Sprintf(format, args...))
+	}
+
+	if !variableNamePattern.MatchString(gv.Name) {
+		warn("name is not supported")
+		return store.DashboardVariable{}, false
+	}
+
+	v := store.DashboardVariable{
+		Name:       gv.Name,
+		Label:      gv.Label,
+		Multi:      gv.Multi,
+		IncludeAll: gv.IncludeAll,
+		Current:    stringValues(gv.Current.Value),
+	}
+	if gv.AllValue != "" && gv.AllValue != ".*" && gv.AllValue != ".+" {
+		warn("custom all value %q was dropped", gv.AllValue)
+	}
+
+	query := variableQuery(gv.Query)
+	switch gv.Type {
+	case "query":
+		v.Type = "query"
+		if m := labelValuesPattern.FindStringSubmatch(query); m != nil {
+			if m[1] == "" {
+				warn("label_values without a metric is not supported")
+				return v, false
+			}
+			sel, err := parsePromQL(m[1])
+			s, ok := sel.(*promSelector)
+			if err != nil || !ok || s.metric == "" {
+				warn("could not read the metric of %q", query)
+				return v, false
+			}
+			if len(s.matchers) > 0 {
+				warn("label matchers of %q were dropped", query)
+			}
+			v.Query = &store.VariableQuery{Source: "label_values", MetricName: s.metric, LabelKey: m[2]}
+		} else if metricsPattern.MatchString(query) {
+			v.Query = &store.VariableQuery{Source: "metric_names"}
+		} else {
+			warn("query %q is not supported", query)
+			return v, false
+		}
+	case "custom":
+		v.Type = "custom"
+		for _, part := range strings.Split(query, ",") {
+			// "text : value" pairs select the value
+			if i := strings.Index(part, " : "); i >= 0 {
+				part = part[i+3:]
+			}
+			if part = strings.TrimSpace(part); part != "" {
+				v.Options = append(v.Options, part)
+			}
+		}
+	case "constant":
+		v.Type = "constant"
+		v.Options = []string{query}
+	case "textbox":
+		v.Type = "custom"
+		v.Options = []string{query}
+		warn("textbox was imported as a custom variable")
+	case "interval":
+		v.Type = "interval"
+		for _, part := range strings.Split(query, ",") {
+			part = strings.TrimSpace(part)
+			if intervalPattern.MatchString(part) {
+				v.Options = append(v.Options, part)
+			} else if part != "" && part != "auto" {
+				warn("interval %q was dropped", part)
+			}
+		}
+	default:
+		warn("%s variables are not supported", gv.Type)
+		return v, false
+	}
+
+	if (v.Type == "custom" || v.Type == "interval") && len(v.Options) == 0 {
+		warn("has no options")
+		return v, false
+	}
+	return v, true
+}
+
+// variableQuery reads a variable query stored as a string or as
+// {"query": "..."}
+func variableQuery(raw interface{}) string {
+	switch q := raw.(type) {
+	case string:
+		return strings.TrimSpace(q)
+	case map[string]interface{}:
+		if s, ok := q["query"].(string); ok {
+			return strings.TrimSpace(s)
+		}
+	}
+	return ""
+}
+
+func stringValues(raw interface{}) []string {
+	switch v := raw.(type) {
+	case string:
+		if v == "" {
+			return nil
+		}
+		return []string{v}
+	case []interface{}:
+		var values []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// datasourceType returns the datasource type of a target, falling back to
+// the panel datasource. Unresolved references return "".
+func datasourceType(target, panel interface{}) string {
+	for _, ds := range []interface{}{target, panel} {
+		switch d := ds.(type) {
+		case map[string]interface{}:
+			if t, ok := d["type"].(string); ok && t != "" && t != "datasource" && !strings.HasPrefix(t, "$") {
+				return t
+			}
+		case string:
+			lower := strings.ToLower(d)
+			for _, known := range []string{"prometheus", "loki", "elasticsearch", "influxdb", "graphite", "mysql", "postgres", "cloudwatch"} {
+				if strings.Contains(lower, known) {
+					return known
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// relativeTimeRange turns "now-6h" or "6h" into a widget time range
+func relativeTimeRange(text string) (string, bool) {
+	m := relativeTimePattern.FindStringSubmatch(text)
+	if m == nil {
+		return "", false
+	}
+	n, _ := strconv.Atoi(m[1])
+	switch m[2] {
+	case "s":
+		if n%60 != 0 {
+			return "", false
+		}
+		return fmt.Sprintf("%dm", n/60), true
+	case "m", "h", "d":
+		return m[1] + m[2], true
+	case "w":
+		return fmt.Sprintf("%dd", n*7), true
+	case "M":
+		return fmt.Sprintf("%dd", n*30), true
+	default: // y
+		return fmt.Sprintf("%dd", n*365), true
+	}
+}
+
+func customString(custom map[string]interface{}, key string) string {
+	s, _ := custom[key].(string)
+	return s
+}
+
+// resolveOverlaps moves widgets down until none overlap. Panels lifted out
+// of collapsed rows keep their expanded positions and can collide.
+func resolveOverlaps(widgets []store.DashboardWidget) {
+	for i := range widgets {
+		for moved := true; moved; {
+			moved = false
+			a := &widgets[i].Layout
+			for j := 0; j < i; j++ {
+				b := widgets[j].Layout
+				if a.X < b.X+b.W && b.X < a.X+a.W && a.Y < b.Y+b.H && b.Y < a.Y+a.H {
+					a.Y = b.Y + b.H
+					moved = true
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,548 @@
+package grafana
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// This file holds a parser for the subset of PromQL found in dashboard
+// panels: selectors, function calls, aggregations with by/without and
+// binary operators. Vector matching modifiers and offsets are parsed and
+// reported rather than translated.
+
+// PromQL limits, so parsing an expression cannot recurse without bound
+const (
+	maxPromQLLength = 16384
+	maxPromQLDepth  = 64 // nested parentheses, calls and unary operators
+)
+
+type promExpr interface{}
+
+type promNumber struct {
+	text string
+}
+
+type promString struct {
+	value string
+}
+
+type promMatcher struct {
+	label, op, value string
+}
+
+type promSelector struct {
+	metric   string
+	matchers []promMatcher
+	rng      string // range duration, e.g. "5m", without brackets
+	offset   bool
+}
+
+type promCall struct {
+	fn          string
+	args        []promExpr
+	grouping    []string
+	hasGrouping bool
+	without     bool
+}
+
+type promBinary struct {
+	op       string
+	lhs, rhs promExpr
+	matching bool // on/ignoring/group_left/group_right/bool was used
+}
+
+type promUnary struct {
+	op    string
+	inner promExpr
+}
+
+type promParen struct {
+	inner promExpr
+}
+
+type promTokenKind int
+
+const (
+	tokEOF promTokenKind = iota
+	tokIdent
+	tokNumber
+	tokString
+	tokOp
+	tokPunct
+	tokRange // contents of [...] after a selector
+)
+
+type promToken struct {
+	kind promTokenKind
+	text string
+}
+
+// lexPromQL splits an expression into tokens. Template variables ($var,
+// ${var}, [[var]]) are kept as identifier characters.
+func lexPromQL(input string) ([]promToken, error) {
+	var tokens []promToken
+	i := 0
+	for i < len(input) {
+		c := input[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '#':
+			for i < len(input) && input[i] != '\n' {
+				i++
+			}
+		case c == '"' || c == '\'' || c == '`':
+			j := i + 1
+			var sb strings.Builder
+			for j < len(input) && input[j] != c {
+				if input[j] == '\\' && c != '`' && j+1 < len(input) {
+					j++
+				}
+				sb.WriteByte(input[j])
+				j++
+			}
+			if j >= len(input) {
+				return nil, fmt.Errorf("unterminated string at offset %d", i)
+			}
+			tokens = append(tokens, promToken{tokString, sb.String()})
+			i = j + 1
+		case c == '[' && strings.HasPrefix(input[i:], "[["):
+			// Legacy [[var]] template syntax
+			end := strings.Index(input[i:], "]]")
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated variable at offset %d", i)
+			}
+			tokens = append(tokens, promToken{tokIdent, "${" + trimVariableFormat(input[i+2:i+end]) + "}"})
+			i += end + 2
+		case c == '[':
+			end := strings.IndexByte(input[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated range at offset %d", i)
+			}
+			tokens = append(tokens, promToken{tokRange, strings.TrimSpace(input[i+1 : i+end])})
+			i += end + 1
+		case c >= '0' && c <= '9' || c == '.' && i+1 < len(input) && input[i+1] >= '0' && input[i+1] <= '9':
+			j := i
+			for j < len(input) && (isDigit(input[j]) || input[j] == '.' || input[j] == 'e' || input[j] == 'E' ||
+				(input[j] == '+' || input[j] == '-') && (input[j-1] == 'e' || input[j-1] == 'E')) {
+				j++
+			}
+			tokens = append(tokens, promToken{tokNumber, input[i:j]})
+			i = j
+		case c == '$' && strings.HasPrefix(input[i:], "${"):
+			end := strings.IndexByte(input[i:], '}')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated variable at offset %d", i)
+			}
+			tokens = append(tokens, promToken{tokIdent, "${" + trimVariableFormat(input[i+2:i+end]) + "}"})
+			i += end + 1
+		case isIdentStart(c):
+			j := i + 1
+			for j < len(input) && isIdentChar(input[j]) {
+				j++
+			}
+			tokens = append(tokens, promToken{tokIdent, input[i:j]})
+			i = j
+		case strings.ContainsRune("(){},", rune(c)):
+			tokens = append(tokens, promToken{tokPunct, string(c)})
+			i++
+		default:
+			op := ""
+			for _, candidate := range []string{"==", "!=", ">=", "<=", "=~", "!~", "+", "-", "*", "/", "%", "^", ">", "<", "="} {
+				if strings.HasPrefix(input[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected character %q at offset %d", c, i)
+			}
+			tokens = append(tokens, promToken{tokOp, op})
+			i += len(op)
+		}
+	}
+	return append(tokens, promToken{kind: tokEOF}), nil
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c == ':' || c == '$' || unicode.IsLetter(rune(c))
+}
+
+func isIdentChar(c byte) bool {
+	return isIdentStart(c) || isDigit(c)
+}
+
+// trimVariableFormat drops Grafana's ":format" suffix from a variable name
+func trimVariableFormat(name string) string {
+	if i := strings.IndexByte(name, ':'); i >= 0 {
+		return name[:i]
+	}
+	return name
+}
+
+type promParser struct {
+	tokens []promToken
+	pos    int
+	depth  int
+}
+
+// parsePromQL parses a PromQL expression
+func parsePromQL(input string) (promExpr, error) {
+	if len(input) > maxPromQLLength {
+		return nil, fmt.Errorf("expression is longer than %d characters", maxPromQLLength)
+	}
+	tokens, err := lexPromQL(input)
+	if err != nil {
+		return nil, err
+	}
+	p := &promParser{tokens: tokens}
+	expr, err := p.parseBinary(0)
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %q", tok.text)
+	}
+	return expr, nil
+}
+
+func (p *promParser) peek() promToken {
+	return p.tokens[p.pos]
+}
+
+func (p *promParser) next() promToken {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *promParser) expect(kind promTokenKind, text string) error {
+	tok := p.next()
+	if tok.kind != kind || tok.text != text {
+		if tok.kind == tokEOF {
+			return fmt.Errorf("expected %q, got end of expression", text)
+		}
+		return fmt.Errorf("expected %q, got %q", text, tok.text)
+	}
+	return nil
+}
+
+// binaryPrecedence lists operators from lowest to highest precedence
+var binaryPrecedence = [][]string{
+	{"or"},
+	{"and", "unless"},
+	{"==", "!=", ">", "<", ">=", "<="},
+	{"+", "-"},
+	{"*", "/", "%"},
+	{"^"},
+}
+
+func (p *promParser) binaryOp(level int) (string, bool) {
+	tok := p.peek()
+	if tok.kind != tokOp && tok.kind != tokIdent {
+		return "", false
+	}
+	for _, op := range binaryPrecedence[level] {
+		if strings.EqualFold(tok.text, op) {
+			return op, true
+		}
+	}
+	return "", false
+}
+
+func (p *promParser) parseBinary(level int) (promExpr, error) {
+	if level == len(binaryPrecedence) {
+		return p.parseUnary()
+	}
+
+	lhs, err := p.parseBinary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.binaryOp(level)
+		if !ok {
+			return lhs, nil
+		}
+		p.next()
+		matching, err := p.parseMatchingModifiers()
+		if err != nil {
+			return nil, err
+		}
+
+		var rhs promExpr
+		if op == "^" {
+			// Exponentiation is right associative
+			rhs, err = p.parseBinary(level)
+		} else {
+			rhs, err = p.parseBinary(level + 1)
+		}
+		if err != nil {
+			return nil, err
+		}
+		lhs = &promBinary{op: op, lhs: lhs, rhs: rhs, matching: matching}
+	}
+}
+
+// parseMatchingModifiers skips bool, on(...), ignoring(...), group_left(...)
+// and group_right(...), reporting whether any was present
+func (p *promParser) parseMatchingModifiers() (bool, error) {
+	found := false
+	for {
+		tok := p.peek()
+		if tok.kind != tokIdent {
+			return found, nil
+		}
+		switch strings.ToLower(tok.text) {
+		case "bool":
+			p.next()
+		case "on", "ignoring", "group_left", "group_right":
+			p.next()
+			if next := p.peek(); next.kind == tokPunct && next.text == "(" {
+				if _, err := p.parseLabelList(); err != nil {
+					return false, err
+				}
+			}
+		default:
+			return found, nil
+		}
+		found = true
+	}
+}
+
+func (p *promParser) parseUnary() (promExpr, error) {
+	if p.depth == maxPromQLDepth {
+		return nil, fmt.Errorf("expression is nested deeper than %d levels", maxPromQLDepth)
+	}
+	p.depth++
+	defer func() { p.depth-- }()
+
+	if tok := p.peek(); tok.kind == tokOp && (tok.text == "-" || tok.text == "+") {
+		p.next()
+		inner, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if tok.text == "+" {
+			return inner, nil
+		}
+		return &promUnary{op: "-", inner: inner}, nil
+	}
+	return p.parsePostfix()
+}
+
+// parsePostfix parses a primary expression followed by an optional range
+// and offset, e.g. a subquery or a selector with [5m] offset 1h
+func (p *promParser) parsePostfix() (promExpr, error) {
+	expr, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok := p.peek()
+		switch {
+		case tok.kind == tokRange:
+			p.next()
+			if sel, ok := expr.(*promSelector); ok {
+				sel.rng = tok.text
+			}
+		case tok.kind == tokIdent && strings.EqualFold(tok.text, "offset"):
+			p.next()
+			if err := p.skipDuration(); err != nil {
+				return nil, err
+			}
+			if sel, ok := expr.(*promSelector); ok {
+				sel.offset = true
+			}
+		default:
+			return expr, nil
+		}
+	}
+}
+
+// skipDuration consumes a duration such as 5m, -1h or 1h30m, which lexes
+// as a number followed by an identifier
+func (p *promParser) skipDuration() error {
+	if tok := p.peek(); tok.kind == tokOp && tok.text == "-" {
+		p.next()
+	}
+	if tok := p.next(); tok.kind != tokNumber {
+		return fmt.Errorf("expected duration, got %q", tok.text)
+	}
+	if tok := p.peek(); tok.kind == tokIdent {
+		p.next()
+	}
+	return nil
+}
+
+var promAggregations = map[string]bool{
+	"sum": true, "avg": true, "min": true, "max": true, "count": true, "group": true,
+	"stddev": true, "stdvar": true, "topk": true, "bottomk": true, "quantile": true,
+	"count_values": true, "limitk": true, "limit_ratio": true,
+}
+
+func (p *promParser) parsePrimary() (promExpr, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokNumber:
+		return &promNumber{text: tok.text}, nil
+	case tokString:
+		return &promString{value: tok.text}, nil
+	case tokPunct:
+		switch tok.text {
+		case "(":
+			inner, err := p.parseBinary(0)
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(tokPunct, ")"); err != nil {
+				return nil, err
+			}
+			return &promParen{inner: inner}, nil
+		case "{":
+			sel := &promSelector{}
+			if err := p.parseMatchers(sel); err != nil {
+				return nil, err
+			}
+			return sel, nil
+		}
+	case tokIdent:
+		name := tok.text
+		lower := strings.ToLower(name)
+		if lower == "inf" || lower == "nan" {
+			return &promNumber{text: name}, nil
+		}
+
+		next := p.peek()
+		isAggregation := promAggregations[lower]
+		if isAggregation && next.kind == tokIdent && (strings.EqualFold(next.text, "by") || strings.EqualFold(next.text, "without")) {
+			call := &promCall{fn: lower}
+			if err := p.parseGrouping(call); err != nil {
+				return nil, err
+			}
+			if err := p.parseArgs(call); err != nil {
+				return nil, err
+			}
+			return call, nil
+		}
+		if next.kind == tokPunct && next.text == "(" {
+			call := &promCall{fn: lower}
+			if err := p.parseArgs(call); err != nil {
+				return nil, err
+			}
+			if after := p.peek(); isAggregation && after.kind == tokIdent &&
+				(strings.EqualFold(after.text, "by") || strings.EqualFold(after.text, "without")) {
+				if err := p.parseGrouping(call); err != nil {
+					return nil, err
+				}
+			}
+			return call, nil
+		}
+
+		sel := &promSelector{metric: name}
+		if next.kind == tokPunct && next.text == "{" {
+			p.next()
+			if err := p.parseMatchers(sel); err != nil {
+				return nil, err
+			}
+		}
+		return sel, nil
+	case tokEOF:
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	return nil, fmt.Errorf("unexpected %q", tok.text)
+}
+
+func (p *promParser) parseGrouping(call *promCall) error {
+	kw := p.next()
+	call.hasGrouping = true
+	call.without = strings.EqualFold(kw.text, "without")
+	labels, err := p.parseLabelList()
+	if err != nil {
+		return err
+	}
+	call.grouping = labels
+	return nil
+}
+
+func (p *promParser) parseLabelList() ([]string, error) {
+	if err := p.expect(tokPunct, "("); err != nil {
+		return nil, err
+	}
+	var labels []string
+	for {
+		tok := p.next()
+		switch {
+		case tok.kind == tokPunct && tok.text == ")":
+			return labels, nil
+		case tok.kind == tokPunct && tok.text == ",":
+		case tok.kind == tokIdent || tok.kind == tokString:
+			labels = append(labels, tok.text)
+		default:
+			return nil, fmt.Errorf("unexpected %q in label list", tok.text)
+		}
+	}
+}
+
+func (p *promParser) parseArgs(call *promCall) error {
+	if err := p.expect(tokPunct, "("); err != nil {
+		return err
+	}
+	if tok := p.peek(); tok.kind == tokPunct && tok.text == ")" {
+		p.next()
+		return nil
+	}
+	for {
+		arg, err := p.parseBinary(0)
+		if err != nil {
+			return err
+		}
+		call.args = append(call.args, arg)
+
+		tok := p.next()
+		if tok.kind == tokPunct && tok.text == ")" {
+			return nil
+		}
+		if tok.kind != tokPunct || tok.text != "," {
+			return fmt.Errorf("expected \",\" or \")\" in call to %s, got %q", call.fn, tok.text)
+		}
+	}
+}
+
+// parseMatchers parses label matchers after the opening brace
+func (p *promParser) parseMatchers(sel *promSelector) error {
+	for {
+		tok := p.next()
+		switch {
+		case tok.kind == tokPunct && tok.text == "}":
+			return nil
+		case tok.kind == tokPunct && tok.text == ",":
+			continue
+		case tok.kind == tokIdent || tok.kind == tokString:
+		default:
+			return fmt.Errorf("unexpected %q in label matchers", tok.text)
+		}
+
+		op := p.next()
+		if op.kind != tokOp || (op.text != "=" && op.text != "!=" && op.text != "=~" && op.text != "!~") {
+			return fmt.Errorf("expected label matcher operator after %q", tok.text)
+		}
+		value := p.next()
+		if value.kind != tokString {
+			return fmt.Errorf("expected quoted value for label %q", tok.text)
+		}
+
+		if tok.text == "__name__" && op.text == "=" {
+			sel.metric = value.text
+			continue
+		}
+		sel.matchers = append(sel.matchers, promMatcher{label: tok.text, op: op.text, value: value.text})
+	}
+}
@@ -0,0 +1,157 @@
+package grafana
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParsePromQL(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+		want promExpr
+	}{
+		{
+			name: "selector",
+			expr: `http_requests_total{job="api", code=~"5.."}`,
+			want: &promSelector{metric: "http_requests_total", matchers: []promMatcher{
+				{label: "job", op: "=", value: "api"},
+				{label: "code", op: "=~", value: "5.."},
+			}},
+		},
+		{
+			name: "name matcher",
+			expr: `{__name__="up", instance!="a"}`,
+			want: &promSelector{metric: "up", matchers: []promMatcher{{label: "instance", op: "!=", value: "a"}}},
+		},
+		{
+			name: "range and offset",
+			expr: `rate(errors_total[5m] offset 1h)`,
+			want: &promCall{fn: "rate", args: []promExpr{&promSelector{metric: "errors_total", rng: "5m", offset: true}}},
+		},
+		{
+			name: "aggregation with grouping first",
+			expr: `sum by (service, env) (rate(x[1m]))`,
+			want: &promCall{fn: "sum", grouping: []string{"service", "env"}, hasGrouping: true, args: []promExpr{
+				&promCall{fn: "rate", args: []promExpr{&promSelector{metric: "x", rng: "1m"}}},
+			}},
+		},
+		{
+			name: "aggregation with grouping last",
+			expr: `avg(x) without (pod)`,
+			want: &promCall{fn: "avg", grouping: []string{"pod"}, hasGrouping: true, without: true, args: []promExpr{
+				&promSelector{metric: "x"},
+			}},
+		},
+		{
+			name: "precedence",
+			expr: `a + b * 2`,
+			want: &promBinary{op: "+", lhs: &promSelector{metric: "a"}, rhs: &promBinary{
+				op: "*", lhs: &promSelector{metric: "b"}, rhs: &promNumber{text: "2"},
+			}},
+		},
+		{
+			name: "exponent is right associative",
+			expr: `2 ^ 3 ^ 2`,
+			want: &promBinary{op: "^", lhs: &promNumber{text: "2"}, rhs: &promBinary{
+				op: "^", lhs: &promNumber{text: "3"}, rhs: &promNumber{text: "2"},
+			}},
+		},
+		{
+			name: "vector matching",
+			expr: `a / on(job) group_left b`,
+			want: &promBinary{op: "/", lhs: &promSelector{metric: "a"}, rhs: &promSelector{metric: "b"}, matching: true},
+		},
+		{
+			name: "keyword operators",
+			expr: `a AND b or c`,
+			want: &promBinary{op: "or", lhs: &promBinary{
+				op: "and", lhs: &promSelector{metric: "a"}, rhs: &promSelector{metric: "b"},
+			}, rhs: &promSelector{metric: "c"}},
+		},
+		{
+			name: "unary minus and parentheses",
+			expr: `-(a) + +1e3`,
+			want: &promBinary{op: "+", lhs: &promUnary{op: "-", inner: &promParen{inner: &promSelector{metric: "a"}}}, rhs: &promNumber{text: "1e3"}},
+		},
+		{
+			name: "quantile with parameter",
+			expr: `histogram_quantile(0.95, sum by (le) (rate(h_bucket[5m])))`,
+			want: &promCall{fn: "histogram_quantile", args: []promExpr{
+				&promNumber{text: "0.95"},
+				&promCall{fn: "sum", grouping: []string{"le"}, hasGrouping: true, args: []promExpr{
+					&promCall{fn: "rate", args: []promExpr{&promSelector{metric: "h_bucket", rng: "5m"}}},
+				}},
+			}},
+		},
+		{
+			name: "template variables",
+			expr: `x{job="$job"}[$__rate_interval] / [[total:raw]]`,
+			want: &promBinary{op: "/", lhs: &promSelector{metric: "x", matchers: []promMatcher{{label: "job", op: "=", value: "$job"}}, rng: "$__rate_interval"}, rhs: &promSelector{metric: "${total}"}},
+		},
+		{
+			name: "comments and escaped strings",
+			expr: "x{path=\"a\\\"b\"} # trailing comment",
+			want: &promSelector{metric: "x", matchers: []promMatcher{{label: "path", op: "=", value: `a"b`}}},
+		},
+		{
+			name: "nested at the limit",
+			expr: strings.Repeat("(", maxPromQLDepth-1) + "1" + strings.Repeat(")", maxPromQLDepth-1),
+			want: nestedParens(maxPromQLDepth-1, &promNumber{text: "1"}),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parsePromQL(tt.expr)
+			if err != nil {
+				t.Fatalf("parsePromQL(%q): %v", tt.expr, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parsePromQL(%q) = %#v, want %#v", tt.expr, got, tt.want)
+			}
+		})
+	}
+}
+
+func nestedParens(n int, inner promExpr) promExpr {
+	for i := 0; i < n; i++ {
+		inner = &promParen{inner: inner}
+	}
+	return inner
+}
+
+func TestParsePromQLErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		expr    string
+		wantErr string
+	}{
+		{"empty", "", "unexpected end of expression"},
+		{"unterminated string", `x{job="api}`, "unterminated string"},
+		{"unterminated range", `rate(x[5m)`, "unterminated range"},
+		{"unterminated variable", `x / ${total`, "unterminated variable"},
+		{"unexpected character", `x @ 5`, "unexpected character"},
+		{"missing closing parenthesis", `sum(rate(x[5m])`, `expected ",`},
+		{"trailing tokens", `x y`, `unexpected "y"`},
+		{"matcher without operator", `x{job}`, "expected label matcher operator"},
+		{"unquoted matcher value", `x{job=api}`, "expected quoted value"},
+		{"bad label list", `sum by (1) (x)`, "in label list"},
+		{"offset without duration", `x offset`, "expected duration"},
+		{"dangling operator", `a +`, "unexpected end of expression"},
+		{"too long", strings.Repeat("a+", maxPromQLLength/2) + "a", "longer than"},
+		{"nested too deep", strings.Repeat("(", maxPromQLDepth) + "1" + strings.Repeat(")", maxPromQLDepth), "nested deeper than"},
+		{"negated too deep", strings.Repeat("-", maxPromQLDepth) + "1", "nested deeper than"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parsePromQL(tt.expr)
+			if err == nil {
+				t.Fatalf("parsePromQL(%q) succeeded, want error containing %q", tt.expr, tt.wantErr)
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("parsePromQL(%q) = %v, want error containing %q", tt.expr, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,338 @@
+package grafana
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/namlabs/obsfly/backend/internal/store"
+)
+
+var (
+	variableRefPattern = regexp.MustCompile(`\$\{([a-zA-Z_][a-zA-Z0-9_]*)(?::[^}]*)?\}|\[\[([a-zA-Z_][a-zA-Z0-9_]*)(?::[^\]]*)?\]\]|\$([a-zA-Z_][a-zA-Z0-9_]*)`)
+	regexMetaPattern   = regexp.MustCompile(`[\\.+*?()|\[\]{}^$]`)
+	aliasUnsafePattern = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+)
+
+// rateFunctions turn counters into rates; the raw values are used instead
+var rateFunctions = map[string]bool{
+	"rate": true, "irate": true, "increase": true, "delta": true, "idelta": true, "deriv": true,
+}
+
+// aggregationFunctions maps PromQL aggregations onto MetricQuery aggregations
+var aggregationFunctions = map[string]string{
+	"sum": "sum", "avg": "avg", "min": "min", "max": "max", "count": "count",
+	"sum_over_time": "sum", "avg_over_time": "avg", "min_over_time": "min",
+	"max_over_time": "max", "count_over_time": "count",
+}
+
+// translator converts the PromQL targets of one panel into metric queries
+type translator struct {
+	variables map[string]bool // variables defined on the dashboard
+	queries   []store.MetricQuery
+	issues    []string
+}
+
+func (t *translator) issue(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	for _, existing := range t.issues {
+		if existing == msg {
+			return
+		}
+	}
+	t.issues = append(t.issues, msg)
+}
+
+// translateTarget appends the queries for one PromQL target. A plain
+// selector or aggregation becomes a single query; arithmetic becomes one
+// query per operand plus a formula combining them.
+func (t *translator) translateTarget(refId, expr, legend string) {
+	parsed, err := parsePromQL(expr)
+	if err != nil {
+		t.issue("target %s: could not parse %q: %v", refId, expr, err)
+		return
+	}
+
+	alias := legend
+	if strings.Contains(legend, "{{") {
+		t.issue("target %s: legend template %q is not supported", refId, legend)
+		alias = ""
+	}
+
+	if q, ok := t.leaf(refId, parsed); ok {
+		q.Alias = alias
+		t.queries = append(t.queries, q)
+		return
+	}
+
+	start := len(t.queries)
+	formula, ok := t.formula(refId, parsed)
+	if !ok {
+		t.queries = t.queries[:start]
+		t.issue("target %s: expression %q could not be translated", refId, expr)
+		return
+	}
+	if len(t.queries) == start {
+		t.issue("target %s: constant expression %q skipped", refId, expr)
+		return
+	}
+	if alias == "" {
+		alias = sanitizeAlias(refId)
+	}
+	t.queries = append(t.queries, store.MetricQuery{Formula: formula, Alias: alias})
+	t.issue("target %s: operands of %q are shown alongside the formula result", refId, expr)
+}
+
+// formula renders an arithmetic expression, adding a query for every
+// operand that is not a number
+func (t *translator) formula(refId string, e promExpr) (string, bool) {
+	switch n := e.(type) {
+	case *promNumber:
+		if _, err := strconv.ParseFloat(n.text, 64); err != nil {
+			return "", false
+		}
+		return n.text, true
+	case *promParen:
+		inner, ok := t.formula(refId, n.inner)
+		return "(" + inner + ")", ok
+	case *promUnary:
+		inner, ok := t.formula(refId, n.inner)
+		return "-" + inner, ok
+	case *promBinary:
+		if n.matching {
+			t.issue("target %s: vector matching modifiers are ignored", refId)
+		}
+		lhs, ok := t.formula(refId, n.lhs)
+		if !ok {
+			return "", false
+		}
+		switch n.op {
+		case "+", "-", "*", "/":
+			rhs, ok := t.formula(refId, n.rhs)
+			if !ok {
+				return "", false
+			}
+			return lhs + " " + n.op + " " + rhs, true
+		default:
+			t.issue("target %s: operator %q is not supported, only its left side is used", refId, n.op)
+			return lhs, true
+		}
+	}
+
+	q, ok := t.leaf(refId, e)
+	if !ok {
+		return "", false
+	}
+	q.Alias = fmt.Sprintf("%s_%d", sanitizeAlias(refId), len(t.queries)+1)
+	t.queries = append(t.queries, q)
+	return q.Alias, true
+}
+
+// leaf translates an expression over a single metric into a query
+func (t *translator) leaf(refId string, e promExpr) (store.MetricQuery, bool) {
+	switch n := e.(type) {
+	case *promParen:
+		return t.leaf(refId, n.inner)
+	case *promSelector:
+		return t.selector(refId, n)
+	case *promCall:
+		return t.call(refId, n)
+	case *promBinary:
+		// Filters such as "up == 1" keep the series they compare
+		switch n.op {
+		case "==", "!=", ">", "<", ">=", "<=", "and", "or", "unless":
+			if _, isNumber := n.rhs.(*promNumber); isNumber || n.op == "and" || n.op == "or" || n.op == "unless" {
+				q, ok := t.leaf(refId, n.lhs)
+				if ok {
+					t.issue("target %s: operator %q is not supported, only its left side is used", refId, n.op)
+				}
+				return q, ok
+			}
+		}
+	}
+	return store.MetricQuery{}, false
+}
+
+func (t *translator) selector(refId string, sel *promSelector) (store.MetricQuery, bool) {
+	if sel.metric == "" {
+		return store.MetricQuery{}, false
+	}
+	if sel.offset {
+		t.issue("target %s: offset modifier is ignored", refId)
+	}
+
+	q := store.MetricQuery{
+		MetricName:  normalizeVariables(sel.metric),
+		Aggregation: "avg",
+		Filters:     map[string]string{},
+	}
+	for _, m := range sel.matchers {
+		value := normalizeVariables(m.value)
+		switch {
+		case m.op == "=":
+		case m.op == "=~" && (value == ".*" || value == ".+"):
+			continue
+		case m.op == "=~" && isWholeVariable(value):
+		case m.op == "=~" && !regexMetaPattern.MatchString(value):
+		default:
+			t.issue("target %s: matcher %s%s%q is not supported and was dropped", refId, m.label, m.op, m.value)
+			continue
+		}
+		if undefined := t.undefinedVariables(value); len(undefined) > 0 {
+			t.issue("target %s: filter on %s uses undefined variable $%s and was dropped", refId, m.label, undefined[0])
+			continue
+		}
+		q.Filters[m.label] = value
+	}
+	return q, true
+}
+
+func (t *translator) call(refId string, call *promCall) (store.MetricQuery, bool) {
+	switch {
+	case call.fn == "histogram_quantile" || call.fn == "quantile":
+		if len(call.args) != 2 {
+			return store.MetricQuery{}, false
+		}
+		q, ok := t.leaf(refId, call.args[1])
+		if !ok {
+			return q, false
+		}
+		if call.fn == "histogram_quantile" {
+			q.MetricName = strings.TrimSuffix(q.MetricName, "_bucket")
+			q.GroupBy = removeLabel(q.GroupBy, "le")
+		} else {
+			t.applyGrouping(refId, &q, call)
+		}
+		q.Aggregation = t.percentile(refId, call.args[0])
+		return q, true
+
+	case rateFunctions[call.fn]:
+		if len(call.args) != 1 {
+			return store.MetricQuery{}, false
+		}
+		t.issue("target %s: %s() is not supported, raw values are used", refId, call.fn)
+		return t.leaf(refId, call.args[0])
+
+	case aggregationFunctions[call.fn] != "":
+		if len(call.args) != 1 {
+			return store.MetricQuery{}, false
+		}
+		q, ok := t.leaf(refId, call.args[0])
+		if !ok {
+			return q, false
+		}
+		q.Aggregation = aggregationFunctions[call.fn]
+		if promAggregations[call.fn] {
+			t.applyGrouping(refId, &q, call)
+		}
+		return q, true
+
+	case promAggregations[call.fn]:
+		// topk, bottomk, stddev, ...: keep the aggregated vector
+		t.issue("target %s: %s() is not supported and was dropped", refId, call.fn)
+		for _, arg := range call.args {
+			if q, ok := t.leaf(refId, arg); ok {
+				t.applyGrouping(refId, &q, call)
+				return q, true
+			}
+		}
+		return store.MetricQuery{}, false
+	}
+
+	// Other functions (abs, clamp_min, label_replace, ...) are dropped in
+	// favour of their first vector argument
+	for _, arg := range call.args {
+		if q, ok := t.leaf(refId, arg); ok {
+			t.issue("target %s: %s() is not supported and was dropped", refId, call.fn)
+			return q, true
+		}
+	}
+	return store.MetricQuery{}, false
+}
+
+func (t *translator) applyGrouping(refId string, q *store.MetricQuery, call *promCall) {
+	if !call.hasGrouping {
+		q.GroupBy = nil
+		return
+	}
+	if call.without {
+		t.issue("target %s: %s without (...) is not supported, series are not grouped", refId, call.fn)
+		q.GroupBy = nil
+		return
+	}
+	q.GroupBy = call.grouping
+}
+
+// percentile maps a quantile argument onto p50, p95 or p99
+func (t *translator) percentile(refId string, arg promExpr) string {
+	if n, ok := arg.(*promNumber); ok {
+		if phi, err := strconv.ParseFloat(n.text, 64); err == nil {
+			switch {
+			case phi == 0.5:
+				return "p50"
+			case phi == 0.95:
+				return "p95"
+			case phi == 0.99:
+				return "p99"
+			case phi < 0.75:
+				t.issue("target %s: quantile %s approximated by p50", refId, n.text)
+				return "p50"
+			case phi < 0.97:
+				t.issue("target %s: quantile %s approximated by p95", refId, n.text)
+				return "p95"
+			default:
+				t.issue("target %s: quantile %s approximated by p99", refId, n.text)
+				return "p99"
+			}
+		}
+	}
+	t.issue("target %s: non-constant quantile approximated by p95", refId)
+	return "p95"
+}
+
+// undefinedVariables returns the variables referenced in text that the
+// dashboard does not define
+func (t *translator) undefinedVariables(text string) []string {
+	var undefined []string
+	for _, m := range variableRefPattern.FindAllStringSubmatch(text, -1) {
+		name := m[1] + m[2] + m[3]
+		if !t.variables[name] {
+			undefined = append(undefined, name)
+		}
+	}
+	return undefined
+}
+
+// normalizeVariables rewrites Grafana variable syntaxes (${var:format},
+// [[var]]) as ${var}
+func normalizeVariables(text string) string {
+	return variableRefPattern.ReplaceAllStringFunc(text, func(ref string) string {
+		m := variableRefPattern.FindStringSubmatch(ref)
+		return "${" + m[1] + m[2] + m[3] + "}"
+	})
+}
+
+func isWholeVariable(text string) bool {
+	loc := variableRefPattern.FindStringIndex(text)
+	return loc != nil && loc[0] == 0 && loc[1] == len(text)
+}
+
+func removeLabel(labels []string, label string) []string {
+	var kept []string
+	for _, l := range labels {
+		if l != label {
+			kept = append(kept, l)
+		}
+	}
+	return kept
+}
+
+// sanitizeAlias makes a refId usable as a formula identifier
+func sanitizeAlias(refId string) string {
+	alias := aliasUnsafePattern.ReplaceAllString(refId, "_")
+	if alias == "" || isDigit(alias[0]) {
+		alias = "q" + alias
+	}
+	return alias
+}
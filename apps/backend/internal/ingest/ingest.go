@@ -0,0 +1,58 @@
+package ingest
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/namlabs/obsfly/backend/internal/logpatterns"
+	"github.com/namlabs/obsfly/backend/internal/pipelines"
+	"github.com/namlabs/obsfly/backend/internal/redaction"
+	"github.com/namlabs/obsfly/backend/internal/store"
+)
+
+// Ingester is the write path for logs and spans. It runs the
+// ingestion-time processing, such as log pipelines, redaction and pattern
+// mining, before they are stored.
+type Ingester struct {
+	store     *store.Store
+	pipelines *pipelines.Processor // nil when log pipelines are disabled
+	redactor  *redaction.Redactor  // nil when redaction is disabled
+	miner     *logpatterns.Miner   // nil when pattern mining is disabled
+}
+
+func NewIngester(st *store.Store, processor *pipelines.Processor, redactor *redaction.Redactor, miner *logpatterns.Miner) *Ingester {
+	return &Ingester{store: st, pipelines: processor, redactor: redactor, miner: miner}
+}
+
+// Logs processes and stores a batch of logs. Failing to process them is
+// logged rather than returned, so logs are never dropped for it. Pipelines
+// run first, so values they extract are redacted too, and patterns are
+// mined from the redacted bodies, so the catalog's examples are clean.
+func (in *Ingester) Logs(ctx context.Context, logs []store.Log) error {
+	if in.pipelines != nil {
+		if err := in.pipelines.Process(ctx, logs); err != nil {
+			fmt.Printf("Error running log pipelines: %v\n", err)
+		}
+	}
+	if in.redactor != nil {
+		if err := in.redactor.Logs(ctx, logs); err != nil {
+			fmt.Printf("Error redacting logs: %v\n", err)
+		}
+	}
+	if in.miner != nil {
+		if err := in.miner.Process(ctx, logs); err != nil {
+			fmt.Printf("Error mining log patterns: %v\n", err)
+		}
+	}
+	return in.store.InsertLogs(ctx, logs)
+}
+
+// Traces redacts and stores a batch of spans
+func (in *Ingester) Traces(ctx context.Context, traces []store.Trace) error {
+	if in.redactor != nil {
+		if err := in.redactor.Spans(ctx, traces); err != nil {
+			fmt.Printf("Error redacting spans: %v\n", err)
+		}
+	}
+	return in.store.InsertTraces(ctx, traces)
+}
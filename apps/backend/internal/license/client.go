@@ -0,0 +1,186 @@
+package license
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// accessTTL is how long a user's access is cached, so permission changes
+// in the license server apply within a minute
+const accessTTL = time.Minute
+
+var (
+	// ErrUserNotFound is returned for users the license server does not know
+	ErrUserNotFound = errors.New("user not found")
+	// ErrInvalidCredentials is returned for unknown, inactive or expired
+	// session tokens and API keys
+	ErrInvalidCredentials = errors.New("invalid or expired credentials")
+)
+
+// UserAccess is a user's role, teams and permission grants as returned by
+// the license server's /api/users/{userId}/access
+type UserAccess struct {
+	UserId      uint64               `json:"user_id"`
+	AccountId   *uint64              `json:"account_id,omitempty"`
+	Role        string               `json:"role"`
+	Teams       []uint64             `json:"teams"`
+	Permissions []ResourcePermission `json:"permissions"`
+}
+
+// ResourcePermission is a grant from the license server's permissions table
+type ResourcePermission struct {
+	ResourceType string  `json:"resource_type"` // account, sub_account, product, dashboard
+	ResourceId   *uint64 `json:"resource_id,omitempty"`
+	Permission   string  `json:"permission"` // read, write, admin, delete
+}
+
+type cachedAccess struct {
+	access    *UserAccess
+	expiresAt time.Time
+}
+
+type cachedUser struct {
+	userId    uint64
+	expiresAt time.Time
+}
+
+// Client looks up users in the license server
+type Client struct {
+	baseURL      string
+	serviceToken string // authenticates this service to the license server
+	http         *http.Client
+
+	mu     sync.Mutex
+	cache  map[uint64]cachedAccess
+	tokens map[string]cachedUser // token hash -> user
+}
+
+func NewClient(baseURL, serviceToken string) *Client {
+	return &Client{
+		baseURL:      strings.TrimRight(baseURL, "/"),
+		serviceToken: serviceToken,
+		http:         &http.Client{Timeout: 5 * time.Second},
+		cache:        make(map[uint64]cachedAccess),
+		tokens:       make(map[string]cachedUser),
+	}
+}
+
+// ClientFromEnv returns a client for LICENSE_SERVER_URL using
+// LICENSE_SERVICE_TOKEN, or nil if the URL is not set
+func ClientFromEnv() *Client {
+	baseURL := os.Getenv("LICENSE_SERVER_URL")
+	if baseURL == "" {
+		return nil
+	}
+	return NewClient(baseURL, os.Getenv("LICENSE_SERVICE_TOKEN"))
+}
+
+// Authenticate returns the user a session token or API key belongs to,
+// from the cache when it is fresh
+func (c *Client) Authenticate(ctx context.Context, token string) (uint64, error) {
+	sum := sha256.Sum256([]byte(token))
+	key := hex.EncodeToString(sum[:])
+
+	c.mu.Lock()
+	cached, ok := c.tokens[key]
+	c.mu.Unlock()
+	if ok && time.Now().Before(cached.expiresAt) {
+		return cached.userId, nil
+	}
+
+	body, err := json.Marshal(map[string]string{"token": token})
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/api/users/authenticate", bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized:
+		return 0, ErrInvalidCredentials
+	case resp.StatusCode != http.StatusOK:
+		return 0, fmt.Errorf("license server returned %s", resp.Status)
+	}
+
+	var user struct {
+		UserId uint64 `json:"user_id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&user); err != nil {
+		return 0, fmt.Errorf("failed to decode authenticated user: %w", err)
+	}
+
+	c.mu.Lock()
+	c.tokens[key] = cachedUser{userId: user.UserId, expiresAt: time.Now().Add(accessTTL)}
+	c.mu.Unlock()
+	return user.UserId, nil
+}
+
+// do sends a request to the license server with the service token
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	if c.serviceToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.serviceToken)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach license server: %w", err)
+	}
+	return resp, nil
+}
+
+// GetUserAccess returns a user's access, from the cache when it is fresh
+func (c *Client) GetUserAccess(ctx context.Context, userId uint64) (*UserAccess, error) {
+	c.mu.Lock()
+	cached, ok := c.cache[userId]
+	c.mu.Unlock()
+	if ok && time.Now().Before(cached.expiresAt) {
+		return cached.access, nil
+	}
+
+	endpoint := fmt.Sprintf("%s/api/users/%s/access", c.baseURL, url.PathEscape(strconv.FormatUint(userId, 10)))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, ErrUserNotFound
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("license server returned %s", resp.Status)
+	}
+
+	var access UserAccess
+	if err := json.NewDecoder(resp.Body).Decode(&access); err != nil {
+		return nil, fmt.Errorf("failed to decode user access: %w", err)
+	}
+
+	c.mu.Lock()
+	c.cache[userId] = cachedAccess{access: &access, expiresAt: time.Now().Add(accessTTL)}
+	c.mu.Unlock()
+	return &access, nil
+}
@@ -0,0 +1,130 @@
+package logmetrics
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/namlabs/obsfly/backend/internal/store"
+)
+
+const (
+	// evaluationDelay leaves room for logs that are shipped late before a
+	// window is considered complete. Logs arriving later are not counted.
+	evaluationDelay = time.Minute
+	// maxCatchUpWindows bounds how many missed windows a metric replays per
+	// tick.
+	maxCatchUpWindows = 60
+)
+
+// Evaluator periodically derives log metrics from the logs of each
+// complete window and writes them into metrics_v1. Each metric's progress
+// is saved before it moves to the next window, and a window that was
+// already written is not written again, so restarts neither skip nor
+// duplicate windows. A metric with no saved progress, or more than
+// maxCatchUpWindows behind, skips ahead to the recent windows.
+type Evaluator struct {
+	store      *store.Store
+	tick       time.Duration
+	watermarks map[string]time.Time // metric ID -> end of last written window
+}
+
+func NewEvaluator(st *store.Store, tick time.Duration) *Evaluator {
+	return &Evaluator{
+		store: st,
+		tick:  tick,
+	}
+}
+
+func (e *Evaluator) Start(ctx context.Context) {
+	ticker := time.NewTicker(e.tick)
+	defer ticker.Stop()
+
+	fmt.Printf("Starting log metric evaluator (tick %s)\n", e.tick)
+
+	for {
+		select {
+		case <-ctx.Done():
+			fmt.Println("Log metric evaluator shutting down")
+			return
+		case <-ticker.C:
+			if err := e.evaluateAll(ctx); err != nil {
+				fmt.Printf("Error evaluating log metrics: %v\n", err)
+			}
+		}
+	}
+}
+
+func (e *Evaluator) evaluateAll(ctx context.Context) error {
+	if e.watermarks == nil {
+		states, err := e.store.GetLogMetricStates(ctx, 0)
+		if err != nil {
+			return fmt.Errorf("failed to load log metric watermarks: %w", err)
+		}
+		e.watermarks = make(map[string]time.Time, len(states))
+		for metricId, st := range states {
+			if !st.WindowEnd.IsZero() {
+				e.watermarks[metricId] = st.WindowEnd
+			}
+		}
+	}
+
+	metrics, err := e.store.ListActiveLogMetrics(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for i := range metrics {
+		e.evaluateMetric(ctx, &metrics[i], now)
+	}
+	return nil
+}
+
+// evaluateMetric writes every complete window since the metric's
+// watermark, stopping at the first failure, or when its progress cannot be
+// saved, so the window is retried on the next tick
+func (e *Evaluator) evaluateMetric(ctx context.Context, m *store.LogMetric, now time.Time) {
+	interval := m.IntervalDuration()
+	end := now.Add(-evaluationDelay).Truncate(interval)
+
+	start, ok := e.watermarks[m.MetricId]
+	if !ok || start.Before(end.Add(-maxCatchUpWindows*interval)) {
+		// First run or too far behind: skip ahead to the most recent windows
+		start = end.Add(-interval)
+		if ok {
+			start = end.Add(-maxCatchUpWindows * interval)
+		}
+	}
+
+	for start.Before(end) {
+		windowEnd := start.Truncate(interval).Add(interval)
+
+		samples, err := e.store.EvaluateLogMetric(ctx, m, start, windowEnd)
+		state := store.LogMetricState{
+			MetricId:       m.MetricId,
+			AccountId:      m.AccountId,
+			WindowEnd:      windowEnd,
+			EvaluatedAt:    time.Now(),
+			SamplesWritten: uint64(samples),
+		}
+		if err != nil {
+			state.WindowEnd = e.watermarks[m.MetricId]
+			state.LastError = err.Error()
+		}
+		saveErr := e.store.SaveLogMetricState(ctx, state)
+		if saveErr != nil {
+			fmt.Printf("Error saving state of log metric %s: %v\n", m.MetricId, saveErr)
+		}
+		if err != nil {
+			fmt.Printf("Log metric %s (%s) failed: %v\n", m.Name, m.MetricId, err)
+			return
+		}
+		if saveErr != nil {
+			return
+		}
+
+		e.watermarks[m.MetricId] = windowEnd
+		start = windowEnd
+	}
+}
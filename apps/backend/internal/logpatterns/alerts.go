@@ -0,0 +1,134 @@
+package logpatterns
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/namlabs/obsfly/backend/internal/store"
+)
+
+// alertSeverities are the severities whose anomalies raise alerts. New
+// patterns and spikes of informational lines are recorded without one.
+var alertSeverities = map[string]bool{
+	"WARN": true, "WARNING": true, "ERROR": true, "FATAL": true, "CRITICAL": true,
+}
+
+// shouldAlert reports whether an anomaly raises an alert: new patterns and
+// spikes of warnings and errors. Drops are only recorded.
+func shouldAlert(a *store.LogPatternAnomaly) bool {
+	return a.Kind != store.LogAnomalyDrop && alertSeverities[strings.ToUpper(a.Severity)]
+}
+
+// Alerter raises alerts for log anomalies. Each alert is recorded as an
+// alert annotation, so it shows on the service's graphs, and posted to a
+// webhook when one is configured.
+type Alerter struct {
+	store      *store.Store
+	webhookURL string
+	client     *http.Client
+}
+
+func NewAlerter(st *store.Store, webhookURL string) *Alerter {
+	return &Alerter{
+		store:      st,
+		webhookURL: webhookURL,
+		client:     &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// AlerterFromEnv posts alerts to LOG_ANOMALY_WEBHOOK_URL when it is set
+func AlerterFromEnv(st *store.Store) *Alerter {
+	return NewAlerter(st, os.Getenv("LOG_ANOMALY_WEBHOOK_URL"))
+}
+
+// alertPayload is posted to the anomaly webhook
+type alertPayload struct {
+	Title   string                   `json:"title"`
+	Anomaly *store.LogPatternAnomaly `json:"anomaly"`
+}
+
+// Alert records the anomaly as an alert annotation and posts it to the
+// webhook
+func (a *Alerter) Alert(ctx context.Context, anomaly *store.LogPatternAnomaly) error {
+	title := alertTitle(anomaly)
+	start := anomaly.DetectedAt
+	if anomaly.Kind == store.LogAnomalyNewPattern || anomaly.Kind == store.LogAnomalyNewInVersion {
+		start = anomaly.FirstSeen
+	}
+
+	annotation := &store.Annotation{
+		AnnotationId: anomaly.AnomalyId,
+		AccountId:    anomaly.AccountId,
+		Type:         store.AnnotationAlert,
+		Title:        title,
+		Text:         anomaly.Template,
+		Tags:         []string{"log_anomaly", anomaly.Kind, strings.ToLower(anomaly.Severity)},
+		ServiceName:  anomaly.ServiceName,
+		StartTime:    start,
+		EndTime:      start,
+		Source:       store.AnnotationSourceLogs,
+		Attributes: map[string]string{
+			"pattern_hash":    anomaly.PatternHash,
+			"service_version": anomaly.ServiceVersion,
+			"count":           fmt.Sprint(anomaly.Count),
+			"expected":        fmt.Sprintf("%.1f", anomaly.Expected),
+		},
+	}
+	if err := a.store.SaveAnnotation(ctx, annotation); err != nil {
+		return err
+	}
+
+	if a.webhookURL == "" {
+		return nil
+	}
+	return a.postWebhook(ctx, alertPayload{Title: title, Anomaly: anomaly})
+}
+
+func (a *Alerter) postWebhook(ctx context.Context, payload alertPayload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("invalid webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("webhook returned %s: %s", resp.Status, strings.TrimSpace(string(snippet)))
+	}
+	return nil
+}
+
+// alertTitle describes an anomaly in a line
+func alertTitle(a *store.LogPatternAnomaly) string {
+	severity := strings.ToLower(a.Severity)
+	switch a.Kind {
+	case store.LogAnomalyNewPattern:
+		if a.ServiceVersion != "" {
+			return fmt.Sprintf("New %s log pattern in %s %s", severity, a.ServiceName, a.ServiceVersion)
+		}
+		return fmt.Sprintf("New %s log pattern in %s", severity, a.ServiceName)
+	case store.LogAnomalyNewInVersion:
+		return fmt.Sprintf("%s %s logs a %s pattern again", a.ServiceName, a.ServiceVersion, severity)
+	case store.LogAnomalySpike:
+		return fmt.Sprintf("Spike of a %s log pattern in %s: %d lines, %.0f expected", severity, a.ServiceName, a.Count, a.Expected)
+	}
+	return fmt.Sprintf("Drop of a %s log pattern in %s: %d lines, %.0f expected", severity, a.ServiceName, a.Count, a.Expected)
+}
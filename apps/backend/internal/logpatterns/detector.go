@@ -0,0 +1,345 @@
+package logpatterns
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/namlabs/obsfly/backend/internal/store"
+)
+
+// Detection parameters
+const (
+	detectWindow     = 10 * time.Minute   // lines counted for spikes and drops
+	baselineRange    = 7 * 24 * time.Hour // history a pattern's rate is compared with
+	minBaselineHours = 6                  // history needed before rates are judged
+	serviceWarmup    = time.Hour          // a service's patterns are all new at first; they are not reported
+	reappearGap      = 24 * time.Hour     // absence after which a pattern logged by a new version is reported
+	emittedRetention = 48 * time.Hour
+
+	spikeMinCount   = 20  // lines in the window before a spike is reported
+	spikeRatio      = 3   // times the expected lines
+	spikeScore      = 5   // standard deviations above the expected lines
+	dropMinExpected = 50  // expected lines before a drop is reported
+	dropRatio       = 0.1 // share of the expected lines left
+)
+
+// anomalyNamespace seeds the deterministic IDs of anomalies
+var anomalyNamespace = uuid.MustParse("a4c2e7d1-58b3-4f6e-9d0a-7b1e3c5f8a92")
+
+// Detector periodically looks for log patterns that are new to a service or
+// version, or whose frequency deviates sharply from their baseline, records
+// them and raises alerts for them.
+type Detector struct {
+	store   *store.Store
+	alerter *Alerter
+	tick    time.Duration
+
+	lastRun      time.Time
+	baseline     map[patternKey]store.LogPatternRate
+	baselineHour time.Time
+	serviceStart map[serviceKey]time.Time
+	emitted      map[string]time.Time // anomaly ID -> detection time
+}
+
+type patternKey struct {
+	accountId uint64
+	service   string
+	hash      string
+}
+
+type serviceKey struct {
+	accountId uint64
+	service   string
+}
+
+func NewDetector(st *store.Store, alerter *Alerter, tick time.Duration) *Detector {
+	return &Detector{
+		store:        st,
+		alerter:      alerter,
+		tick:         tick,
+		serviceStart: make(map[serviceKey]time.Time),
+		emitted:      make(map[string]time.Time),
+	}
+}
+
+func (d *Detector) Start(ctx context.Context) {
+	ticker := time.NewTicker(d.tick)
+	defer ticker.Stop()
+
+	fmt.Printf("Starting log anomaly detector (tick %s)\n", d.tick)
+
+	// Anomalies recorded before a restart are not reported again
+	recent, err := d.store.GetLogPatternAnomalies(ctx, store.LogAnomalyFilter{From: time.Now().Add(-emittedRetention), Limit: 100000})
+	if err != nil {
+		fmt.Printf("Error loading recent log anomalies: %v\n", err)
+	}
+	for _, a := range recent {
+		d.emitted[a.AnomalyId] = a.DetectedAt
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			fmt.Println("Log anomaly detector shutting down")
+			return
+		case <-ticker.C:
+			if err := d.detect(ctx); err != nil {
+				fmt.Printf("Error detecting log anomalies: %v\n", err)
+			}
+		}
+	}
+}
+
+// detect records the anomalies found since the last run. Runs overlap by a
+// window, so late logs are still seen; anomalies already recorded are
+// skipped.
+func (d *Detector) detect(ctx context.Context) error {
+	now := time.Now()
+	since := now.Add(-detectWindow)
+	if !d.lastRun.IsZero() {
+		since = d.lastRun.Add(-detectWindow)
+	}
+
+	novel, err := d.novelPatterns(ctx, since, now)
+	if err != nil {
+		return err
+	}
+	shifts, err := d.rateShifts(ctx, now)
+	if err != nil {
+		return err
+	}
+
+	anomalies := append(novel, shifts...)
+	var fresh []*store.LogPatternAnomaly
+	for i := range anomalies {
+		if _, ok := d.emitted[anomalies[i].AnomalyId]; !ok {
+			fresh = append(fresh, &anomalies[i])
+		}
+	}
+	if err := d.fillTemplates(ctx, fresh); err != nil {
+		return err
+	}
+
+	for _, a := range fresh {
+		if shouldAlert(a) {
+			if err := d.alerter.Alert(ctx, a); err != nil {
+				fmt.Printf("Error raising log anomaly alert: %v\n", err)
+			} else {
+				a.Alerted = true
+			}
+		}
+		if err := d.store.SaveLogPatternAnomaly(ctx, a); err != nil {
+			return err
+		}
+		d.emitted[a.AnomalyId] = a.DetectedAt
+	}
+	d.lastRun = now
+
+	cutoff := now.Add(-emittedRetention)
+	for id, at := range d.emitted {
+		if at.Before(cutoff) {
+			delete(d.emitted, id)
+		}
+	}
+	return nil
+}
+
+// novelPatterns finds patterns a service logged for the first time, and
+// patterns a version brought back after the service had not logged them
+// for a while
+func (d *Detector) novelPatterns(ctx context.Context, since, now time.Time) ([]store.LogPatternAnomaly, error) {
+	stats, err := d.store.GetLogPatternVersionStats(ctx, since)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.loadServiceStarts(ctx, stats); err != nil {
+		return nil, err
+	}
+
+	byPattern := make(map[patternKey][]store.LogPatternVersionStats)
+	for _, st := range stats {
+		key := patternKey{st.AccountId, st.ServiceName, st.PatternHash}
+		byPattern[key] = append(byPattern[key], st)
+	}
+
+	var anomalies []store.LogPatternAnomaly
+	for key, versions := range byPattern {
+		start := d.serviceStart[serviceKey{key.accountId, key.service}]
+		if start.IsZero() {
+			continue
+		}
+
+		first := versions[0]
+		for _, v := range versions[1:] {
+			if v.FirstSeen.Before(first.FirstSeen) {
+				first = v
+			}
+		}
+
+		for _, v := range versions {
+			if !v.FirstSeen.After(since) || v.FirstSeen.Before(start.Add(serviceWarmup)) {
+				continue
+			}
+			kind := store.LogAnomalyNewPattern
+			if v.ServiceVersion != first.ServiceVersion {
+				if !absentBefore(versions, v) {
+					continue
+				}
+				kind = store.LogAnomalyNewInVersion
+			}
+			anomalies = append(anomalies, store.LogPatternAnomaly{
+				AnomalyId:      anomalyId(kind, key, v.ServiceVersion, ""),
+				AccountId:      key.accountId,
+				Kind:           kind,
+				ServiceName:    key.service,
+				ServiceVersion: v.ServiceVersion,
+				PatternHash:    key.hash,
+				Severity:       v.Severity,
+				Count:          v.Count,
+				FirstSeen:      v.FirstSeen,
+				DetectedAt:     now,
+			})
+		}
+	}
+	return anomalies, nil
+}
+
+// absentBefore reports whether no other version logged the pattern in the
+// gap before v first did
+func absentBefore(versions []store.LogPatternVersionStats, v store.LogPatternVersionStats) bool {
+	for _, other := range versions {
+		if other.ServiceVersion != v.ServiceVersion && other.LastSeen.After(v.FirstSeen.Add(-reappearGap)) {
+			return false
+		}
+	}
+	return true
+}
+
+// loadServiceStarts looks up when services new to the detector first
+// logged a pattern
+func (d *Detector) loadServiceStarts(ctx context.Context, stats []store.LogPatternVersionStats) error {
+	var services []string
+	seen := make(map[string]bool)
+	for _, st := range stats {
+		if _, ok := d.serviceStart[serviceKey{st.AccountId, st.ServiceName}]; !ok && !seen[st.ServiceName] {
+			seen[st.ServiceName] = true
+			services = append(services, st.ServiceName)
+		}
+	}
+	if len(services) == 0 {
+		return nil
+	}
+
+	starts, err := d.store.GetLogPatternServiceStarts(ctx, services)
+	if err != nil {
+		return err
+	}
+	for _, st := range starts {
+		d.serviceStart[serviceKey{st.AccountId, st.ServiceName}] = st.FirstSeen
+	}
+	return nil
+}
+
+// rateShifts compares how often each pattern was logged in the last window
+// with its hourly rate over the baseline range
+func (d *Detector) rateShifts(ctx context.Context, now time.Time) ([]store.LogPatternAnomaly, error) {
+	hour := now.Truncate(time.Hour)
+	if !hour.Equal(d.baselineHour) {
+		// The baseline covers whole hours, so it changes once an hour
+		rates, err := d.store.GetLogPatternBaselines(ctx, hour.Add(-baselineRange), hour)
+		if err != nil {
+			return nil, err
+		}
+		d.baseline = make(map[patternKey]store.LogPatternRate, len(rates))
+		for _, r := range rates {
+			d.baseline[patternKey{r.AccountId, r.ServiceName, r.PatternHash}] = r
+		}
+		d.baselineHour = hour
+	}
+
+	rates, err := d.store.GetLogPatternRates(ctx, detectWindow)
+	if err != nil {
+		return nil, err
+	}
+	current := make(map[patternKey]store.LogPatternRate, len(rates))
+	for _, r := range rates {
+		current[patternKey{r.AccountId, r.ServiceName, r.PatternHash}] = r
+	}
+
+	var anomalies []store.LogPatternAnomaly
+	for key, base := range d.baseline {
+		from := base.FirstSeen.Truncate(time.Hour)
+		if from.Before(hour.Add(-baselineRange)) {
+			from = hour.Add(-baselineRange)
+		}
+		hours := hour.Sub(from).Hours()
+		if hours < minBaselineHours {
+			continue
+		}
+
+		expected := float64(base.Count) / hours * detectWindow.Hours()
+		cur, ok := current[key]
+		if !ok {
+			cur = base
+			cur.Count = 0
+		}
+		count := float64(cur.Count)
+		score := (count - expected) / math.Sqrt(math.Max(expected, 1))
+
+		var kind string
+		switch {
+		case count >= spikeMinCount && count >= spikeRatio*expected && score >= spikeScore:
+			kind = store.LogAnomalySpike
+		case expected >= dropMinExpected && count <= dropRatio*expected:
+			kind = store.LogAnomalyDrop
+		default:
+			continue
+		}
+		anomalies = append(anomalies, store.LogPatternAnomaly{
+			AnomalyId:      anomalyId(kind, key, "", hour.Format(time.RFC3339)),
+			AccountId:      key.accountId,
+			Kind:           kind,
+			ServiceName:    key.service,
+			ServiceVersion: cur.ServiceVersion,
+			PatternHash:    key.hash,
+			Severity:       cur.Severity,
+			Count:          cur.Count,
+			Expected:       expected,
+			Score:          score,
+			FirstSeen:      base.FirstSeen,
+			DetectedAt:     now,
+		})
+	}
+	return anomalies, nil
+}
+
+// fillTemplates sets the templates of anomalies from the pattern catalog
+func (d *Detector) fillTemplates(ctx context.Context, anomalies []*store.LogPatternAnomaly) error {
+	hashes := make(map[uint64][]string)
+	for _, a := range anomalies {
+		hashes[a.AccountId] = append(hashes[a.AccountId], a.PatternHash)
+	}
+	for accountId, list := range hashes {
+		templates, err := d.store.GetLogPatternTemplatesByHash(ctx, accountId, list)
+		if err != nil {
+			return err
+		}
+		for _, a := range anomalies {
+			if t, ok := templates[a.PatternHash]; ok && a.AccountId == accountId {
+				a.Template = t.Template
+			}
+		}
+	}
+	return nil
+}
+
+// anomalyId derives an anomaly's ID, so an anomaly found by overlapping
+// runs, or again after a restart, is recorded once. Rate shifts get one ID
+// per hour.
+func anomalyId(kind string, key patternKey, version, period string) string {
+	name := fmt.Sprintf("%s/%d/%s/%s/%s/%s", kind, key.accountId, key.service, key.hash, version, period)
+	return uuid.NewSHA1(anomalyNamespace, []byte(name)).String()
+}
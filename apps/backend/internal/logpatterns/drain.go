@@ -0,0 +1,175 @@
+package logpatterns
+
+import (
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Wildcard stands for a token that varies between lines of a template
+const Wildcard = "<*>"
+
+// Tree parameters, as in the Drain paper
+const (
+	treeDepth           = 2   // leading tokens routed on below the length level
+	similarityThreshold = 0.4 // share of matching tokens needed to join a template
+	maxChildren         = 100 // distinct tokens per tree node before routing to the wildcard
+)
+
+var (
+	uuidPattern   = regexp.MustCompile(`[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`)
+	ipPattern     = regexp.MustCompile(`\b\d{1,3}(?:\.\d{1,3}){3}(?::\d{1,5})?\b`)
+	hexPattern    = regexp.MustCompile(`\b0x[0-9a-fA-F]+\b|\b[0-9a-fA-F]{8,}\b`)
+	numberPattern = regexp.MustCompile(`\b\d+(?:\.\d+)?(?:[a-zA-Z]{1,3}\b|%|\b)`)
+)
+
+// Mask replaces the variable parts of a log line, such as numbers, IDs, IPs
+// and UUIDs, with placeholders. The most specific masks go first, so an IP
+// is not masked as numbers.
+func Mask(body string) string {
+	body = uuidPattern.ReplaceAllString(body, "<UUID>")
+	body = ipPattern.ReplaceAllString(body, "<IP>")
+	body = hexPattern.ReplaceAllStringFunc(body, func(s string) string {
+		switch {
+		case strings.HasPrefix(s, "0x"):
+			return "<HEX>"
+		case strings.Trim(s, "0123456789") == "":
+			return "<NUM>"
+		case strings.ContainsAny(s, "0123456789"):
+			return "<HEX>"
+		}
+		return s // a word made of the letters a to f
+	})
+	return numberPattern.ReplaceAllString(body, "<NUM>")
+}
+
+// cluster is a template and the lines that joined it
+type cluster struct {
+	hash      string
+	tokens    []string
+	examples  []string
+	firstSeen time.Time
+}
+
+func (c *cluster) template() string {
+	return strings.Join(c.tokens, " ")
+}
+
+// node is a level of the prefix tree. Leaves hold the clusters of lines
+// sharing a length and leading tokens.
+type node struct {
+	children map[string]*node
+	clusters []*cluster
+}
+
+func newNode() *node {
+	return &node{children: make(map[string]*node)}
+}
+
+// drain clusters the lines of one service into templates
+type drain struct {
+	root *node
+	size int
+}
+
+func newDrain() *drain {
+	return &drain{root: newNode()}
+}
+
+// leaf returns the leaf for a line's tokens, creating the path as needed.
+// Tokens holding values route to the wildcard child, as do new tokens once
+// a node is full.
+func (d *drain) leaf(tokens []string) *node {
+	path := []string{strconv.Itoa(len(tokens))}
+	for i := 0; i < treeDepth && i < len(tokens); i++ {
+		path = append(path, tokens[i])
+	}
+
+	n := d.root
+	for i, key := range path {
+		if i > 0 && hasVariable(key) {
+			key = Wildcard
+		}
+		child, ok := n.children[key]
+		if !ok && i > 0 && len(n.children) >= maxChildren {
+			key = Wildcard
+			child, ok = n.children[key]
+		}
+		if !ok {
+			child = newNode()
+			n.children[key] = child
+		}
+		n = child
+	}
+	return n
+}
+
+// match finds the most similar template in a leaf, or nil when none is
+// similar enough
+func match(leaf *node, tokens []string) *cluster {
+	var best *cluster
+	bestScore, bestWildcards := -1.0, -1
+	for _, c := range leaf.clusters {
+		same, wildcards := 0, 0
+		for i, t := range c.tokens {
+			switch {
+			case t == Wildcard:
+				wildcards++
+			case t == tokens[i]:
+				same++
+			}
+		}
+		score := 1.0
+		if len(tokens) > 0 {
+			score = float64(same) / float64(len(tokens))
+		}
+		if score > bestScore || score == bestScore && wildcards > bestWildcards {
+			best, bestScore, bestWildcards = c, score, wildcards
+		}
+	}
+	if best == nil || bestScore < similarityThreshold {
+		return nil
+	}
+	return best
+}
+
+// merge widens a template to cover tokens, reporting whether it changed
+func (c *cluster) merge(tokens []string) bool {
+	changed := false
+	for i, t := range c.tokens {
+		if t != Wildcard && t != tokens[i] {
+			c.tokens[i] = Wildcard
+			changed = true
+		}
+	}
+	return changed
+}
+
+// add inserts a known template, as loaded from the catalog
+func (d *drain) add(c *cluster) {
+	leaf := d.leaf(c.tokens)
+	leaf.clusters = append(leaf.clusters, c)
+	d.size++
+}
+
+// hashTemplate derives a pattern hash from the service and the first
+// template of a cluster. The hash stays with the cluster as it widens.
+func hashTemplate(service string, tokens []string) string {
+	h := fnv.New64a()
+	h.Write([]byte(service))
+	h.Write([]byte{0})
+	h.Write([]byte(strings.Join(tokens, " ")))
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// hasVariable reports whether a token holds a masked or numeric value,
+// which should not decide the tree path
+func hasVariable(token string) bool {
+	if token == Wildcard || strings.HasPrefix(token, "<") && strings.HasSuffix(token, ">") {
+		return true
+	}
+	return strings.ContainsAny(token, "0123456789")
+}
@@ -0,0 +1,252 @@
+package logpatterns
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestMask(t *testing.T) {
+	tests := []struct {
+		body string
+		want string
+	}{
+		{"user 42 logged in", "user <NUM> logged in"},
+		{"took 1.5s, 20ms and 93%", "took <NUM>, <NUM> and <NUM>"},
+		{"from 10.0.0.1:8080", "from <IP>"},
+		{"request 123e4567-e89b-12d3-a456-426614174000 failed", "request <UUID> failed"},
+		{"pointer 0xdeadBEEF", "pointer <HEX>"},
+		{"commit a1b2c3d4e5", "commit <HEX>"},
+		{"id 12345678", "id <NUM>"},
+		{"deadbeef facade", "deadbeef facade"},
+		{"http2 v2 abc123", "http2 v2 abc123"},
+		{"no values here", "no values here"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.body, func(t *testing.T) {
+			if got := Mask(tt.body); got != tt.want {
+				t.Errorf("Mask(%q) = %q, want %q", tt.body, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMine(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []string
+		want  []string // the final template of each line's cluster
+	}{
+		{
+			name:  "masked values share a template",
+			lines: []string{"GET /api/users took 12ms", "GET /api/users took 30ms"},
+			want:  []string{"GET /api/users took <NUM>", "GET /api/users took <NUM>"},
+		},
+		{
+			name:  "differing tokens widen the template",
+			lines: []string{"connection to redis lost: timeout", "connection to redis lost: reset"},
+			want:  []string{"connection to redis lost: <*>", "connection to redis lost: <*>"},
+		},
+		{
+			name:  "lengths are kept apart",
+			lines: []string{"job done", "job done twice"},
+			want:  []string{"job done", "job done twice"},
+		},
+		{
+			name:  "leading tokens are kept apart",
+			lines: []string{"user alice logged in", "user bob logged in"},
+			want:  []string{"user alice logged in", "user bob logged in"},
+		},
+		{
+			name:  "leading tokens with values route together",
+			lines: []string{"worker-a1 failed", "worker-b2 failed"},
+			want:  []string{"<*> failed", "<*> failed"},
+		},
+		{
+			name:  "similar at the threshold",
+			lines: []string{"disk sda a b c", "disk sda x y z"},
+			want:  []string{"disk sda <*> <*> <*>", "disk sda <*> <*> <*>"},
+		},
+		{
+			name:  "below the threshold",
+			lines: []string{"disk sda a b c d", "disk sda w x y z"},
+			want:  []string{"disk sda a b c d", "disk sda w x y z"},
+		},
+		{
+			name:  "empty line",
+			lines: []string{"", "  "},
+			want:  []string{"", ""},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewMiner(nil)
+			key := drainKey{1, "api"}
+			hashes := make([]string, len(tt.lines))
+			for i, line := range tt.lines {
+				hashes[i] = m.mine(key, line, time.Time{})
+			}
+
+			saved, _ := m.takeDirty()
+			templates := make(map[string]string)
+			for _, tmpl := range saved {
+				templates[tmpl.PatternHash] = tmpl.Template
+			}
+			for i, hash := range hashes {
+				if got := templates[hash]; got != tt.want[i] {
+					t.Errorf("line %q: template = %q, want %q", tt.lines[i], got, tt.want[i])
+				}
+			}
+			for i := range hashes {
+				for j := range hashes {
+					if same := hashes[i] == hashes[j]; same != (tt.want[i] == tt.want[j]) {
+						t.Errorf("lines %q and %q share a hash = %v", tt.lines[i], tt.lines[j], same)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestMineHashes(t *testing.T) {
+	m := NewMiner(nil)
+	hash := m.mine(drainKey{1, "api"}, "cache miss for key", time.Time{})
+	if want := hashTemplate("api", []string{"cache", "miss", "for", "key"}); hash != want {
+		t.Errorf("hash = %s, want %s", hash, want)
+	}
+
+	// The hash stays with the cluster as it widens
+	if widened := m.mine(drainKey{1, "api"}, "cache miss for session", time.Time{}); widened != hash {
+		t.Errorf("widened hash = %s, want %s", widened, hash)
+	}
+	// Services and accounts have their own templates
+	if other := m.mine(drainKey{1, "web"}, "cache miss for key", time.Time{}); other == hash {
+		t.Error("another service got the same hash")
+	}
+	if other := m.mine(drainKey{2, "api"}, "cache miss for key", time.Time{}); other != hash {
+		t.Error("another account got a different hash for the same service and template")
+	}
+	if len(m.drains) != 3 {
+		t.Errorf("drains = %d, want 3", len(m.drains))
+	}
+}
+
+func TestMineLoadedTemplate(t *testing.T) {
+	m := NewMiner(nil)
+	key := drainKey{1, "api"}
+	m.drain(key).add(&cluster{hash: "cataloged", tokens: strings.Fields("payment for <*> declined")})
+
+	if hash := m.mine(key, "payment for card declined", time.Time{}); hash != "cataloged" {
+		t.Errorf("hash = %s, want the cataloged hash", hash)
+	}
+}
+
+func TestMineDirty(t *testing.T) {
+	m := NewMiner(nil)
+	key := drainKey{7, "api"}
+	first := time.Date(2024, 3, 15, 10, 0, 0, 0, time.UTC)
+	m.mine(key, "worker started", first)
+	m.mine(key, "worker started", first.Add(time.Minute))
+
+	templates, keys := m.takeDirty()
+	if len(templates) != 1 || len(keys) != 1 {
+		t.Fatalf("takeDirty = %d templates, want 1", len(templates))
+	}
+	got := templates[0]
+	if got.AccountId != 7 || got.ServiceName != "api" || got.Template != "worker started" || !got.FirstSeen.Equal(first) {
+		t.Errorf("template = %+v", got)
+	}
+	if len(got.Examples) != 1 {
+		t.Errorf("examples = %q, want one", got.Examples)
+	}
+
+	// Unchanged templates are not saved again
+	m.mine(key, "worker started", first.Add(2*time.Minute))
+	if templates, _ := m.takeDirty(); templates != nil {
+		t.Errorf("takeDirty = %+v, want none", templates)
+	}
+}
+
+func TestMineLimits(t *testing.T) {
+	t.Run("tokens", func(t *testing.T) {
+		m := NewMiner(nil)
+		key := drainKey{1, "api"}
+		long := strings.Repeat("word ", maxTokens)
+		a := m.mine(key, long+"tail one", time.Time{})
+		b := m.mine(key, long+"different ending", time.Time{})
+		if a != b {
+			t.Error("lines differing past the token limit got different hashes")
+		}
+		saved, _ := m.takeDirty()
+		for _, tmpl := range saved {
+			if n := len(strings.Fields(tmpl.Template)); n != maxTokens {
+				t.Errorf("template has %d tokens, want %d", n, maxTokens)
+			}
+		}
+	})
+
+	t.Run("patterns per service", func(t *testing.T) {
+		m := NewMiner(nil)
+		key := drainKey{1, "api"}
+		known := m.mine(key, "known line here", time.Time{})
+		m.takeDirty()
+		m.drain(key).size = maxPatternsPerService
+
+		hash := m.mine(key, "brand new line", time.Time{})
+		if want := hashTemplate("api", []string{"brand", "new", "line"}); hash != want {
+			t.Errorf("hash = %s, want %s", hash, want)
+		}
+		if m.drain(key).size != maxPatternsPerService {
+			t.Errorf("size = %d, want %d", m.drain(key).size, maxPatternsPerService)
+		}
+		if hash := m.mine(key, "known line there", time.Time{}); hash != known {
+			t.Error("a known template stopped matching at the limit")
+		}
+		saved, _ := m.takeDirty()
+		for _, tmpl := range saved {
+			if tmpl.PatternHash != known {
+				t.Errorf("cataloged %q past the limit", tmpl.Template)
+			}
+		}
+	})
+
+	t.Run("children per node", func(t *testing.T) {
+		m := NewMiner(nil)
+		key := drainKey{1, "api"}
+		for i := 0; i < maxChildren+50; i++ {
+			m.mine(key, fmt.Sprintf("%c%c event", 'a'+i/26, 'a'+i%26), time.Time{})
+		}
+		level := m.drain(key).root.children["2"]
+		if len(level.children) != maxChildren+1 {
+			t.Errorf("children = %d, want %d", len(level.children), maxChildren+1)
+		}
+		if _, ok := level.children[Wildcard]; !ok {
+			t.Error("no wildcard child once the node is full")
+		}
+	})
+
+	t.Run("examples", func(t *testing.T) {
+		m := NewMiner(nil)
+		key := drainKey{1, "api"}
+		long := "payload body " + strings.Repeat("é", maxExampleLength)
+		for _, line := range []string{long, "payload body a", "payload body a", "payload body b", "payload body c"} {
+			m.mine(key, line, time.Time{})
+		}
+		templates, _ := m.takeDirty()
+		if len(templates) != 1 {
+			t.Fatalf("templates = %d, want 1", len(templates))
+		}
+		examples := templates[0].Examples
+		if len(examples) != maxExamples {
+			t.Fatalf("examples = %q, want %d", examples, maxExamples)
+		}
+		if len(examples[0]) > maxExampleLength || !utf8.ValidString(examples[0]) {
+			t.Errorf("example of %d bytes, valid UTF-8 %v", len(examples[0]), utf8.ValidString(examples[0]))
+		}
+		if examples[1] != "payload body a" || examples[2] != "payload body b" {
+			t.Errorf("examples = %q", examples[1:])
+		}
+	})
+}
@@ -0,0 +1,165 @@
+package logpatterns
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/namlabs/obsfly/backend/internal/store"
+)
+
+// Bounds on what the miner keeps in memory and in the catalog
+const (
+	maxTokens             = 100  // tokens of a line compared; the rest are ignored
+	maxPatternsPerService = 2000 // templates per service before new ones stop being cataloged
+	maxExamples           = 3
+	maxExampleLength      = 500
+)
+
+// Miner assigns a PatternHash to logs as they are ingested by clustering
+// their bodies into templates with Drain, one tree per account and service.
+// New and widened templates are saved to the pattern catalog with a few
+// example lines.
+type Miner struct {
+	store *store.Store
+
+	mu     sync.Mutex
+	drains map[drainKey]*drain
+	dirty  map[*cluster]drainKey // clusters changed since the last save
+}
+
+type drainKey struct {
+	accountId uint64
+	service   string
+}
+
+func NewMiner(st *store.Store) *Miner {
+	return &Miner{
+		store:  st,
+		drains: make(map[drainKey]*drain),
+		dirty:  make(map[*cluster]drainKey),
+	}
+}
+
+// Load restores the templates saved in the catalog, so logs keep their
+// pattern hashes across restarts
+func (m *Miner) Load(ctx context.Context) error {
+	templates, err := m.store.GetLogPatternTemplates(ctx)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, t := range templates {
+		m.drain(drainKey{t.AccountId, t.ServiceName}).add(&cluster{
+			hash:      t.PatternHash,
+			tokens:    strings.Fields(t.Template),
+			examples:  t.Examples,
+			firstSeen: t.FirstSeen,
+		})
+	}
+	fmt.Printf("Loaded %d log pattern templates\n", len(templates))
+	return nil
+}
+
+// Process sets the PatternHash of each log that has none and saves the
+// templates that changed. Logs keep their hashes when saving fails; the
+// templates are saved with the next batch.
+func (m *Miner) Process(ctx context.Context, logs []store.Log) error {
+	m.mu.Lock()
+	for i := range logs {
+		l := &logs[i]
+		if l.PatternHash != "" {
+			continue
+		}
+		l.PatternHash = m.mine(drainKey{l.AccountId, l.ServiceName}, l.Body, l.Timestamp)
+	}
+	changed, keys := m.takeDirty()
+	m.mu.Unlock()
+
+	if len(changed) == 0 {
+		return nil
+	}
+	if err := m.store.SaveLogPatternTemplates(ctx, changed); err != nil {
+		m.mu.Lock()
+		for c, key := range keys {
+			m.dirty[c] = key
+		}
+		m.mu.Unlock()
+		return fmt.Errorf("failed to save log patterns: %w", err)
+	}
+	return nil
+}
+
+// mine finds or creates the template for a line and returns its hash
+func (m *Miner) mine(key drainKey, body string, at time.Time) string {
+	d := m.drain(key)
+	tokens := strings.Fields(Mask(body))
+	if len(tokens) > maxTokens {
+		tokens = tokens[:maxTokens]
+	}
+
+	leaf := d.leaf(tokens)
+	c := match(leaf, tokens)
+	switch {
+	case c == nil && d.size >= maxPatternsPerService:
+		// Too many templates already; hash the line's own template
+		// without keeping it
+		return hashTemplate(key.service, tokens)
+	case c == nil:
+		c = &cluster{hash: hashTemplate(key.service, tokens), tokens: slices.Clone(tokens), firstSeen: at}
+		leaf.clusters = append(leaf.clusters, c)
+		d.size++
+		m.dirty[c] = key
+	case c.merge(tokens):
+		m.dirty[c] = key
+	}
+
+	if len(c.examples) < maxExamples {
+		example := body
+		if len(example) > maxExampleLength {
+			example = strings.ToValidUTF8(example[:maxExampleLength], "")
+		}
+		if !slices.Contains(c.examples, example) {
+			c.examples = append(c.examples, example)
+			m.dirty[c] = key
+		}
+	}
+	return c.hash
+}
+
+// takeDirty returns the changed clusters as catalog entries and clears
+// the dirty set
+func (m *Miner) takeDirty() ([]store.LogPatternTemplate, map[*cluster]drainKey) {
+	if len(m.dirty) == 0 {
+		return nil, nil
+	}
+	keys := m.dirty
+	m.dirty = make(map[*cluster]drainKey)
+
+	templates := make([]store.LogPatternTemplate, 0, len(keys))
+	for c, key := range keys {
+		templates = append(templates, store.LogPatternTemplate{
+			AccountId:   key.accountId,
+			ServiceName: key.service,
+			PatternHash: c.hash,
+			Template:    c.template(),
+			Examples:    slices.Clone(c.examples),
+			FirstSeen:   c.firstSeen,
+		})
+	}
+	return templates, keys
+}
+
+func (m *Miner) drain(key drainKey) *drain {
+	d, ok := m.drains[key]
+	if !ok {
+		d = newDrain()
+		m.drains[key] = d
+	}
+	return d
+}
@@ -0,0 +1,260 @@
+package logtail
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/namlabs/obsfly/backend/internal/store"
+)
+
+// Defaults and bounds for tail options
+const (
+	DefaultMaxRate         = 100  // lines per second sent to a client
+	MaxRate                = 1000 // upper bound a client may ask for
+	DefaultSampleThreshold = 200  // matching lines per second before sampling starts
+
+	pollInterval  = time.Second
+	ingestDelay   = 2 * time.Second  // logs younger than this are left for the next poll, so late batches are not skipped
+	maxLag        = 30 * time.Second // falling further behind than this skips ahead
+	maxStartBack  = 5 * time.Minute  // how far back a resumed tail may start
+	statsInterval = 5 * time.Second
+	fetchLimit    = 5000 // rows read per poll
+)
+
+// Options control how much of the matching volume is sent to the client
+type Options struct {
+	MaxRate         int // lines per second sent; excess lines are dropped
+	SampleThreshold int // matching lines per second above which lines are sampled
+}
+
+// Event is sent to the client: a log line, or the tail's stats
+type Event struct {
+	Type  string          // "log" or "stats"
+	Log   *store.LogEntry // for log events
+	Stats *Stats          // for stats events
+}
+
+// Stats describe what the tail did since it started
+type Stats struct {
+	Matched    uint64    `json:"matched"`     // lines matching the filters
+	Sent       uint64    `json:"sent"`        // lines sent to the client
+	SampledOut uint64    `json:"sampled_out"` // lines left out by sampling
+	Dropped    uint64    `json:"dropped"`     // lines over the rate limit
+	Skipped    uint64    `json:"skipped"`     // times the tail fell too far behind and skipped ahead
+	SampleRate float64   `json:"sample_rate"` // fraction of lines currently kept, 1 when not sampling
+	Watermark  time.Time `json:"watermark"`   // timestamp of the newest line read
+}
+
+// Tail streams logs as they are ingested by polling for lines newer than
+// the last one read. Lines are read ingestDelay behind real time, so batches
+// that arrive slightly late are still picked up.
+type Tail struct {
+	store *store.Store
+	req   store.LogsListRequest
+	opts  Options
+
+	watermark time.Time
+	boundary  map[string]bool // IDs of the lines read at exactly the watermark
+	tokens    float64
+	stride    uint64 // keep 1 in stride lines while sampling
+	counter   uint64
+	stats     Stats
+}
+
+// New starts a tail at start, or at now when start is zero. Starts further
+// back than a few minutes are moved up.
+func New(st *store.Store, req store.LogsListRequest, start time.Time, opts Options) *Tail {
+	if opts.MaxRate <= 0 {
+		opts.MaxRate = DefaultMaxRate
+	}
+	opts.MaxRate = min(opts.MaxRate, MaxRate)
+	if opts.SampleThreshold <= 0 {
+		opts.SampleThreshold = DefaultSampleThreshold
+	}
+
+	now := time.Now()
+	if start.IsZero() {
+		start = now.Add(-ingestDelay)
+	}
+	if start.Before(now.Add(-maxStartBack)) {
+		start = now.Add(-maxStartBack)
+	}
+
+	return &Tail{
+		store:     st,
+		req:       req,
+		opts:      opts,
+		watermark: start,
+		boundary:  map[string]bool{},
+		tokens:    float64(opts.MaxRate),
+		stride:    1,
+		stats:     Stats{SampleRate: 1, Watermark: start},
+	}
+}
+
+// Run polls until ctx is done or emit fails, which happens when the client
+// goes away. It returns nil when ctx is cancelled.
+func (t *Tail) Run(ctx context.Context, emit func(Event) error) error {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	lastPoll := time.Now()
+	lastStats := lastPoll
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case now := <-ticker.C:
+			lines, err := t.poll(ctx, now, now.Sub(lastPoll))
+			lastPoll = now
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return err
+			}
+			for i := range lines {
+				if err := emit(Event{Type: "log", Log: &lines[i]}); err != nil {
+					return err
+				}
+			}
+			if now.Sub(lastStats) >= statsInterval {
+				lastStats = now
+				stats := t.stats
+				if err := emit(Event{Type: "stats", Stats: &stats}); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// poll reads the lines that arrived since the last poll and returns the
+// ones to send, after sampling and rate limiting
+func (t *Tail) poll(ctx context.Context, now time.Time, elapsed time.Duration) ([]store.LogEntry, error) {
+	until := now.Add(-ingestDelay)
+	if !until.After(t.watermark) {
+		return nil, nil
+	}
+
+	// Read from the watermark inclusively, skipping the lines already read
+	// at that exact timestamp; many lines can share one
+	rows, err := t.store.TailLogs(ctx, t.req, t.watermark, until, true, fetchLimit)
+	if err != nil {
+		return nil, fmt.Errorf("tail poll failed: %w", err)
+	}
+
+	fresh := make([]store.LogEntry, 0, len(rows))
+	for _, row := range rows {
+		if row.Timestamp.Equal(t.watermark) && t.boundary[row.LogId] {
+			continue
+		}
+		fresh = append(fresh, row)
+	}
+	t.advance(rows, until)
+	t.stats.Matched += uint64(len(fresh))
+
+	t.updateSampling(len(fresh), len(rows) == fetchLimit, elapsed)
+	t.tokens = math.Min(float64(t.opts.MaxRate), t.tokens+float64(t.opts.MaxRate)*elapsed.Seconds())
+
+	send := make([]store.LogEntry, 0, len(fresh))
+	for _, row := range fresh {
+		t.counter++
+		if t.counter%t.stride != 0 {
+			t.stats.SampledOut++
+			continue
+		}
+		if t.tokens < 1 {
+			t.stats.Dropped++
+			continue
+		}
+		t.tokens--
+		send = append(send, row)
+	}
+	t.stats.Sent += uint64(len(send))
+	return send, nil
+}
+
+// advance moves the watermark to until, or to the newest line read when
+// the read was cut short by the fetch limit, remembering the lines read at
+// the new watermark so the next inclusive read skips them
+func (t *Tail) advance(rows []store.LogEntry, until time.Time) {
+	watermark := until
+	if len(rows) == fetchLimit {
+		last := rows[len(rows)-1].Timestamp
+		switch {
+		case until.Sub(last) > maxLag:
+			// Too far behind to catch up; jump ahead rather than replay
+			t.stats.Skipped++
+		case last.Equal(t.watermark):
+			// A full read of lines sharing one timestamp; move past them
+			watermark = last.Add(time.Nanosecond)
+			t.stats.Skipped++
+		default:
+			watermark = last
+		}
+	}
+
+	t.watermark = watermark
+	t.boundary = map[string]bool{}
+	for _, row := range rows {
+		if row.Timestamp.Equal(watermark) {
+			t.boundary[row.LogId] = true
+		}
+	}
+	t.stats.Watermark = watermark
+}
+
+// updateSampling keeps 1 in every stride lines while the matching volume
+// is above the sample threshold
+func (t *Tail) updateSampling(matched int, truncated bool, elapsed time.Duration) {
+	seconds := math.Max(elapsed.Seconds(), pollInterval.Seconds())
+	rate := float64(matched) / seconds
+	if truncated {
+		// The real volume is at least what was read
+		rate = math.Max(rate, float64(fetchLimit)/seconds)
+	}
+
+	t.stride = 1
+	if rate > float64(t.opts.SampleThreshold) {
+		t.stride = uint64(math.Ceil(rate / float64(t.opts.SampleThreshold)))
+	}
+	t.stats.SampleRate = 1 / float64(t.stride)
+}
+
+// Registry bounds the number of concurrent tails per account
+type Registry struct {
+	max    int
+	mu     sync.Mutex
+	active map[uint64]int
+}
+
+func NewRegistry(maxPerAccount int) *Registry {
+	return &Registry{max: maxPerAccount, active: make(map[uint64]int)}
+}
+
+// Acquire reserves a tail for the account, reporting false when the account
+// already has the maximum number open
+func (r *Registry) Acquire(accountId uint64) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.active[accountId] >= r.max {
+		return false
+	}
+	r.active[accountId]++
+	return true
+}
+
+// Release frees a tail reserved with Acquire
+func (r *Registry) Release(accountId uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.active[accountId] <= 1 {
+		delete(r.active, accountId)
+		return
+	}
+	r.active[accountId]--
+}
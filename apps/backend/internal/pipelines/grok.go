@@ -0,0 +1,126 @@
+package pipelines
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// grokPatterns are the named patterns grok expressions can refer to. They
+// follow the common Logstash definitions, in RE2 syntax.
+var grokPatterns = map[string]string{
+	"WORD":              `\b\w+\b`,
+	"NOTSPACE":          `\S+`,
+	"SPACE":             `\s*`,
+	"DATA":              `.*?`,
+	"GREEDYDATA":        `.*`,
+	"QUOTEDSTRING":      `"(?:[^"\\]|\\.)*"|'(?:[^'\\]|\\.)*'`,
+	"INT":               `[+-]?\d+`,
+	"POSINT":            `\b[1-9]\d*\b`,
+	"NONNEGINT":         `\b\d+\b`,
+	"NUMBER":            `[+-]?(?:\d+(?:\.\d+)?|\.\d+)(?:[eE][+-]?\d+)?`,
+	"BASE16NUM":         `(?:0[xX])?[0-9A-Fa-f]+`,
+	"UUID":              `[A-Fa-f0-9]{8}-(?:[A-Fa-f0-9]{4}-){3}[A-Fa-f0-9]{12}`,
+	"USERNAME":          `[a-zA-Z0-9._-]+`,
+	"USER":              `%{USERNAME}`,
+	"EMAILADDRESS":      `[a-zA-Z0-9_.+=:-]+@[0-9A-Za-z][0-9A-Za-z-]{0,62}(?:\.[0-9A-Za-z][0-9A-Za-z-]{0,62})*`,
+	"IPV4":              `(?:(?:25[0-5]|2[0-4]\d|1?\d?\d)\.){3}(?:25[0-5]|2[0-4]\d|1?\d?\d)`,
+	"IPV6":              `(?:[0-9A-Fa-f]{0,4}:){2,7}[0-9A-Fa-f]{0,4}`,
+	"IP":                `(?:%{IPV6}|%{IPV4})`,
+	"HOSTNAME":          `\b[0-9A-Za-z][0-9A-Za-z-]{0,62}(?:\.[0-9A-Za-z][0-9A-Za-z-]{0,62})*\.?\b`,
+	"IPORHOST":          `(?:%{IP}|%{HOSTNAME})`,
+	"HOSTPORT":          `%{IPORHOST}:%{POSINT}`,
+	"PATH":              `(?:/[^/\s?#]*)+`,
+	"URIPATH":           `(?:/[A-Za-z0-9$.+!*'(){},~:;=@#%&_\-]*)+`,
+	"URIPARAM":          `\?[A-Za-z0-9$.+!*'|(){},~@#%&/=:;_?\-\[\]<>]*`,
+	"URIPATHPARAM":      `%{URIPATH}(?:%{URIPARAM})?`,
+	"URI":               `[A-Za-z][A-Za-z0-9+\-.]*://\S+`,
+	"HTTPMETHOD":        `\b(?:GET|HEAD|POST|PUT|DELETE|CONNECT|OPTIONS|TRACE|PATCH)\b`,
+	"LOGLEVEL":          `(?i:trace|debug|info|notice|warn(?:ing)?|err(?:or)?|crit(?:ical)?|fatal|severe|emerg(?:ency)?|alert|panic)`,
+	"MONTH":             `\b(?:Jan(?:uary)?|Feb(?:ruary)?|Mar(?:ch)?|Apr(?:il)?|May|June?|July?|Aug(?:ust)?|Sep(?:tember)?|Oct(?:ober)?|Nov(?:ember)?|Dec(?:ember)?)\b`,
+	"MONTHDAY":          `(?:0[1-9]|[12]\d|3[01]|[1-9])`,
+	"YEAR":              `\d{4}`,
+	"HOUR":              `(?:2[0123]|[01]?\d)`,
+	"MINUTE":            `[0-5]\d`,
+	"SECOND":            `(?:[0-5]?\d|60)(?:[.,]\d+)?`,
+	"TIME":              `%{HOUR}:%{MINUTE}(?::%{SECOND})?`,
+	"ISO8601_TIMEZONE":  `(?:Z|[+-]%{HOUR}(?::?%{MINUTE}))`,
+	"TIMESTAMP_ISO8601": `%{YEAR}-\d{2}-\d{2}[T ]%{HOUR}:?%{MINUTE}(?::?%{SECOND})?%{ISO8601_TIMEZONE}?`,
+	"HTTPDATE":          `%{MONTHDAY}/%{MONTH}/%{YEAR}:%{TIME} [+-]\d{4}`,
+	"SYSLOGTIMESTAMP":   `%{MONTH} +%{MONTHDAY} %{TIME}`,
+}
+
+// grokReference matches %{PATTERN} and %{PATTERN:field}. A third part, the
+// Logstash type conversion, is accepted and ignored: attributes are strings.
+var grokReference = regexp.MustCompile(`%\{(\w+)(?::([\w.@\-]+))?(?::\w+)?\}`)
+
+// grokReservedGroup matches groups of the expression itself named like the
+// groups fields are captured in
+var grokReservedGroup = regexp.MustCompile(`\(\?P?<g\d+>`)
+
+// maxGrokDepth bounds how deeply patterns may refer to each other
+const maxGrokDepth = 10
+
+// compileGrok turns a grok expression into a regex and the attribute name
+// of each of its groups, empty for unnamed ones. Groups are named g0, g1,
+// ... since attribute names such as http.status are not valid group names.
+func compileGrok(expr string) (*regexp.Regexp, []string, error) {
+	if grokReservedGroup.MatchString(expr) {
+		return nil, nil, fmt.Errorf("group names g0, g1, ... are reserved for grok fields")
+	}
+
+	var fields []string
+	var expand func(s string, depth int) (string, error)
+	expand = func(s string, depth int) (string, error) {
+		if depth > maxGrokDepth {
+			return "", fmt.Errorf("grok patterns nest too deeply")
+		}
+		var expandErr error
+		out := grokReference.ReplaceAllStringFunc(s, func(ref string) string {
+			m := grokReference.FindStringSubmatch(ref)
+			def, ok := grokPatterns[m[1]]
+			if !ok {
+				if expandErr == nil {
+					expandErr = fmt.Errorf("unknown grok pattern %s", m[1])
+				}
+				return ""
+			}
+			inner, err := expand(def, depth+1)
+			if err != nil {
+				if expandErr == nil {
+					expandErr = err
+				}
+				return ""
+			}
+			if depth > 0 || m[2] == "" {
+				return "(?:" + inner + ")"
+			}
+			fields = append(fields, m[2])
+			return fmt.Sprintf("(?P<g%d>%s)", len(fields)-1, inner)
+		})
+		return out, expandErr
+	}
+
+	pattern, err := expand(expr, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid grok pattern: %w", err)
+	}
+	if len(fields) == 0 {
+		return nil, nil, fmt.Errorf("grok pattern captures no fields; name them as %%{PATTERN:field}")
+	}
+
+	// Literal parentheses in the expression are groups too, so fields are
+	// mapped by group name
+	groups := make([]string, re.NumSubexp())
+	for i, name := range re.SubexpNames()[1:] {
+		if idx, err := strconv.Atoi(strings.TrimPrefix(name, "g")); err == nil && strings.HasPrefix(name, "g") {
+			groups[i] = fields[idx]
+		}
+	}
+	return re, groups, nil
+}
@@ -0,0 +1,115 @@
+package pipelines
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCompileGrok(t *testing.T) {
+	tests := []struct {
+		name  string
+		expr  string
+		input string
+		want  map[string]string
+	}{
+		{
+			name:  "access log",
+			expr:  `%{IPORHOST:client.ip} - %{USER:user} \[%{HTTPDATE:timestamp}\] "%{HTTPMETHOD:http.method} %{URIPATHPARAM:http.path}" %{INT:http.status}`,
+			input: `10.0.0.1 - alice [15/Mar/2024:10:20:30 +0000] "GET /api/items?page=2" 200`,
+			want: map[string]string{
+				"client.ip":   "10.0.0.1",
+				"user":        "alice",
+				"timestamp":   "15/Mar/2024:10:20:30 +0000",
+				"http.method": "GET",
+				"http.path":   "/api/items?page=2",
+				"http.status": "200",
+			},
+		},
+		{
+			name:  "level and message",
+			expr:  `%{TIMESTAMP_ISO8601:ts} %{LOGLEVEL:level} %{GREEDYDATA:message}`,
+			input: `2024-03-15T10:20:30.123Z WARNING disk almost full`,
+			want:  map[string]string{"ts": "2024-03-15T10:20:30.123Z", "level": "WARNING", "message": "disk almost full"},
+		},
+		{
+			name:  "type conversion suffix ignored",
+			expr:  `took %{NUMBER:duration:float}ms`,
+			input: `request took 12.5ms`,
+			want:  map[string]string{"duration": "12.5"},
+		},
+		{
+			name:  "literal groups of the expression",
+			expr:  `(a|b) %{WORD:word} (?:c|d) (?P<other>\d+) %{INT:n}`,
+			input: `a hello c 7 42`,
+			want:  map[string]string{"word": "hello", "n": "42"},
+		},
+		{
+			name:  "optional field not matched",
+			expr:  `%{WORD:verb}(?: %{INT:code})?$`,
+			input: `ok`,
+			want:  map[string]string{"verb": "ok"},
+		},
+		{
+			name:  "unnamed references are not captured",
+			expr:  `%{IP} %{UUID:id}`,
+			input: `::1 123e4567-e89b-12d3-a456-426614174000`,
+			want:  map[string]string{"id": "123e4567-e89b-12d3-a456-426614174000"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			re, fields, err := compileGrok(tt.expr)
+			if err != nil {
+				t.Fatalf("compileGrok(%q): %v", tt.expr, err)
+			}
+			got, err := matchParser(re, fields)(tt.input)
+			if err != nil {
+				t.Fatalf("match %q: %v", tt.input, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("match %q = %v, want %v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileGrokNoMatch(t *testing.T) {
+	re, fields, err := compileGrok(`^%{INT:code} %{WORD:status}$`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := matchParser(re, fields)("ok 200"); err == nil {
+		t.Error("match succeeded, want an error")
+	}
+}
+
+func TestCompileGrokErrors(t *testing.T) {
+	grokPatterns["TEST_LOOP"] = "%{TEST_LOOP}"
+	defer delete(grokPatterns, "TEST_LOOP")
+
+	tests := []struct {
+		name    string
+		expr    string
+		wantErr string
+	}{
+		{"unknown pattern", `%{NOPE:x}`, "unknown grok pattern NOPE"},
+		{"unknown nested pattern among known ones", `%{WORD:a} %{MISSING}`, "unknown grok pattern MISSING"},
+		{"no fields", `%{WORD} %{INT}`, "captures no fields"},
+		{"invalid regex", `%{WORD:a} (unclosed`, "invalid grok pattern"},
+		{"reserved group name", `(?P<g0>x) %{WORD:a}`, "reserved"},
+		{"reserved group name out of range", `(?P<g5>x) %{WORD:a}`, "reserved"},
+		{"nested too deep", `%{TEST_LOOP:x}`, "nest too deeply"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := compileGrok(tt.expr)
+			if err == nil {
+				t.Fatalf("compileGrok(%q) succeeded, want error containing %q", tt.expr, tt.wantErr)
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("compileGrok(%q) = %v, want error containing %q", tt.expr, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package pipelines
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/namlabs/obsfly/backend/internal/store"
+)
+
+// Step outcomes reported when testing a pipeline
+const (
+	StepApplied = "applied"
+	StepSkipped = "skipped" // the step's source is not on the log
+	StepFailed  = "failed"  // the log was left as it was
+)
+
+// maxSteps bounds the steps of a pipeline
+const maxSteps = 50
+
+// Pipeline is a log pipeline compiled for execution
+type Pipeline struct {
+	config   store.LogPipeline
+	services []string
+	steps    []stepFunc
+}
+
+// StepResult is the outcome of one step on one log
+type StepResult struct {
+	Type   string `json:"type"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Compile validates a pipeline and prepares it for execution
+func Compile(p store.LogPipeline) (*Pipeline, error) {
+	if p.Name == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+	if len(p.Steps) == 0 {
+		return nil, fmt.Errorf("at least one step is required")
+	}
+	if len(p.Steps) > maxSteps {
+		return nil, fmt.Errorf("a pipeline has at most %d steps", maxSteps)
+	}
+
+	compiled := &Pipeline{config: p, services: p.Services}
+	for i, s := range p.Steps {
+		fn, err := compileStep(s)
+		if err != nil {
+			return nil, fmt.Errorf("step %d (%s): %w", i, s.Type, err)
+		}
+		compiled.steps = append(compiled.steps, fn)
+	}
+	return compiled, nil
+}
+
+// Matches reports whether the pipeline processes the logs of a service
+func (p *Pipeline) Matches(service string) bool {
+	return len(p.services) == 0 || slices.Contains(p.services, service)
+}
+
+// Apply runs the steps on a log. A step that fails leaves the log as it
+// was and the next steps still run.
+func (p *Pipeline) Apply(l *store.Log) {
+	for _, step := range p.steps {
+		step(l)
+	}
+}
+
+// Trace runs the steps on a log like Apply, reporting each step's outcome
+func (p *Pipeline) Trace(l *store.Log) []StepResult {
+	results := make([]StepResult, len(p.steps))
+	for i, step := range p.steps {
+		results[i] = StepResult{Type: p.config.Steps[i].Type, Status: StepApplied}
+		if err := step(l); errors.Is(err, errNoSource) {
+			results[i].Status = StepSkipped
+		} else if err != nil {
+			results[i].Status = StepFailed
+			results[i].Error = err.Error()
+		}
+	}
+	return results
+}
+
+// Sample is a log a pipeline is tested on
+type Sample struct {
+	Timestamp      time.Time         `json:"timestamp"`
+	ServiceName    string            `json:"service_name"`
+	SeverityText   string            `json:"severity_text"`
+	SeverityNumber int32             `json:"severity_number"`
+	Body           string            `json:"body"`
+	TraceId        string            `json:"trace_id"`
+	SpanId         string            `json:"span_id"`
+	Attributes     map[string]string `json:"attributes"`
+}
+
+// TestResult is what a pipeline made of a sample
+type TestResult struct {
+	Input   Sample       `json:"input"`
+	Output  Sample       `json:"output"`
+	Matched bool         `json:"matched"` // false when the pipeline does not cover the sample's service
+	Steps   []StepResult `json:"steps"`
+}
+
+// Test runs the pipeline on samples without storing anything. Samples
+// without a timestamp are taken as logged now.
+func (p *Pipeline) Test(samples []Sample) []TestResult {
+	results := make([]TestResult, len(samples))
+	for i, sample := range samples {
+		if sample.Timestamp.IsZero() {
+			sample.Timestamp = time.Now()
+		}
+		l := store.Log{
+			Timestamp:      sample.Timestamp,
+			ServiceName:    sample.ServiceName,
+			SeverityText:   sample.SeverityText,
+			SeverityNumber: sample.SeverityNumber,
+			Body:           sample.Body,
+			TraceId:        sample.TraceId,
+			SpanId:         sample.SpanId,
+			LogAttributes:  maps.Clone(sample.Attributes),
+		}
+
+		results[i] = TestResult{Input: sample, Matched: p.Matches(sample.ServiceName), Steps: []StepResult{}}
+		if results[i].Matched {
+			results[i].Steps = p.Trace(&l)
+		}
+		results[i].Output = Sample{
+			Timestamp:      l.Timestamp,
+			ServiceName:    l.ServiceName,
+			SeverityText:   l.SeverityText,
+			SeverityNumber: l.SeverityNumber,
+			Body:           l.Body,
+			TraceId:        l.TraceId,
+			SpanId:         l.SpanId,
+			Attributes:     l.LogAttributes,
+		}
+	}
+	return results
+}
@@ -0,0 +1,90 @@
+package pipelines
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/namlabs/obsfly/backend/internal/store"
+)
+
+// Processor runs the enabled pipelines of each account on logs as they are
+// ingested. Pipelines are reloaded from the store once the refresh interval
+// has passed, so changes apply within it without a restart.
+type Processor struct {
+	store   *store.Store
+	refresh time.Duration
+
+	mu        sync.Mutex
+	pipelines map[uint64][]*Pipeline
+	loadedAt  time.Time
+}
+
+func NewProcessor(st *store.Store, refresh time.Duration) *Processor {
+	return &Processor{
+		store:     st,
+		refresh:   refresh,
+		pipelines: make(map[uint64][]*Pipeline),
+	}
+}
+
+// Load reads and compiles the enabled pipelines. Pipelines that no longer
+// compile are skipped; the others still run.
+func (p *Processor) Load(ctx context.Context) error {
+	configs, err := p.store.ListEnabledLogPipelines(ctx)
+	if err != nil {
+		return err
+	}
+
+	pipelines := make(map[uint64][]*Pipeline)
+	for _, cfg := range configs {
+		compiled, err := Compile(cfg)
+		if err != nil {
+			fmt.Printf("Skipping log pipeline %s: %v\n", cfg.PipelineId, err)
+			continue
+		}
+		pipelines[cfg.AccountId] = append(pipelines[cfg.AccountId], compiled)
+	}
+
+	p.mu.Lock()
+	p.pipelines = pipelines
+	p.loadedAt = time.Now()
+	p.mu.Unlock()
+	return nil
+}
+
+// Process runs the account's pipelines on each log. It only fails when the
+// pipelines cannot be reloaded; the ones loaded before still run.
+func (p *Processor) Process(ctx context.Context, logs []store.Log) error {
+	var loadErr error
+	p.mu.Lock()
+	stale := time.Since(p.loadedAt) >= p.refresh
+	p.mu.Unlock()
+	if stale {
+		if loadErr = p.Load(ctx); loadErr != nil {
+			// Try again at the next refresh rather than on every batch
+			p.mu.Lock()
+			p.loadedAt = time.Now()
+			p.mu.Unlock()
+			loadErr = fmt.Errorf("failed to reload log pipelines: %w", loadErr)
+		}
+	}
+
+	p.mu.Lock()
+	pipelines := p.pipelines
+	p.mu.Unlock()
+	if len(pipelines) == 0 {
+		return loadErr
+	}
+
+	for i := range logs {
+		l := &logs[i]
+		for _, pipeline := range pipelines[l.AccountId] {
+			if pipeline.Matches(l.ServiceName) {
+				pipeline.Apply(l)
+			}
+		}
+	}
+	return loadErr
+}
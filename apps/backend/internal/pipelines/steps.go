@@ -0,0 +1,447 @@
+package pipelines
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/namlabs/obsfly/backend/internal/store"
+)
+
+// maxParsedAttributes bounds the attributes a parsing step adds to a log
+const maxParsedAttributes = 200
+
+// A timestamp step may only move a log this far from when it is ingested.
+// Logs are partitioned by day, and a batch spread over many days can exceed
+// ClickHouse's max_partitions_per_insert_block, failing the whole insert.
+const (
+	maxTimestampAge  = 7 * 24 * time.Hour
+	maxTimestampSkew = time.Hour // into the future
+)
+
+// errNoSource means a step's source is not on the log, so the step did not
+// apply. It is not a failure: pipelines usually cover logs of several shapes.
+var errNoSource = errors.New("source not present")
+
+// stepFunc applies a step to a log in place. Steps check their input
+// before changing anything, so a step that fails leaves the log as it was.
+type stepFunc func(l *store.Log) error
+
+// compileStep validates a step and returns the function applying it
+func compileStep(s store.LogPipelineStep) (stepFunc, error) {
+	switch s.Type {
+	case store.PipelineStepJSON:
+		return parseStep(s, parseJSON), nil
+	case store.PipelineStepLogfmt:
+		return parseStep(s, parseLogfmt), nil
+	case store.PipelineStepRegex:
+		re, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex: %w", err)
+		}
+		fields := re.SubexpNames()[1:]
+		if !slices.ContainsFunc(fields, func(name string) bool { return name != "" }) {
+			return nil, fmt.Errorf("regex has no named groups; name them as (?P<field>...)")
+		}
+		return parseStep(s, matchParser(re, fields)), nil
+	case store.PipelineStepGrok:
+		re, fields, err := compileGrok(s.Pattern)
+		if err != nil {
+			return nil, err
+		}
+		return parseStep(s, matchParser(re, fields)), nil
+	case store.PipelineStepSeverity:
+		return severityStep(s), nil
+	case store.PipelineStepTimestamp:
+		return timestampStep(s)
+	case store.PipelineStepRename:
+		if len(s.Mapping) == 0 {
+			return nil, fmt.Errorf("rename needs a mapping of attributes to new names")
+		}
+		return renameStep(s), nil
+	case store.PipelineStepDrop:
+		if len(s.Fields) == 0 {
+			return nil, fmt.Errorf("drop needs the fields to remove")
+		}
+		return dropStep(s), nil
+	case store.PipelineStepTrace:
+		if len(s.Fields) > 2 {
+			return nil, fmt.Errorf("trace takes the trace ID and span ID attributes")
+		}
+		return traceStep(s), nil
+	case "":
+		return nil, fmt.Errorf("step type is required")
+	}
+	return nil, fmt.Errorf("unknown step type %q", s.Type)
+}
+
+// source returns the value a step reads: the body, or an attribute
+func source(l *store.Log, name string) (string, error) {
+	if name == "" || name == "body" {
+		return l.Body, nil
+	}
+	v, ok := l.LogAttributes[name]
+	if !ok {
+		return "", errNoSource
+	}
+	return v, nil
+}
+
+// setAttribute sets an attribute, creating the map of a log without any
+func setAttribute(l *store.Log, key, value string) {
+	if l.LogAttributes == nil {
+		l.LogAttributes = make(map[string]string)
+	}
+	l.LogAttributes[key] = value
+}
+
+// parser extracts attributes from a value
+type parser func(value string) (map[string]string, error)
+
+// parseStep runs a parser on the step's source and adds what it extracts
+// to the log's attributes
+func parseStep(s store.LogPipelineStep, parse parser) stepFunc {
+	return func(l *store.Log) error {
+		value, err := source(l, s.Source)
+		if err != nil {
+			return err
+		}
+		attrs, err := parse(value)
+		if err != nil {
+			return err
+		}
+		added := 0
+		for k, v := range attrs {
+			if added == maxParsedAttributes {
+				break
+			}
+			setAttribute(l, s.Prefix+k, v)
+			added++
+		}
+		return nil
+	}
+}
+
+// parseJSON flattens a JSON object into attributes, joining nested keys
+// with dots. Arrays are kept as JSON.
+func parseJSON(value string) (map[string]string, error) {
+	dec := json.NewDecoder(strings.NewReader(value))
+	dec.UseNumber()
+	var obj map[string]interface{}
+	if err := dec.Decode(&obj); err != nil {
+		return nil, fmt.Errorf("not a JSON object: %w", err)
+	}
+	attrs := make(map[string]string)
+	flatten(attrs, "", obj)
+	return attrs, nil
+}
+
+func flatten(attrs map[string]string, prefix string, obj map[string]interface{}) {
+	for k, v := range obj {
+		key := prefix + k
+		switch v := v.(type) {
+		case map[string]interface{}:
+			flatten(attrs, key+".", v)
+		case string:
+			attrs[key] = v
+		case json.Number:
+			attrs[key] = v.String()
+		case bool:
+			attrs[key] = strconv.FormatBool(v)
+		case nil:
+			attrs[key] = ""
+		default:
+			var buf bytes.Buffer
+			enc := json.NewEncoder(&buf)
+			enc.SetEscapeHTML(false)
+			enc.Encode(v)
+			attrs[key] = strings.TrimSpace(buf.String())
+		}
+	}
+}
+
+// parseLogfmt parses key=value pairs. Values may be double quoted; a key
+// without a value is set to "true".
+func parseLogfmt(value string) (map[string]string, error) {
+	attrs := make(map[string]string)
+	i := 0
+	for i < len(value) {
+		for i < len(value) && value[i] == ' ' {
+			i++
+		}
+		start := i
+		for i < len(value) && value[i] != '=' && value[i] != ' ' {
+			i++
+		}
+		key := value[start:i]
+		if i >= len(value) || value[i] == ' ' {
+			if key != "" {
+				attrs[key] = "true"
+			}
+			continue
+		}
+		i++ // '='
+
+		var v string
+		if i < len(value) && value[i] == '"' {
+			end := i + 1
+			for end < len(value) && value[end] != '"' {
+				if value[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(value) {
+				return nil, fmt.Errorf("unterminated quoted value of %s", key)
+			}
+			unquoted, err := strconv.Unquote(value[i : end+1])
+			if err != nil {
+				unquoted = value[i+1 : end]
+			}
+			v = unquoted
+			i = end + 1
+		} else {
+			start := i
+			for i < len(value) && value[i] != ' ' {
+				i++
+			}
+			v = value[start:i]
+		}
+		if key != "" {
+			attrs[key] = v
+		}
+	}
+	if len(attrs) == 0 {
+		return nil, fmt.Errorf("no key=value pairs")
+	}
+	return attrs, nil
+}
+
+// matchParser extracts the groups of a regex that have a field name.
+// Groups that did not take part in the match are left out.
+func matchParser(re *regexp.Regexp, fields []string) parser {
+	return func(value string) (map[string]string, error) {
+		m := re.FindStringSubmatchIndex(value)
+		if m == nil {
+			return nil, fmt.Errorf("pattern does not match")
+		}
+		attrs := make(map[string]string)
+		for i, field := range fields {
+			if field == "" || m[2*i+2] < 0 {
+				continue
+			}
+			attrs[field] = value[m[2*i+2]:m[2*i+3]]
+		}
+		return attrs, nil
+	}
+}
+
+// Severity numbers of the OpenTelemetry log data model
+var severityNumbers = map[string]int32{
+	"TRACE": 1, "DEBUG": 5, "INFO": 9, "WARN": 13, "ERROR": 17, "FATAL": 21,
+}
+
+// severityAliases maps common level names to the severity texts logs use
+var severityAliases = map[string]string{
+	"trace": "TRACE", "debug": "DEBUG", "dbg": "DEBUG", "verbose": "DEBUG",
+	"info": "INFO", "information": "INFO", "notice": "INFO",
+	"warn": "WARN", "warning": "WARN",
+	"error": "ERROR", "err": "ERROR", "severe": "ERROR",
+	"fatal": "FATAL", "critical": "FATAL", "crit": "FATAL", "panic": "FATAL",
+	"emerg": "FATAL", "emergency": "FATAL", "alert": "FATAL",
+}
+
+// severityStep sets the severity from an attribute, level by default. The
+// mapping translates values first, e.g. the numeric levels of some
+// loggers; the result must name a standard level.
+func severityStep(s store.LogPipelineStep) stepFunc {
+	attr := s.Source
+	if attr == "" {
+		attr = "level"
+	}
+	return func(l *store.Log) error {
+		value, err := source(l, attr)
+		if err != nil {
+			return err
+		}
+		if mapped, ok := s.Mapping[value]; ok {
+			value = mapped
+		} else if mapped, ok := s.Mapping[strings.ToLower(value)]; ok {
+			value = mapped
+		}
+		text, ok := severityAliases[strings.ToLower(strings.TrimSpace(value))]
+		if !ok {
+			return fmt.Errorf("unknown severity %q", value)
+		}
+		l.SeverityText = text
+		l.SeverityNumber = severityNumbers[text]
+		if !s.Keep && attr != "body" {
+			delete(l.LogAttributes, attr)
+		}
+		return nil
+	}
+}
+
+// timestampStep sets the log's timestamp from an attribute, timestamp by
+// default. Timestamps outside the ingest window fail the step, so the log
+// keeps its original timestamp.
+func timestampStep(s store.LogPipelineStep) (stepFunc, error) {
+	attr := s.Source
+	if attr == "" {
+		attr = "timestamp"
+	}
+
+	var parse func(string) (time.Time, error)
+	switch s.Layout {
+	case "", "rfc3339":
+		parse = func(v string) (time.Time, error) { return time.Parse(time.RFC3339Nano, v) }
+	case "unix", "unix_ms", "unix_us", "unix_ns":
+		scale := map[string]int64{"unix": 1e9, "unix_ms": 1e6, "unix_us": 1e3, "unix_ns": 1}[s.Layout]
+		parse = func(v string) (time.Time, error) {
+			// Integers are exact; fractions, as in 1700000000.123, go
+			// through a float
+			if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+				return time.Unix(0, n*scale), nil
+			}
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return time.Time{}, err
+			}
+			return time.Unix(0, int64(f*float64(scale))), nil
+		}
+	default:
+		if !strings.ContainsAny(s.Layout, "0123456789") {
+			return nil, fmt.Errorf("layout must be rfc3339, unix, unix_ms, unix_us, unix_ns or a Go time layout")
+		}
+		parse = func(v string) (time.Time, error) { return time.Parse(s.Layout, v) }
+	}
+
+	return func(l *store.Log) error {
+		value, err := source(l, attr)
+		if err != nil {
+			return err
+		}
+		t, err := parse(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("invalid timestamp %q: %w", value, err)
+		}
+		now := time.Now()
+		if t.Before(now.Add(-maxTimestampAge)) || t.After(now.Add(maxTimestampSkew)) {
+			return fmt.Errorf("timestamp %q is more than %s old or %s ahead", value, maxTimestampAge, maxTimestampSkew)
+		}
+		l.Timestamp = t
+		if !s.Keep && attr != "body" {
+			delete(l.LogAttributes, attr)
+		}
+		return nil
+	}, nil
+}
+
+// renameStep renames attributes, replacing any attribute already holding
+// the new name. Renaming an attribute to body makes it the log's body, so
+// the message of a parsed JSON line can replace the line.
+func renameStep(s store.LogPipelineStep) stepFunc {
+	return func(l *store.Log) error {
+		renamed := false
+		for from, to := range s.Mapping {
+			v, ok := l.LogAttributes[from]
+			if !ok {
+				continue
+			}
+			delete(l.LogAttributes, from)
+			if to == "body" {
+				l.Body = v
+			} else {
+				l.LogAttributes[to] = v
+			}
+			renamed = true
+		}
+		if !renamed {
+			return errNoSource
+		}
+		return nil
+	}
+}
+
+// dropStep removes attributes
+func dropStep(s store.LogPipelineStep) stepFunc {
+	return func(l *store.Log) error {
+		dropped := false
+		for _, field := range s.Fields {
+			if _, ok := l.LogAttributes[field]; ok {
+				delete(l.LogAttributes, field)
+				dropped = true
+			}
+		}
+		if !dropped {
+			return errNoSource
+		}
+		return nil
+	}
+}
+
+var hexID = regexp.MustCompile(`^[0-9a-fA-F]+$`)
+
+// traceStep sets TraceId and SpanId from a W3C traceparent attribute, or
+// from the attributes named in Fields, trace_id and span_id by default
+func traceStep(s store.LogPipelineStep) stepFunc {
+	traceAttr, spanAttr := "trace_id", "span_id"
+	if len(s.Fields) > 0 && s.Fields[0] != "" {
+		traceAttr = s.Fields[0]
+	}
+	if len(s.Fields) > 1 && s.Fields[1] != "" {
+		spanAttr = s.Fields[1]
+	}
+
+	return func(l *store.Log) error {
+		if tp, ok := l.LogAttributes["traceparent"]; ok {
+			// version-traceid-spanid-flags
+			parts := strings.Split(strings.TrimSpace(tp), "-")
+			if len(parts) != 4 || len(parts[1]) != 32 || len(parts[2]) != 16 || !hexID.MatchString(parts[1]+parts[2]) {
+				return fmt.Errorf("invalid traceparent %q", tp)
+			}
+			l.TraceId, l.SpanId = strings.ToLower(parts[1]), strings.ToLower(parts[2])
+			if !s.Keep {
+				delete(l.LogAttributes, "traceparent")
+			}
+			return nil
+		}
+
+		traceId, ok := l.LogAttributes[traceAttr]
+		if !ok {
+			return errNoSource
+		}
+		tid, err := normalizeID(traceId, 32)
+		if err != nil {
+			return fmt.Errorf("invalid trace ID %q", traceId)
+		}
+		sid := l.SpanId
+		if spanId, ok := l.LogAttributes[spanAttr]; ok {
+			if sid, err = normalizeID(spanId, 16); err != nil {
+				return fmt.Errorf("invalid span ID %q", spanId)
+			}
+		}
+		l.TraceId, l.SpanId = tid, sid
+		if !s.Keep {
+			delete(l.LogAttributes, traceAttr)
+			delete(l.LogAttributes, spanAttr)
+		}
+		return nil
+	}
+}
+
+// normalizeID lower-cases a hex ID and pads shorter IDs, such as 64-bit
+// trace IDs, to the given length
+func normalizeID(id string, length int) (string, error) {
+	id = strings.ToLower(strings.TrimSpace(id))
+	if id == "" || len(id) > length || !hexID.MatchString(id) {
+		return "", fmt.Errorf("not a hex ID")
+	}
+	return strings.Repeat("0", length-len(id)) + id, nil
+}
@@ -0,0 +1,56 @@
+package pipelines
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/namlabs/obsfly/backend/internal/store"
+)
+
+func TestTimestampStep(t *testing.T) {
+	received := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	recent := time.Now().Add(-time.Hour).Truncate(time.Millisecond).UTC()
+
+	tests := []struct {
+		name    string
+		layout  string
+		value   string
+		want    time.Time // received when the step fails
+		wantErr bool
+	}{
+		{"rfc3339", "", recent.Format(time.RFC3339Nano), recent, false},
+		{"unix milliseconds", "unix_ms", strconv.FormatInt(recent.UnixMilli(), 10), recent, false},
+		{"fractional unix seconds", "unix", strconv.FormatInt(recent.Unix(), 10) + ".5", recent.Truncate(time.Second).Add(500 * time.Millisecond), false},
+		{"go layout", "2006-01-02 15:04:05", recent.Format("2006-01-02 15:04:05"), recent.Truncate(time.Second), false},
+		{"invalid", "", "yesterday", received, true},
+		{"older than the ingest window", "", time.Now().Add(-maxTimestampAge - time.Hour).Format(time.RFC3339), received, true},
+		{"ahead of the ingest window", "", time.Now().Add(maxTimestampSkew + time.Hour).Format(time.RFC3339), received, true},
+		{"epoch", "unix", "0", received, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, err := timestampStep(store.LogPipelineStep{Type: store.PipelineStepTimestamp, Layout: tt.layout})
+			if err != nil {
+				t.Fatal(err)
+			}
+			l := &store.Log{Timestamp: received, LogAttributes: map[string]string{"timestamp": tt.value}}
+			err = step(l)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("step error = %v, want error %v", err, tt.wantErr)
+			}
+			if !l.Timestamp.Equal(tt.want) {
+				t.Errorf("Timestamp = %v, want %v", l.Timestamp, tt.want)
+			}
+			if _, kept := l.LogAttributes["timestamp"]; kept != tt.wantErr {
+				t.Errorf("timestamp attribute kept = %v, want %v", kept, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTimestampStepLayout(t *testing.T) {
+	if _, err := timestampStep(store.LogPipelineStep{Type: store.PipelineStepTimestamp, Layout: "iso"}); err == nil {
+		t.Error("timestampStep accepted an unknown layout")
+	}
+}
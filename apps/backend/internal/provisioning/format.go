@@ -0,0 +1,342 @@
+// Package provisioning keeps dashboards as code: it exports dashboards to
+// stable YAML or JSON files, imports them idempotently by slug and syncs a
+// watched directory into the dashboards table.
+package provisioning
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/namlabs/obsfly/backend/internal/store"
+	"gopkg.in/yaml.v3"
+)
+
+// Supported file formats
+const (
+	FormatYAML = "yaml"
+	FormatJSON = "json"
+)
+
+// DashboardFile is the as-code representation of a dashboard. It leaves
+// out IDs, versions and timestamps so that files only change when the
+// dashboard does.
+type DashboardFile struct {
+	Slug        string                    `json:"slug"`
+	Name        string                    `json:"name"`
+	Description string                    `json:"description,omitempty"`
+	Tags        []string                  `json:"tags,omitempty"`
+	Variables   []store.DashboardVariable `json:"variables,omitempty"`
+	Widgets     []store.DashboardWidget   `json:"widgets"`
+}
+
+// FromDashboard converts a stored dashboard into its file representation
+func FromDashboard(d *store.Dashboard) DashboardFile {
+	return DashboardFile{
+		Slug:        d.Slug,
+		Name:        d.Name,
+		Description: d.Description,
+		Tags:        d.Tags,
+		Variables:   d.Variables,
+		Widgets:     d.Widgets,
+	}
+}
+
+// FormatForPath picks the format from a file extension
+func FormatForPath(path string) (string, bool) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return FormatYAML, true
+	case ".json":
+		return FormatJSON, true
+	}
+	return "", false
+}
+
+// EncodeDashboard writes a single dashboard
+func EncodeDashboard(file DashboardFile, format string) ([]byte, error) {
+	return encode([]DashboardFile{file}, format, false)
+}
+
+// Encode writes dashboards in slug order. YAML output is a stream of
+// documents, JSON output an array.
+func Encode(files []DashboardFile, format string) ([]byte, error) {
+	return encode(files, format, true)
+}
+
+func encode(files []DashboardFile, format string, list bool) ([]byte, error) {
+	sorted := append([]DashboardFile(nil), files...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Slug < sorted[j].Slug })
+
+	docs := make([]interface{}, 0, len(sorted))
+	for _, f := range sorted {
+		doc, err := canonicalize(f)
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, doc)
+	}
+
+	switch format {
+	case FormatJSON:
+		var out []byte
+		var err error
+		if !list && len(docs) == 1 {
+			out, err = json.MarshalIndent(docs[0], "", "  ")
+		} else {
+			out, err = json.MarshalIndent(docs, "", "  ")
+		}
+		if err != nil {
+			return nil, err
+		}
+		return append(out, '\n'), nil
+	case FormatYAML:
+		var buf bytes.Buffer
+		for i, doc := range docs {
+			if i > 0 {
+				buf.WriteString("---\n")
+			}
+			node, err := yamlNode(doc)
+			if err != nil {
+				return nil, err
+			}
+			enc := yaml.NewEncoder(&buf)
+			enc.SetIndent(2)
+			if err := enc.Encode(node); err != nil {
+				return nil, err
+			}
+			enc.Close()
+		}
+		return buf.Bytes(), nil
+	}
+	return nil, fmt.Errorf("unknown format %q, expected yaml or json", format)
+}
+
+// Decode reads one or more dashboards. JSON input may be a single object or
+// an array; YAML input may hold several documents.
+func Decode(data []byte, format string) ([]DashboardFile, error) {
+	var files []DashboardFile
+	switch format {
+	case FormatJSON:
+		trimmed := bytes.TrimSpace(data)
+		if len(trimmed) > 0 && trimmed[0] == '[' {
+			if err := json.Unmarshal(trimmed, &files); err != nil {
+				return nil, fmt.Errorf("invalid dashboard JSON: %w", err)
+			}
+		} else {
+			var f DashboardFile
+			if err := json.Unmarshal(trimmed, &f); err != nil {
+				return nil, fmt.Errorf("invalid dashboard JSON: %w", err)
+			}
+			files = append(files, f)
+		}
+	case FormatYAML:
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		for {
+			var doc interface{}
+			err := dec.Decode(&doc)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid dashboard YAML: %w", err)
+			}
+			if doc == nil {
+				continue
+			}
+			// Round-trip through JSON so the json tags of the store types apply
+			raw, err := json.Marshal(doc)
+			if err != nil {
+				return nil, fmt.Errorf("invalid dashboard YAML: %w", err)
+			}
+			var f DashboardFile
+			if err := json.Unmarshal(raw, &f); err != nil {
+				return nil, fmt.Errorf("invalid dashboard YAML: %w", err)
+			}
+			files = append(files, f)
+		}
+	default:
+		return nil, fmt.Errorf("unknown format %q, expected yaml or json", format)
+	}
+	return files, nil
+}
+
+// Equal reports whether two dashboards have the same file representation
+func Equal(a, b DashboardFile) bool {
+	ca, errA := canonicalize(a)
+	cb, errB := canonicalize(b)
+	if errA != nil || errB != nil {
+		return false
+	}
+	ja, _ := json.Marshal(ca)
+	jb, _ := json.Marshal(cb)
+	return bytes.Equal(ja, jb)
+}
+
+// canonicalize converts a dashboard into ordered JSON values, dropping
+// empty fields so defaults do not show up as changes
+func canonicalize(f DashboardFile) (interface{}, error) {
+	raw, err := json.Marshal(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode dashboard %s: %w", f.Slug, err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	value, err := decodeOrdered(dec)
+	if err != nil {
+		return nil, err
+	}
+	pruned, _ := prune(value)
+	return pruned, nil
+}
+
+// orderedObject is a JSON object that keeps its key order
+type orderedObject []orderedField
+
+type orderedField struct {
+	key   string
+	value interface{}
+}
+
+func (o orderedObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, field := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(field.key)
+		buf.Write(key)
+		buf.WriteByte(':')
+		value, err := json.Marshal(field.value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// decodeOrdered decodes the next JSON value, keeping object key order.
+// Struct fields keep their declaration order; map keys come out sorted.
+func decodeOrdered(dec *json.Decoder) (interface{}, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch t := tok.(type) {
+	case json.Delim:
+		switch t {
+		case '{':
+			obj := orderedObject{}
+			for dec.More() {
+				keyTok, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				value, err := decodeOrdered(dec)
+				if err != nil {
+					return nil, err
+				}
+				obj = append(obj, orderedField{key: keyTok.(string), value: value})
+			}
+			_, err := dec.Token()
+			return obj, err
+		case '[':
+			arr := []interface{}{}
+			for dec.More() {
+				value, err := decodeOrdered(dec)
+				if err != nil {
+					return nil, err
+				}
+				arr = append(arr, value)
+			}
+			_, err := dec.Token()
+			return arr, err
+		}
+	}
+	return tok, nil
+}
+
+// prune drops null, false, empty strings, empty arrays and empty objects
+// from objects, reporting whether the value itself is empty
+func prune(value interface{}) (interface{}, bool) {
+	switch v := value.(type) {
+	case nil:
+		return nil, true
+	case bool:
+		return v, !v
+	case string:
+		return v, v == ""
+	case orderedObject:
+		kept := orderedObject{}
+		for _, field := range v {
+			if pruned, empty := prune(field.value); !empty {
+				kept = append(kept, orderedField{key: field.key, value: pruned})
+			}
+		}
+		return kept, len(kept) == 0
+	case []interface{}:
+		// Array elements are kept even if empty so indexes stay meaningful
+		for i := range v {
+			v[i], _ = prune(v[i])
+		}
+		return v, len(v) == 0
+	}
+	return value, false
+}
+
+// yamlNode builds a block-style YAML node from ordered JSON values
+func yamlNode(value interface{}) (*yaml.Node, error) {
+	switch v := value.(type) {
+	case orderedObject:
+		node := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		for _, field := range v {
+			child, err := yamlNode(field.value)
+			if err != nil {
+				return nil, err
+			}
+			node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: field.key}, child)
+		}
+		if len(v) == 0 {
+			node.Style = yaml.FlowStyle
+		}
+		return node, nil
+	case []interface{}:
+		node := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		for _, item := range v {
+			child, err := yamlNode(item)
+			if err != nil {
+				return nil, err
+			}
+			node.Content = append(node.Content, child)
+		}
+		if len(v) == 0 {
+			node.Style = yaml.FlowStyle
+		}
+		return node, nil
+	case nil:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null", Value: "null"}, nil
+	case bool:
+		node := &yaml.Node{}
+		return node, node.Encode(v)
+	case json.Number:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: numberTag(v), Value: v.String()}, nil
+	case string:
+		node := &yaml.Node{}
+		return node, node.Encode(v)
+	}
+	return nil, fmt.Errorf("unexpected value %T", value)
+}
+
+func numberTag(n json.Number) string {
+	if _, err := n.Int64(); err == nil {
+		return "!!int"
+	}
+	return "!!float"
+}
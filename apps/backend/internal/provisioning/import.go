@@ -0,0 +1,150 @@
+package provisioning
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/namlabs/obsfly/backend/internal/store"
+)
+
+// Import actions
+const (
+	ActionCreated   = "created"
+	ActionUpdated   = "updated"
+	ActionUnchanged = "unchanged"
+	ActionDeleted   = "deleted"
+	ActionConflict  = "conflict"
+	ActionFailed    = "failed"
+)
+
+// ErrReadOnly is returned when an import targets a dashboard that is
+// managed by directory sync
+var ErrReadOnly = errors.New("dashboard is managed by directory sync and is read-only")
+
+// ErrSlugConflict is returned when a synced file has the slug of a
+// dashboard created in the app, which sync does not take over
+var ErrSlugConflict = errors.New("a dashboard created in the app already has this slug")
+
+// ErrForbidden is returned when the importing user may not edit the
+// dashboard an import targets
+var ErrForbidden = errors.New("editor permission on the dashboard is required")
+
+// ImportOptions control how dashboards are written
+type ImportOptions struct {
+	Source     string // store.DashboardSourceFile for synced dashboards
+	SourcePath string
+	Author     string
+	DryRun     bool                      // report the action without saving
+	Principal  *store.DashboardPrincipal // user the import is made for; nil skips permission checks
+}
+
+// ImportResult reports what an import did with one dashboard
+type ImportResult struct {
+	Slug        string `json:"slug"`
+	DashboardId string `json:"dashboard_id,omitempty"`
+	Action      string `json:"action"`
+	Error       string `json:"error,omitempty"`
+	Path        string `json:"path,omitempty"`
+}
+
+// Import creates or updates the dashboard with the file's slug. Importing
+// the same file twice leaves the second import unchanged, without adding a
+// version.
+func Import(ctx context.Context, st *store.Store, accountId uint64, file DashboardFile, opts ImportOptions) (ImportResult, error) {
+	if file.Slug == "" {
+		file.Slug = store.Slugify(file.Name)
+	}
+	result := ImportResult{Slug: file.Slug, Path: opts.SourcePath}
+	file.Widgets = withWidgetIds(file.Widgets)
+	file.Tags = store.NormalizeTags(file.Tags)
+
+	existing, err := st.GetDashboardBySlug(ctx, accountId, file.Slug)
+	if err != nil {
+		return result, err
+	}
+
+	dashboard := &store.Dashboard{
+		DashboardId: uuid.NewString(),
+		AccountId:   accountId,
+		Slug:        file.Slug,
+		Name:        file.Name,
+		Description: file.Description,
+		Tags:        file.Tags,
+		Variables:   file.Variables,
+		Widgets:     file.Widgets,
+		Source:      opts.Source,
+		SourcePath:  opts.SourcePath,
+		Author:      opts.Author,
+	}
+	result.Action = ActionCreated
+	dashboard.ChangeMessage = "Imported " + file.Slug
+
+	if existing != nil {
+		if existing.ReadOnly && opts.Source != store.DashboardSourceFile {
+			return result, ErrReadOnly
+		}
+		if opts.Source == store.DashboardSourceFile && existing.Source != store.DashboardSourceFile {
+			result.DashboardId = existing.DashboardId
+			return result, ErrSlugConflict
+		}
+		if opts.Principal != nil {
+			level, err := st.GetDashboardAccess(ctx, existing, *opts.Principal)
+			if err != nil {
+				return result, err
+			}
+			if !store.DashboardPermissionAllows(level, store.DashboardEditor) {
+				return result, ErrForbidden
+			}
+		}
+		// Files do not carry the folder and owner, so imports keep them
+		dashboard.DashboardId = existing.DashboardId
+		dashboard.FolderId = existing.FolderId
+		dashboard.OwnerId = existing.OwnerId
+		result.Action = ActionUpdated
+		if Equal(FromDashboard(existing), file) && existing.Source == opts.Source && existing.SourcePath == opts.SourcePath {
+			result.Action = ActionUnchanged
+		}
+	} else if opts.Principal != nil {
+		dashboard.OwnerId = opts.Principal.UserId
+	}
+	result.DashboardId = dashboard.DashboardId
+
+	if opts.Source == store.DashboardSourceFile && opts.SourcePath != "" {
+		dashboard.ChangeMessage = "Synced from " + opts.SourcePath
+	}
+
+	if err := store.ValidateDashboardSchema(dashboard); err != nil {
+		return result, err
+	}
+	if result.Action == ActionUnchanged || opts.DryRun {
+		return result, nil
+	}
+	if err := st.SaveDashboard(ctx, dashboard); err != nil {
+		return result, fmt.Errorf("failed to import dashboard %s: %w", file.Slug, err)
+	}
+	return result, nil
+}
+
+// withWidgetIds numbers widgets that have no ID by position, so that
+// re-importing the same file yields the same IDs
+func withWidgetIds(widgets []store.DashboardWidget) []store.DashboardWidget {
+	used := make(map[string]bool, len(widgets))
+	for _, w := range widgets {
+		used[w.WidgetId] = true
+	}
+
+	out := make([]store.DashboardWidget, len(widgets))
+	for i, w := range widgets {
+		if w.WidgetId == "" {
+			w.WidgetId = fmt.Sprintf("widget-%d", i+1)
+			for n := 2; used[w.WidgetId]; n++ {
+				w.WidgetId = fmt.Sprintf("widget-%d-%d", i+1, n)
+			}
+			used[w.WidgetId] = true
+		}
+		out[i] = w
+	}
+	return out
+}
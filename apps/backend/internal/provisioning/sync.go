@@ -0,0 +1,224 @@
+package provisioning
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/namlabs/obsfly/backend/internal/store"
+)
+
+// Syncer reconciles the dashboards of one account with the YAML and JSON
+// files of a directory. Synced dashboards are read-only in the API; those
+// whose file disappears are deleted.
+type Syncer struct {
+	store     *store.Store
+	dir       string
+	accountId uint64
+	interval  time.Duration
+
+	fingerprint string
+}
+
+// NewSyncer creates a syncer that polls dir every interval
+func NewSyncer(st *store.Store, dir string, accountId uint64, interval time.Duration) *Syncer {
+	return &Syncer{
+		store:     st,
+		dir:       dir,
+		accountId: accountId,
+		interval:  interval,
+	}
+}
+
+// Start reconciles immediately and then whenever the directory changes,
+// until the context is cancelled
+func (s *Syncer) Start(ctx context.Context) {
+	log.Printf("Syncing dashboards from %s every %s", s.dir, s.interval)
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		fingerprint, err := s.scan()
+		if err != nil {
+			log.Printf("Dashboard sync: failed to scan %s: %v", s.dir, err)
+		} else if fingerprint != s.fingerprint {
+			// Only a complete run is remembered; otherwise the directory
+			// is reconciled again on the next tick
+			results, err := s.Reconcile(ctx)
+			logResults(results)
+			if err != nil {
+				log.Printf("Dashboard sync failed: %v", err)
+			} else {
+				s.fingerprint = fingerprint
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// scan fingerprints the directory by file name, size and modification time
+func (s *Syncer) scan() (string, error) {
+	paths, err := s.files()
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&sb, "%s:%d:%d;", path, info.Size(), info.ModTime().UnixNano())
+	}
+	return sb.String(), nil
+}
+
+// files lists the dashboard files below the directory in path order
+func (s *Syncer) files() ([]string, error) {
+	var paths []string
+	err := filepath.WalkDir(s.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != s.dir && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if _, ok := FormatForPath(path); ok && !strings.HasPrefix(d.Name(), ".") {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	sort.Strings(paths)
+	return paths, err
+}
+
+// Reconcile imports every dashboard file and deletes synced dashboards
+// whose file is gone. Deletions are skipped if any file failed to load, so
+// a broken file never removes a dashboard. A file whose slug belongs to a
+// dashboard created in the app is reported as a conflict rather than
+// taking it over. Reconcile returns an error, along with the results, when
+// any dashboard was not reconciled.
+func (s *Syncer) Reconcile(ctx context.Context) ([]ImportResult, error) {
+	paths, err := s.files()
+	if err != nil {
+		return nil, err
+	}
+
+	var results []ImportResult
+	seen := make(map[string]string) // slug -> path
+	complete := true
+
+	for _, path := range paths {
+		rel, _ := filepath.Rel(s.dir, path)
+		format, _ := FormatForPath(path)
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			results = append(results, ImportResult{Path: rel, Action: ActionFailed, Error: err.Error()})
+			complete = false
+			continue
+		}
+		files, err := Decode(data, format)
+		if err != nil {
+			results = append(results, ImportResult{Path: rel, Action: ActionFailed, Error: err.Error()})
+			complete = false
+			continue
+		}
+
+		for _, file := range files {
+			if file.Slug == "" {
+				file.Slug = store.Slugify(file.Name)
+			}
+			if other, dup := seen[file.Slug]; dup {
+				results = append(results, ImportResult{Slug: file.Slug, Path: rel, Action: ActionFailed,
+					Error: fmt.Sprintf("slug already defined in %s", other)})
+				complete = false
+				continue
+			}
+			seen[file.Slug] = rel
+
+			result, err := Import(ctx, s.store, s.accountId, file, ImportOptions{
+				Source:     store.DashboardSourceFile,
+				SourcePath: rel,
+				Author:     "dashboard-sync",
+			})
+			if errors.Is(err, ErrSlugConflict) {
+				result.Action = ActionConflict
+				result.Error = err.Error()
+				complete = false
+			} else if err != nil {
+				result.Action = ActionFailed
+				result.Error = err.Error()
+				complete = false
+			}
+			results = append(results, result)
+		}
+	}
+
+	if !complete {
+		return results, incompleteError(results)
+	}
+
+	dashboards, err := s.store.ListDashboards(ctx, s.accountId)
+	if err != nil {
+		return results, err
+	}
+	for _, d := range dashboards {
+		if d.Source != store.DashboardSourceFile || seen[d.Slug] != "" {
+			continue
+		}
+		result := ImportResult{Slug: d.Slug, DashboardId: d.DashboardId, Path: d.SourcePath, Action: ActionDeleted}
+		if err := s.store.DeleteDashboard(ctx, s.accountId, d.DashboardId); err != nil {
+			result.Action = ActionFailed
+			result.Error = err.Error()
+			complete = false
+		}
+		results = append(results, result)
+	}
+	if !complete {
+		return results, incompleteError(results)
+	}
+	return results, nil
+}
+
+// incompleteError summarizes the dashboards a reconcile left out of sync
+func incompleteError(results []ImportResult) error {
+	var failed, conflicts int
+	for _, r := range results {
+		switch r.Action {
+		case ActionFailed:
+			failed++
+		case ActionConflict:
+			conflicts++
+		}
+	}
+	return fmt.Errorf("%d dashboards failed and %d conflicted", failed, conflicts)
+}
+
+func logResults(results []ImportResult) {
+	counts := make(map[string]int)
+	for _, r := range results {
+		counts[r.Action]++
+		if r.Action == ActionFailed || r.Action == ActionConflict {
+			log.Printf("Dashboard sync: %s (%s): %s", r.Slug, r.Path, r.Error)
+		}
+	}
+	log.Printf("Dashboard sync: %d created, %d updated, %d unchanged, %d deleted, %d conflicted, %d failed",
+		counts[ActionCreated], counts[ActionUpdated], counts[ActionUnchanged], counts[ActionDeleted], counts[ActionConflict], counts[ActionFailed])
+}
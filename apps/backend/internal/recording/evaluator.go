@@ -0,0 +1,140 @@
+package recording
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/namlabs/obsfly/backend/internal/store"
+)
+
+const (
+	// evaluationDelay leaves room for late-arriving samples before a window
+	// is considered complete.
+	evaluationDelay = 30 * time.Second
+	// maxCatchUpWindows bounds how many missed windows a rule replays per tick.
+	maxCatchUpWindows = 10
+)
+
+// Evaluator periodically runs recording rules and materializes their results
+// into metrics_v1. Watermarks are kept in memory, so only one backend
+// instance may run it: a second one would record every window again.
+type Evaluator struct {
+	store      *store.Store
+	tick       time.Duration
+	watermarks map[string]time.Time // rule ID -> end of last recorded window
+}
+
+func NewEvaluator(st *store.Store, tick time.Duration) *Evaluator {
+	return &Evaluator{
+		store: st,
+		tick:  tick,
+	}
+}
+
+func (e *Evaluator) Start(ctx context.Context) {
+	ticker := time.NewTicker(e.tick)
+	defer ticker.Stop()
+
+	fmt.Printf("Starting recording rule evaluator (tick %s)\n", e.tick)
+
+	for {
+		select {
+		case <-ctx.Done():
+			fmt.Println("Recording rule evaluator shutting down")
+			return
+		case <-ticker.C:
+			if err := e.evaluateAll(ctx); err != nil {
+				fmt.Printf("Error evaluating recording rules: %v\n", err)
+			}
+		}
+	}
+}
+
+func (e *Evaluator) evaluateAll(ctx context.Context) error {
+	if e.watermarks == nil {
+		statuses, err := e.store.GetRecordingRuleStatuses(ctx, 0)
+		if err != nil {
+			return fmt.Errorf("failed to load rule watermarks: %w", err)
+		}
+		e.watermarks = make(map[string]time.Time, len(statuses))
+		for ruleId, st := range statuses {
+			if !st.LastWindowEnd.IsZero() {
+				e.watermarks[ruleId] = st.LastWindowEnd
+			}
+		}
+	}
+
+	rules, err := e.store.ListActiveRecordingRules(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for i := range rules {
+		e.evaluateRule(ctx, &rules[i], now)
+	}
+	return nil
+}
+
+// evaluateRule records every complete window since the rule's watermark,
+// stopping at the first failure so the window is retried on the next tick.
+func (e *Evaluator) evaluateRule(ctx context.Context, rule *store.RecordingRule, now time.Time) {
+	interval := rule.IntervalDuration()
+	end := alignWindow(now.Add(-evaluationDelay), interval)
+
+	start, ok := e.watermarks[rule.RuleId]
+	if !ok || start.Before(end.Add(-maxCatchUpWindows*interval)) {
+		// First run or too far behind: skip ahead to the most recent windows
+		start = end.Add(-interval)
+		if ok {
+			start = end.Add(-maxCatchUpWindows * interval)
+		}
+	}
+
+	// A watermark off the interval's boundaries was left by a rule whose
+	// interval has since changed. Resume at the next boundary, so no window
+	// is recorded as a partial bucket.
+	if aligned := alignWindow(start, interval); !aligned.Equal(start) {
+		start = aligned.Add(interval)
+	}
+
+	for start.Before(end) {
+		windowEnd := start.Add(interval)
+
+		began := time.Now()
+		samples, err := e.store.EvaluateRecordingRule(ctx, rule, start, windowEnd)
+		eval := store.RecordingRuleEvaluation{
+			RuleId:         rule.RuleId,
+			AccountId:      rule.AccountId,
+			EvaluatedAt:    time.Now(),
+			WindowStart:    start,
+			WindowEnd:      windowEnd,
+			DurationMs:     uint32(time.Since(began).Milliseconds()),
+			SamplesWritten: uint64(samples),
+			Success:        err == nil,
+		}
+		if err != nil {
+			eval.Error = err.Error()
+		}
+		if recordErr := e.store.InsertRecordingRuleEvaluation(ctx, eval); recordErr != nil {
+			fmt.Printf("Error recording evaluation of rule %s: %v\n", rule.RuleId, recordErr)
+		}
+		if err != nil {
+			fmt.Printf("Recording rule %s (%s) failed: %v\n", rule.Name, rule.RuleId, err)
+			return
+		}
+
+		e.watermarks[rule.RuleId] = windowEnd
+		start = windowEnd
+	}
+}
+
+// alignWindow rounds t down to a multiple of interval since the Unix epoch,
+// the boundaries toStartOfInterval buckets on. time.Truncate counts from
+// the zero time instead, which only agrees for intervals dividing a day.
+func alignWindow(t time.Time, interval time.Duration) time.Time {
+	seconds := int64(interval / time.Second)
+	unix := t.Unix()
+	return time.Unix(unix-unix%seconds, 0)
+}
@@ -0,0 +1,223 @@
+package redaction
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/namlabs/obsfly/backend/internal/store"
+)
+
+// Redactor removes sensitive values from logs and spans as they are
+// ingested, following each account's redaction config, and counts what it
+// redacted by rule and service. Configs are reloaded from the store once
+// the refresh interval has passed; counts are written every tick.
+type Redactor struct {
+	store   *store.Store
+	hashKey []byte
+	refresh time.Duration
+	tick    time.Duration
+
+	mu       sync.Mutex
+	sets     map[uint64]*RuleSet // accounts with a saved config
+	loadedAt time.Time
+	counts   map[countKey]uint64
+}
+
+type countKey struct {
+	accountId uint64
+	minute    time.Time
+	service   string
+	rule      string
+	signal    string
+}
+
+func NewRedactor(st *store.Store, hashKey []byte, refresh, tick time.Duration) *Redactor {
+	return &Redactor{
+		store:   st,
+		hashKey: hashKey,
+		refresh: refresh,
+		tick:    tick,
+		sets:    make(map[uint64]*RuleSet),
+		counts:  make(map[countKey]uint64),
+	}
+}
+
+// RedactorFromEnv keys hashes with REDACTION_HASH_KEY. Without a key,
+// hashed values of small spaces such as card numbers can be recovered by
+// hashing guesses, so hash mode should not be used.
+func RedactorFromEnv(st *store.Store) *Redactor {
+	key := os.Getenv("REDACTION_HASH_KEY")
+	if key == "" {
+		fmt.Println("Warning: REDACTION_HASH_KEY is not set; hashed values are not keyed")
+	}
+	return NewRedactor(st, []byte(key), 30*time.Second, 10*time.Second)
+}
+
+// Load reads and compiles the saved redaction configs. Accounts whose
+// config no longer compiles get the default one.
+func (r *Redactor) Load(ctx context.Context) error {
+	configs, err := r.store.ListRedactionConfigs(ctx)
+	if err != nil {
+		return err
+	}
+
+	sets := make(map[uint64]*RuleSet)
+	for _, cfg := range configs {
+		set, err := Compile(cfg, r.hashKey)
+		if err != nil {
+			fmt.Printf("Using the default redaction config for account %d: %v\n", cfg.AccountId, err)
+			continue
+		}
+		sets[cfg.AccountId] = set
+	}
+
+	r.mu.Lock()
+	r.sets = sets
+	r.loadedAt = time.Now()
+	r.mu.Unlock()
+	return nil
+}
+
+// Logs redacts the bodies and attributes of logs in place. It only fails
+// when the configs cannot be reloaded; the ones loaded before still apply.
+func (r *Redactor) Logs(ctx context.Context, logs []store.Log) error {
+	err := r.reload(ctx)
+	found := make(map[countKey]uint64)
+	for i := range logs {
+		l := &logs[i]
+		set := r.ruleSet(l.AccountId)
+		count := counter(found, l.AccountId, l.Timestamp, l.ServiceName, store.RedactionSignalLogs)
+
+		l.Body = set.redact(l.Body, count)
+		redactValues(set, l.LogAttributes, count)
+	}
+	r.addCounts(found)
+	return err
+}
+
+// Spans redacts the attributes, events and status messages of spans in
+// place
+func (r *Redactor) Spans(ctx context.Context, traces []store.Trace) error {
+	err := r.reload(ctx)
+	found := make(map[countKey]uint64)
+	for i := range traces {
+		t := &traces[i]
+		set := r.ruleSet(t.AccountId)
+		count := counter(found, t.AccountId, t.Timestamp, t.ServiceName, store.RedactionSignalTraces)
+
+		redactValues(set, t.Attributes, count)
+		for _, event := range t.Events {
+			redactValues(set, event, count)
+		}
+		t.StatusMessage = set.redact(t.StatusMessage, count)
+	}
+	r.addCounts(found)
+	return err
+}
+
+func redactValues(set *RuleSet, values map[string]string, found func(rule string)) {
+	for k, v := range values {
+		values[k] = set.redact(v, found)
+	}
+}
+
+// counter returns a callback counting redactions into found
+func counter(found map[countKey]uint64, accountId uint64, at time.Time, service, signal string) func(rule string) {
+	return func(rule string) {
+		found[countKey{accountId, at.Truncate(time.Minute), service, rule, signal}]++
+	}
+}
+
+// ruleSet returns the rules of an account, compiling the default config
+// for accounts without a saved one
+func (r *Redactor) ruleSet(accountId uint64) *RuleSet {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	set, ok := r.sets[accountId]
+	if !ok {
+		set, _ = Compile(*store.DefaultRedactionConfig(accountId), r.hashKey)
+		r.sets[accountId] = set
+	}
+	return set
+}
+
+// reload loads the configs again once the refresh interval has passed
+func (r *Redactor) reload(ctx context.Context) error {
+	r.mu.Lock()
+	stale := time.Since(r.loadedAt) >= r.refresh
+	r.mu.Unlock()
+	if !stale {
+		return nil
+	}
+	if err := r.Load(ctx); err != nil {
+		// Try again at the next refresh rather than on every batch
+		r.mu.Lock()
+		r.loadedAt = time.Now()
+		r.mu.Unlock()
+		return fmt.Errorf("failed to reload redaction configs: %w", err)
+	}
+	return nil
+}
+
+func (r *Redactor) addCounts(found map[countKey]uint64) {
+	if len(found) == 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for key, n := range found {
+		r.counts[key] += n
+	}
+}
+
+// Start writes the redaction counts every tick until the context ends
+func (r *Redactor) Start(ctx context.Context) {
+	ticker := time.NewTicker(r.tick)
+	defer ticker.Stop()
+
+	fmt.Printf("Starting redaction counter flush (tick %s)\n", r.tick)
+
+	for {
+		select {
+		case <-ctx.Done():
+			fmt.Println("Redaction counter flush shutting down")
+			return
+		case <-ticker.C:
+			if err := r.flush(ctx); err != nil {
+				fmt.Printf("Error writing redaction counts: %v\n", err)
+			}
+		}
+	}
+}
+
+// flush writes the counts gathered since the last flush. Counts that fail
+// to be written are kept for the next one.
+func (r *Redactor) flush(ctx context.Context) error {
+	r.mu.Lock()
+	counts := r.counts
+	r.counts = make(map[countKey]uint64)
+	r.mu.Unlock()
+	if len(counts) == 0 {
+		return nil
+	}
+
+	samples := make([]store.RedactionCountSample, 0, len(counts))
+	for key, n := range counts {
+		samples = append(samples, store.RedactionCountSample{
+			AccountId:   key.accountId,
+			Minute:      key.minute,
+			ServiceName: key.service,
+			Rule:        key.rule,
+			Signal:      key.signal,
+			Count:       n,
+		})
+	}
+	if err := r.store.InsertRedactionCounts(ctx, samples); err != nil {
+		r.addCounts(counts)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,243 @@
+package redaction
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/namlabs/obsfly/backend/internal/store"
+)
+
+// Bounds on the custom rules of an account, which run on every value
+// ingested
+const (
+	maxCustomRules       = 50
+	maxRulePatternLength = 1024
+)
+
+// detector finds a kind of sensitive value. When the pattern has groups,
+// the first group is the value and the rest of the match is context.
+type detector struct {
+	pattern *regexp.Regexp
+	find    func(match string) [][2]int // the values within a match, nil to take the whole match
+}
+
+var detectors = map[string]detector{
+	store.RedactionCreditCard: {
+		pattern: regexp.MustCompile(`\b\d+(?:[ -]\d+)*\b`),
+		find:    cardNumbers,
+	},
+	store.RedactionEmail: {
+		pattern: regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9\-]+(?:\.[A-Za-z0-9\-]+)*\.[A-Za-z]{2,}`),
+	},
+	store.RedactionBearerToken: {
+		pattern: regexp.MustCompile(`(?i)\bbearer\s+([A-Za-z0-9\-._~+/]{8,}=*)`),
+	},
+	store.RedactionAWSAccessKey: {
+		pattern: regexp.MustCompile(`\b(?:AKIA|ASIA|ABIA|ACCA)[0-9A-Z]{16}\b`),
+	},
+	store.RedactionAWSSecretKey: {
+		pattern: regexp.MustCompile(`(?i)aws.{0,20}?(?:secret|key).{0,20}?[=:]\s*["']?([A-Za-z0-9/+]{40})\b`),
+	},
+}
+
+var digitGroup = regexp.MustCompile(`\d+`)
+
+// cardNumbers finds the card numbers in a run of digit groups, such as
+// "4111 1111 1111 1111 12/25". A card is the longest valid number from
+// the first group it can start at, so numbers next to a card, such as an
+// expiry date, do not hide it.
+func cardNumbers(run string) [][2]int {
+	groups := digitGroup.FindAllStringIndex(run, -1)
+	var cards [][2]int
+	for i := 0; i < len(groups); {
+		last, digits := -1, 0
+		for j := i; j < len(groups); j++ {
+			digits += groups[j][1] - groups[j][0]
+			if digits > 19 {
+				break
+			}
+			if digits >= 13 && isCardNumber(run[groups[i][0]:groups[j][1]]) {
+				last = j
+			}
+		}
+		if last < 0 {
+			i++
+			continue
+		}
+		cards = append(cards, [2]int{groups[i][0], groups[last][1]})
+		i = last + 1
+	}
+	return cards
+}
+
+// isCardNumber checks the length, issuer prefix and Luhn checksum of a
+// card number, so IDs and timestamps of the same length are not taken
+// for one
+func isCardNumber(value string) bool {
+	digits := make([]byte, 0, 19)
+	for i := 0; i < len(value); i++ {
+		if value[i] >= '0' && value[i] <= '9' {
+			digits = append(digits, value[i]-'0')
+		}
+	}
+	n := len(digits)
+	if n < 13 || n > 19 {
+		return false
+	}
+
+	prefix2 := int(digits[0])*10 + int(digits[1])
+	prefix4 := prefix2*100 + int(digits[2])*10 + int(digits[3])
+	switch {
+	case digits[0] == 4: // Visa
+	case prefix2 >= 51 && prefix2 <= 55, prefix4 >= 2221 && prefix4 <= 2720: // Mastercard
+	case prefix2 == 34 || prefix2 == 37: // American Express
+	case prefix4 == 6011 || prefix2 == 65: // Discover
+	case prefix2 == 35: // JCB
+	default:
+		return false
+	}
+
+	sum := 0
+	for i := n - 1; i >= 0; i-- {
+		d := int(digits[i])
+		if (n-1-i)%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return sum%10 == 0
+}
+
+// rule is a detector in use by an account
+type rule struct {
+	name string
+	mode string
+	detector
+}
+
+// RuleSet is an account's compiled redaction config
+type RuleSet struct {
+	accountId uint64
+	rules     []rule
+	hashKey   []byte
+}
+
+// Compile validates a redaction config and prepares it for use
+func Compile(cfg store.RedactionConfig, hashKey []byte) (*RuleSet, error) {
+	if !validMode(cfg.Mode) {
+		return nil, fmt.Errorf("mode must be %s or %s", store.RedactionMask, store.RedactionHash)
+	}
+	if len(cfg.Rules) > maxCustomRules {
+		return nil, fmt.Errorf("at most %d custom rules are allowed", maxCustomRules)
+	}
+
+	set := &RuleSet{accountId: cfg.AccountId, hashKey: hashKey}
+	names := make(map[string]bool)
+	for _, name := range cfg.Detectors {
+		d, ok := detectors[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown detector %q; detectors are %s", name, strings.Join(store.RedactionDetectors, ", "))
+		}
+		if !names[name] {
+			names[name] = true
+			set.rules = append(set.rules, rule{name: name, mode: cfg.Mode, detector: d})
+		}
+	}
+	for i, r := range cfg.Rules {
+		if r.Name == "" {
+			return nil, fmt.Errorf("rule %d: name is required", i)
+		}
+		if names[r.Name] || slices.Contains(store.RedactionDetectors, r.Name) {
+			return nil, fmt.Errorf("rule %d: name %q is already used", i, r.Name)
+		}
+		names[r.Name] = true
+		if len(r.Pattern) > maxRulePatternLength {
+			return nil, fmt.Errorf("rule %s: pattern is longer than %d characters", r.Name, maxRulePatternLength)
+		}
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: invalid pattern: %w", r.Name, err)
+		}
+		if re.MatchString("") {
+			return nil, fmt.Errorf("rule %s: pattern matches empty text", r.Name)
+		}
+		mode := r.Mode
+		if mode == "" {
+			mode = cfg.Mode
+		}
+		if !validMode(mode) {
+			return nil, fmt.Errorf("rule %s: mode must be %s or %s", r.Name, store.RedactionMask, store.RedactionHash)
+		}
+		set.rules = append(set.rules, rule{name: r.Name, mode: mode, detector: detector{pattern: re}})
+	}
+
+	// A disabled config is still validated, so it can be enabled as saved
+	if !cfg.Enabled {
+		set.rules = nil
+	}
+	return set, nil
+}
+
+func validMode(mode string) bool {
+	return mode == store.RedactionMask || mode == store.RedactionHash
+}
+
+// redact replaces the sensitive values in text, calling found once per
+// value replaced
+func (set *RuleSet) redact(text string, found func(rule string)) string {
+	for _, r := range set.rules {
+		matches := r.pattern.FindAllStringSubmatchIndex(text, -1)
+		if len(matches) == 0 {
+			continue
+		}
+
+		var b strings.Builder
+		last, changed := 0, false
+		for _, m := range matches {
+			start, end := m[0], m[1]
+			if len(m) > 2 && m[2] >= 0 {
+				start, end = m[2], m[3]
+			}
+			if start == end {
+				continue
+			}
+			values := [][2]int{{0, end - start}}
+			if r.find != nil {
+				values = r.find(text[start:end])
+			}
+			for _, v := range values {
+				b.WriteString(text[last : start+v[0]])
+				b.WriteString(set.replacement(r, text[start+v[0]:start+v[1]]))
+				last, changed = start+v[1], true
+				found(r.name)
+			}
+		}
+		if changed {
+			b.WriteString(text[last:])
+			text = b.String()
+		}
+	}
+	return text
+}
+
+// replacement is what a value is replaced with. Hashes are keyed and
+// scoped to the account, so they cannot be reversed by hashing guesses
+// and do not correlate across accounts.
+func (set *RuleSet) replacement(r rule, value string) string {
+	if r.mode == store.RedactionMask {
+		return "[REDACTED:" + r.name + "]"
+	}
+	mac := hmac.New(sha256.New, set.hashKey)
+	binary.Write(mac, binary.BigEndian, set.accountId)
+	mac.Write([]byte(value))
+	return "[" + r.name + ":" + hex.EncodeToString(mac.Sum(nil))[:16] + "]"
+}
@@ -0,0 +1,264 @@
+package reports
+
+import (
+	"fmt"
+	"html"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"sort"
+	"strings"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// Text anchors
+const (
+	anchorStart = iota
+	anchorMiddle
+	anchorEnd
+)
+
+// baseFontSize is the size of the bitmap font; larger text is drawn at
+// integer multiples of it
+const baseFontSize = 13
+
+type point struct{ x, y float64 }
+
+// canvas is the drawing surface charts render onto. The SVG and raster
+// implementations draw the same report, so PNG and PDF output match SVG.
+type canvas interface {
+	fillRect(x, y, w, h float64, c color.RGBA)
+	strokeRect(x, y, w, h float64, c color.RGBA)
+	polyline(pts []point, c color.RGBA, width float64)
+	fillPolygon(pts []point, c color.RGBA)
+	text(x, y float64, s string, size float64, c color.RGBA, anchor int) // y is the baseline
+}
+
+// textWidth estimates the width of text in the monospace report font
+func textWidth(s string, size float64) float64 {
+	return float64(len([]rune(s))) * 7 * fontScale(size)
+}
+
+func fontScale(size float64) float64 {
+	return math.Max(1, math.Round(size/baseFontSize))
+}
+
+// truncate shortens s to fit into width
+func truncate(s string, size, width float64) string {
+	if textWidth(s, size) <= width {
+		return s
+	}
+	runes := []rune(s)
+	n := int(width / (7 * fontScale(size)))
+	if n <= 3 {
+		return ""
+	}
+	return string(runes[:n-3]) + "..."
+}
+
+// ========== SVG ==========
+
+type svgCanvas struct {
+	sb            strings.Builder
+	width, height int
+}
+
+func newSVGCanvas(width, height int) *svgCanvas {
+	c := &svgCanvas{width: width, height: height}
+	fmt.Fprintf(&c.sb, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" font-family="monospace">`+"\n",
+		width, height, width, height)
+	return c
+}
+
+func (c *svgCanvas) bytes() []byte {
+	return []byte(c.sb.String() + "</svg>\n")
+}
+
+func svgColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+func svgOpacity(c color.RGBA) string {
+	if c.A == 255 {
+		return ""
+	}
+	return fmt.Sprintf(` fill-opacity="%.2f"`, float64(c.A)/255)
+}
+
+func svgPoints(pts []point) string {
+	parts := make([]string, len(pts))
+	for i, p := range pts {
+		parts[i] = fmt.Sprintf("%.1f,%.1f", p.x, p.y)
+	}
+	return strings.Join(parts, " ")
+}
+
+func (c *svgCanvas) fillRect(x, y, w, h float64, col color.RGBA) {
+	fmt.Fprintf(&c.sb, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="%s"%s/>`+"\n", x, y, w, h, svgColor(col), svgOpacity(col))
+}
+
+func (c *svgCanvas) strokeRect(x, y, w, h float64, col color.RGBA) {
+	fmt.Fprintf(&c.sb, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="none" stroke="%s"/>`+"\n", x+0.5, y+0.5, w-1, h-1, svgColor(col))
+}
+
+func (c *svgCanvas) polyline(pts []point, col color.RGBA, width float64) {
+	if len(pts) < 2 {
+		return
+	}
+	fmt.Fprintf(&c.sb, `<polyline points="%s" fill="none" stroke="%s" stroke-width="%.1f" stroke-linejoin="round"/>`+"\n",
+		svgPoints(pts), svgColor(col), width)
+}
+
+func (c *svgCanvas) fillPolygon(pts []point, col color.RGBA) {
+	if len(pts) < 3 {
+		return
+	}
+	fmt.Fprintf(&c.sb, `<polygon points="%s" fill="%s"%s/>`+"\n", svgPoints(pts), svgColor(col), svgOpacity(col))
+}
+
+func (c *svgCanvas) text(x, y float64, s string, size float64, col color.RGBA, anchor int) {
+	anchors := []string{"start", "middle", "end"}
+	fmt.Fprintf(&c.sb, `<text x="%.1f" y="%.1f" font-size="%.0f" fill="%s" text-anchor="%s">%s</text>`+"\n",
+		x, y, baseFontSize*fontScale(size), svgColor(col), anchors[anchor], html.EscapeString(s))
+}
+
+// ========== RASTER ==========
+
+type rasterCanvas struct {
+	img *image.RGBA
+}
+
+func newRasterCanvas(width, height int) *rasterCanvas {
+	return &rasterCanvas{img: image.NewRGBA(image.Rect(0, 0, width, height))}
+}
+
+// blend draws a pixel, mixing in translucent colors
+func (c *rasterCanvas) blend(x, y int, col color.RGBA) {
+	if !(image.Point{x, y}.In(c.img.Rect)) {
+		return
+	}
+	if col.A == 255 {
+		c.img.SetRGBA(x, y, col)
+		return
+	}
+	dst := c.img.RGBAAt(x, y)
+	a := uint32(col.A)
+	mix := func(s, d uint8) uint8 { return uint8((uint32(s)*a + uint32(d)*(255-a)) / 255) }
+	c.img.SetRGBA(x, y, color.RGBA{mix(col.R, dst.R), mix(col.G, dst.G), mix(col.B, dst.B), 255})
+}
+
+func (c *rasterCanvas) fillRect(x, y, w, h float64, col color.RGBA) {
+	r := image.Rect(int(math.Round(x)), int(math.Round(y)), int(math.Round(x+w)), int(math.Round(y+h)))
+	if col.A == 255 {
+		draw.Draw(c.img, r, image.NewUniform(col), image.Point{}, draw.Src)
+		return
+	}
+	r = r.Intersect(c.img.Rect)
+	for py := r.Min.Y; py < r.Max.Y; py++ {
+		for px := r.Min.X; px < r.Max.X; px++ {
+			c.blend(px, py, col)
+		}
+	}
+}
+
+func (c *rasterCanvas) strokeRect(x, y, w, h float64, col color.RGBA) {
+	c.fillRect(x, y, w, 1, col)
+	c.fillRect(x, y+h-1, w, 1, col)
+	c.fillRect(x, y, 1, h, col)
+	c.fillRect(x+w-1, y, 1, h, col)
+}
+
+// polyline stamps a square brush along every segment
+func (c *rasterCanvas) polyline(pts []point, col color.RGBA, width float64) {
+	half := int(math.Max(0, math.Round(width/2-0.5)))
+	for i := 1; i < len(pts); i++ {
+		a, b := pts[i-1], pts[i]
+		steps := int(math.Ceil(math.Max(math.Abs(b.x-a.x), math.Abs(b.y-a.y)))) + 1
+		for s := 0; s <= steps; s++ {
+			t := float64(s) / float64(steps)
+			px := int(math.Round(a.x + (b.x-a.x)*t))
+			py := int(math.Round(a.y + (b.y-a.y)*t))
+			for dy := -half; dy <= half; dy++ {
+				for dx := -half; dx <= half; dx++ {
+					c.img.SetRGBA(px+dx, py+dy, col)
+				}
+			}
+		}
+	}
+}
+
+// fillPolygon fills with the even-odd rule, one scanline at a time
+func (c *rasterCanvas) fillPolygon(pts []point, col color.RGBA) {
+	if len(pts) < 3 {
+		return
+	}
+	minY, maxY := pts[0].y, pts[0].y
+	for _, p := range pts {
+		minY, maxY = math.Min(minY, p.y), math.Max(maxY, p.y)
+	}
+
+	for py := int(math.Floor(minY)); py <= int(math.Ceil(maxY)); py++ {
+		y := float64(py) + 0.5
+		var xs []float64
+		for i := range pts {
+			a, b := pts[i], pts[(i+1)%len(pts)]
+			if (a.y <= y) != (b.y <= y) {
+				xs = append(xs, a.x+(y-a.y)/(b.y-a.y)*(b.x-a.x))
+			}
+		}
+		sort.Float64s(xs)
+		for i := 0; i+1 < len(xs); i += 2 {
+			for px := int(math.Round(xs[i])); px < int(math.Round(xs[i+1])); px++ {
+				c.blend(px, py, col)
+			}
+		}
+	}
+}
+
+func (c *rasterCanvas) text(x, y float64, s string, size float64, col color.RGBA, anchor int) {
+	scale := int(fontScale(size))
+	w := textWidth(s, size)
+	switch anchor {
+	case anchorMiddle:
+		x -= w / 2
+	case anchorEnd:
+		x -= w
+	}
+
+	if scale == 1 {
+		d := &font.Drawer{
+			Dst:  c.img,
+			Src:  image.NewUniform(col),
+			Face: basicfont.Face7x13,
+			Dot:  fixed.P(int(math.Round(x)), int(math.Round(y))),
+		}
+		d.DrawString(s)
+		return
+	}
+
+	// Draw at the font's size and scale up pixel by pixel
+	face := basicfont.Face7x13
+	small := image.NewAlpha(image.Rect(0, 0, int(w)/scale+1, face.Height))
+	d := &font.Drawer{Dst: small, Src: image.Opaque, Face: face, Dot: fixed.P(0, face.Ascent)}
+	d.DrawString(s)
+
+	top := int(math.Round(y)) - face.Ascent*scale
+	left := int(math.Round(x))
+	b := small.Bounds()
+	for sy := b.Min.Y; sy < b.Max.Y; sy++ {
+		for sx := b.Min.X; sx < b.Max.X; sx++ {
+			if small.AlphaAt(sx, sy).A < 128 {
+				continue
+			}
+			for dy := 0; dy < scale; dy++ {
+				for dx := 0; dx < scale; dx++ {
+					c.img.SetRGBA(left+sx*scale+dx, top+sy*scale+dy, col)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,427 @@
+package reports
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/namlabs/obsfly/backend/internal/store"
+)
+
+// Report layout, in pixels
+const (
+	pageWidth     = 1200
+	headerHeight  = 80
+	panelWidth    = 580
+	panelHeight   = 320
+	panelGap      = 20
+	panelsPerRow  = 2
+	maxLegendRows = 3
+)
+
+var (
+	colorBackground = color.RGBA{255, 255, 255, 255}
+	colorPanel      = color.RGBA{250, 250, 252, 255}
+	colorBorder     = color.RGBA{220, 222, 228, 255}
+	colorGrid       = color.RGBA{232, 234, 238, 255}
+	colorText       = color.RGBA{33, 37, 41, 255}
+	colorMuted      = color.RGBA{110, 117, 125, 255}
+	colorError      = color.RGBA{201, 42, 42, 255}
+)
+
+// defaultPalette colors series without custom colors
+var defaultPalette = []color.RGBA{
+	{59, 130, 246, 255}, {16, 185, 129, 255}, {245, 158, 11, 255}, {239, 68, 68, 255},
+	{139, 92, 246, 255}, {236, 72, 153, 255}, {20, 184, 166, 255}, {132, 204, 22, 255},
+}
+
+// panel is one widget placed on the report
+type panel struct {
+	widget store.DashboardWidget
+	data   store.WidgetData
+}
+
+// page is a set of panels drawn under the report header
+type page struct {
+	title, subtitle string
+	panels          []panel
+	location        *time.Location // time axis labels are shown in this zone
+}
+
+// panels orders the widgets of a rendered dashboard by grid position
+func panels(dashboard *store.Dashboard, data *store.DashboardData) []panel {
+	byId := make(map[string]store.WidgetData, len(data.Widgets))
+	for _, w := range data.Widgets {
+		byId[w.WidgetId] = w
+	}
+
+	widgets := append([]store.DashboardWidget(nil), dashboard.Widgets...)
+	sort.SliceStable(widgets, func(i, j int) bool {
+		if widgets[i].Layout.Y != widgets[j].Layout.Y {
+			return widgets[i].Layout.Y < widgets[j].Layout.Y
+		}
+		return widgets[i].Layout.X < widgets[j].Layout.X
+	})
+
+	out := make([]panel, 0, len(widgets))
+	for _, w := range widgets {
+		out = append(out, panel{widget: w, data: byId[w.WidgetId]})
+	}
+	return out
+}
+
+func (p *page) height() int {
+	rows := (len(p.panels) + panelsPerRow - 1) / panelsPerRow
+	if rows == 0 {
+		rows = 1
+	}
+	return headerHeight + rows*(panelHeight+panelGap) + panelGap
+}
+
+// draw renders the page onto a canvas of the given height
+func (p *page) draw(c canvas, height int) {
+	c.fillRect(0, 0, pageWidth, float64(height), colorBackground)
+	c.text(panelGap, 38, truncate(p.title, 26, pageWidth-2*panelGap), 26, colorText, anchorStart)
+	c.text(panelGap, 62, p.subtitle, 13, colorMuted, anchorStart)
+
+	if len(p.panels) == 0 {
+		c.text(pageWidth/2, headerHeight+panelHeight/2, "This dashboard has no widgets", 13, colorMuted, anchorMiddle)
+	}
+	for i, pn := range p.panels {
+		x := float64(panelGap + (i%panelsPerRow)*(panelWidth+panelGap))
+		y := float64(headerHeight + (i/panelsPerRow)*(panelHeight+panelGap))
+		drawPanel(c, pn, p.location, x, y, panelWidth, panelHeight)
+	}
+}
+
+func drawPanel(c canvas, p panel, loc *time.Location, x, y, w, h float64) {
+	c.fillRect(x, y, w, h, colorPanel)
+	c.strokeRect(x, y, w, h, colorBorder)
+
+	title := p.widget.Title
+	if title == "" {
+		title = p.widget.WidgetId
+	}
+	c.text(x+12, y+22, truncate(title, 13, w-24), 13, colorText, anchorStart)
+
+	body := struct{ x, y, w, h float64 }{x + 12, y + 34, w - 24, h - 46}
+	switch {
+	case p.data.Error != "":
+		c.text(body.x, body.y+20, "Failed to render:", 13, colorError, anchorStart)
+		for i, line := range wrap(p.data.Error, int(body.w/7), 6) {
+			c.text(body.x, body.y+40+float64(i)*16, line, 13, colorError, anchorStart)
+		}
+	case !hasData(p.data.Series):
+		c.text(x+w/2, y+h/2, "No data", 13, colorMuted, anchorMiddle)
+	case p.widget.WidgetType == "metric":
+		drawStat(c, p, body.x, body.y, body.w, body.h)
+	case p.widget.WidgetType == "table":
+		drawTable(c, p, body.x, body.y, body.w, body.h)
+	default:
+		drawTimeSeries(c, p, loc, body.x, body.y, body.w, body.h)
+	}
+}
+
+func hasData(series []store.MetricSeries) bool {
+	for _, s := range series {
+		if len(s.DataPoints) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// drawStat shows the latest value of the first series, colored by the
+// highest threshold it reaches
+func drawStat(c canvas, p panel, x, y, w, h float64) {
+	viz := p.widget.Visualization
+	series := p.data.Series[0]
+	value := lastValue(series)
+
+	col := colorText
+	thresholds := append([]store.Threshold(nil), viz.Thresholds...)
+	sort.Slice(thresholds, func(i, j int) bool { return thresholds[i].Value < thresholds[j].Value })
+	for _, t := range thresholds {
+		if value >= t.Value {
+			if parsed, ok := parseColor(t.Color); ok {
+				col = parsed
+			}
+		}
+	}
+
+	c.text(x+w/2, y+h/2+10, formatValue(value, viz.Unit, viz.Decimals), 52, col, anchorMiddle)
+	c.text(x+w/2, y+h/2+44, truncate(seriesName(series), 13, w), 13, colorMuted, anchorMiddle)
+}
+
+// drawTable lists every series with its latest, average and maximum values
+func drawTable(c canvas, p panel, x, y, w, h float64) {
+	viz := p.widget.Visualization
+	valueWidth := 90.0
+	nameWidth := w - 3*valueWidth
+
+	header := []string{"Last", "Avg", "Max"}
+	c.text(x, y+14, "Series", 13, colorMuted, anchorStart)
+	for i, col := range header {
+		c.text(x+nameWidth+float64(i+1)*valueWidth, y+14, col, 13, colorMuted, anchorEnd)
+	}
+	c.fillRect(x, y+20, w, 1, colorBorder)
+
+	rowHeight := 20.0
+	maxRows := int((h - 28) / rowHeight)
+	for i, s := range p.data.Series {
+		rowY := y + 28 + float64(i)*rowHeight
+		if i == maxRows-1 && len(p.data.Series) > maxRows {
+			c.text(x, rowY+12, fmt.Sprintf("and %d more", len(p.data.Series)-i), 13, colorMuted, anchorStart)
+			break
+		}
+		c.text(x, rowY+12, truncate(seriesName(s), 13, nameWidth-8), 13, colorText, anchorStart)
+		for j, v := range []float64{lastValue(s), s.Stats.Avg, s.Stats.Max} {
+			c.text(x+nameWidth+float64(j+1)*valueWidth, rowY+12, formatValue(v, viz.Unit, viz.Decimals), 13, colorText, anchorEnd)
+		}
+	}
+}
+
+// drawTimeSeries draws a line chart, filled below the lines for area charts
+func drawTimeSeries(c canvas, p panel, loc *time.Location, x, y, w, h float64) {
+	viz := p.widget.Visualization
+	series := p.data.Series
+
+	legendRows := 0
+	if len(series) > 1 || viz.ShowLegend {
+		legendRows = min((len(series)+2)/3, maxLegendRows)
+	}
+
+	// Plot area, leaving room for the y axis labels, the time axis and the legend
+	plotX, plotY := x+64, y+6
+	plotW, plotH := w-70, h-28-float64(legendRows)*20
+
+	from, to := p.data.From, p.data.To
+	minV, maxV := math.Inf(1), math.Inf(-1)
+	for _, s := range series {
+		for _, dp := range s.DataPoints {
+			minV, maxV = math.Min(minV, dp.Value), math.Max(maxV, dp.Value)
+			if from.IsZero() || dp.Timestamp.Before(from) {
+				from = dp.Timestamp
+			}
+			if to.IsZero() || dp.Timestamp.After(to) {
+				to = dp.Timestamp
+			}
+		}
+	}
+	if minV > 0 {
+		minV = 0
+	}
+	ticks := niceTicks(minV, maxV, 5)
+	lo, hi := ticks[0], ticks[len(ticks)-1]
+	span := to.Sub(from).Seconds()
+	if span <= 0 {
+		span = 1
+	}
+
+	px := func(t time.Time) float64 { return plotX + t.Sub(from).Seconds()/span*plotW }
+	py := func(v float64) float64 { return plotY + plotH - (v-lo)/(hi-lo)*plotH }
+
+	for _, tick := range ticks {
+		ty := py(tick)
+		c.fillRect(plotX, ty, plotW, 1, colorGrid)
+		c.text(plotX-6, ty+4, formatValue(tick, viz.Unit, viz.Decimals), 13, colorMuted, anchorEnd)
+	}
+	layout := timeLayout(to.Sub(from))
+	for i := 0; i <= 4; i++ {
+		t := from.Add(time.Duration(float64(to.Sub(from)) * float64(i) / 4))
+		anchor := anchorMiddle
+		if i == 0 {
+			anchor = anchorStart
+		} else if i == 4 {
+			anchor = anchorEnd
+		}
+		c.text(px(t), plotY+plotH+16, t.In(loc).Format(layout), 13, colorMuted, anchor)
+	}
+
+	fill := viz.ChartType == "area"
+	for i, s := range series {
+		col := seriesColor(viz, i)
+		pts := make([]point, 0, len(s.DataPoints))
+		for _, dp := range s.DataPoints {
+			pts = append(pts, point{px(dp.Timestamp), py(dp.Value)})
+		}
+		if len(pts) == 1 {
+			// A single point would not show up as a line
+			c.fillRect(pts[0].x-2, pts[0].y-2, 4, 4, col)
+			continue
+		}
+		if fill {
+			base := py(math.Max(lo, 0))
+			area := append([]point{{pts[0].x, base}}, pts...)
+			area = append(area, point{pts[len(pts)-1].x, base})
+			c.fillPolygon(area, color.RGBA{col.R, col.G, col.B, 56})
+		}
+		c.polyline(pts, col, 2)
+	}
+
+	if legendRows > 0 {
+		colWidth := w / 3
+		for i, s := range series {
+			if i >= legendRows*3 {
+				break
+			}
+			lx := x + float64(i%3)*colWidth
+			ly := y + h - float64(legendRows-1-i/3)*20 - 4
+			label := seriesName(s)
+			if i == legendRows*3-1 && len(series) > legendRows*3 {
+				label = fmt.Sprintf("and %d more", len(series)-i)
+			} else {
+				c.fillRect(lx, ly-9, 10, 10, seriesColor(viz, i))
+			}
+			c.text(lx+14, ly, truncate(label, 13, colWidth-20), 13, colorText, anchorStart)
+		}
+	}
+}
+
+func seriesColor(viz store.VisualizationConfig, i int) color.RGBA {
+	if len(viz.Colors) > 0 {
+		if col, ok := parseColor(viz.Colors[i%len(viz.Colors)]); ok {
+			return col
+		}
+	}
+	return defaultPalette[i%len(defaultPalette)]
+}
+
+// parseColor reads #rgb and #rrggbb colors
+func parseColor(s string) (color.RGBA, bool) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "#")
+	if len(s) == 3 {
+		s = string([]byte{s[0], s[0], s[1], s[1], s[2], s[2]})
+	}
+	if len(s) != 6 {
+		return color.RGBA{}, false
+	}
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return color.RGBA{}, false
+	}
+	return color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 255}, true
+}
+
+func seriesName(s store.MetricSeries) string {
+	if s.Name != "" {
+		return s.Name
+	}
+	keys := make([]string, 0, len(s.Labels))
+	for k := range s.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = k + "=" + s.Labels[k]
+	}
+	return strings.Join(parts, ", ")
+}
+
+func lastValue(s store.MetricSeries) float64 {
+	if len(s.DataPoints) == 0 {
+		return 0
+	}
+	return s.DataPoints[len(s.DataPoints)-1].Value
+}
+
+// formatValue renders a value with its unit, abbreviating large numbers
+func formatValue(v float64, unit string, decimals int) string {
+	suffix := ""
+	if unit != "%" && unit != "percent" {
+		switch abs := math.Abs(v); {
+		case abs >= 1e9:
+			v, suffix = v/1e9, "G"
+		case abs >= 1e6:
+			v, suffix = v/1e6, "M"
+		case abs >= 1e4:
+			v, suffix = v/1e3, "k"
+		}
+	}
+	if decimals <= 0 {
+		decimals = 0
+		if v != math.Trunc(v) && math.Abs(v) < 100 {
+			decimals = 1
+		}
+	}
+
+	text := strconv.FormatFloat(v, 'f', decimals, 64) + suffix
+	switch unit {
+	case "":
+		return text
+	case "%", "percent":
+		return text + "%"
+	}
+	return text + " " + unit
+}
+
+// niceTicks returns evenly spaced round values covering [lo, hi]
+func niceTicks(lo, hi float64, count int) []float64 {
+	if math.IsInf(lo, 0) || math.IsInf(hi, 0) {
+		lo, hi = 0, 1
+	}
+	if hi <= lo {
+		hi = lo + 1
+	}
+	raw := (hi - lo) / float64(count-1)
+	magnitude := math.Pow(10, math.Floor(math.Log10(raw)))
+	step := magnitude * 10
+	for _, m := range []float64{1, 2, 2.5, 5, 10} {
+		if m*magnitude >= raw {
+			step = m * magnitude
+			break
+		}
+	}
+
+	var ticks []float64
+	for v := math.Floor(lo/step) * step; v < hi+step/2; v += step {
+		ticks = append(ticks, v)
+	}
+	if len(ticks) < 2 {
+		ticks = append(ticks, ticks[0]+step)
+	}
+	return ticks
+}
+
+func timeLayout(span time.Duration) string {
+	switch {
+	case span <= 24*time.Hour:
+		return "15:04"
+	case span <= 7*24*time.Hour:
+		return "Mon 15:04"
+	}
+	return "Jan 02"
+}
+
+// wrap breaks text into at most maxLines lines of width characters
+func wrap(text string, width, maxLines int) []string {
+	var lines []string
+	var line strings.Builder
+	for _, word := range strings.Fields(text) {
+		if line.Len() > 0 && line.Len()+1+len(word) > width {
+			lines = append(lines, line.String())
+			line.Reset()
+		}
+		if line.Len() > 0 {
+			line.WriteByte(' ')
+		}
+		line.WriteString(word)
+	}
+	if line.Len() > 0 {
+		lines = append(lines, line.String())
+	}
+	if len(lines) > maxLines {
+		lines = append(lines[:maxLines-1], "...")
+	}
+	for i := range lines {
+		if len(lines[i]) > width {
+			lines[i] = lines[i][:width]
+		}
+	}
+	return lines
+}
@@ -0,0 +1,105 @@
+package reports
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	// Friday 2024-03-15 10:20:30 UTC
+	from := time.Date(2024, 3, 15, 10, 20, 30, 0, time.UTC)
+
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2024, 3, 15, 10, 21, 0, 0, time.UTC)},
+		{"0 8 * * *", time.Date(2024, 3, 16, 8, 0, 0, 0, time.UTC)},
+		{"30 10 * * *", time.Date(2024, 3, 15, 10, 30, 0, 0, time.UTC)},
+		{"20 10 * * *", time.Date(2024, 3, 16, 10, 20, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, 3, 15, 10, 30, 0, 0, time.UTC)},
+		{"5/20 * * * *", time.Date(2024, 3, 15, 10, 25, 0, 0, time.UTC)},
+		{"0 9-17/4 * * *", time.Date(2024, 3, 15, 13, 0, 0, 0, time.UTC)},
+		{"0 0,12 * * *", time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC)},
+		{"0 8 * * mon", time.Date(2024, 3, 18, 8, 0, 0, 0, time.UTC)},
+		{"0 8 * * MON-WED", time.Date(2024, 3, 18, 8, 0, 0, 0, time.UTC)},
+		{"0 8 * * 7", time.Date(2024, 3, 17, 8, 0, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 jan *", time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2024, 3, 15, 11, 0, 0, 0, time.UTC)},
+		{"@weekly", time.Date(2024, 3, 17, 0, 0, 0, 0, time.UTC)},
+		{"@MONTHLY", time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 ? * *", time.Date(2024, 3, 16, 0, 0, 0, 0, time.UTC)},
+		// A restricted day of month and day of week match when either does
+		{"0 0 20 * mon", time.Date(2024, 3, 18, 0, 0, 0, 0, time.UTC)},
+		// Never matches within five years
+		{"0 0 30 2 *", time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			sched, err := ParseCron(tt.expr)
+			if err != nil {
+				t.Fatalf("ParseCron(%q): %v", tt.expr, err)
+			}
+			if got := sched.Next(from); !got.Equal(tt.want) {
+				t.Errorf("Next = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCronNextInLocation(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Skipf("time zone data unavailable: %v", err)
+	}
+	sched, err := ParseCron("0 8 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Across the change to summer time on 2024-03-31, runs stay at 8:00
+	from := time.Date(2024, 3, 30, 9, 0, 0, 0, loc)
+	want := time.Date(2024, 3, 31, 8, 0, 0, 0, loc)
+	if got := sched.Next(from); !got.Equal(want) {
+		t.Errorf("Next = %v, want %v", got, want)
+	}
+}
+
+func TestParseCronErrors(t *testing.T) {
+	tests := []struct {
+		expr    string
+		wantErr string
+	}{
+		{"", "must have 5 fields"},
+		{"* * * *", "must have 5 fields"},
+		{"* * * * * *", "must have 5 fields"},
+		{"@reboot", "must have 5 fields"},
+		{"60 * * * *", "out of range 0-59"},
+		{"* 24 * * *", "out of range 0-23"},
+		{"* * 0 * *", "out of range 1-31"},
+		{"* * * 13 *", "out of range 1-12"},
+		{"* * * * 8", "out of range 0-7"},
+		{"*/0 * * * *", "invalid step"},
+		{"*/-1 * * * *", "invalid step"},
+		{"*/x * * * *", "invalid step"},
+		{"10-5 * * * *", "invalid range"},
+		{"1,,2 * * * *", "invalid value"},
+		{"a * * * *", "invalid value"},
+		{"* * * foo *", "invalid value"},
+		{"* * * * mon-", "invalid value"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			_, err := ParseCron(tt.expr)
+			if err == nil {
+				t.Fatalf("ParseCron(%q) succeeded, want error containing %q", tt.expr, tt.wantErr)
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ParseCron(%q) = %v, want error containing %q", tt.expr, err, tt.wantErr)
+			}
+		})
+	}
+}
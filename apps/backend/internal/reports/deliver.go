@@ -0,0 +1,214 @@
+package reports
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/namlabs/obsfly/backend/internal/store"
+)
+
+// SMTPConfig is the mail server reports are sent through
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+// SMTPConfigFromEnv reads SMTP_HOST, SMTP_PORT, SMTP_USERNAME,
+// SMTP_PASSWORD and SMTP_FROM
+func SMTPConfigFromEnv() SMTPConfig {
+	cfg := SMTPConfig{
+		Host:     os.Getenv("SMTP_HOST"),
+		Port:     587,
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From:     os.Getenv("SMTP_FROM"),
+	}
+	if port, err := strconv.Atoi(os.Getenv("SMTP_PORT")); err == nil && port > 0 {
+		cfg.Port = port
+	}
+	if cfg.From == "" {
+		cfg.From = "reports@obsfly.local"
+	}
+	return cfg
+}
+
+// Delivery describes a finished report for its recipients
+type Delivery struct {
+	Report       *store.ReportSchedule
+	Dashboard    *store.Dashboard
+	Artifact     *Artifact
+	From, To     time.Time
+	ScheduledFor time.Time
+}
+
+// Deliverer sends rendered reports by email or webhook
+type Deliverer struct {
+	smtp   SMTPConfig
+	client *http.Client
+}
+
+func NewDeliverer(cfg SMTPConfig) *Deliverer {
+	return &Deliverer{
+		smtp:   cfg,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Deliver sends a report through its configured channel
+func (d *Deliverer) Deliver(ctx context.Context, delivery Delivery) error {
+	switch delivery.Report.Delivery.Type {
+	case store.ReportDeliveryEmail:
+		return d.sendEmail(delivery)
+	case store.ReportDeliveryWebhook:
+		return d.postWebhook(ctx, delivery)
+	}
+	return fmt.Errorf("unknown delivery type %q", delivery.Report.Delivery.Type)
+}
+
+func subject(delivery Delivery) string {
+	if s := delivery.Report.Delivery.Subject; s != "" {
+		return s
+	}
+	return delivery.Report.Name
+}
+
+// ========== EMAIL ==========
+
+func (d *Deliverer) sendEmail(delivery Delivery) error {
+	if d.smtp.Host == "" {
+		return fmt.Errorf("email delivery is not configured, set SMTP_HOST")
+	}
+
+	msg, err := buildEmail(d.smtp.From, delivery)
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if d.smtp.Username != "" {
+		auth = smtp.PlainAuth("", d.smtp.Username, d.smtp.Password, d.smtp.Host)
+	}
+	addr := net.JoinHostPort(d.smtp.Host, strconv.Itoa(d.smtp.Port))
+	if err := smtp.SendMail(addr, auth, d.smtp.From, delivery.Report.Delivery.Recipients, msg); err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+	return nil
+}
+
+// buildEmail writes a multipart message with a short text body and the
+// report attached
+func buildEmail(from string, delivery Delivery) ([]byte, error) {
+	var boundaryBytes [12]byte
+	if _, err := rand.Read(boundaryBytes[:]); err != nil {
+		return nil, err
+	}
+	boundary := "obsfly-" + hex.EncodeToString(boundaryBytes[:])
+	report, artifact := delivery.Report, delivery.Artifact
+
+	var buf bytes.Buffer
+	header := func(key, value string) { fmt.Fprintf(&buf, "%s: %s\r\n", key, value) }
+	header("From", from)
+	header("To", strings.Join(report.Delivery.Recipients, ", "))
+	header("Subject", mime.QEncoding.Encode("utf-8", subject(delivery)))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("MIME-Version", "1.0")
+	header("Content-Type", fmt.Sprintf("multipart/mixed; boundary=%q", boundary))
+	buf.WriteString("\r\n")
+
+	fmt.Fprintf(&buf, "--%s\r\n", boundary)
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&buf, "%s\r\n\r\nDashboard: %s\r\nTime range: %s - %s\r\n",
+		report.Name, delivery.Dashboard.Name,
+		delivery.From.UTC().Format(time.RFC3339), delivery.To.UTC().Format(time.RFC3339))
+
+	fmt.Fprintf(&buf, "--%s\r\n", boundary)
+	fmt.Fprintf(&buf, "Content-Type: %s\r\n", artifact.ContentType)
+	buf.WriteString("Content-Transfer-Encoding: base64\r\n")
+	fmt.Fprintf(&buf, "Content-Disposition: attachment; filename=%q\r\n\r\n", artifact.Filename)
+
+	encoded := base64.StdEncoding.EncodeToString(artifact.Data)
+	for len(encoded) > 76 {
+		buf.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	buf.WriteString(encoded + "\r\n")
+	fmt.Fprintf(&buf, "--%s--\r\n", boundary)
+	return buf.Bytes(), nil
+}
+
+// ========== WEBHOOK ==========
+
+// webhookPayload is posted to report webhooks, with the file base64-encoded
+type webhookPayload struct {
+	ReportId     string    `json:"report_id"`
+	Name         string    `json:"name"`
+	Subject      string    `json:"subject"`
+	DashboardId  string    `json:"dashboard_id"`
+	Dashboard    string    `json:"dashboard"`
+	ScheduledFor time.Time `json:"scheduled_for"`
+	From         time.Time `json:"from"`
+	To           time.Time `json:"to"`
+	Format       string    `json:"format"`
+	Filename     string    `json:"filename"`
+	ContentType  string    `json:"content_type"`
+	Content      string    `json:"content"`
+}
+
+func (d *Deliverer) postWebhook(ctx context.Context, delivery Delivery) error {
+	report := delivery.Report
+	body, err := json.Marshal(webhookPayload{
+		ReportId:     report.ReportId,
+		Name:         report.Name,
+		Subject:      subject(delivery),
+		DashboardId:  report.DashboardId,
+		Dashboard:    delivery.Dashboard.Name,
+		ScheduledFor: delivery.ScheduledFor,
+		From:         delivery.From,
+		To:           delivery.To,
+		Format:       report.Format,
+		Filename:     delivery.Artifact.Filename,
+		ContentType:  delivery.Artifact.ContentType,
+		Content:      base64.StdEncoding.EncodeToString(delivery.Artifact.Data),
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, report.Delivery.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("invalid webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range report.Delivery.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("webhook returned %s: %s", resp.Status, strings.TrimSpace(string(snippet)))
+	}
+	return nil
+}
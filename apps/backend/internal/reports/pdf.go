@@ -0,0 +1,133 @@
+package reports
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image"
+	"time"
+)
+
+// A4 landscape in points
+const (
+	pdfPageWidth  = 842.0
+	pdfPageHeight = 595.0
+	pdfMargin     = 24.0
+)
+
+// writePDF assembles page images into a PDF document with one image per
+// page, scaled to fit an A4 landscape page
+func writePDF(title string, pages []*image.RGBA, created time.Time) ([]byte, error) {
+	var buf bytes.Buffer
+	var offsets []int
+
+	// Objects are numbered from 1 in the order they are written: the
+	// catalog, the page tree, the document info, then a page, its content
+	// stream and its image for every page
+	startObject := func() int {
+		offsets = append(offsets, buf.Len())
+		n := len(offsets)
+		fmt.Fprintf(&buf, "%d 0 obj\n", n)
+		return n
+	}
+	endObject := func() { buf.WriteString("endobj\n") }
+
+	buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	startObject()
+	buf.WriteString("<< /Type /Catalog /Pages 2 0 R >>\n")
+	endObject()
+
+	startObject()
+	buf.WriteString("<< /Type /Pages /Kids [")
+	for i := range pages {
+		fmt.Fprintf(&buf, " %d 0 R", 4+3*i)
+	}
+	fmt.Fprintf(&buf, " ] /Count %d >>\n", len(pages))
+	endObject()
+
+	startObject()
+	fmt.Fprintf(&buf, "<< /Title %s /Producer (ObsFly) /CreationDate (D:%s) >>\n",
+		pdfString(title), created.UTC().Format("20060102150405Z"))
+	endObject()
+
+	for i, img := range pages {
+		contentObj, imageObj := 5+3*i, 6+3*i
+
+		// Fit the image inside the margins, keeping its aspect ratio
+		bounds := img.Bounds()
+		scale := min((pdfPageWidth-2*pdfMargin)/float64(bounds.Dx()), (pdfPageHeight-2*pdfMargin)/float64(bounds.Dy()))
+		w, h := float64(bounds.Dx())*scale, float64(bounds.Dy())*scale
+		x, y := (pdfPageWidth-w)/2, pdfPageHeight-pdfMargin-h
+
+		startObject()
+		fmt.Fprintf(&buf, "<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] /Resources << /XObject << /Im%d %d 0 R >> >> /Contents %d 0 R >>\n",
+			pdfPageWidth, pdfPageHeight, i, imageObj, contentObj)
+		endObject()
+
+		content := fmt.Sprintf("q %.2f 0 0 %.2f %.2f %.2f cm /Im%d Do Q\n", w, h, x, y, i)
+		startObject()
+		fmt.Fprintf(&buf, "<< /Length %d >>\nstream\n%s\nendstream\n", len(content), content)
+		endObject()
+
+		pixels, err := deflateRGB(img)
+		if err != nil {
+			return nil, err
+		}
+		startObject()
+		fmt.Fprintf(&buf, "<< /Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceRGB /BitsPerComponent 8 /Filter /FlateDecode /Length %d >>\nstream\n",
+			bounds.Dx(), bounds.Dy(), len(pixels))
+		buf.Write(pixels)
+		buf.WriteString("\nendstream\n")
+		endObject()
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R /Info 3 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	return buf.Bytes(), nil
+}
+
+// deflateRGB compresses the pixels of an opaque image as packed RGB
+func deflateRGB(img *image.RGBA) ([]byte, error) {
+	var out bytes.Buffer
+	zw := zlib.NewWriter(&out)
+	b := img.Bounds()
+	row := make([]byte, 3*b.Dx())
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		src := img.Pix[img.PixOffset(b.Min.X, y):]
+		for x := 0; x < b.Dx(); x++ {
+			copy(row[3*x:3*x+3], src[4*x:4*x+3])
+		}
+		if _, err := zw.Write(row); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// pdfString encodes text as a PDF literal string, replacing characters
+// outside printable ASCII
+func pdfString(s string) string {
+	var b bytes.Buffer
+	b.WriteByte('(')
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < 32 || r > 126:
+			b.WriteByte('?')
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte(')')
+	return b.String()
+}
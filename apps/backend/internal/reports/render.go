@@ -0,0 +1,89 @@
+package reports
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"time"
+
+	"github.com/namlabs/obsfly/backend/internal/store"
+)
+
+// panelsPerPDFPage keeps PDF pages legible: two rows of two panels
+const panelsPerPDFPage = 4
+
+// Artifact is a rendered report file
+type Artifact struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+// Render draws the widgets of a rendered dashboard into a report. PNG and
+// SVG reports are a single image; PDF reports put four widgets on a page.
+func Render(dashboard *store.Dashboard, data *store.DashboardData, format string, loc *time.Location) (*Artifact, error) {
+	if loc == nil {
+		loc = time.UTC
+	}
+	all := panels(dashboard, data)
+	title := dashboard.Name
+	subtitle := fmt.Sprintf("%s - %s (%s)",
+		data.From.In(loc).Format("Jan 02, 2006 15:04"), data.To.In(loc).Format("Jan 02, 2006 15:04"), loc)
+	base := fmt.Sprintf("%s-%s", fileSlug(dashboard), data.To.In(loc).Format("2006-01-02"))
+
+	single := &page{title: title, subtitle: subtitle, panels: all, location: loc}
+
+	switch format {
+	case store.ReportFormatSVG:
+		c := newSVGCanvas(pageWidth, single.height())
+		single.draw(c, single.height())
+		return &Artifact{Filename: base + ".svg", ContentType: "image/svg+xml", Data: c.bytes()}, nil
+
+	case store.ReportFormatPNG:
+		c := newRasterCanvas(pageWidth, single.height())
+		single.draw(c, single.height())
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, c.img); err != nil {
+			return nil, fmt.Errorf("failed to encode PNG: %w", err)
+		}
+		return &Artifact{Filename: base + ".png", ContentType: "image/png", Data: buf.Bytes()}, nil
+
+	case store.ReportFormatPDF:
+		var pages []*page
+		for start := 0; start < len(all) || start == 0; start += panelsPerPDFPage {
+			end := min(start+panelsPerPDFPage, len(all))
+			p := &page{title: title, subtitle: subtitle, panels: all[start:end], location: loc}
+			if len(pages) > 0 {
+				p.subtitle = fmt.Sprintf("%s, page %d", subtitle, len(pages)+1)
+			}
+			pages = append(pages, p)
+		}
+
+		// Every page has the height of a full page so widgets keep their size
+		height := (&page{panels: make([]panel, panelsPerPDFPage)}).height()
+		images := make([]*image.RGBA, len(pages))
+		for i, p := range pages {
+			c := newRasterCanvas(pageWidth, height)
+			p.draw(c, height)
+			images[i] = c.img
+		}
+		out, err := writePDF(title, images, data.To)
+		if err != nil {
+			return nil, fmt.Errorf("failed to assemble PDF: %w", err)
+		}
+		return &Artifact{Filename: base + ".pdf", ContentType: "application/pdf", Data: out}, nil
+	}
+	return nil, fmt.Errorf("unknown report format %q", format)
+}
+
+// fileSlug names report files after the dashboard
+func fileSlug(d *store.Dashboard) string {
+	if d.Slug != "" {
+		return d.Slug
+	}
+	if slug := store.Slugify(d.Name); slug != "" {
+		return slug
+	}
+	return "dashboard"
+}
@@ -0,0 +1,224 @@
+package reports
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/namlabs/obsfly/backend/internal/store"
+)
+
+// maxMissedRuns bounds how far a report that missed runs, e.g. while the
+// server was down, skips ahead to its latest due run
+const maxMissedRuns = 100000
+
+// Validate checks a report schedule, including its cron expression
+func Validate(report *store.ReportSchedule) error {
+	if err := report.Validate(); err != nil {
+		return err
+	}
+	sched, err := ParseCron(report.Cron)
+	if err != nil {
+		return err
+	}
+	if sched.Next(time.Now()).IsZero() {
+		return fmt.Errorf("cron expression %q never matches", report.Cron)
+	}
+	return nil
+}
+
+// NextRun returns the first scheduled run of a report after t, or the zero
+// time if the schedule is invalid
+func NextRun(report *store.ReportSchedule, t time.Time) time.Time {
+	sched, err := ParseCron(report.Cron)
+	if err != nil {
+		return time.Time{}
+	}
+	loc, err := time.LoadLocation(report.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	return sched.Next(t.In(loc))
+}
+
+// Runner renders reports and delivers them
+type Runner struct {
+	store     *store.Store
+	deliverer *Deliverer
+}
+
+func NewRunner(st *store.Store, deliverer *Deliverer) *Runner {
+	return &Runner{store: st, deliverer: deliverer}
+}
+
+// Render renders the report's dashboard for the window ending at `at`
+// without delivering it
+func (r *Runner) Render(ctx context.Context, report *store.ReportSchedule, at time.Time) (*Artifact, *store.Dashboard, *store.DashboardData, error) {
+	dashboard, err := r.store.GetDashboard(ctx, report.AccountId, report.DashboardId)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	data, err := r.store.RenderDashboard(ctx, dashboard, store.DashboardDataRequest{
+		AccountId: report.AccountId,
+		TimeRange: report.TimeRange,
+		Interval:  report.Interval,
+		To:        at,
+		Variables: report.Variables,
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	loc, err := time.LoadLocation(report.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	artifact, err := Render(dashboard, data, report.Format, loc)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return artifact, dashboard, data, nil
+}
+
+// Run renders and delivers a report and records the run in its history
+func (r *Runner) Run(ctx context.Context, report *store.ReportSchedule, trigger string, scheduledFor time.Time) store.ReportRun {
+	start := time.Now()
+	run := store.ReportRun{
+		RunId:        uuid.NewString(),
+		ReportId:     report.ReportId,
+		AccountId:    report.AccountId,
+		Trigger:      trigger,
+		ScheduledFor: scheduledFor,
+		StartedAt:    start,
+		Format:       report.Format,
+		DeliveryType: report.Delivery.Type,
+	}
+
+	err := func() error {
+		artifact, dashboard, data, err := r.Render(ctx, report, scheduledFor)
+		if err != nil {
+			return err
+		}
+		run.ArtifactBytes = uint64(len(artifact.Data))
+		for _, w := range data.Widgets {
+			if w.Error != "" {
+				run.WidgetErrors++
+			}
+		}
+		return r.deliverer.Deliver(ctx, Delivery{
+			Report:       report,
+			Dashboard:    dashboard,
+			Artifact:     artifact,
+			From:         data.From,
+			To:           data.To,
+			ScheduledFor: scheduledFor,
+		})
+	}()
+
+	run.DurationMs = uint32(time.Since(start).Milliseconds())
+	run.Success = err == nil
+	if err != nil {
+		run.Error = err.Error()
+	}
+	if err := r.store.InsertReportRun(ctx, run); err != nil {
+		fmt.Printf("Error recording run of report %s: %v\n", report.ReportId, err)
+	}
+	return run
+}
+
+// Scheduler runs reports when their cron schedule is due.
+type Scheduler struct {
+	store  *store.Store
+	runner *Runner
+	tick   time.Duration
+	last   map[string]time.Time // report ID -> last scheduled run handled
+}
+
+func NewScheduler(st *store.Store, runner *Runner, tick time.Duration) *Scheduler {
+	return &Scheduler{
+		store:  st,
+		runner: runner,
+		tick:   tick,
+	}
+}
+
+func (s *Scheduler) Start(ctx context.Context) {
+	ticker := time.NewTicker(s.tick)
+	defer ticker.Stop()
+
+	fmt.Printf("Starting report scheduler (tick %s)\n", s.tick)
+
+	for {
+		select {
+		case <-ctx.Done():
+			fmt.Println("Report scheduler shutting down")
+			return
+		case <-ticker.C:
+			if err := s.runDue(ctx); err != nil {
+				fmt.Printf("Error running scheduled reports: %v\n", err)
+			}
+		}
+	}
+}
+
+// runDue runs every report whose next run is due. A report that missed
+// several runs runs once, for the latest of them; failed runs are not
+// retried and show up in the run history instead.
+func (s *Scheduler) runDue(ctx context.Context) error {
+	if s.last == nil {
+		statuses, err := s.store.GetReportStatuses(ctx, 0)
+		if err != nil {
+			return fmt.Errorf("failed to load report history: %w", err)
+		}
+		s.last = make(map[string]time.Time, len(statuses))
+		for reportId, st := range statuses {
+			s.last[reportId] = st.LastScheduledFor
+		}
+	}
+
+	reports, err := s.store.ListActiveReportSchedules(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for i := range reports {
+		report := &reports[i]
+
+		// Schedules count from their last run or their last change, so an
+		// edited or resumed report does not run for times before the edit
+		base := s.last[report.ReportId]
+		if base.Before(report.UpdatedAt) {
+			base = report.UpdatedAt
+		}
+		sched, err := ParseCron(report.Cron)
+		if err != nil {
+			fmt.Printf("Skipping report %s: %v\n", report.ReportId, err)
+			continue
+		}
+		loc, err := time.LoadLocation(report.Timezone)
+		if err != nil {
+			loc = time.UTC
+		}
+
+		due := sched.Next(base.In(loc))
+		if due.IsZero() || due.After(now) {
+			continue
+		}
+		for i := 0; i < maxMissedRuns; i++ {
+			next := sched.Next(due)
+			if next.IsZero() || next.After(now) {
+				break
+			}
+			due = next
+		}
+
+		run := s.runner.Run(ctx, report, store.ReportTriggerSchedule, due)
+		if !run.Success {
+			fmt.Printf("Report %s (%s) failed: %s\n", report.Name, report.ReportId, run.Error)
+		}
+		s.last[report.ReportId] = due
+	}
+	return nil
+}
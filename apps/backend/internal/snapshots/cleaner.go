@@ -0,0 +1,46 @@
+package snapshots
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/namlabs/obsfly/backend/internal/store"
+)
+
+// Cleaner periodically deletes dashboard snapshots past their expiry.
+type Cleaner struct {
+	store *store.Store
+	tick  time.Duration
+}
+
+func NewCleaner(st *store.Store, tick time.Duration) *Cleaner {
+	return &Cleaner{
+		store: st,
+		tick:  tick,
+	}
+}
+
+func (c *Cleaner) Start(ctx context.Context) {
+	ticker := time.NewTicker(c.tick)
+	defer ticker.Stop()
+
+	fmt.Printf("Starting snapshot cleaner (tick %s)\n", c.tick)
+
+	for {
+		select {
+		case <-ctx.Done():
+			fmt.Println("Snapshot cleaner shutting down")
+			return
+		case <-ticker.C:
+			deleted, err := c.store.DeleteExpiredSnapshots(ctx)
+			if err != nil {
+				fmt.Printf("Error deleting expired snapshots: %v\n", err)
+				continue
+			}
+			if deleted > 0 {
+				fmt.Printf("Deleted %d expired dashboard snapshots\n", deleted)
+			}
+		}
+	}
+}
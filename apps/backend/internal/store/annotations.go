@@ -0,0 +1,384 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Annotation types
+const (
+	AnnotationDeploy       = "deploy"
+	AnnotationConfigChange = "config_change"
+	AnnotationIncident     = "incident"
+	AnnotationNote         = "note"
+	AnnotationAlert        = "alert"
+)
+
+// Annotation sources
+const (
+	AnnotationSourceAPI      = "api"
+	AnnotationSourceVersions = "version_detector"
+	AnnotationSourceAlerts   = "alerts"
+	AnnotationSourceLogs     = "log_anomalies"
+)
+
+var knownAnnotationTypes = map[string]bool{
+	AnnotationDeploy: true, AnnotationConfigChange: true, AnnotationIncident: true,
+	AnnotationNote: true, AnnotationAlert: true,
+}
+
+// annotationNamespace seeds deterministic IDs of generated annotations
+var annotationNamespace = uuid.MustParse("6f1c5b8e-3f0a-4d8e-9a57-2b1f0c9d4e21")
+
+// Annotation marks an event or a period on time series graphs, e.g. a
+// deploy, a config change, an incident or a free-form note. Point events
+// have EndTime equal to StartTime.
+type Annotation struct {
+	AnnotationId string            `json:"annotation_id"`
+	AccountId    uint64            `json:"account_id"`
+	Type         string            `json:"type"` // deploy, config_change, incident, note, alert
+	Title        string            `json:"title"`
+	Text         string            `json:"text"`
+	Tags         []string          `json:"tags"`
+	ServiceName  string            `json:"service_name"` // empty applies to every service
+	HostName     string            `json:"host_name"`    // empty applies to every host
+	StartTime    time.Time         `json:"start_time"`
+	EndTime      time.Time         `json:"end_time"`
+	Source       string            `json:"source"` // api, version_detector, alerts, log_anomalies
+	Attributes   map[string]string `json:"attributes"`
+	CreatedAt    time.Time         `json:"created_at"`
+}
+
+// AnnotationFilter selects annotations overlapping [From, To]
+type AnnotationFilter struct {
+	AccountId uint64
+	From      time.Time
+	To        time.Time
+	Services  []string // match these services or annotations without a service
+	Hosts     []string // match these hosts or annotations without a host
+	Types     []string
+	Tags      []string // match any of the tags
+	Limit     int
+}
+
+// AlertTransition is an alert changing state, e.g. from an Alertmanager
+// webhook. Each alert instance (fingerprint and start) maps to a single
+// annotation that is closed when the alert resolves.
+type AlertTransition struct {
+	AccountId   uint64
+	AlertName   string
+	Fingerprint string
+	Status      string // firing, resolved
+	Severity    string
+	ServiceName string
+	HostName    string
+	Summary     string
+	StartsAt    time.Time
+	EndsAt      time.Time
+	Labels      map[string]string
+}
+
+// Validate checks a user-supplied annotation and fills in defaults
+func (a *Annotation) Validate() error {
+	if a.Type == "" {
+		a.Type = AnnotationNote
+	}
+	if !knownAnnotationTypes[a.Type] {
+		return fmt.Errorf("unknown annotation type %q", a.Type)
+	}
+	if strings.TrimSpace(a.Title) == "" {
+		return fmt.Errorf("title is required")
+	}
+	if a.StartTime.IsZero() {
+		a.StartTime = time.Now()
+	}
+	if a.EndTime.IsZero() {
+		a.EndTime = a.StartTime
+	}
+	if a.EndTime.Before(a.StartTime) {
+		return fmt.Errorf("end_time must not be before start_time")
+	}
+	return nil
+}
+
+const annotationColumns = `AnnotationId, AccountId, Type, Title, Text, Tags, ServiceName, HostName, StartTime, EndTime, Source, Attributes, CreatedAt`
+
+// SaveAnnotation inserts an annotation, or replaces the one with the same ID
+func (s *Store) SaveAnnotation(ctx context.Context, a *Annotation) error {
+	if a.AnnotationId == "" {
+		a.AnnotationId = uuid.NewString()
+	}
+	if a.Tags == nil {
+		a.Tags = []string{}
+	}
+	if a.Attributes == nil {
+		a.Attributes = map[string]string{}
+	}
+	a.CreatedAt = time.Now()
+
+	query := fmt.Sprintf(`
+		INSERT INTO metrics.annotations
+		(%s)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, annotationColumns)
+
+	err := s.conn.Exec(ctx, query,
+		a.AnnotationId,
+		a.AccountId,
+		a.Type,
+		a.Title,
+		a.Text,
+		a.Tags,
+		a.ServiceName,
+		a.HostName,
+		a.StartTime,
+		a.EndTime,
+		a.Source,
+		a.Attributes,
+		a.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save annotation: %w", err)
+	}
+	return nil
+}
+
+// ListAnnotations returns the annotations overlapping the filter's time
+// range, newest first
+func (s *Store) ListAnnotations(ctx context.Context, f AnnotationFilter) ([]Annotation, error) {
+	conditions := []string{"AccountId = ?", "StartTime <= ?", "EndTime >= ?"}
+	args := []interface{}{f.AccountId, f.To, f.From}
+
+	if len(f.Services) > 0 {
+		conditions = append(conditions, "(ServiceName = '' OR has(?, ServiceName))")
+		args = append(args, f.Services)
+	}
+	if len(f.Hosts) > 0 {
+		conditions = append(conditions, "(HostName = '' OR has(?, HostName))")
+		args = append(args, f.Hosts)
+	}
+	if len(f.Types) > 0 {
+		conditions = append(conditions, "has(?, Type)")
+		args = append(args, f.Types)
+	}
+	if len(f.Tags) > 0 {
+		conditions = append(conditions, "hasAny(Tags, ?)")
+		args = append(args, f.Tags)
+	}
+
+	limit := f.Limit
+	if limit <= 0 || limit > 1000 {
+		limit = 500
+	}
+	args = append(args, limit)
+
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM metrics.annotations FINAL
+		WHERE %s
+		ORDER BY StartTime DESC
+		LIMIT ?
+	`, annotationColumns, strings.Join(conditions, " AND "))
+
+	rows, err := s.conn.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list annotations: %w", err)
+	}
+	defer rows.Close()
+
+	annotations := []Annotation{}
+	for rows.Next() {
+		var a Annotation
+		if err := rows.Scan(
+			&a.AnnotationId,
+			&a.AccountId,
+			&a.Type,
+			&a.Title,
+			&a.Text,
+			&a.Tags,
+			&a.ServiceName,
+			&a.HostName,
+			&a.StartTime,
+			&a.EndTime,
+			&a.Source,
+			&a.Attributes,
+			&a.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		annotations = append(annotations, a)
+	}
+	return annotations, nil
+}
+
+// DeleteAnnotation deletes an annotation
+func (s *Store) DeleteAnnotation(ctx context.Context, accountId uint64, annotationId string) error {
+	query := `
+		ALTER TABLE metrics.annotations
+		DELETE WHERE AccountId = ? AND AnnotationId = ?
+	`
+	if err := s.conn.Exec(ctx, query, accountId, annotationId); err != nil {
+		return fmt.Errorf("failed to delete annotation: %w", err)
+	}
+	return nil
+}
+
+// GetNodeAnnotations returns the annotations relevant to a node: those for
+// the node itself and for the services that ran on it in [from, to]
+func (s *Store) GetNodeAnnotations(ctx context.Context, accountId uint64, hostId string, from, to time.Time) ([]Annotation, error) {
+	query := `
+		SELECT groupUniqArray(ServiceName), groupUniqArray(HostName)
+		FROM metrics.metrics_v1
+		WHERE AccountId = ?
+		  AND HostId = ?
+		  AND Timestamp >= ? AND Timestamp <= ?
+	`
+	var services, hosts []string
+	if err := s.conn.QueryRow(ctx, query, accountId, hostId, from, to).Scan(&services, &hosts); err != nil {
+		return nil, fmt.Errorf("failed to get node services: %w", err)
+	}
+
+	// The empty entries keep the filters non-empty, so a node without
+	// services only gets the annotations that apply everywhere
+	return s.ListAnnotations(ctx, AnnotationFilter{
+		AccountId: accountId,
+		From:      from,
+		To:        to,
+		Services:  append(nonEmpty(services), ""),
+		Hosts:     append(nonEmpty(hosts), hostId),
+	})
+}
+
+func nonEmpty(values []string) []string {
+	kept := make([]string, 0, len(values))
+	for _, v := range values {
+		if v != "" {
+			kept = append(kept, v)
+		}
+	}
+	return kept
+}
+
+// RecordAlertTransition creates the annotation of a firing alert, or closes
+// it when the alert resolves
+func (s *Store) RecordAlertTransition(ctx context.Context, t AlertTransition) error {
+	if t.StartsAt.IsZero() {
+		t.StartsAt = time.Now()
+	}
+	key := fmt.Sprintf("alert/%d/%s/%s/%d", t.AccountId, t.AlertName, t.Fingerprint, t.StartsAt.UnixMilli())
+
+	a := &Annotation{
+		AnnotationId: uuid.NewSHA1(annotationNamespace, []byte(key)).String(),
+		AccountId:    t.AccountId,
+		Type:         AnnotationAlert,
+		Title:        fmt.Sprintf("%s %s", t.AlertName, t.Status),
+		Text:         t.Summary,
+		Tags:         []string{"alert", t.Status},
+		ServiceName:  t.ServiceName,
+		HostName:     t.HostName,
+		StartTime:    t.StartsAt,
+		EndTime:      t.StartsAt,
+		Source:       AnnotationSourceAlerts,
+		Attributes:   t.Labels,
+	}
+	if t.Severity != "" {
+		a.Tags = append(a.Tags, t.Severity)
+	}
+	if t.Status == "resolved" && t.EndsAt.After(t.StartsAt) {
+		a.EndTime = t.EndsAt
+	}
+	return s.SaveAnnotation(ctx, a)
+}
+
+// DetectVersionChanges finds services whose ServiceVersion changed within
+// the lookback window and returns one deploy annotation per new version.
+// The annotation spans the rollout, from the first to the last host that
+// reported the new version.
+func (s *Store) DetectVersionChanges(ctx context.Context, lookback time.Duration) ([]Annotation, error) {
+	query := `
+		SELECT
+			AccountId,
+			ServiceName,
+			ServiceVersion,
+			min(first_seen) AS rollout_start,
+			max(first_seen) AS rollout_end,
+			groupArray(10)(HostName) AS hosts,
+			count() AS host_count
+		FROM (
+			SELECT AccountId, ServiceName, ServiceVersion, HostName, min(Timestamp) AS first_seen
+			FROM metrics.metrics_v1
+			WHERE Timestamp > now() - INTERVAL ? SECOND
+			  AND ServiceName != ''
+			  AND ServiceVersion != ''
+			GROUP BY AccountId, ServiceName, ServiceVersion, HostName
+		)
+		GROUP BY AccountId, ServiceName, ServiceVersion
+		ORDER BY AccountId, ServiceName, rollout_start
+	`
+	rows, err := s.conn.Query(ctx, query, int(lookback.Seconds()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to detect version changes: %w", err)
+	}
+	defer rows.Close()
+
+	type serviceVersion struct {
+		accountId  uint64
+		service    string
+		version    string
+		start, end time.Time
+		hosts      []string
+		hostCount  uint64
+	}
+	var versions []serviceVersion
+	for rows.Next() {
+		var v serviceVersion
+		if err := rows.Scan(&v.accountId, &v.service, &v.version, &v.start, &v.end, &v.hosts, &v.hostCount); err != nil {
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+
+	// Within a service, every version after the first replaced the one
+	// seen before it. Versions first seen at the start of the window may
+	// have been running for longer and are not reported as deploys.
+	windowStart := time.Now().Add(-lookback + time.Minute)
+	var annotations []Annotation
+	for i := 1; i < len(versions); i++ {
+		prev, cur := versions[i-1], versions[i]
+		if prev.accountId != cur.accountId || prev.service != cur.service || !cur.start.After(windowStart) {
+			continue
+		}
+
+		sort.Strings(cur.hosts)
+		host := ""
+		if cur.hostCount == 1 && len(cur.hosts) == 1 {
+			host = cur.hosts[0]
+		}
+		key := fmt.Sprintf("deploy/%d/%s/%s/%s", cur.accountId, cur.service, prev.version, cur.version)
+
+		annotations = append(annotations, Annotation{
+			AnnotationId: uuid.NewSHA1(annotationNamespace, []byte(key)).String(),
+			AccountId:    cur.accountId,
+			Type:         AnnotationDeploy,
+			Title:        fmt.Sprintf("%s deployed %s", cur.service, cur.version),
+			Text:         fmt.Sprintf("%s changed from %s to %s on %d host(s)", cur.service, prev.version, cur.version, cur.hostCount),
+			Tags:         []string{"deploy", "version"},
+			ServiceName:  cur.service,
+			HostName:     host,
+			StartTime:    cur.start,
+			EndTime:      cur.end,
+			Source:       AnnotationSourceVersions,
+			Attributes: map[string]string{
+				"previous_version": prev.version,
+				"version":          cur.version,
+				"hosts":            strings.Join(cur.hosts, ","),
+			},
+		})
+	}
+	return annotations, nil
+}
@@ -7,6 +7,7 @@ import (
 
 	"github.com/ClickHouse/clickhouse-go/v2"
 	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+	"github.com/google/uuid"
 )
 
 type Store struct {
@@ -108,18 +109,340 @@ func NewStore(addr string, db string, user string, password string) (*Store, err
 		Description        String CODEC(ZSTD(1)),
 		Config             String CODEC(ZSTD(1)),
 		CreatedAt          DateTime64(3) CODEC(Delta, ZSTD(1)),
-		UpdatedAt          DateTime64(3) CODEC(Delta, ZSTD(1))
+		UpdatedAt          DateTime64(3) CODEC(Delta, ZSTD(1)),
+		Version            UInt32 DEFAULT 1,
+		Author             String CODEC(ZSTD(1)),
+		ChangeMessage      String CODEC(ZSTD(1)),
+		Slug               String CODEC(ZSTD(1)),
+		Source             LowCardinality(String),
+		SourcePath         String CODEC(ZSTD(1)),
+		FolderId           String CODEC(ZSTD(1)),
+		Tags               Array(LowCardinality(String)) CODEC(ZSTD(1)),
+		OwnerId            UInt64 CODEC(ZSTD(1)),
+		SaveId             String CODEC(ZSTD(1))
 	)
 	ENGINE = MergeTree
 	PARTITION BY AccountId
 	ORDER BY (AccountId, DashboardId)
-	SETTINGS index_granularity = 8192;
+	SETTINGS index_granularity = 8192, non_replicated_deduplication_window = 1000;
 	`
 	err = conn.Exec(context.Background(), dashboardSchema)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create dashboards table: %w", err)
 	}
 
+	// Add version history, sync and organization columns to dashboards tables created before them
+	dashboardMigration := `
+	ALTER TABLE metrics.dashboards
+		ADD COLUMN IF NOT EXISTS Version UInt32 DEFAULT 1,
+		ADD COLUMN IF NOT EXISTS Author String CODEC(ZSTD(1)),
+		ADD COLUMN IF NOT EXISTS ChangeMessage String CODEC(ZSTD(1)),
+		ADD COLUMN IF NOT EXISTS Slug String CODEC(ZSTD(1)),
+		ADD COLUMN IF NOT EXISTS Source LowCardinality(String),
+		ADD COLUMN IF NOT EXISTS SourcePath String CODEC(ZSTD(1)),
+		ADD COLUMN IF NOT EXISTS FolderId String CODEC(ZSTD(1)),
+		ADD COLUMN IF NOT EXISTS Tags Array(LowCardinality(String)) CODEC(ZSTD(1)),
+		ADD COLUMN IF NOT EXISTS OwnerId UInt64 CODEC(ZSTD(1)),
+		ADD COLUMN IF NOT EXISTS SaveId String CODEC(ZSTD(1))
+	`
+	err = conn.Exec(context.Background(), dashboardMigration)
+	if err != nil {
+		return nil, fmt.Errorf("failed to migrate dashboards table: %w", err)
+	}
+
+	// Saves claim their version through insert deduplication, which
+	// non-replicated tables only do within this window of inserts, on the
+	// server that took them
+	err = conn.Exec(context.Background(), `ALTER TABLE metrics.dashboards MODIFY SETTING non_replicated_deduplication_window = 1000`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to migrate dashboards table: %w", err)
+	}
+
+	// Create Recording Rules Tables
+	recordingRulesSchema := `
+	CREATE TABLE IF NOT EXISTS metrics.recording_rules
+	(
+		RuleId             UUID CODEC(ZSTD(1)),
+		AccountId          UInt64 CODEC(ZSTD(1)),
+		Name               String CODEC(ZSTD(1)),
+		Record             LowCardinality(String) CODEC(ZSTD(1)),
+		Queries            String CODEC(ZSTD(1)),
+		Interval           LowCardinality(String),
+		Labels             Map(String, String) CODEC(ZSTD(1)),
+		Paused             UInt8 DEFAULT 0,
+		CreatedAt          DateTime64(3) CODEC(Delta, ZSTD(1)),
+		UpdatedAt          DateTime64(3) CODEC(Delta, ZSTD(1))
+	)
+	ENGINE = ReplacingMergeTree(UpdatedAt)
+	ORDER BY (AccountId, RuleId)
+	SETTINGS index_granularity = 8192;
+	`
+	err = conn.Exec(context.Background(), recordingRulesSchema)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create recording rules table: %w", err)
+	}
+
+	recordingEvaluationsSchema := `
+	CREATE TABLE IF NOT EXISTS metrics.recording_rule_evaluations
+	(
+		RuleId             UUID CODEC(ZSTD(1)),
+		AccountId          UInt64 CODEC(ZSTD(1)),
+		EvaluatedAt        DateTime64(3) CODEC(Delta, ZSTD(1)),
+		WindowStart        DateTime64(3) CODEC(Delta, ZSTD(1)),
+		WindowEnd          DateTime64(3) CODEC(Delta, ZSTD(1)),
+		DurationMs         UInt32 CODEC(ZSTD(1)),
+		SamplesWritten     UInt64 CODEC(ZSTD(1)),
+		Success            UInt8,
+		Error              String CODEC(ZSTD(1))
+	)
+	ENGINE = MergeTree
+	PARTITION BY toDate(EvaluatedAt)
+	ORDER BY (AccountId, RuleId, EvaluatedAt)
+	TTL toDateTime(EvaluatedAt) + INTERVAL 30 DAY
+	SETTINGS index_granularity = 8192, ttl_only_drop_parts = 1;
+	`
+	err = conn.Exec(context.Background(), recordingEvaluationsSchema)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create recording rule evaluations table: %w", err)
+	}
+
+	// Create Log Metric Tables
+	logMetricsSchema := `
+	CREATE TABLE IF NOT EXISTS metrics.log_metrics
+	(
+		MetricId           String,
+		AccountId          UInt64 CODEC(ZSTD(1)),
+		Name               LowCardinality(String) CODEC(ZSTD(1)),
+		Description        String CODEC(ZSTD(1)),
+		Definition         String CODEC(ZSTD(1)),
+		Interval           LowCardinality(String),
+		RetentionDays      UInt16,
+		Paused             Bool,
+		CreatedAt          DateTime64(3) CODEC(Delta, ZSTD(1)),
+		UpdatedAt          DateTime64(3) CODEC(Delta, ZSTD(1))
+	)
+	ENGINE = ReplacingMergeTree(UpdatedAt)
+	ORDER BY (AccountId, MetricId)
+	SETTINGS index_granularity = 8192;
+	`
+	err = conn.Exec(context.Background(), logMetricsSchema)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create log metrics table: %w", err)
+	}
+
+	logMetricStateSchema := `
+	CREATE TABLE IF NOT EXISTS metrics.log_metric_state
+	(
+		MetricId           String,
+		AccountId          UInt64 CODEC(ZSTD(1)),
+		WindowEnd          DateTime64(3) CODEC(Delta, ZSTD(1)),
+		EvaluatedAt        DateTime64(3) CODEC(Delta, ZSTD(1)),
+		SamplesWritten     UInt64 CODEC(ZSTD(1)),
+		LastError          String CODEC(ZSTD(1))
+	)
+	ENGINE = ReplacingMergeTree(EvaluatedAt)
+	ORDER BY (AccountId, MetricId)
+	SETTINGS index_granularity = 8192;
+	`
+	err = conn.Exec(context.Background(), logMetricStateSchema)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create log metric state table: %w", err)
+	}
+
+	// Create Report Tables
+	reportSchedulesSchema := `
+	CREATE TABLE IF NOT EXISTS metrics.report_schedules
+	(
+		ReportId           UUID CODEC(ZSTD(1)),
+		AccountId          UInt64 CODEC(ZSTD(1)),
+		DashboardId        UUID CODEC(ZSTD(1)),
+		Name               String CODEC(ZSTD(1)),
+		Cron               String CODEC(ZSTD(1)),
+		Timezone           LowCardinality(String),
+		TimeRange          LowCardinality(String),
+		Interval           LowCardinality(String),
+		Variables          String CODEC(ZSTD(1)),
+		Format             LowCardinality(String),
+		Delivery           String CODEC(ZSTD(1)),
+		Paused             UInt8 DEFAULT 0,
+		CreatedBy          String CODEC(ZSTD(1)),
+		CreatedAt          DateTime64(3) CODEC(Delta, ZSTD(1)),
+		UpdatedAt          DateTime64(3) CODEC(Delta, ZSTD(1))
+	)
+	ENGINE = ReplacingMergeTree(UpdatedAt)
+	ORDER BY (AccountId, ReportId)
+	SETTINGS index_granularity = 8192;
+	`
+	err = conn.Exec(context.Background(), reportSchedulesSchema)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create report schedules table: %w", err)
+	}
+
+	reportRunsSchema := `
+	CREATE TABLE IF NOT EXISTS metrics.report_runs
+	(
+		RunId              UUID CODEC(ZSTD(1)),
+		ReportId           UUID CODEC(ZSTD(1)),
+		AccountId          UInt64 CODEC(ZSTD(1)),
+		Trigger            LowCardinality(String),
+		ScheduledFor       DateTime64(3) CODEC(Delta, ZSTD(1)),
+		StartedAt          DateTime64(3) CODEC(Delta, ZSTD(1)),
+		DurationMs         UInt32 CODEC(ZSTD(1)),
+		Format             LowCardinality(String),
+		DeliveryType       LowCardinality(String),
+		ArtifactBytes      UInt64 CODEC(ZSTD(1)),
+		WidgetErrors       UInt32 CODEC(ZSTD(1)),
+		Success            UInt8,
+		Error              String CODEC(ZSTD(1))
+	)
+	ENGINE = MergeTree
+	PARTITION BY toYYYYMM(StartedAt)
+	ORDER BY (AccountId, ReportId, StartedAt)
+	TTL toDateTime(StartedAt) + INTERVAL 180 DAY
+	SETTINGS index_granularity = 8192, ttl_only_drop_parts = 1;
+	`
+	err = conn.Exec(context.Background(), reportRunsSchema)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create report runs table: %w", err)
+	}
+
+	// Create Annotations Table
+	annotationsSchema := `
+	CREATE TABLE IF NOT EXISTS metrics.annotations
+	(
+		AnnotationId       UUID CODEC(ZSTD(1)),
+		AccountId          UInt64 CODEC(ZSTD(1)),
+		Type               LowCardinality(String),
+		Title              String CODEC(ZSTD(1)),
+		Text               String CODEC(ZSTD(1)),
+		Tags               Array(LowCardinality(String)) CODEC(ZSTD(1)),
+		ServiceName        LowCardinality(String),
+		HostName           LowCardinality(String),
+		StartTime          DateTime64(3) CODEC(Delta, ZSTD(1)),
+		EndTime            DateTime64(3) CODEC(Delta, ZSTD(1)),
+		Source             LowCardinality(String),
+		Attributes         Map(LowCardinality(String), String) CODEC(ZSTD(1)),
+		CreatedAt          DateTime64(3) CODEC(Delta, ZSTD(1))
+	)
+	ENGINE = ReplacingMergeTree(CreatedAt)
+	ORDER BY (AccountId, AnnotationId)
+	TTL toDateTime(StartTime) + INTERVAL 400 DAY
+	SETTINGS index_granularity = 8192;
+	`
+	err = conn.Exec(context.Background(), annotationsSchema)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create annotations table: %w", err)
+	}
+
+	// Create Dashboard Folder, Star and Access Log Tables
+	dashboardFoldersSchema := `
+	CREATE TABLE IF NOT EXISTS metrics.dashboard_folders
+	(
+		FolderId           UUID CODEC(ZSTD(1)),
+		AccountId          UInt64 CODEC(ZSTD(1)),
+		ParentId           String CODEC(ZSTD(1)),
+		Name               String CODEC(ZSTD(1)),
+		CreatedBy          UInt64 CODEC(ZSTD(1)),
+		CreatedAt          DateTime64(3) CODEC(Delta, ZSTD(1)),
+		UpdatedAt          DateTime64(3) CODEC(Delta, ZSTD(1))
+	)
+	ENGINE = ReplacingMergeTree(UpdatedAt)
+	ORDER BY (AccountId, FolderId)
+	SETTINGS index_granularity = 8192;
+	`
+	err = conn.Exec(context.Background(), dashboardFoldersSchema)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dashboard folders table: %w", err)
+	}
+
+	dashboardStarsSchema := `
+	CREATE TABLE IF NOT EXISTS metrics.dashboard_stars
+	(
+		AccountId          UInt64 CODEC(ZSTD(1)),
+		UserId             UInt64 CODEC(ZSTD(1)),
+		DashboardId        UUID CODEC(ZSTD(1)),
+		Starred            UInt8,
+		UpdatedAt          DateTime64(3) CODEC(Delta, ZSTD(1))
+	)
+	ENGINE = ReplacingMergeTree(UpdatedAt)
+	ORDER BY (AccountId, UserId, DashboardId)
+	SETTINGS index_granularity = 8192;
+	`
+	err = conn.Exec(context.Background(), dashboardStarsSchema)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dashboard stars table: %w", err)
+	}
+
+	dashboardPermissionsSchema := `
+	CREATE TABLE IF NOT EXISTS metrics.dashboard_permissions
+	(
+		AccountId          UInt64 CODEC(ZSTD(1)),
+		DashboardId        UUID CODEC(ZSTD(1)),
+		PrincipalType      LowCardinality(String) CODEC(ZSTD(1)),
+		PrincipalId        UInt64 CODEC(ZSTD(1)),
+		Permission         LowCardinality(String) CODEC(ZSTD(1)),
+		GrantedBy          UInt64 CODEC(ZSTD(1)),
+		UpdatedAt          DateTime64(3) CODEC(Delta, ZSTD(1))
+	)
+	ENGINE = ReplacingMergeTree(UpdatedAt)
+	ORDER BY (AccountId, DashboardId, PrincipalType, PrincipalId)
+	SETTINGS index_granularity = 8192;
+	`
+	err = conn.Exec(context.Background(), dashboardPermissionsSchema)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dashboard permissions table: %w", err)
+	}
+
+	dashboardAccessLogSchema := `
+	CREATE TABLE IF NOT EXISTS metrics.dashboard_access_log
+	(
+		DashboardId        UUID,
+		UserId             UInt64,
+		AccountId          UInt64,
+		AccessedAt         DateTime64(3) DEFAULT now64(3),
+		IPAddress          IPv4,
+		UserAgent          String
+	)
+	ENGINE = MergeTree
+	PARTITION BY toDate(AccessedAt)
+	ORDER BY (DashboardId, UserId, AccessedAt)
+	TTL toDateTime(AccessedAt) + INTERVAL 90 DAY
+	SETTINGS index_granularity = 8192;
+	`
+	err = conn.Exec(context.Background(), dashboardAccessLogSchema)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dashboard access log table: %w", err)
+	}
+
+	// Create Dashboard Snapshots Table
+	snapshotsSchema := `
+	CREATE TABLE IF NOT EXISTS metrics.dashboard_snapshots
+	(
+		SnapshotId         UUID CODEC(ZSTD(1)),
+		TokenHash          String CODEC(ZSTD(1)),
+		AccountId          UInt64 CODEC(ZSTD(1)),
+		DashboardId        UUID CODEC(ZSTD(1)),
+		DashboardVersion   UInt32,
+		Name               String CODEC(ZSTD(1)),
+		Dashboard          String CODEC(ZSTD(3)),
+		Data               String CODEC(ZSTD(3)),
+		FromTime           DateTime64(3) CODEC(Delta, ZSTD(1)),
+		ToTime             DateTime64(3) CODEC(Delta, ZSTD(1)),
+		CreatedBy          String CODEC(ZSTD(1)),
+		CreatedAt          DateTime64(3) CODEC(Delta, ZSTD(1)),
+		ExpiresAt          DateTime64(3) CODEC(Delta, ZSTD(1)),
+		INDEX idx_token_hash TokenHash TYPE bloom_filter GRANULARITY 1
+	)
+	ENGINE = MergeTree
+	ORDER BY (AccountId, DashboardId, SnapshotId)
+	TTL toDateTime(ExpiresAt) + INTERVAL 1 DAY
+	SETTINGS index_granularity = 8192;
+	`
+	err = conn.Exec(context.Background(), snapshotsSchema)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dashboard snapshots table: %w", err)
+	}
+
 	// Create Traces Table (with Array(Map) for Events and Links)
 	tracesSchema := `
 	CREATE TABLE IF NOT EXISTS traces.traces_v1
@@ -213,9 +536,11 @@ func NewStore(addr string, db string, user string, password string) (*Store, err
 		PatternHash        String,
 		BodyHash           String,
 		Bytes              UInt64 CODEC(ZSTD(1)),
+		LogId              String DEFAULT ` + legacyLogId + ` CODEC(ZSTD(1)),
 		INDEX idx_trace_id TraceId TYPE bloom_filter(0.01) GRANULARITY 1,
 		INDEX idx_service  ServiceName TYPE bloom_filter(0.01) GRANULARITY 1,
-		INDEX idx_body     Body TYPE tokenbf_v1(32768, 3, 0) GRANULARITY 1
+		INDEX idx_body     Body TYPE tokenbf_v1(32768, 3, 0) GRANULARITY 1,
+		INDEX idx_log_id   LogId TYPE bloom_filter(0.001) GRANULARITY 1
 	)
 	ENGINE = MergeTree
 	PARTITION BY (toDate(Timestamp), AccountId)
@@ -228,6 +553,230 @@ func NewStore(addr string, db string, user string, password string) (*Store, err
 		return nil, fmt.Errorf("failed to create logs table: %w", err)
 	}
 
+	// Add log IDs to logs tables created before them. Logs written before
+	// get an ID derived from their content, which stays the same across reads.
+	logsMigration := `
+	ALTER TABLE logs.logs_v1
+		ADD COLUMN IF NOT EXISTS LogId String DEFAULT ` + legacyLogId + ` CODEC(ZSTD(1)),
+		ADD INDEX IF NOT EXISTS idx_log_id LogId TYPE bloom_filter(0.001) GRANULARITY 1
+	`
+	err = conn.Exec(context.Background(), logsMigration)
+	if err != nil {
+		return nil, fmt.Errorf("failed to migrate logs table: %w", err)
+	}
+
+	// Create Log Pattern Catalog Table
+	logPatternsSchema := `
+	CREATE TABLE IF NOT EXISTS logs.log_patterns
+	(
+		AccountId          UInt64 CODEC(ZSTD(1)),
+		ServiceName        LowCardinality(String),
+		PatternHash        String,
+		Template           String CODEC(ZSTD(1)),
+		Examples           Array(String) CODEC(ZSTD(1)),
+		FirstSeen          DateTime64(9) CODEC(Delta, ZSTD(1)),
+		UpdatedAt          DateTime64(3) CODEC(Delta, ZSTD(1))
+	)
+	ENGINE = ReplacingMergeTree(UpdatedAt)
+	ORDER BY (AccountId, ServiceName, PatternHash)
+	SETTINGS index_granularity = 8192;
+	`
+	err = conn.Exec(context.Background(), logPatternsSchema)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create log patterns table: %w", err)
+	}
+
+	// Create Log Pattern Stats Table, counting each pattern per service
+	// version and hour as logs are inserted
+	logPatternStatsSchema := `
+	CREATE TABLE IF NOT EXISTS logs.log_pattern_stats
+	(
+		AccountId          UInt64 CODEC(ZSTD(1)),
+		ServiceName        LowCardinality(String),
+		PatternHash        String,
+		ServiceVersion     LowCardinality(String),
+		Hour               DateTime CODEC(Delta, ZSTD(1)),
+		Severity           SimpleAggregateFunction(anyLast, String),
+		Count              SimpleAggregateFunction(sum, UInt64),
+		FirstSeen          SimpleAggregateFunction(min, DateTime64(9)),
+		LastSeen           SimpleAggregateFunction(max, DateTime64(9))
+	)
+	ENGINE = AggregatingMergeTree
+	ORDER BY (AccountId, ServiceName, PatternHash, ServiceVersion, Hour)
+	TTL Hour + INTERVAL 90 DAY
+	SETTINGS index_granularity = 8192;
+	`
+	err = conn.Exec(context.Background(), logPatternStatsSchema)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create log pattern stats table: %w", err)
+	}
+
+	logPatternStatsView := `
+	CREATE MATERIALIZED VIEW IF NOT EXISTS logs.log_pattern_stats_mv TO logs.log_pattern_stats AS
+	SELECT
+		AccountId,
+		ServiceName,
+		PatternHash,
+		ServiceVersion,
+		toStartOfHour(Timestamp) AS Hour,
+		anyLast(SeverityText) AS Severity,
+		count() AS Count,
+		min(Timestamp) AS FirstSeen,
+		max(Timestamp) AS LastSeen
+	FROM logs.logs_v1
+	WHERE PatternHash != ''
+	GROUP BY AccountId, ServiceName, PatternHash, ServiceVersion, Hour
+	`
+	err = conn.Exec(context.Background(), logPatternStatsView)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create log pattern stats view: %w", err)
+	}
+
+	// Create Log Pattern Anomalies Table
+	logAnomaliesSchema := `
+	CREATE TABLE IF NOT EXISTS logs.log_pattern_anomalies
+	(
+		AnomalyId          String,
+		AccountId          UInt64 CODEC(ZSTD(1)),
+		Kind               LowCardinality(String),
+		ServiceName        LowCardinality(String),
+		ServiceVersion     LowCardinality(String),
+		PatternHash        String,
+		Template           String CODEC(ZSTD(1)),
+		Severity           LowCardinality(String),
+		Count              UInt64 CODEC(ZSTD(1)),
+		Expected           Float64 CODEC(ZSTD(1)),
+		Score              Float64 CODEC(ZSTD(1)),
+		FirstSeen          DateTime64(9) CODEC(Delta, ZSTD(1)),
+		DetectedAt         DateTime64(3) CODEC(Delta, ZSTD(1)),
+		Alerted            Bool
+	)
+	ENGINE = ReplacingMergeTree(DetectedAt)
+	ORDER BY (AccountId, AnomalyId)
+	TTL toDateTime(DetectedAt) + INTERVAL 30 DAY
+	SETTINGS index_granularity = 8192;
+	`
+	err = conn.Exec(context.Background(), logAnomaliesSchema)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create log anomalies table: %w", err)
+	}
+
+	// Create Log Pipelines Table
+	logPipelinesSchema := `
+	CREATE TABLE IF NOT EXISTS logs.log_pipelines
+	(
+		PipelineId         String,
+		AccountId          UInt64 CODEC(ZSTD(1)),
+		Name               String CODEC(ZSTD(1)),
+		Description        String CODEC(ZSTD(1)),
+		Services           Array(String) CODEC(ZSTD(1)),
+		Steps              String CODEC(ZSTD(1)),
+		Position           Int32,
+		Enabled            Bool,
+		CreatedAt          DateTime64(3) CODEC(Delta, ZSTD(1)),
+		UpdatedAt          DateTime64(3) CODEC(Delta, ZSTD(1))
+	)
+	ENGINE = ReplacingMergeTree(UpdatedAt)
+	ORDER BY (AccountId, PipelineId)
+	SETTINGS index_granularity = 8192;
+	`
+	err = conn.Exec(context.Background(), logPipelinesSchema)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create log pipelines table: %w", err)
+	}
+
+	// Create Redaction Tables
+	redactionConfigsSchema := `
+	CREATE TABLE IF NOT EXISTS logs.redaction_configs
+	(
+		AccountId          UInt64 CODEC(ZSTD(1)),
+		Enabled            Bool,
+		Mode               LowCardinality(String),
+		Detectors          Array(String) CODEC(ZSTD(1)),
+		Rules              String CODEC(ZSTD(1)),
+		UpdatedAt          DateTime64(3) CODEC(Delta, ZSTD(1))
+	)
+	ENGINE = ReplacingMergeTree(UpdatedAt)
+	ORDER BY AccountId
+	SETTINGS index_granularity = 8192;
+	`
+	err = conn.Exec(context.Background(), redactionConfigsSchema)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create redaction configs table: %w", err)
+	}
+
+	redactionCountsSchema := `
+	CREATE TABLE IF NOT EXISTS logs.redaction_counts
+	(
+		AccountId          UInt64 CODEC(ZSTD(1)),
+		Minute             DateTime CODEC(Delta, ZSTD(1)),
+		ServiceName        LowCardinality(String),
+		Rule               LowCardinality(String),
+		Signal             LowCardinality(String),
+		Count              UInt64 CODEC(ZSTD(1))
+	)
+	ENGINE = SummingMergeTree(Count)
+	ORDER BY (AccountId, Minute, ServiceName, Rule, Signal)
+	TTL Minute + INTERVAL 90 DAY
+	SETTINGS index_granularity = 8192;
+	`
+	err = conn.Exec(context.Background(), redactionCountsSchema)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create redaction counts table: %w", err)
+	}
+
+	// Create Log Archive Tables. Archives outlive the logs, so the catalog
+	// has no TTL.
+	logArchivesSchema := `
+	CREATE TABLE IF NOT EXISTS logs.log_archives
+	(
+		AccountId          UInt64 CODEC(ZSTD(1)),
+		Date               Date,
+		ServiceName        LowCardinality(String),
+		Format             LowCardinality(String),
+		DataKey            String CODEC(ZSTD(1)),
+		ManifestKey        String CODEC(ZSTD(1)),
+		Rows               UInt64 CODEC(ZSTD(1)),
+		Bytes              UInt64 CODEC(ZSTD(1)),
+		MinTimestamp       DateTime64(9) CODEC(Delta, ZSTD(1)),
+		MaxTimestamp       DateTime64(9) CODEC(Delta, ZSTD(1)),
+		ArchivedAt         DateTime64(3) CODEC(Delta, ZSTD(1))
+	)
+	ENGINE = ReplacingMergeTree(ArchivedAt)
+	ORDER BY (AccountId, Date, ServiceName)
+	SETTINGS index_granularity = 8192;
+	`
+	err = conn.Exec(context.Background(), logArchivesSchema)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create log archives table: %w", err)
+	}
+
+	logRehydrationsSchema := `
+	CREATE TABLE IF NOT EXISTS logs.log_rehydrations
+	(
+		RehydrationId      String,
+		AccountId          UInt64 CODEC(ZSTD(1)),
+		ServiceName        LowCardinality(String),
+		From               DateTime64(9) CODEC(Delta, ZSTD(1)),
+		To                 DateTime64(9) CODEC(Delta, ZSTD(1)),
+		TableName          String,
+		Status             LowCardinality(String),
+		Rows               UInt64 CODEC(ZSTD(1)),
+		Error              String CODEC(ZSTD(1)),
+		CreatedAt          DateTime64(3) CODEC(Delta, ZSTD(1)),
+		ExpiresAt          DateTime64(3) CODEC(Delta, ZSTD(1)),
+		UpdatedAt          DateTime64(3) CODEC(Delta, ZSTD(1))
+	)
+	ENGINE = ReplacingMergeTree(UpdatedAt)
+	ORDER BY (AccountId, RehydrationId)
+	TTL toDateTime(ExpiresAt) + INTERVAL 30 DAY
+	SETTINGS index_granularity = 8192;
+	`
+	err = conn.Exec(context.Background(), logRehydrationsSchema)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create log rehydrations table: %w", err)
+	}
+
 	// Create Profiles Table
 	profilesSchema := `
 	CREATE TABLE IF NOT EXISTS profiles.profiling_v1
@@ -283,7 +832,11 @@ func NewStore(addr string, db string, user string, password string) (*Store, err
 		return nil, fmt.Errorf("failed to create profiles table: %w", err)
 	}
 
-	return &Store{conn: conn}, nil
+	s := &Store{conn: conn}
+	if err := s.backfillDashboardVersions(context.Background()); err != nil {
+		return nil, err
+	}
+	return s, nil
 }
 
 // Metric represents a single data point
@@ -297,6 +850,7 @@ type Metric struct {
 	Value              float64
 	Labels             map[string]string
 	ResourceAttributes map[string]string
+	RetentionDays      uint16 // 0 for the default of 30 days
 }
 
 func (s *Store) InsertMetrics(ctx context.Context, metrics []Metric) error {
@@ -336,11 +890,16 @@ func (s *Store) InsertMetrics(ctx context.Context, metrics []Metric) error {
 		containerId := res["container.id"]
 		container := res["container.name"]
 
+		retentionDays := m.RetentionDays
+		if retentionDays == 0 {
+			retentionDays = 30
+		}
+
 		err := batch.Append(
 			m.Timestamp,
 			m.AccountId,
-			uint64(0),  // SubAccountId
-			uint16(30), // RetentionDays
+			uint64(0), // SubAccountId
+			retentionDays,
 			hostId,
 			hostName,
 			hostIP,
@@ -405,8 +964,13 @@ type Log struct {
 	PatternHash        string
 	BodyHash           string
 	Bytes              uint64
+	LogId              string // assigned on insert when empty
 }
 
+// legacyLogId derives IDs for logs written before IDs were assigned
+const legacyLogId = "lower(hex(cityHash64(Timestamp, ServiceName, HostName, Pod, ContainerId, Body)))"
+
+// InsertLogs writes logs, giving each one without an ID a new unique ID
 func (s *Store) InsertLogs(ctx context.Context, logs []Log) error {
 	batch, err := s.conn.PrepareBatch(ctx, "INSERT INTO logs.logs_v1")
 	if err != nil {
@@ -450,6 +1014,12 @@ func (s *Store) InsertLogs(ctx context.Context, logs []Log) error {
 				}
 				return uint64(len(l.Body) + len(l.ServiceName) + len(l.SeverityText) + 100)
 			}(),
+			func() string {
+				if l.LogId != "" {
+					return l.LogId
+				}
+				return uuid.NewString()
+			}(),
 		)
 		if err != nil {
 			return err
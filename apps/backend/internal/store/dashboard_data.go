@@ -0,0 +1,198 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// defaultRenderConcurrency bounds the queries a dashboard render runs at once
+const defaultRenderConcurrency = 4
+
+// DashboardDataRequest selects the time window and variables for rendering
+// every widget of a dashboard in one pass
+type DashboardDataRequest struct {
+	AccountId   uint64
+	TimeRange   string              // overrides every widget's time range when set
+	Interval    string              // bucket size, e.g., "1m"
+	To          time.Time           // end of the window, defaults to now
+	Variables   map[string][]string // selected values by variable name
+	Concurrency int                 // max queries in flight, defaults to defaultRenderConcurrency
+}
+
+// DashboardData holds the rendered series of every widget of a dashboard
+type DashboardData struct {
+	DashboardId      string       `json:"dashboard_id"`
+	Version          uint32       `json:"version"`
+	From             time.Time    `json:"from"`
+	To               time.Time    `json:"to"`
+	Interval         int          `json:"interval"` // seconds
+	Widgets          []WidgetData `json:"widgets"`
+	QueriesExecuted  int          `json:"queries_executed"`
+	QueriesDeduped   int          `json:"queries_deduped"`
+	RenderDurationMs int64        `json:"render_duration_ms"`
+}
+
+// WidgetData is the result of one widget: its series, or the error that
+// prevented rendering it
+type WidgetData struct {
+	WidgetId string         `json:"widget_id"`
+	Title    string         `json:"title"`
+	From     time.Time      `json:"from"`
+	To       time.Time      `json:"to"`
+	Series   []MetricSeries `json:"series"`
+	Error    string         `json:"error,omitempty"`
+}
+
+// renderJob is one distinct metric query of a dashboard render
+type renderJob struct {
+	query    MetricQuery
+	from, to time.Time
+	series   []MetricSeries
+	err      error
+}
+
+// RenderDashboard runs the queries of every widget over a shared time window.
+// Identical queries are executed once, at most req.Concurrency at a time, and
+// a failing widget does not fail the others.
+func (s *Store) RenderDashboard(ctx context.Context, dashboard *Dashboard, req DashboardDataRequest) (*DashboardData, error) {
+	start := time.Now()
+
+	scope, err := s.buildVariableScope(ctx, req.AccountId, dashboard.Variables, req.Variables)
+	if err != nil {
+		return nil, err
+	}
+
+	to := req.To
+	if to.IsZero() {
+		to = time.Now()
+	}
+	verr := &ValidationError{}
+	interval := parseInterval(scope.interpolateSingle(verr, "interval", req.Interval))
+	if len(verr.Errors) > 0 {
+		return nil, verr
+	}
+
+	type widgetWindow struct {
+		queries  []MetricQuery
+		from, to time.Time
+		err      error // the queries could not take the variables
+	}
+	windows := make([]widgetWindow, len(dashboard.Widgets))
+	jobs := make(map[string]*renderJob)
+	var order []string
+	total := 0
+
+	for i, widget := range dashboard.Widgets {
+		timeRange := req.TimeRange
+		if timeRange == "" {
+			timeRange = widget.TimeConfig.TimeRange
+		}
+		terr := &ValidationError{}
+		from := to.Add(-time.Duration(parseTimeRange(scope.interpolateSingle(terr, "time_range", timeRange))) * time.Minute)
+		queries, err := scope.applyToQueries(widget.Queries)
+		if len(terr.Errors) > 0 {
+			err = terr
+		}
+		windows[i] = widgetWindow{queries: queries, from: from, to: to, err: err}
+		if err != nil {
+			continue
+		}
+
+		for _, q := range queries {
+			if q.Formula != "" && q.MetricName == "" {
+				continue
+			}
+			total++
+			key := renderJobKey(q, from, to)
+			if _, ok := jobs[key]; !ok {
+				jobs[key] = &renderJob{query: q, from: from, to: to}
+				order = append(order, key)
+			}
+		}
+	}
+
+	workers := req.Concurrency
+	if workers <= 0 {
+		workers = defaultRenderConcurrency
+	}
+	if workers > len(order) {
+		workers = len(order)
+	}
+
+	queue := make(chan *renderJob)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range queue {
+				job.series, job.err = s.queryMetricSeries(ctx, req.AccountId, job.query, job.from, job.to, interval)
+			}
+		}()
+	}
+	for _, key := range order {
+		queue <- jobs[key]
+	}
+	close(queue)
+	wg.Wait()
+
+	data := &DashboardData{
+		DashboardId:     dashboard.DashboardId,
+		Version:         dashboard.Version,
+		From:            to,
+		To:              to,
+		Interval:        interval,
+		Widgets:         make([]WidgetData, 0, len(dashboard.Widgets)),
+		QueriesExecuted: len(order),
+		QueriesDeduped:  total - len(order),
+	}
+
+	for i, widget := range dashboard.Widgets {
+		win := windows[i]
+		if win.from.Before(data.From) {
+			data.From = win.from
+		}
+
+		wd := WidgetData{
+			WidgetId: widget.WidgetId,
+			Title:    widget.Title,
+			From:     win.from,
+			To:       win.to,
+			Series:   []MetricSeries{},
+		}
+		if win.err != nil {
+			wd.Error = win.err.Error()
+			data.Widgets = append(data.Widgets, wd)
+			continue
+		}
+		results, err := composeMetricQueries(win.queries, func(q MetricQuery) ([]MetricSeries, error) {
+			job := jobs[renderJobKey(q, win.from, win.to)]
+			// Series are shared between widgets, so hand out copies
+			return append([]MetricSeries(nil), job.series...), job.err
+		})
+		if err != nil {
+			wd.Error = err.Error()
+		} else {
+			for _, series := range results {
+				wd.Series = append(wd.Series, series...)
+			}
+		}
+		data.Widgets = append(data.Widgets, wd)
+	}
+
+	data.RenderDurationMs = time.Since(start).Milliseconds()
+	return data, nil
+}
+
+// renderJobKey identifies identical queries over the same window
+func renderJobKey(q MetricQuery, from, to time.Time) string {
+	key, _ := json.Marshal(struct {
+		Query        MetricQuery         `json:"q"`
+		MultiFilters map[string][]string `json:"m"`
+		From         int64               `json:"f"`
+		To           int64               `json:"t"`
+	}{q, q.multiFilters, from.UnixNano(), to.UnixNano()})
+	return string(key)
+}
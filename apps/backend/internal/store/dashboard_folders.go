@@ -0,0 +1,280 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxFolderDepth bounds folder nesting
+const maxFolderDepth = 8
+
+// ErrFolderNotEmpty is returned when deleting a folder that still holds
+// dashboards or subfolders
+var ErrFolderNotEmpty = errors.New("folder is not empty")
+
+// DashboardFolder groups dashboards. Folders nest through ParentId; an
+// empty ParentId is a top-level folder.
+type DashboardFolder struct {
+	FolderId       string            `json:"folder_id"`
+	AccountId      uint64            `json:"account_id"`
+	ParentId       string            `json:"parent_id"`
+	Name           string            `json:"name"`
+	CreatedBy      uint64            `json:"created_by"`
+	CreatedAt      time.Time         `json:"created_at"`
+	UpdatedAt      time.Time         `json:"updated_at"`
+	Path           []string          `json:"path,omitempty"` // names from the top-level folder down
+	DashboardCount int               `json:"dashboard_count"`
+	Children       []DashboardFolder `json:"children,omitempty"`
+}
+
+const folderColumns = `FolderId, AccountId, ParentId, Name, CreatedBy, CreatedAt, UpdatedAt`
+
+// ListFolders returns every folder of an account, unordered
+func (s *Store) ListFolders(ctx context.Context, accountId uint64) ([]DashboardFolder, error) {
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM metrics.dashboard_folders FINAL
+		WHERE AccountId = ?
+	`, folderColumns)
+
+	rows, err := s.conn.Query(ctx, query, accountId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list folders: %w", err)
+	}
+	defer rows.Close()
+
+	var folders []DashboardFolder
+	for rows.Next() {
+		var f DashboardFolder
+		if err := rows.Scan(&f.FolderId, &f.AccountId, &f.ParentId, &f.Name, &f.CreatedBy, &f.CreatedAt, &f.UpdatedAt); err != nil {
+			return nil, err
+		}
+		folders = append(folders, f)
+	}
+	return folders, nil
+}
+
+// GetFolder returns a folder with its path, or nil if it does not exist
+func (s *Store) GetFolder(ctx context.Context, accountId uint64, folderId string) (*DashboardFolder, error) {
+	folders, err := s.ListFolders(ctx, accountId)
+	if err != nil {
+		return nil, err
+	}
+	byId := folderIndex(folders)
+	f, ok := byId[folderId]
+	if !ok {
+		return nil, nil
+	}
+	f.Path = folderPath(byId, folderId)
+	return &f, nil
+}
+
+// SaveFolder creates or updates a folder. The parent must exist, must not
+// be the folder itself or one of its descendants, and names must be unique
+// among siblings.
+func (s *Store) SaveFolder(ctx context.Context, folder *DashboardFolder) error {
+	folder.Name = strings.TrimSpace(folder.Name)
+	if folder.Name == "" {
+		return &ValidationError{Errors: []FieldError{{Field: "name", Message: "name is required"}}}
+	}
+
+	folders, err := s.ListFolders(ctx, folder.AccountId)
+	if err != nil {
+		return err
+	}
+	byId := folderIndex(folders)
+
+	if folder.ParentId != "" {
+		if _, ok := byId[folder.ParentId]; !ok {
+			return &ValidationError{Errors: []FieldError{{Field: "parent_id", Message: "parent folder does not exist"}}}
+		}
+		// Walking up from the new parent must not reach the folder itself
+		for id := folder.ParentId; id != ""; id = byId[id].ParentId {
+			if id == folder.FolderId {
+				return &ValidationError{Errors: []FieldError{{Field: "parent_id", Message: "a folder cannot be moved into itself or its subfolders"}}}
+			}
+		}
+		if len(folderPath(byId, folder.ParentId))+subtreeDepth(folders, folder.FolderId) > maxFolderDepth {
+			return &ValidationError{Errors: []FieldError{{Field: "parent_id", Message: fmt.Sprintf("folders can be nested at most %d levels deep", maxFolderDepth)}}}
+		}
+	}
+	for _, f := range folders {
+		if f.FolderId != folder.FolderId && f.ParentId == folder.ParentId && strings.EqualFold(f.Name, folder.Name) {
+			return &ValidationError{Errors: []FieldError{{Field: "name", Message: fmt.Sprintf("a folder named %q already exists here", f.Name)}}}
+		}
+	}
+
+	if existing, ok := byId[folder.FolderId]; ok {
+		folder.CreatedAt = existing.CreatedAt
+		folder.CreatedBy = existing.CreatedBy
+	} else {
+		folder.CreatedAt = time.Now()
+	}
+	folder.UpdatedAt = time.Now()
+
+	query := fmt.Sprintf(`
+		INSERT INTO metrics.dashboard_folders
+		(%s)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, folderColumns)
+	err = s.conn.Exec(ctx, query,
+		folder.FolderId,
+		folder.AccountId,
+		folder.ParentId,
+		folder.Name,
+		folder.CreatedBy,
+		folder.CreatedAt,
+		folder.UpdatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save folder: %w", err)
+	}
+	return nil
+}
+
+// DeleteFolder deletes an empty folder
+func (s *Store) DeleteFolder(ctx context.Context, accountId uint64, folderId string) error {
+	folders, err := s.ListFolders(ctx, accountId)
+	if err != nil {
+		return err
+	}
+	for _, f := range folders {
+		if f.ParentId == folderId {
+			return ErrFolderNotEmpty
+		}
+	}
+	dashboards, err := s.ListDashboards(ctx, accountId)
+	if err != nil {
+		return err
+	}
+	for _, d := range dashboards {
+		if d.FolderId == folderId {
+			return ErrFolderNotEmpty
+		}
+	}
+
+	query := `
+		ALTER TABLE metrics.dashboard_folders
+		DELETE WHERE AccountId = ? AND FolderId = ?
+	`
+	if err := s.conn.Exec(ctx, query, accountId, folderId); err != nil {
+		return fmt.Errorf("failed to delete folder: %w", err)
+	}
+	return nil
+}
+
+// GetFolderTree returns the folders of an account as a tree sorted by name,
+// with the number of dashboards directly in each folder
+func (s *Store) GetFolderTree(ctx context.Context, accountId uint64) ([]DashboardFolder, error) {
+	folders, err := s.ListFolders(ctx, accountId)
+	if err != nil {
+		return nil, err
+	}
+	dashboards, err := s.ListDashboards(ctx, accountId)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int)
+	for _, d := range dashboards {
+		counts[d.FolderId]++
+	}
+	children := make(map[string][]DashboardFolder)
+	byId := folderIndex(folders)
+	for _, f := range folders {
+		f.DashboardCount = counts[f.FolderId]
+		parent := f.ParentId
+		// Folders whose parent was deleted concurrently show up at the top
+		if _, ok := byId[parent]; !ok {
+			parent = ""
+		}
+		children[parent] = append(children[parent], f)
+	}
+
+	var build func(parentId string, depth int) []DashboardFolder
+	build = func(parentId string, depth int) []DashboardFolder {
+		level := children[parentId]
+		sort.Slice(level, func(i, j int) bool { return strings.ToLower(level[i].Name) < strings.ToLower(level[j].Name) })
+		if depth < maxFolderDepth {
+			for i := range level {
+				level[i].Children = build(level[i].FolderId, depth+1)
+			}
+		}
+		return level
+	}
+	tree := build("", 0)
+	if tree == nil {
+		tree = []DashboardFolder{}
+	}
+	return tree, nil
+}
+
+// validateFolder checks that a dashboard's folder exists
+func (s *Store) validateFolder(ctx context.Context, d *Dashboard) (*FieldError, error) {
+	if d.FolderId == "" {
+		return nil, nil
+	}
+	folder, err := s.GetFolder(ctx, d.AccountId, d.FolderId)
+	if err != nil {
+		return nil, err
+	}
+	if folder == nil {
+		return &FieldError{Field: "folder_id", Message: "folder does not exist"}, nil
+	}
+	return nil, nil
+}
+
+// descendantFolders returns the IDs of a folder and every folder below it
+func descendantFolders(folders []DashboardFolder, folderId string) map[string]bool {
+	ids := map[string]bool{folderId: true}
+	for changed := true; changed; {
+		changed = false
+		for _, f := range folders {
+			if ids[f.ParentId] && !ids[f.FolderId] {
+				ids[f.FolderId] = true
+				changed = true
+			}
+		}
+	}
+	return ids
+}
+
+func folderIndex(folders []DashboardFolder) map[string]DashboardFolder {
+	byId := make(map[string]DashboardFolder, len(folders))
+	for _, f := range folders {
+		byId[f.FolderId] = f
+	}
+	return byId
+}
+
+// folderPath lists folder names from the top level down to folderId
+func folderPath(byId map[string]DashboardFolder, folderId string) []string {
+	var path []string
+	for id := folderId; id != "" && len(path) <= maxFolderDepth; {
+		f, ok := byId[id]
+		if !ok {
+			break
+		}
+		path = append([]string{f.Name}, path...)
+		id = f.ParentId
+	}
+	return path
+}
+
+// subtreeDepth is the number of levels from a folder down to its deepest
+// descendant, counting the folder itself
+func subtreeDepth(folders []DashboardFolder, folderId string) int {
+	depth := 1
+	for _, f := range folders {
+		if f.ParentId == folderId && f.FolderId != folderId {
+			if d := subtreeDepth(folders, f.FolderId) + 1; d > depth && d <= maxFolderDepth+1 {
+				depth = d
+			}
+		}
+	}
+	return depth
+}
@@ -6,13 +6,103 @@ import (
 
 // Dashboard represents a saved dashboard configuration
 type Dashboard struct {
-	DashboardId string    `json:"dashboard_id"`
-	AccountId   uint64    `json:"account_id"`
-	Name        string    `json:"name"`
-	Description string    `json:"description"`
-	Config      string    `json:"config"` // JSON-encoded widget layout
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
+	DashboardId   string              `json:"dashboard_id"`
+	AccountId     uint64              `json:"account_id"`
+	Name          string              `json:"name"`
+	Description   string              `json:"description"`
+	Config        string              `json:"-"` // JSON-encoded DashboardConfig, as stored
+	Widgets       []DashboardWidget   `json:"widgets"`
+	Variables     []DashboardVariable `json:"variables"`
+	CreatedAt     time.Time           `json:"created_at"`
+	UpdatedAt     time.Time           `json:"updated_at"`
+	Version       uint32              `json:"version"`
+	Author        string              `json:"author"`
+	ChangeMessage string              `json:"change_message"`
+	Slug          string              `json:"slug"`                  // stable key for dashboards-as-code
+	Source        string              `json:"source"`                // "" when edited in the app, "file" when synced
+	SourcePath    string              `json:"source_path,omitempty"` // file a synced dashboard comes from
+	ReadOnly      bool                `json:"read_only"`             // synced dashboards can only change through their file
+	FolderId      string              `json:"folder_id"`             // "" for the root folder
+	Tags          []string            `json:"tags"`
+	OwnerId       uint64              `json:"owner_id"`
+	Starred       bool                `json:"starred"`                  // starred by the requesting user
+	LastViewedAt  *time.Time          `json:"last_viewed_at,omitempty"` // by the requesting user, in recently viewed lists
+	Permission    string              `json:"permission,omitempty"`     // the requesting user's permission level
+
+	// UnknownMetrics are the query fields naming a metric without data,
+	// reported when the dashboard is saved
+	UnknownMetrics []FieldError `json:"unknown_metrics,omitempty"`
+
+	// undecodedConfig is the stored config when it did not decode into
+	// widgets, so saving the dashboard again does not lose it
+	undecodedConfig string
+}
+
+// DashboardSourceFile marks dashboards reconciled from a watched directory
+const DashboardSourceFile = "file"
+
+// DashboardConfig is the stored layout of a dashboard
+type DashboardConfig struct {
+	Widgets   []DashboardWidget   `json:"widgets"`
+	Variables []DashboardVariable `json:"variables,omitempty"`
+}
+
+// DashboardVariable is a template variable that widget queries reference as
+// $name or ${name} in filters, group-by keys, formulas and aliases
+type DashboardVariable struct {
+	Name       string         `json:"name"`
+	Label      string         `json:"label"`
+	Type       string         `json:"type"`              // query, custom, constant, interval
+	Query      *VariableQuery `json:"query,omitempty"`   // options source for query variables
+	Options    []string       `json:"options,omitempty"` // values for custom, constant and interval variables
+	Current    []string       `json:"current,omitempty"` // default selection
+	Multi      bool           `json:"multi"`
+	IncludeAll bool           `json:"include_all"`
+	AllValue   string         `json:"all_value,omitempty"` // literal used for "All" instead of every option
+}
+
+// VariableQuery describes where a query variable gets its options from
+type VariableQuery struct {
+	Source     string `json:"source"` // label_values, metric_names
+	MetricName string `json:"metric_name,omitempty"`
+	LabelKey   string `json:"label_key,omitempty"`
+}
+
+// ResolvedVariable is a variable with its current options and selection
+type ResolvedVariable struct {
+	Name       string   `json:"name"`
+	Label      string   `json:"label"`
+	Type       string   `json:"type"`
+	Options    []string `json:"options"`
+	Current    []string `json:"current"`
+	Multi      bool     `json:"multi"`
+	IncludeAll bool     `json:"include_all"`
+}
+
+// DashboardVersion describes one saved version of a dashboard
+type DashboardVersion struct {
+	DashboardId   string    `json:"dashboard_id"`
+	Version       uint32    `json:"version"`
+	Name          string    `json:"name"`
+	Author        string    `json:"author"`
+	ChangeMessage string    `json:"change_message"`
+	SavedAt       time.Time `json:"saved_at"`
+}
+
+// DashboardDiff lists the changes between two versions of a dashboard
+type DashboardDiff struct {
+	DashboardId string            `json:"dashboard_id"`
+	FromVersion uint32            `json:"from_version"`
+	ToVersion   uint32            `json:"to_version"`
+	Changes     []DashboardChange `json:"changes"`
+}
+
+// DashboardChange is a single added, removed or changed value
+type DashboardChange struct {
+	Path     string      `json:"path"` // e.g., "name", "widgets[cpu].title"
+	Type     string      `json:"type"` // added, removed, changed
+	OldValue interface{} `json:"old_value,omitempty"`
+	NewValue interface{} `json:"new_value,omitempty"`
 }
 
 // DashboardWidget represents a widget configuration
@@ -34,6 +124,12 @@ type MetricQuery struct {
 	Filters     map[string]string `json:"filters"`     // label filters
 	Formula     string            `json:"formula"`     // optional formula expression
 	Alias       string            `json:"alias"`       // display name
+
+	// Logs makes this a log query, aggregating logs instead of a metric
+	Logs *LogAggregation `json:"logs,omitempty"`
+
+	// multiFilters holds filters expanded from multi-value variables
+	multiFilters map[string][]string
 }
 
 // VisualizationConfig contains chart/visualization settings
@@ -94,11 +190,20 @@ type MetricQueryRequest struct {
 	TimeRange string        `json:"time_range"` // e.g., "15m", "1h"
 	Interval  string        `json:"interval"`   // e.g., "1m", "5m" for bucketing
 	AccountId uint64        `json:"account_id"`
+
+	// Optional dashboard whose variables are substituted into the queries
+	DashboardId string              `json:"dashboard_id,omitempty"`
+	Variables   map[string][]string `json:"variables,omitempty"` // selected values by variable name
+
+	// Optionally return the annotations overlapping the queried range
+	IncludeAnnotations bool     `json:"include_annotations,omitempty"`
+	AnnotationTags     []string `json:"annotation_tags,omitempty"` // only annotations with any of these tags
 }
 
 // MetricQueryResponse contains time-series data
 type MetricQueryResponse struct {
-	Series []MetricSeries `json:"series"`
+	Series      []MetricSeries `json:"series"`
+	Annotations []Annotation   `json:"annotations,omitempty"`
 }
 
 // MetricSeries represents a single time series
@@ -0,0 +1,300 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// ========== DASHBOARD PERMISSIONS ==========
+
+// Dashboard permission levels; each level includes the ones before it.
+// Viewers can see a dashboard and its data, editors can change, share and
+// delete it, admins can also change its permissions.
+const (
+	DashboardViewer = "viewer"
+	DashboardEditor = "editor"
+	DashboardAdmin  = "admin"
+)
+
+// Principal types of dashboard permission entries
+const (
+	PrincipalUser = "user" // users.user_id in the license server
+	PrincipalTeam = "team" // team_members.sub_account_id in the license server
+)
+
+// maxDashboardPermissions bounds the entries of a single dashboard ACL
+const maxDashboardPermissions = 200
+
+var dashboardPermissionRank = map[string]int{
+	DashboardViewer: 1,
+	DashboardEditor: 2,
+	DashboardAdmin:  3,
+}
+
+// DashboardPermission grants a user or team a level on one dashboard
+type DashboardPermission struct {
+	PrincipalType string    `json:"principal_type"` // user or team
+	PrincipalId   uint64    `json:"principal_id"`
+	Permission    string    `json:"permission"` // viewer, editor, admin
+	GrantedBy     uint64    `json:"granted_by"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// DashboardPrincipal is the user a dashboard request is made for, as known
+// to the license server. The zero value is an anonymous request.
+type DashboardPrincipal struct {
+	UserId    uint64
+	AccountId uint64   // users.account_id, 0 if unknown
+	Role      string   // users.role: super_admin, admin, owner, member, viewer
+	TeamIds   []uint64 // team_members.sub_account_id
+	Granted   string   // dashboard level granted account-wide in the permissions table
+}
+
+// VersionConflictError reports an update made against an outdated version
+type VersionConflictError struct {
+	Expected uint32 `json:"expected_version"`
+	Current  uint32 `json:"current_version"`
+}
+
+func (e *VersionConflictError) Error() string {
+	return fmt.Sprintf("dashboard was changed: expected version %d but the latest is %d", e.Expected, e.Current)
+}
+
+// DashboardPermissionAllows reports whether level includes want
+func DashboardPermissionAllows(level, want string) bool {
+	return level != "" && dashboardPermissionRank[level] >= dashboardPermissionRank[want]
+}
+
+// maxDashboardPermission returns the higher of two levels
+func maxDashboardPermission(a, b string) string {
+	if dashboardPermissionRank[b] > dashboardPermissionRank[a] {
+		return b
+	}
+	return a
+}
+
+// DashboardAccessLevel works out the level a principal has on a dashboard,
+// or "" for none:
+//   - anonymous requests, users of other accounts and users whose account
+//     is unknown get nothing, except super admins without an account
+//   - account super admins, admins and owners administer every dashboard
+//   - a dashboard's owner administers it
+//   - otherwise the highest of the user's own entries, their teams' entries
+//     and the account-wide grant applies
+//   - a dashboard without entries is open: any signed-in user in the
+//     account may edit it
+//
+// Users with the account viewer role never get more than viewer.
+func DashboardAccessLevel(dashboard *Dashboard, acl []DashboardPermission, p DashboardPrincipal) string {
+	if p.UserId == 0 {
+		return ""
+	}
+	if p.AccountId == 0 {
+		if p.Role == "super_admin" {
+			return DashboardAdmin
+		}
+		return ""
+	}
+	if p.AccountId != dashboard.AccountId {
+		return ""
+	}
+	switch p.Role {
+	case "super_admin", "admin", "owner":
+		return DashboardAdmin
+	}
+
+	level := p.Granted
+	if dashboard.OwnerId == p.UserId {
+		level = DashboardAdmin
+	}
+	for _, entry := range acl {
+		if principalMatches(entry, p) {
+			level = maxDashboardPermission(level, entry.Permission)
+		}
+	}
+	if len(acl) == 0 {
+		level = maxDashboardPermission(level, DashboardEditor)
+	}
+
+	if p.Role == "viewer" && level != "" {
+		return DashboardViewer
+	}
+	return level
+}
+
+func principalMatches(entry DashboardPermission, p DashboardPrincipal) bool {
+	switch entry.PrincipalType {
+	case PrincipalUser:
+		return entry.PrincipalId == p.UserId
+	case PrincipalTeam:
+		for _, team := range p.TeamIds {
+			if entry.PrincipalId == team {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// GetDashboardPermissions returns the ACL entries of a dashboard
+func (s *Store) GetDashboardPermissions(ctx context.Context, accountId uint64, dashboardId string) ([]DashboardPermission, error) {
+	acls, err := s.dashboardPermissions(ctx, accountId, dashboardId)
+	if err != nil {
+		return nil, err
+	}
+	entries := acls[dashboardId]
+	if entries == nil {
+		entries = []DashboardPermission{}
+	}
+	return entries, nil
+}
+
+// dashboardPermissions loads the ACL entries of one dashboard, or of every
+// dashboard in the account when dashboardId is empty, keyed by dashboard
+func (s *Store) dashboardPermissions(ctx context.Context, accountId uint64, dashboardId string) (map[string][]DashboardPermission, error) {
+	query := `
+		SELECT toString(DashboardId), PrincipalType, PrincipalId, Permission, GrantedBy, UpdatedAt
+		FROM metrics.dashboard_permissions FINAL
+		WHERE AccountId = ? AND Permission != ''
+	`
+	args := []interface{}{accountId}
+	if dashboardId != "" {
+		query += " AND DashboardId = ?"
+		args = append(args, dashboardId)
+	}
+	query += " ORDER BY PrincipalType, PrincipalId"
+
+	rows, err := s.conn.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get dashboard permissions: %w", err)
+	}
+	defer rows.Close()
+
+	acls := make(map[string][]DashboardPermission)
+	for rows.Next() {
+		var id string
+		var entry DashboardPermission
+		if err := rows.Scan(&id, &entry.PrincipalType, &entry.PrincipalId, &entry.Permission, &entry.GrantedBy, &entry.UpdatedAt); err != nil {
+			return nil, err
+		}
+		acls[id] = append(acls[id], entry)
+	}
+	return acls, nil
+}
+
+// SetDashboardPermissions replaces the ACL of a dashboard. Entries missing
+// from the new list are revoked; an empty list opens the dashboard to its
+// account again.
+func (s *Store) SetDashboardPermissions(ctx context.Context, accountId uint64, dashboardId string, entries []DashboardPermission, grantedBy uint64) error {
+	if err := ValidateDashboardPermissions(entries); err != nil {
+		return err
+	}
+
+	current, err := s.GetDashboardPermissions(ctx, accountId, dashboardId)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	kept := make(map[string]bool, len(entries))
+	var rows []DashboardPermission
+	for _, entry := range entries {
+		kept[principalKey(entry)] = true
+		entry.GrantedBy = grantedBy
+		entry.UpdatedAt = now
+		rows = append(rows, entry)
+	}
+	for _, entry := range current {
+		if !kept[principalKey(entry)] {
+			entry.Permission = ""
+			entry.GrantedBy = grantedBy
+			entry.UpdatedAt = now
+			rows = append(rows, entry)
+		}
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+
+	batch, err := s.conn.PrepareBatch(ctx, `
+		INSERT INTO metrics.dashboard_permissions
+		(AccountId, DashboardId, PrincipalType, PrincipalId, Permission, GrantedBy, UpdatedAt)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare dashboard permissions: %w", err)
+	}
+	for _, entry := range rows {
+		if err := batch.Append(accountId, dashboardId, entry.PrincipalType, entry.PrincipalId, entry.Permission, entry.GrantedBy, entry.UpdatedAt); err != nil {
+			return fmt.Errorf("failed to append dashboard permission: %w", err)
+		}
+	}
+	if err := batch.Send(); err != nil {
+		return fmt.Errorf("failed to save dashboard permissions: %w", err)
+	}
+	return nil
+}
+
+// ValidateDashboardPermissions checks an ACL, returning a *ValidationError
+// listing every invalid entry
+func ValidateDashboardPermissions(entries []DashboardPermission) error {
+	verr := &ValidationError{}
+	if len(entries) > maxDashboardPermissions {
+		verr.add("permissions", "at most %d entries are allowed", maxDashboardPermissions)
+	}
+
+	seen := make(map[string]bool, len(entries))
+	for i, entry := range entries {
+		field := fmt.Sprintf("permissions[%d]", i)
+		if entry.PrincipalType != PrincipalUser && entry.PrincipalType != PrincipalTeam {
+			verr.add(field+".principal_type", "must be %q or %q", PrincipalUser, PrincipalTeam)
+		}
+		if entry.PrincipalId == 0 {
+			verr.add(field+".principal_id", "is required")
+		}
+		if _, ok := dashboardPermissionRank[entry.Permission]; !ok {
+			verr.add(field+".permission", "must be %s, %s or %s", DashboardViewer, DashboardEditor, DashboardAdmin)
+		}
+		if key := principalKey(entry); seen[key] {
+			verr.add(field, "duplicate entry for %s %d", entry.PrincipalType, entry.PrincipalId)
+		} else {
+			seen[key] = true
+		}
+	}
+
+	if len(verr.Errors) > 0 {
+		return verr
+	}
+	return nil
+}
+
+func principalKey(entry DashboardPermission) string {
+	return fmt.Sprintf("%s:%d", entry.PrincipalType, entry.PrincipalId)
+}
+
+// GetDashboardAccess returns the level a principal has on a dashboard
+func (s *Store) GetDashboardAccess(ctx context.Context, dashboard *Dashboard, p DashboardPrincipal) (string, error) {
+	acl, err := s.GetDashboardPermissions(ctx, dashboard.AccountId, dashboard.DashboardId)
+	if err != nil {
+		return "", err
+	}
+	return DashboardAccessLevel(dashboard, acl, p), nil
+}
+
+// FilterDashboardsByAccess keeps the dashboards the principal has at least
+// the wanted level on, setting their Permission
+func (s *Store) FilterDashboardsByAccess(ctx context.Context, accountId uint64, dashboards []Dashboard, p DashboardPrincipal, want string) ([]Dashboard, error) {
+	acls, err := s.dashboardPermissions(ctx, accountId, "")
+	if err != nil {
+		return nil, err
+	}
+
+	filtered := make([]Dashboard, 0, len(dashboards))
+	for _, d := range dashboards {
+		d.Permission = DashboardAccessLevel(&d, acls[d.DashboardId], p)
+		if DashboardPermissionAllows(d.Permission, want) {
+			filtered = append(filtered, d)
+		}
+	}
+	return filtered, nil
+}
@@ -0,0 +1,339 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ========== DASHBOARD SEARCH, STARS AND RECENTLY VIEWED ==========
+
+// Dashboard list orders
+const (
+	DashboardSortName    = "name"
+	DashboardSortUpdated = "updated"
+)
+
+// DashboardSearch filters and orders the dashboards of an account
+type DashboardSearch struct {
+	AccountId uint64
+	UserId    uint64   // marks the dashboards this user starred
+	Query     string   // every word must appear in the name, description, tags or widget titles
+	FolderId  string   // only dashboards in this folder ("" for any folder)
+	Recursive bool     // include the folder's subfolders
+	Tags      []string // dashboards with all of these tags
+	OwnerId   uint64
+	Starred   bool   // only dashboards the user starred
+	Sort      string // name (default), updated; searches order by relevance
+}
+
+// SearchDashboards returns the latest version of the dashboards matching a
+// search. Matching happens in memory on the latest versions, which keeps
+// ranking simple: name matches first, then tags, widget titles and
+// descriptions.
+func (s *Store) SearchDashboards(ctx context.Context, search DashboardSearch) ([]Dashboard, error) {
+	dashboards, err := s.ListDashboards(ctx, search.AccountId)
+	if err != nil {
+		return nil, err
+	}
+
+	starred := map[string]bool{}
+	if search.UserId != 0 {
+		if starred, err = s.starredDashboards(ctx, search.AccountId, search.UserId); err != nil {
+			return nil, err
+		}
+	}
+
+	var folders map[string]bool
+	if search.FolderId != "" {
+		folders = map[string]bool{search.FolderId: true}
+		if search.Recursive {
+			all, err := s.ListFolders(ctx, search.AccountId)
+			if err != nil {
+				return nil, err
+			}
+			folders = descendantFolders(all, search.FolderId)
+		}
+	}
+
+	terms := strings.Fields(strings.ToLower(search.Query))
+	wantTags := NormalizeTags(search.Tags)
+
+	type scored struct {
+		dashboard Dashboard
+		score     int
+	}
+	var matches []scored
+	for _, d := range dashboards {
+		d.Starred = starred[d.DashboardId]
+		if folders != nil && !folders[d.FolderId] {
+			continue
+		}
+		if search.OwnerId != 0 && d.OwnerId != search.OwnerId {
+			continue
+		}
+		if search.Starred && !d.Starred {
+			continue
+		}
+		if !hasAllTags(d.Tags, wantTags) {
+			continue
+		}
+		score, ok := matchDashboard(&d, terms)
+		if !ok {
+			continue
+		}
+		matches = append(matches, scored{d, score})
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		if len(terms) > 0 && a.score != b.score {
+			return a.score > b.score
+		}
+		if search.Sort == DashboardSortUpdated {
+			return a.dashboard.UpdatedAt.After(b.dashboard.UpdatedAt)
+		}
+		return strings.ToLower(a.dashboard.Name) < strings.ToLower(b.dashboard.Name)
+	})
+
+	results := make([]Dashboard, len(matches))
+	for i, m := range matches {
+		results[i] = m.dashboard
+	}
+	return results, nil
+}
+
+// matchDashboard reports whether every term occurs in the dashboard and
+// scores where the terms were found
+func matchDashboard(d *Dashboard, terms []string) (int, bool) {
+	if len(terms) == 0 {
+		return 0, true
+	}
+
+	name := strings.ToLower(d.Name)
+	description := strings.ToLower(d.Description)
+	tags := strings.ToLower(strings.Join(d.Tags, " "))
+	titles := make([]string, 0, len(d.Widgets))
+	for _, w := range d.Widgets {
+		titles = append(titles, strings.ToLower(w.Title))
+	}
+	widgetTitles := strings.Join(titles, "\n")
+
+	score := 0
+	for _, term := range terms {
+		switch {
+		case name == term:
+			score += 100
+		case strings.HasPrefix(name, term):
+			score += 50
+		case strings.Contains(name, term):
+			score += 30
+		case strings.Contains(tags, term):
+			score += 20
+		case strings.Contains(widgetTitles, term):
+			score += 10
+		case strings.Contains(description, term):
+			score += 5
+		default:
+			return 0, false
+		}
+	}
+	return score, true
+}
+
+func hasAllTags(tags, want []string) bool {
+	for _, w := range want {
+		found := false
+		for _, t := range tags {
+			if t == w {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// NormalizeTags lowercases and trims tags, dropping empty and duplicate ones
+func NormalizeTags(tags []string) []string {
+	out := []string{}
+	seen := make(map[string]bool, len(tags))
+	for _, t := range tags {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t == "" || seen[t] {
+			continue
+		}
+		seen[t] = true
+		out = append(out, t)
+	}
+	return out
+}
+
+// ListDashboardTags returns the tags in use with the number of dashboards
+// carrying each
+func (s *Store) ListDashboardTags(ctx context.Context, accountId uint64) (map[string]int, error) {
+	dashboards, err := s.ListDashboards(ctx, accountId)
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int)
+	for _, d := range dashboards {
+		for _, t := range d.Tags {
+			counts[t]++
+		}
+	}
+	return counts, nil
+}
+
+// ========== STARS ==========
+
+// SetDashboardStarred stars or unstars a dashboard for a user
+func (s *Store) SetDashboardStarred(ctx context.Context, accountId, userId uint64, dashboardId string, starred bool) error {
+	query := `
+		INSERT INTO metrics.dashboard_stars
+		(AccountId, UserId, DashboardId, Starred, UpdatedAt)
+		VALUES (?, ?, ?, ?, ?)
+	`
+	if err := s.conn.Exec(ctx, query, accountId, userId, dashboardId, boolToUInt8(starred), time.Now()); err != nil {
+		return fmt.Errorf("failed to star dashboard: %w", err)
+	}
+	return nil
+}
+
+func (s *Store) starredDashboards(ctx context.Context, accountId, userId uint64) (map[string]bool, error) {
+	query := `
+		SELECT DashboardId
+		FROM metrics.dashboard_stars FINAL
+		WHERE AccountId = ? AND UserId = ? AND Starred = 1
+	`
+	rows, err := s.conn.Query(ctx, query, accountId, userId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get starred dashboards: %w", err)
+	}
+	defer rows.Close()
+
+	starred := make(map[string]bool)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		starred[id] = true
+	}
+	return starred, nil
+}
+
+// IsDashboardStarred reports whether a user starred a dashboard
+func (s *Store) IsDashboardStarred(ctx context.Context, accountId, userId uint64, dashboardId string) (bool, error) {
+	starred, err := s.starredDashboards(ctx, accountId, userId)
+	if err != nil {
+		return false, err
+	}
+	return starred[dashboardId], nil
+}
+
+// ========== ACCESS LOG ==========
+
+// DashboardAccess is one view of a dashboard
+type DashboardAccess struct {
+	DashboardId string
+	UserId      uint64
+	AccountId   uint64
+	AccessedAt  time.Time
+	IPAddress   net.IP // IPv6 addresses are stored as 0.0.0.0
+	UserAgent   string
+}
+
+// RecordDashboardAccess appends a view to the access log
+func (s *Store) RecordDashboardAccess(ctx context.Context, access DashboardAccess) error {
+	ip := access.IPAddress.To4()
+	if ip == nil {
+		ip = net.IPv4zero.To4()
+	}
+	if access.AccessedAt.IsZero() {
+		access.AccessedAt = time.Now()
+	}
+
+	query := `
+		INSERT INTO metrics.dashboard_access_log
+		(DashboardId, UserId, AccountId, AccessedAt, IPAddress, UserAgent)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`
+	err := s.conn.Exec(ctx, query,
+		access.DashboardId,
+		access.UserId,
+		access.AccountId,
+		access.AccessedAt,
+		ip,
+		access.UserAgent,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record dashboard access: %w", err)
+	}
+	return nil
+}
+
+// GetRecentlyViewedDashboards returns the dashboards a user viewed most
+// recently, newest first. Deleted dashboards are left out.
+func (s *Store) GetRecentlyViewedDashboards(ctx context.Context, accountId, userId uint64, limit int) ([]Dashboard, error) {
+	query := `
+		SELECT toString(DashboardId), max(AccessedAt) AS last_viewed
+		FROM metrics.dashboard_access_log
+		WHERE AccountId = ? AND UserId = ?
+		GROUP BY DashboardId
+		ORDER BY last_viewed DESC
+		LIMIT ?
+	`
+	// Fetch extra rows so deleted dashboards do not shorten the list
+	rows, err := s.conn.Query(ctx, query, accountId, userId, limit*2)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get recently viewed dashboards: %w", err)
+	}
+	defer rows.Close()
+
+	type view struct {
+		dashboardId string
+		at          time.Time
+	}
+	var views []view
+	for rows.Next() {
+		var v view
+		if err := rows.Scan(&v.dashboardId, &v.at); err != nil {
+			return nil, err
+		}
+		views = append(views, v)
+	}
+	if len(views) == 0 {
+		return []Dashboard{}, nil
+	}
+
+	dashboards, err := s.SearchDashboards(ctx, DashboardSearch{AccountId: accountId, UserId: userId})
+	if err != nil {
+		return nil, err
+	}
+	byId := make(map[string]Dashboard, len(dashboards))
+	for _, d := range dashboards {
+		byId[d.DashboardId] = d
+	}
+
+	recent := []Dashboard{}
+	for _, v := range views {
+		d, ok := byId[v.dashboardId]
+		if !ok {
+			continue
+		}
+		viewedAt := v.at
+		d.LastViewedAt = &viewedAt
+		recent = append(recent, d)
+		if len(recent) == limit {
+			break
+		}
+	}
+	return recent, nil
+}
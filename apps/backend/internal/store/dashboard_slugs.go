@@ -0,0 +1,79 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// maxDashboardSlug bounds the length of derived slugs
+const maxDashboardSlug = 100
+
+var (
+	slugPattern     = regexp.MustCompile(`^[a-z0-9]+(?:-[a-z0-9]+)*$`)
+	slugUnsafeChars = regexp.MustCompile(`[^a-z0-9]+`)
+)
+
+// Slugify derives a URL and file name friendly slug from a dashboard name
+func Slugify(name string) string {
+	slug := strings.Trim(slugUnsafeChars.ReplaceAllString(strings.ToLower(name), "-"), "-")
+	if len(slug) > maxDashboardSlug {
+		slug = strings.TrimRight(slug[:maxDashboardSlug], "-")
+	}
+	if slug == "" {
+		slug = "dashboard"
+	}
+	return slug
+}
+
+// uniqueDashboardSlug returns base, or base with a numeric suffix, such that
+// no dashboard of the account uses it yet
+func (s *Store) uniqueDashboardSlug(ctx context.Context, accountId uint64, base string) (string, error) {
+	query := `
+		SELECT Slug
+		FROM (
+			SELECT argMax(Slug, Version) AS Slug
+			FROM metrics.dashboards
+			WHERE AccountId = ?
+			GROUP BY DashboardId
+		)
+		WHERE Slug = ? OR startsWith(Slug, ?)
+	`
+	rows, err := s.conn.Query(ctx, query, accountId, base, base+"-")
+	if err != nil {
+		return "", fmt.Errorf("failed to check dashboard slugs: %w", err)
+	}
+	defer rows.Close()
+
+	taken := make(map[string]bool)
+	for rows.Next() {
+		var slug string
+		if err := rows.Scan(&slug); err != nil {
+			return "", err
+		}
+		taken[slug] = true
+	}
+
+	slug := base
+	for n := 2; taken[slug]; n++ {
+		slug = fmt.Sprintf("%s-%d", base, n)
+	}
+	return slug, nil
+}
+
+// validateSlugOwner checks that no other dashboard of the account uses the
+// dashboard's slug
+func (s *Store) validateSlugOwner(ctx context.Context, verr *ValidationError, dashboard *Dashboard) error {
+	if dashboard.Slug == "" {
+		return nil
+	}
+	owner, err := s.GetDashboardBySlug(ctx, dashboard.AccountId, dashboard.Slug)
+	if err != nil {
+		return err
+	}
+	if owner != nil && owner.DashboardId != dashboard.DashboardId {
+		verr.add("slug", "already used by dashboard %q", owner.Name)
+	}
+	return nil
+}
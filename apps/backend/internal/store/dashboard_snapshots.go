@@ -0,0 +1,283 @@
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ========== DASHBOARD SNAPSHOTS ==========
+
+// Snapshot expiry bounds
+const (
+	DefaultSnapshotExpiry = 7 * 24 * time.Hour
+	MaxSnapshotExpiry     = 90 * 24 * time.Hour
+)
+
+// DashboardSnapshot is a frozen, read-only copy of a dashboard and the
+// rendered data of its widgets, shared through an unguessable token. Only a
+// hash of the token is stored, so the token itself is returned once, when
+// the snapshot is created.
+type DashboardSnapshot struct {
+	SnapshotId       string         `json:"snapshot_id"`
+	AccountId        uint64         `json:"account_id,omitempty"`
+	DashboardId      string         `json:"dashboard_id"`
+	DashboardVersion uint32         `json:"dashboard_version"`
+	Name             string         `json:"name"`
+	Dashboard        *Dashboard     `json:"dashboard,omitempty"`
+	Data             *DashboardData `json:"data,omitempty"`
+	From             time.Time      `json:"from"`
+	To               time.Time      `json:"to"`
+	CreatedBy        string         `json:"created_by"`
+	CreatedAt        time.Time      `json:"created_at"`
+	ExpiresAt        time.Time      `json:"expires_at"`
+	Token            string         `json:"token,omitempty"` // only set on creation
+}
+
+// PublicSnapshot is a snapshot as served to anyone with its link: the
+// captured content, without the account, owner, permissions or author
+type PublicSnapshot struct {
+	Name      string           `json:"name"`
+	Dashboard *PublicDashboard `json:"dashboard"`
+	Data      *DashboardData   `json:"data"`
+	From      time.Time        `json:"from"`
+	To        time.Time        `json:"to"`
+	CreatedAt time.Time        `json:"created_at"`
+	ExpiresAt time.Time        `json:"expires_at"`
+}
+
+// PublicDashboard is the content of a snapshot's dashboard
+type PublicDashboard struct {
+	Name        string              `json:"name"`
+	Description string              `json:"description"`
+	Widgets     []DashboardWidget   `json:"widgets"`
+	Variables   []DashboardVariable `json:"variables"`
+}
+
+// CreateSnapshotRequest selects what a snapshot captures
+type CreateSnapshotRequest struct {
+	Name      string
+	CreatedBy string
+	ExpiresIn time.Duration // defaults to DefaultSnapshotExpiry, capped at MaxSnapshotExpiry
+	Render    DashboardDataRequest
+}
+
+// CreateDashboardSnapshot renders every widget of a dashboard over a fixed
+// time range and stores the result under a new token
+func (s *Store) CreateDashboardSnapshot(ctx context.Context, dashboard *Dashboard, req CreateSnapshotRequest) (*DashboardSnapshot, error) {
+	expiresIn := req.ExpiresIn
+	if expiresIn <= 0 {
+		expiresIn = DefaultSnapshotExpiry
+	}
+	if expiresIn > MaxSnapshotExpiry {
+		return nil, fmt.Errorf("snapshot expiry must not exceed %s", MaxSnapshotExpiry)
+	}
+
+	// Pin the end of the window so the captured range is exact
+	if req.Render.To.IsZero() {
+		req.Render.To = time.Now()
+	}
+	data, err := s.RenderDashboard(ctx, dashboard, req.Render)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := newSnapshotToken()
+	if err != nil {
+		return nil, err
+	}
+
+	name := req.Name
+	if name == "" {
+		name = dashboard.Name
+	}
+	now := time.Now()
+	snapshot := &DashboardSnapshot{
+		SnapshotId:       uuid.NewString(),
+		AccountId:        dashboard.AccountId,
+		DashboardId:      dashboard.DashboardId,
+		DashboardVersion: dashboard.Version,
+		Name:             name,
+		Dashboard:        dashboard,
+		Data:             data,
+		From:             data.From,
+		To:               data.To,
+		CreatedBy:        req.CreatedBy,
+		CreatedAt:        now,
+		ExpiresAt:        now.Add(expiresIn),
+		Token:            token,
+	}
+
+	dashboardJSON, err := json.Marshal(dashboard)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode snapshot dashboard: %w", err)
+	}
+	dataJSON, err := json.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode snapshot data: %w", err)
+	}
+
+	query := `
+		INSERT INTO metrics.dashboard_snapshots
+		(SnapshotId, TokenHash, AccountId, DashboardId, DashboardVersion, Name, Dashboard, Data, FromTime, ToTime, CreatedBy, CreatedAt, ExpiresAt)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	err = s.conn.Exec(ctx, query,
+		snapshot.SnapshotId,
+		hashSnapshotToken(token),
+		snapshot.AccountId,
+		snapshot.DashboardId,
+		snapshot.DashboardVersion,
+		snapshot.Name,
+		string(dashboardJSON),
+		string(dataJSON),
+		snapshot.From,
+		snapshot.To,
+		snapshot.CreatedBy,
+		snapshot.CreatedAt,
+		snapshot.ExpiresAt,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save snapshot: %w", err)
+	}
+	return snapshot, nil
+}
+
+// GetDashboardSnapshotByToken returns the unexpired snapshot a token refers
+// to, or nil if there is none
+func (s *Store) GetDashboardSnapshotByToken(ctx context.Context, token string) (*PublicSnapshot, error) {
+	query := `
+		SELECT Name, Dashboard, Data, FromTime, ToTime, CreatedAt, ExpiresAt
+		FROM metrics.dashboard_snapshots
+		WHERE TokenHash = ? AND ExpiresAt > now64(3)
+		LIMIT 1
+	`
+	rows, err := s.conn.Query(ctx, query, hashSnapshotToken(token))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get snapshot: %w", err)
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+
+	var snapshot PublicSnapshot
+	var dashboardJSON, dataJSON string
+	if err := rows.Scan(
+		&snapshot.Name,
+		&dashboardJSON,
+		&dataJSON,
+		&snapshot.From,
+		&snapshot.To,
+		&snapshot.CreatedAt,
+		&snapshot.ExpiresAt,
+	); err != nil {
+		return nil, err
+	}
+	// Only the content is decoded, so nothing else the stored dashboard
+	// carries can reach the public
+	if err := json.Unmarshal([]byte(dashboardJSON), &snapshot.Dashboard); err != nil {
+		return nil, fmt.Errorf("failed to decode snapshot dashboard: %w", err)
+	}
+	if err := json.Unmarshal([]byte(dataJSON), &snapshot.Data); err != nil {
+		return nil, fmt.Errorf("failed to decode snapshot data: %w", err)
+	}
+	if snapshot.Data != nil {
+		snapshot.Data.DashboardId = ""
+	}
+	return &snapshot, nil
+}
+
+// ListDashboardSnapshots returns the unexpired snapshots of a dashboard,
+// newest first, without their captured data
+func (s *Store) ListDashboardSnapshots(ctx context.Context, accountId uint64, dashboardId string) ([]DashboardSnapshot, error) {
+	query := `
+		SELECT SnapshotId, AccountId, DashboardId, DashboardVersion, Name, FromTime, ToTime, CreatedBy, CreatedAt, ExpiresAt
+		FROM metrics.dashboard_snapshots
+		WHERE AccountId = ? AND DashboardId = ? AND ExpiresAt > now64(3)
+		ORDER BY CreatedAt DESC
+	`
+	rows, err := s.conn.Query(ctx, query, accountId, dashboardId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snapshots: %w", err)
+	}
+	defer rows.Close()
+
+	snapshots := []DashboardSnapshot{}
+	for rows.Next() {
+		var snapshot DashboardSnapshot
+		if err := rows.Scan(
+			&snapshot.SnapshotId,
+			&snapshot.AccountId,
+			&snapshot.DashboardId,
+			&snapshot.DashboardVersion,
+			&snapshot.Name,
+			&snapshot.From,
+			&snapshot.To,
+			&snapshot.CreatedBy,
+			&snapshot.CreatedAt,
+			&snapshot.ExpiresAt,
+		); err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	return snapshots, nil
+}
+
+// DeleteDashboardSnapshot removes a snapshot, revoking its link
+func (s *Store) DeleteDashboardSnapshot(ctx context.Context, accountId uint64, dashboardId, snapshotId string) error {
+	query := `
+		ALTER TABLE metrics.dashboard_snapshots
+		DELETE WHERE AccountId = ? AND DashboardId = ? AND SnapshotId = ?
+	`
+	if err := s.conn.Exec(ctx, query, accountId, dashboardId, snapshotId); err != nil {
+		return fmt.Errorf("failed to delete snapshot: %w", err)
+	}
+	return nil
+}
+
+// DeleteExpiredSnapshots removes snapshots past their expiry. Expired
+// snapshots are never served, and the table TTL drops them eventually;
+// this frees the space right away.
+func (s *Store) DeleteExpiredSnapshots(ctx context.Context) (uint64, error) {
+	var expired uint64
+	err := s.conn.QueryRow(ctx, `SELECT count() FROM metrics.dashboard_snapshots WHERE ExpiresAt <= now64(3)`).Scan(&expired)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count expired snapshots: %w", err)
+	}
+	if expired == 0 {
+		return 0, nil
+	}
+
+	query := `
+		ALTER TABLE metrics.dashboard_snapshots
+		DELETE WHERE ExpiresAt <= now64(3)
+	`
+	if err := s.conn.Exec(ctx, query); err != nil {
+		return 0, fmt.Errorf("failed to delete expired snapshots: %w", err)
+	}
+	return expired, nil
+}
+
+// newSnapshotToken returns 256 random bits, URL-safe encoded
+func newSnapshotToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate snapshot token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashSnapshotToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,350 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// dashboardGridColumns is the width of the dashboard layout grid
+const dashboardGridColumns = 24
+
+// Dashboard tag limits
+const (
+	maxDashboardTags      = 20
+	maxDashboardTagLength = 50
+)
+
+var (
+	knownWidgetTypes  = map[string]bool{"chart": true, "metric": true, "table": true}
+	knownChartTypes   = map[string]bool{"line": true, "bar": true, "area": true, "pie": true, "scatter": true, "heatmap": true, "gauge": true}
+	knownAggregations = map[string]bool{"avg": true, "sum": true, "min": true, "max": true, "count": true, "p50": true, "p95": true, "p99": true}
+
+	timeRangePattern       = regexp.MustCompile(`^[1-9][0-9]*[mhd]$`)
+	refreshIntervalPattern = regexp.MustCompile(`^[1-9][0-9]*[smh]$`)
+)
+
+// FieldError describes a single invalid field of a request
+type FieldError struct {
+	Field   string `json:"field"` // e.g., "widgets[2].queries[0].metric_name"
+	Message string `json:"message"`
+}
+
+// ValidationError collects every field error found while validating
+type ValidationError struct {
+	Errors []FieldError `json:"errors"`
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, fe := range e.Errors {
+		msgs = append(msgs, fe.Field+": "+fe.Message)
+	}
+	return "validation failed: " + strings.Join(msgs, "; ")
+}
+
+func (e *ValidationError) add(field, format string, args ...interface{}) {
+	e.Errors = append(e.Errors, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// ValidateDashboard checks a dashboard and its widgets against the widget
+// schema, its slug and its folder. It returns a *ValidationError listing
+// every invalid field, or a plain error if they could not be checked.
+// Metrics without data are not errors, see UnknownDashboardMetrics.
+func (s *Store) ValidateDashboard(ctx context.Context, dashboard *Dashboard) error {
+	verr, _ := validateDashboardSchema(dashboard)
+
+	if err := s.validateSlugOwner(ctx, verr, dashboard); err != nil {
+		return err
+	}
+	folderErr, err := s.validateFolder(ctx, dashboard)
+	if err != nil {
+		return err
+	}
+	if folderErr != nil {
+		verr.Errors = append(verr.Errors, *folderErr)
+	}
+
+	if len(verr.Errors) > 0 {
+		return verr
+	}
+	return nil
+}
+
+// ValidateDashboardSchema checks a dashboard against the widget schema only,
+// without requiring its metrics to exist
+func ValidateDashboardSchema(dashboard *Dashboard) error {
+	if verr, _ := validateDashboardSchema(dashboard); len(verr.Errors) > 0 {
+		return verr
+	}
+	return nil
+}
+
+// UnknownDashboardMetrics lists the query fields of a dashboard that name a
+// metric without recent data for the account and not recorded by any of its
+// recording rules. Such metrics may be seasonal or not emitted yet, so they
+// are warnings rather than errors.
+func (s *Store) UnknownDashboardMetrics(ctx context.Context, dashboard *Dashboard) ([]FieldError, error) {
+	_, metricFields := validateDashboardSchema(dashboard)
+	return s.unknownMetricFields(ctx, dashboard.AccountId, metricFields)
+}
+
+// validateDashboardSchema returns the schema errors of a dashboard and the
+// query fields using each metric name
+func validateDashboardSchema(dashboard *Dashboard) (*ValidationError, map[string][]string) {
+	verr := &ValidationError{}
+
+	if strings.TrimSpace(dashboard.Name) == "" {
+		verr.add("name", "is required")
+	}
+	if dashboard.Slug != "" && !slugPattern.MatchString(dashboard.Slug) {
+		verr.add("slug", "must be lowercase letters, digits and single dashes")
+	}
+
+	if len(dashboard.Tags) > maxDashboardTags {
+		verr.add("tags", "at most %d tags are allowed", maxDashboardTags)
+	}
+	for i, tag := range dashboard.Tags {
+		if len(tag) > maxDashboardTagLength {
+			verr.add(fmt.Sprintf("tags[%d]", i), "must be at most %d characters", maxDashboardTagLength)
+		}
+	}
+
+	variables := validateVariables(verr, dashboard.Variables)
+	multi := multiValueVariables(dashboard.Variables)
+
+	widgetIds := make(map[string]int)
+	metricFields := make(map[string][]string) // metric name -> fields using it
+
+	for i, widget := range dashboard.Widgets {
+		field := fmt.Sprintf("widgets[%d]", i)
+
+		if widget.WidgetId == "" {
+			verr.add(field+".widget_id", "is required")
+		} else if prev, dup := widgetIds[widget.WidgetId]; dup {
+			verr.add(field+".widget_id", "duplicates widgets[%d]", prev)
+		} else {
+			widgetIds[widget.WidgetId] = i
+		}
+
+		if !knownWidgetTypes[widget.WidgetType] {
+			verr.add(field+".widget_type", "unknown widget type %q", widget.WidgetType)
+		}
+		chartType := widget.Visualization.ChartType
+		if widget.WidgetType == "chart" && chartType == "" {
+			verr.add(field+".visualization.chart_type", "is required for chart widgets")
+		} else if chartType != "" && !knownChartTypes[chartType] {
+			verr.add(field+".visualization.chart_type", "unknown chart type %q", chartType)
+		}
+
+		validateWidgetQueries(verr, field, widget.Queries, variables, multi, metricFields)
+		validateWidgetLayout(verr, field+".layout", widget.Layout)
+
+		if tr := widget.TimeConfig.TimeRange; tr != "" && !timeRangePattern.MatchString(tr) {
+			verr.add(field+".time_config.time_range", "invalid time range %q, expected e.g. 15m, 1h, 7d", tr)
+		}
+		if ri := widget.TimeConfig.RefreshInterval; ri != "" && ri != "off" && !refreshIntervalPattern.MatchString(ri) {
+			verr.add(field+".time_config.refresh_interval", "invalid refresh interval %q, expected e.g. 30s, 1m, off", ri)
+		}
+	}
+
+	validateLayoutOverlaps(verr, dashboard.Widgets)
+
+	return verr, metricFields
+}
+
+// unknownMetricFields returns an error for every field naming a metric
+// without data, in metric name order
+func (s *Store) unknownMetricFields(ctx context.Context, accountId uint64, metricFields map[string][]string) ([]FieldError, error) {
+	if len(metricFields) == 0 {
+		return nil, nil
+	}
+
+	names := make([]string, 0, len(metricFields))
+	for name := range metricFields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	existing, err := s.existingMetricNames(ctx, accountId, names)
+	if err != nil {
+		return nil, err
+	}
+
+	var unknown []FieldError
+	for _, name := range names {
+		if existing[name] {
+			continue
+		}
+		for _, field := range metricFields[name] {
+			unknown = append(unknown, FieldError{Field: field, Message: fmt.Sprintf("unknown metric %q", name)})
+		}
+	}
+	return unknown, nil
+}
+
+func validateWidgetQueries(verr *ValidationError, field string, queries []MetricQuery, variables, multi map[string]bool, metricFields map[string][]string) {
+	refs := make(map[string]bool)
+	for j, q := range queries {
+		qfield := fmt.Sprintf("%s.queries[%d]", field, j)
+
+		if q.Logs != nil {
+			// Log queries using variables are only checked once substituted
+			checked := q
+			if len(variableRefs(q.Logs.Query)) > 0 {
+				logs := *q.Logs
+				logs.Query = ""
+				checked.Logs = &logs
+			}
+			if err := validateLogQuery(checked); err != nil {
+				verr.add(qfield+".logs", "%v", err)
+			}
+		} else if q.MetricName == "" && q.Formula == "" {
+			verr.add(qfield+".metric_name", "is required unless a formula or logs is given")
+		}
+		if q.MetricName != "" && q.Logs == nil {
+			metricFields[q.MetricName] = append(metricFields[q.MetricName], qfield+".metric_name")
+		} else if q.Formula != "" && len(variableRefs(q.Formula)) == 0 {
+			// Formulas using variables are only checked once substituted
+			_, used, err := parseFormula(q.Formula)
+			if err != nil {
+				verr.add(qfield+".formula", "%v", err)
+			}
+			for _, ref := range used {
+				if !refs[ref] {
+					verr.add(qfield+".formula", "references unknown query %q", ref)
+				}
+			}
+		}
+		if q.Logs == nil && q.Aggregation != "" && !knownAggregations[q.Aggregation] {
+			verr.add(qfield+".aggregation", "unknown aggregation %q", q.Aggregation)
+		}
+
+		// Every variable reference must name a dashboard variable
+		templated := [][2]string{{"formula", q.Formula}, {"alias", q.Alias}}
+		if q.Logs != nil {
+			templated = append(templated, [2]string{"logs.query", q.Logs.Query}, [2]string{"logs.field", q.Logs.Field})
+		}
+		filterKeys := make([]string, 0, len(q.Filters))
+		for key := range q.Filters {
+			filterKeys = append(filterKeys, key)
+		}
+		sort.Strings(filterKeys)
+		for _, key := range filterKeys {
+			templated = append(templated, [2]string{"filters." + key, q.Filters[key]})
+		}
+		for k, key := range q.GroupBy {
+			templated = append(templated, [2]string{fmt.Sprintf("group_by[%d]", k), key})
+		}
+		for _, t := range templated {
+			for _, ref := range variableRefs(t[1]) {
+				if !variables[ref] {
+					verr.add(qfield+"."+t[0], "references undefined variable %q", ref)
+				}
+			}
+		}
+
+		// Multi-value variables only fit where a list of values does
+		for _, t := range templated {
+			if t[0] == "alias" || t[0] == "logs.query" {
+				continue
+			}
+			if _, whole := wholeVariableRef(t[1]); whole && (strings.HasPrefix(t[0], "filters.") || strings.HasPrefix(t[0], "group_by[")) {
+				continue
+			}
+			for _, ref := range variableRefs(t[1]) {
+				if multi[ref] {
+					verr.add(qfield+"."+t[0], "variable %q can take several values, which cannot be used here", ref)
+				}
+			}
+		}
+		for _, key := range filterKeys {
+			for _, ref := range variableRefs(key) {
+				if multi[ref] {
+					verr.add(qfield+".filters."+key, "variable %q can take several values, which a filter key cannot", ref)
+				}
+			}
+		}
+		if q.Logs != nil {
+			for _, ref := range misplacedLogQueryRefs(q.Logs.Query, func(name string) bool { return multi[name] }) {
+				verr.add(qfield+".logs.query", "variable %q can take several values, so it must be a whole term such as field:$%s", ref, ref)
+			}
+		}
+
+		ref := q.MetricName
+		if q.Alias != "" {
+			ref = q.Alias
+		}
+		refs[ref] = true
+	}
+}
+
+func validateWidgetLayout(verr *ValidationError, field string, layout WidgetLayout) {
+	if layout.X < 0 || layout.Y < 0 {
+		verr.add(field, "x and y must not be negative")
+	}
+	if layout.W <= 0 || layout.H <= 0 {
+		verr.add(field, "w and h must be positive")
+	}
+	if layout.X+layout.W > dashboardGridColumns {
+		verr.add(field, "exceeds the %d column grid", dashboardGridColumns)
+	}
+}
+
+func validateLayoutOverlaps(verr *ValidationError, widgets []DashboardWidget) {
+	for i := range widgets {
+		a := widgets[i].Layout
+		if a.W <= 0 || a.H <= 0 {
+			continue
+		}
+		for j := i + 1; j < len(widgets); j++ {
+			b := widgets[j].Layout
+			if b.W <= 0 || b.H <= 0 {
+				continue
+			}
+			if a.X < b.X+b.W && b.X < a.X+a.W && a.Y < b.Y+b.H && b.Y < a.Y+a.H {
+				verr.add(fmt.Sprintf("widgets[%d].layout", j), "overlaps widgets[%d]", i)
+			}
+		}
+	}
+}
+
+// existingMetricNames reports which of the given metric names have data
+// for the account within the metrics retention window, or are recorded by
+// one of its recording rules, which may not have been evaluated yet
+func (s *Store) existingMetricNames(ctx context.Context, accountId uint64, names []string) (map[string]bool, error) {
+	query := `
+		SELECT DISTINCT MetricName
+		FROM metrics.metrics_v1
+		WHERE AccountId = ?
+		  AND has(?, MetricName)
+		  AND Timestamp > now() - INTERVAL 30 DAY
+	`
+	rows, err := s.conn.Query(ctx, query, accountId, names)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check metric names: %w", err)
+	}
+	defer rows.Close()
+
+	existing := make(map[string]bool, len(names))
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		existing[name] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rules, err := s.ListRecordingRules(ctx, accountId)
+	if err != nil {
+		return nil, err
+	}
+	for _, rule := range rules {
+		existing[rule.Record] = true
+	}
+	return existing, nil
+}
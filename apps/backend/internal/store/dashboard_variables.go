@@ -0,0 +1,450 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// VariableAllValue selects every option of a variable that includes "All"
+const VariableAllValue = "$__all"
+
+var (
+	knownVariableSources = map[string]bool{"label_values": true, "metric_names": true}
+
+	variableNamePattern = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_]*$`)
+	variableRefPattern  = regexp.MustCompile(`\$\{([a-zA-Z][a-zA-Z0-9_]*)\}|\$([a-zA-Z][a-zA-Z0-9_]*)`)
+)
+
+// variableValue is the effective value of a variable at query time
+type variableValue struct {
+	values []string
+	all    bool // "All" without an AllValue: filters on it are dropped
+}
+
+// variableScope maps variable names to their effective values
+type variableScope map[string]variableValue
+
+// ResolveDashboardVariables returns the options and effective selection of
+// every variable, given the selected values by variable name
+func (s *Store) ResolveDashboardVariables(ctx context.Context, accountId uint64, vars []DashboardVariable, selected map[string][]string) ([]ResolvedVariable, error) {
+	resolved := make([]ResolvedVariable, 0, len(vars))
+	for _, v := range vars {
+		options, err := s.variableOptions(ctx, accountId, v)
+		if err != nil {
+			return nil, err
+		}
+		resolved = append(resolved, ResolvedVariable{
+			Name:       v.Name,
+			Label:      v.Label,
+			Type:       v.Type,
+			Options:    options,
+			Current:    selectVariableValues(v, selected[v.Name], options),
+			Multi:      v.Multi,
+			IncludeAll: v.IncludeAll,
+		})
+	}
+	return resolved, nil
+}
+
+// variableOptions lists the selectable values of a variable
+func (s *Store) variableOptions(ctx context.Context, accountId uint64, v DashboardVariable) ([]string, error) {
+	if v.Type != "query" {
+		return v.Options, nil
+	}
+	if v.Query == nil {
+		return nil, fmt.Errorf("variable %s has no query", v.Name)
+	}
+
+	options := []string{}
+	switch v.Query.Source {
+	case "label_values":
+		values, err := s.GetLabelValues(ctx, accountId, v.Query.MetricName, v.Query.LabelKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve variable %s: %w", v.Name, err)
+		}
+		for _, lv := range values {
+			options = append(options, lv.Value)
+		}
+	case "metric_names":
+		names, err := s.GetMetricNames(ctx, accountId)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve variable %s: %w", v.Name, err)
+		}
+		for _, n := range names {
+			options = append(options, n.Name)
+		}
+	default:
+		return nil, fmt.Errorf("variable %s has unknown query source %q", v.Name, v.Query.Source)
+	}
+	return options, nil
+}
+
+// selectVariableValues applies defaults to a selection: the variable's
+// current value, then "All", then the first option. Single-value variables
+// keep only the first selected value.
+func selectVariableValues(v DashboardVariable, selected []string, options []string) []string {
+	current := selected
+	if len(current) == 0 {
+		current = v.Current
+	}
+	if len(current) == 0 {
+		if v.IncludeAll {
+			current = []string{VariableAllValue}
+		} else if len(options) > 0 {
+			current = options[:1]
+		}
+	}
+	if !v.Multi && len(current) > 1 {
+		current = current[:1]
+	}
+	return current
+}
+
+func isAllSelection(values []string) bool {
+	for _, v := range values {
+		if v == VariableAllValue {
+			return true
+		}
+	}
+	return false
+}
+
+// buildVariableScope resolves the effective value of every variable. Query
+// options are only fetched when "All" or a default option is needed.
+func (s *Store) buildVariableScope(ctx context.Context, accountId uint64, vars []DashboardVariable, selected map[string][]string) (variableScope, error) {
+	scope := make(variableScope, len(vars))
+	for _, v := range vars {
+		var options []string
+		current := selectVariableValues(v, selected[v.Name], nil)
+		all := v.IncludeAll && isAllSelection(current)
+		if len(current) == 0 || (all && v.AllValue == "") {
+			var err error
+			if options, err = s.variableOptions(ctx, accountId, v); err != nil {
+				return nil, err
+			}
+			current = selectVariableValues(v, selected[v.Name], options)
+		}
+
+		switch {
+		case all && v.AllValue != "":
+			scope[v.Name] = variableValue{values: []string{v.AllValue}}
+		case all:
+			scope[v.Name] = variableValue{values: options, all: true}
+		default:
+			scope[v.Name] = variableValue{values: current}
+		}
+	}
+	return scope, nil
+}
+
+// wholeVariableRef reports whether text is exactly one variable reference
+func wholeVariableRef(text string) (string, bool) {
+	m := variableRefPattern.FindStringSubmatchIndex(text)
+	if m == nil || m[0] != 0 || m[1] != len(text) {
+		return "", false
+	}
+	return variableRefName(text, m), true
+}
+
+// variableRefs returns the names of all variables referenced in text
+func variableRefs(text string) []string {
+	var names []string
+	for _, m := range variableRefPattern.FindAllStringSubmatchIndex(text, -1) {
+		names = append(names, variableRefName(text, m))
+	}
+	return names
+}
+
+// multiValued reports whether the variable stands for more than one value
+func (v variableValue) multiValued() bool {
+	return v.all || len(v.values) > 1
+}
+
+// isMulti reports whether the named variable has several values
+func (scope variableScope) isMulti(name string) bool {
+	val, ok := scope[name]
+	return ok && val.multiValued()
+}
+
+// interpolate replaces variable references in text, joining multiple
+// values with commas. It is only used for display text such as aliases.
+// Unknown variables are left untouched.
+func (scope variableScope) interpolate(text string) string {
+	if !strings.Contains(text, "$") {
+		return text
+	}
+	return variableRefPattern.ReplaceAllStringFunc(text, func(ref string) string {
+		name, _ := wholeVariableRef(ref)
+		val, ok := scope[name]
+		if !ok {
+			return ref
+		}
+		return strings.Join(val.values, ",")
+	})
+}
+
+// interpolateSingle replaces variable references in text where only one
+// value can stand, adding an error to verr for a variable with several
+func (scope variableScope) interpolateSingle(verr *ValidationError, field, text string) string {
+	for _, name := range variableRefs(text) {
+		if scope.isMulti(name) {
+			verr.add(field, "variable %q has several values, which cannot be used here", name)
+			return text
+		}
+	}
+	return scope.interpolate(text)
+}
+
+// interpolateLogQuery substitutes variables into a log query. A term that
+// is a multi-value variable alone, as field:$name or $name, matches any of
+// its values; a multi-value variable anywhere else is an error in verr.
+func (scope variableScope) interpolateLogQuery(verr *ValidationError, field, query string) string {
+	if misplaced := misplacedLogQueryRefs(query, scope.isMulti); len(misplaced) > 0 {
+		for _, name := range misplaced {
+			verr.add(field, "variable %q has several values, so it must be a whole term such as field:$%s", name, name)
+		}
+		return query
+	}
+
+	var b strings.Builder
+	last := 0
+	for _, m := range variableRefPattern.FindAllStringSubmatchIndex(query, -1) {
+		name := variableRefName(query, m)
+		val, ok := scope[name]
+		if !ok {
+			continue
+		}
+		if !val.multiValued() {
+			b.WriteString(query[last:m[0]])
+			b.WriteString(strings.Join(val.values, ","))
+			last = m[1]
+			continue
+		}
+
+		termStart, termField := logQueryTerm(query, m[0])
+		b.WriteString(query[last:termStart])
+		if termField == "" {
+			termField = "body"
+		}
+		terms := make([]string, 0, len(val.values))
+		if val.all {
+			// Every value: whether the field is set or not
+			terms = append(terms, termField+":*", "-"+termField+":*")
+		} else {
+			for _, value := range val.values {
+				terms = append(terms, termField+":"+logQueryQuote(value))
+			}
+		}
+		b.WriteString("(" + strings.Join(terms, " OR ") + ")")
+		last = m[1]
+	}
+	b.WriteString(query[last:])
+	return b.String()
+}
+
+// misplacedLogQueryRefs returns the multi-value variables a log query
+// uses other than as a whole term
+func misplacedLogQueryRefs(query string, multi func(string) bool) []string {
+	var misplaced []string
+	for _, m := range variableRefPattern.FindAllStringSubmatchIndex(query, -1) {
+		name := variableRefName(query, m)
+		if !multi(name) {
+			continue
+		}
+		start, _ := logQueryTerm(query, m[0])
+		next, _ := utf8.DecodeRuneInString(query[m[1]:])
+		if start < 0 || (m[1] < len(query) && !unicode.IsSpace(next) && next != ')') {
+			misplaced = append(misplaced, name)
+		}
+	}
+	return misplaced
+}
+
+// logQueryTerm returns where the term whose value starts at ref begins,
+// and the term's field if it has one. The start is -1 when ref is not
+// the start of an unquoted value.
+func logQueryTerm(query string, ref int) (int, string) {
+	if logQueryQuoted(query, ref) {
+		return -1, ""
+	}
+	start, field := ref, ""
+	if start > 0 && query[start-1] == ':' {
+		i := start - 1
+		for i > 0 {
+			r, size := utf8.DecodeLastRuneInString(query[:i])
+			if !isLogQueryFieldRune(r) {
+				break
+			}
+			i -= size
+		}
+		for i < start-1 && query[i] == '-' {
+			i++ // negation, not part of the field
+		}
+		if i == start-1 {
+			return -1, ""
+		}
+		start, field = i, query[i:ref-1]
+	}
+	if start > 0 {
+		prev, _ := utf8.DecodeLastRuneInString(query[:start])
+		if !unicode.IsSpace(prev) && prev != '(' && prev != '-' {
+			return -1, ""
+		}
+	}
+	return start, field
+}
+
+// logQueryQuoted reports whether pos is inside a quoted phrase or regex
+func logQueryQuoted(query string, pos int) bool {
+	var delim rune
+	escaped, valueStart := false, true
+	for _, r := range query[:pos] {
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case delim != 0 && r == delim:
+			delim = 0
+		case delim == 0 && valueStart && (r == '"' || r == '/'):
+			delim = r
+		}
+		valueStart = unicode.IsSpace(r) || r == '(' || r == '-' || r == ':'
+	}
+	return delim != 0
+}
+
+// logQueryQuote quotes a value for a log query
+func logQueryQuote(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
+
+// variableRefName returns the variable name of a variableRefPattern match
+func variableRefName(text string, m []int) string {
+	if m[2] >= 0 {
+		return text[m[2]:m[3]]
+	}
+	return text[m[4]:m[5]]
+}
+
+// applyToQueries substitutes variables into filters, group-by keys,
+// formulas, aliases and log queries. A filter whose value is a single
+// variable matches any of its selected values, or is dropped when the
+// variable is "All"; a group-by key that is a single variable groups by
+// each of its values. Multi-value variables anywhere a list cannot stand
+// are reported as a *ValidationError.
+func (scope variableScope) applyToQueries(queries []MetricQuery) ([]MetricQuery, error) {
+	verr := &ValidationError{}
+	resolved := make([]MetricQuery, len(queries))
+	for i, q := range queries {
+		qfield := fmt.Sprintf("queries[%d]", i)
+		r := q
+		r.Filters = make(map[string]string, len(q.Filters))
+		r.multiFilters = nil
+		for key, value := range q.Filters {
+			if name, whole := wholeVariableRef(value); whole {
+				if val, ok := scope[name]; ok {
+					switch {
+					case val.all:
+					case len(val.values) == 1:
+						r.Filters[key] = val.values[0]
+					default:
+						if r.multiFilters == nil {
+							r.multiFilters = make(map[string][]string)
+						}
+						r.multiFilters[key] = val.values
+					}
+					continue
+				}
+			}
+			field := qfield + ".filters." + key
+			r.Filters[scope.interpolateSingle(verr, field, key)] = scope.interpolateSingle(verr, field, value)
+		}
+
+		r.GroupBy = nil
+		for k, key := range q.GroupBy {
+			if name, whole := wholeVariableRef(key); whole {
+				if val, ok := scope[name]; ok {
+					r.GroupBy = append(r.GroupBy, val.values...)
+					continue
+				}
+			}
+			r.GroupBy = append(r.GroupBy, scope.interpolateSingle(verr, fmt.Sprintf("%s.group_by[%d]", qfield, k), key))
+		}
+
+		r.Formula = scope.interpolateSingle(verr, qfield+".formula", q.Formula)
+		r.Alias = scope.interpolate(q.Alias)
+		if q.Logs != nil {
+			logs := *q.Logs
+			logs.Query = scope.interpolateLogQuery(verr, qfield+".logs.query", q.Logs.Query)
+			logs.Field = scope.interpolateSingle(verr, qfield+".logs.field", q.Logs.Field)
+			r.Logs = &logs
+		}
+		resolved[i] = r
+	}
+	if len(verr.Errors) > 0 {
+		return nil, verr
+	}
+	return resolved, nil
+}
+
+// multiValueVariables returns the names of the variables that can stand
+// for several values: multi-select ones, and "All" without an AllValue
+func multiValueVariables(vars []DashboardVariable) map[string]bool {
+	multi := make(map[string]bool)
+	for _, v := range vars {
+		if v.Multi || (v.IncludeAll && v.AllValue == "") {
+			multi[v.Name] = true
+		}
+	}
+	return multi
+}
+
+// validateVariables checks variable definitions and returns the set of
+// defined variable names
+func validateVariables(verr *ValidationError, vars []DashboardVariable) map[string]bool {
+	defined := make(map[string]bool, len(vars))
+	for i, v := range vars {
+		field := fmt.Sprintf("variables[%d]", i)
+
+		if !variableNamePattern.MatchString(v.Name) {
+			verr.add(field+".name", "must start with a letter and contain only letters, digits and underscores")
+		} else if defined[v.Name] {
+			verr.add(field+".name", "duplicate variable %q", v.Name)
+		}
+		defined[v.Name] = true
+
+		switch v.Type {
+		case "query":
+			if v.Query == nil || !knownVariableSources[v.Query.Source] {
+				verr.add(field+".query.source", "must be one of label_values, metric_names")
+			} else if v.Query.Source == "label_values" && (v.Query.MetricName == "" || v.Query.LabelKey == "") {
+				verr.add(field+".query", "label_values needs metric_name and label_key")
+			}
+		case "custom":
+			if len(v.Options) == 0 {
+				verr.add(field+".options", "custom variables need at least one option")
+			}
+		case "constant":
+			if len(v.Options) != 1 {
+				verr.add(field+".options", "constant variables need exactly one option")
+			}
+		case "interval":
+			if len(v.Options) == 0 {
+				verr.add(field+".options", "interval variables need at least one option")
+			}
+			for j, opt := range v.Options {
+				if !refreshIntervalPattern.MatchString(opt) {
+					verr.add(fmt.Sprintf("%s.options[%d]", field, j), "invalid interval %q, expected e.g. 30s, 1m, 1h", opt)
+				}
+			}
+		default:
+			verr.add(field+".type", "unknown variable type %q", v.Type)
+		}
+	}
+	return defined
+}
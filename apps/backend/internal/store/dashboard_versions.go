@@ -0,0 +1,334 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+)
+
+// ========== DASHBOARD VERSION HISTORY ==========
+
+// ListDashboardVersions returns the version history of a dashboard, newest first
+func (s *Store) ListDashboardVersions(ctx context.Context, accountId uint64, dashboardId string) ([]DashboardVersion, error) {
+	query := `
+		SELECT DashboardId, Version, Name, Author, ChangeMessage, UpdatedAt
+		FROM metrics.dashboards
+		WHERE AccountId = ? AND DashboardId = ?
+		ORDER BY Version DESC, UpdatedAt DESC
+	`
+
+	rows, err := s.conn.Query(ctx, query, accountId, dashboardId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list dashboard versions: %w", err)
+	}
+	defer rows.Close()
+
+	var versions []DashboardVersion
+	for rows.Next() {
+		var v DashboardVersion
+		if err := rows.Scan(&v.DashboardId, &v.Version, &v.Name, &v.Author, &v.ChangeMessage, &v.SavedAt); err != nil {
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+	return versions, nil
+}
+
+// GetDashboardVersion retrieves a specific version of a dashboard
+func (s *Store) GetDashboardVersion(ctx context.Context, accountId uint64, dashboardId string, version uint32) (*Dashboard, error) {
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM metrics.dashboards
+		WHERE AccountId = ? AND DashboardId = ? AND Version = ?
+		ORDER BY UpdatedAt DESC
+		LIMIT 1
+	`, dashboardColumns)
+
+	dashboard, err := scanDashboard(s.conn.QueryRow(ctx, query, accountId, dashboardId, version))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get dashboard version %d: %w", version, err)
+	}
+	return dashboard, nil
+}
+
+// RestoreDashboardVersion saves an old version as the new latest version,
+// only if the latest version is still expectedVersion. It returns a
+// *VersionConflictError otherwise.
+func (s *Store) RestoreDashboardVersion(ctx context.Context, accountId uint64, dashboardId string, version, expectedVersion uint32, author string) (*Dashboard, error) {
+	dashboard, err := s.GetDashboardVersion(ctx, accountId, dashboardId, version)
+	if err != nil {
+		return nil, err
+	}
+
+	// Restoring brings back the content only: where the dashboard lives, who
+	// owns it and how it is managed stay as they are now
+	current, err := s.GetDashboard(ctx, accountId, dashboardId)
+	if err != nil {
+		return nil, err
+	}
+	dashboard.FolderId = current.FolderId
+	dashboard.OwnerId = current.OwnerId
+	dashboard.Slug = current.Slug
+	dashboard.Source = current.Source
+	dashboard.SourcePath = current.SourcePath
+	dashboard.ReadOnly = current.ReadOnly
+	dashboard.Tags = current.Tags
+
+	// Versions saved before the current schema may no longer be valid
+	if err := ValidateDashboardSchema(dashboard); err != nil {
+		return nil, err
+	}
+
+	dashboard.Author = author
+	dashboard.ChangeMessage = fmt.Sprintf("Restored version %d", version)
+	if err := s.SaveDashboardIfVersion(ctx, dashboard, expectedVersion); err != nil {
+		return nil, err
+	}
+	return dashboard, nil
+}
+
+// backfillDashboardVersions gives distinct version numbers to the rows of
+// dashboards that share one: rows saved before versions existed all
+// default to version 1. Rows keep their order, by version and then by when
+// they were saved.
+func (s *Store) backfillDashboardVersions(ctx context.Context) error {
+	rows, err := s.conn.Query(ctx, `
+		SELECT DISTINCT AccountId, toString(DashboardId)
+		FROM metrics.dashboards
+		GROUP BY AccountId, DashboardId, Version
+		HAVING count() > 1
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to find dashboards to renumber: %w", err)
+	}
+	type dashboardKey struct {
+		accountId   uint64
+		dashboardId string
+	}
+	var dashboards []dashboardKey
+	for rows.Next() {
+		var k dashboardKey
+		if err := rows.Scan(&k.accountId, &k.dashboardId); err != nil {
+			rows.Close()
+			return err
+		}
+		dashboards = append(dashboards, k)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, k := range dashboards {
+		if err := s.renumberDashboardVersions(ctx, k.accountId, k.dashboardId); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// renumberDashboardVersions numbers a dashboard's rows 1, 2, ... in order.
+// Rows alike in every field saved keep sharing a number, as there is no
+// telling them apart.
+func (s *Store) renumberDashboardVersions(ctx context.Context, accountId uint64, dashboardId string) error {
+	const rowKey = "Version, toUnixTimestamp64Milli(UpdatedAt), SaveId, cityHash64(Name, Description, Config, Author, ChangeMessage)"
+	rows, err := s.conn.Query(ctx, fmt.Sprintf(`
+		SELECT DISTINCT %s
+		FROM metrics.dashboards
+		WHERE AccountId = ? AND DashboardId = ?
+		ORDER BY %s
+	`, rowKey, rowKey), accountId, dashboardId)
+	if err != nil {
+		return fmt.Errorf("failed to read dashboard versions: %w", err)
+	}
+	defer rows.Close()
+
+	var branches []string
+	var args []interface{}
+	var next uint32
+	for rows.Next() {
+		var version uint32
+		var updatedAt int64
+		var saveId string
+		var hash uint64
+		if err := rows.Scan(&version, &updatedAt, &saveId, &hash); err != nil {
+			return err
+		}
+		next++
+		if version != next {
+			branches = append(branches, "("+rowKey+") = (?, ?, ?, ?), ?")
+			args = append(args, version, updatedAt, saveId, hash, next)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(branches) == 0 {
+		return nil
+	}
+
+	// Wait for the mutation, so versions are distinct once the store is up
+	ctx = clickhouse.Context(ctx, clickhouse.WithSettings(clickhouse.Settings{
+		"mutations_sync": 2,
+	}))
+	query := fmt.Sprintf(`
+		ALTER TABLE metrics.dashboards
+		UPDATE Version = toUInt32(multiIf(%s, Version))
+		WHERE AccountId = ? AND DashboardId = ?
+	`, strings.Join(branches, ", "))
+	if err := s.conn.Exec(ctx, query, append(args, accountId, dashboardId)...); err != nil {
+		return fmt.Errorf("failed to renumber dashboard versions: %w", err)
+	}
+	return nil
+}
+
+// DiffDashboardVersions compares two versions of a dashboard
+func (s *Store) DiffDashboardVersions(ctx context.Context, accountId uint64, dashboardId string, fromVersion, toVersion uint32) (*DashboardDiff, error) {
+	from, err := s.GetDashboardVersion(ctx, accountId, dashboardId, fromVersion)
+	if err != nil {
+		return nil, err
+	}
+	to, err := s.GetDashboardVersion(ctx, accountId, dashboardId, toVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := &DashboardDiff{
+		DashboardId: dashboardId,
+		FromVersion: fromVersion,
+		ToVersion:   toVersion,
+		Changes:     []DashboardChange{},
+	}
+	diffValues("name", from.Name, to.Name, &diff.Changes)
+	diffValues("description", from.Description, to.Description, &diff.Changes)
+	diffValues("tags", strings.Join(from.Tags, ", "), strings.Join(to.Tags, ", "), &diff.Changes)
+	diffValues("folder_id", from.FolderId, to.FolderId, &diff.Changes)
+	diffConfigs(from.Config, to.Config, &diff.Changes)
+	return diff, nil
+}
+
+// diffConfigs compares two stored configs key by key, so widget changes are
+// reported as "widgets[...]" paths. Configs that are not JSON objects are
+// compared as a whole.
+func diffConfigs(old, new string, changes *[]DashboardChange) {
+	var oldConfig, newConfig map[string]interface{}
+	oldErr := json.Unmarshal([]byte(old), &oldConfig)
+	newErr := json.Unmarshal([]byte(new), &newConfig)
+	if oldErr != nil || newErr != nil {
+		diffValues("config", old, new, changes)
+		return
+	}
+
+	keys := make(map[string]bool)
+	for k := range oldConfig {
+		keys[k] = true
+	}
+	for k := range newConfig {
+		keys[k] = true
+	}
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+	for _, k := range sorted {
+		diffValues(k, oldConfig[k], newConfig[k], changes)
+	}
+}
+
+// diffValues walks two decoded JSON values and appends the differences.
+// Arrays of objects carrying a widget_id are matched by ID rather than index
+// so that reordering widgets does not show up as a change to every widget.
+func diffValues(path string, old, new interface{}, changes *[]DashboardChange) {
+	if reflect.DeepEqual(old, new) {
+		return
+	}
+	if old == nil {
+		*changes = append(*changes, DashboardChange{Path: path, Type: "added", NewValue: new})
+		return
+	}
+	if new == nil {
+		*changes = append(*changes, DashboardChange{Path: path, Type: "removed", OldValue: old})
+		return
+	}
+
+	switch o := old.(type) {
+	case map[string]interface{}:
+		if n, ok := new.(map[string]interface{}); ok {
+			keys := make(map[string]bool)
+			for k := range o {
+				keys[k] = true
+			}
+			for k := range n {
+				keys[k] = true
+			}
+			sorted := make([]string, 0, len(keys))
+			for k := range keys {
+				sorted = append(sorted, k)
+			}
+			sort.Strings(sorted)
+			for _, k := range sorted {
+				diffValues(path+"."+k, o[k], n[k], changes)
+			}
+			return
+		}
+	case []interface{}:
+		if n, ok := new.([]interface{}); ok {
+			oldById, oldKeyed := indexByWidgetId(o)
+			newById, newKeyed := indexByWidgetId(n)
+			if oldKeyed && newKeyed {
+				var ids []string
+				for id := range oldById {
+					ids = append(ids, id)
+				}
+				for id := range newById {
+					if _, ok := oldById[id]; !ok {
+						ids = append(ids, id)
+					}
+				}
+				sort.Strings(ids)
+				for _, id := range ids {
+					diffValues(fmt.Sprintf("%s[%s]", path, id), oldById[id], newById[id], changes)
+				}
+				return
+			}
+
+			for i := 0; i < len(o) || i < len(n); i++ {
+				var ov, nv interface{}
+				if i < len(o) {
+					ov = o[i]
+				}
+				if i < len(n) {
+					nv = n[i]
+				}
+				diffValues(fmt.Sprintf("%s[%d]", path, i), ov, nv, changes)
+			}
+			return
+		}
+	}
+
+	*changes = append(*changes, DashboardChange{Path: path, Type: "changed", OldValue: old, NewValue: new})
+}
+
+// indexByWidgetId maps array elements by their widget_id, reporting false
+// if any element is not an object with a non-empty widget_id
+func indexByWidgetId(items []interface{}) (map[string]interface{}, bool) {
+	byId := make(map[string]interface{}, len(items))
+	for _, item := range items {
+		obj, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		id, ok := obj["widget_id"].(string)
+		if !ok || id == "" {
+			return nil, false
+		}
+		byId[id] = obj
+	}
+	return byId, true
+}
@@ -0,0 +1,312 @@
+package store
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Formula limits, so parsing a formula cannot recurse without bound
+const (
+	maxFormulaLength = 1024
+	maxFormulaDepth  = 32 // nested parentheses and negations
+)
+
+// formulaNode is a parsed arithmetic expression over series references
+type formulaNode interface {
+	eval(vars map[string]float64) float64
+}
+
+type formulaNumber float64
+
+type formulaRef string
+
+type formulaUnary struct {
+	operand formulaNode
+}
+
+type formulaBinary struct {
+	op          byte
+	left, right formulaNode
+}
+
+func (n formulaNumber) eval(vars map[string]float64) float64 { return float64(n) }
+
+func (n formulaRef) eval(vars map[string]float64) float64 { return vars[string(n)] }
+
+func (n formulaUnary) eval(vars map[string]float64) float64 { return -n.operand.eval(vars) }
+
+func (n formulaBinary) eval(vars map[string]float64) float64 {
+	l, r := n.left.eval(vars), n.right.eval(vars)
+	switch n.op {
+	case '+':
+		return l + r
+	case '-':
+		return l - r
+	case '*':
+		return l * r
+	default:
+		if r == 0 {
+			return math.NaN()
+		}
+		return l / r
+	}
+}
+
+// parseFormula parses expressions like "errors / requests * 100" and returns
+// the expression tree together with the references it uses.
+func parseFormula(formula string) (formulaNode, []string, error) {
+	if len(formula) > maxFormulaLength {
+		return nil, nil, fmt.Errorf("formula is longer than %d characters", maxFormulaLength)
+	}
+	p := &formulaParser{input: formula, refs: make(map[string]bool)}
+	node, err := p.parseExpr()
+	if err != nil {
+		return nil, nil, err
+	}
+	p.skipSpaces()
+	if p.pos < len(p.input) {
+		return nil, nil, fmt.Errorf("unexpected %q at position %d", p.input[p.pos], p.pos)
+	}
+
+	var refs []string
+	for ref := range p.refs {
+		refs = append(refs, ref)
+	}
+	sort.Strings(refs)
+	return node, refs, nil
+}
+
+type formulaParser struct {
+	input string
+	pos   int
+	depth int
+	refs  map[string]bool
+}
+
+func (p *formulaParser) skipSpaces() {
+	for p.pos < len(p.input) && p.input[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+func (p *formulaParser) parseExpr() (formulaNode, error) {
+	left, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		p.skipSpaces()
+		if p.pos >= len(p.input) || (p.input[p.pos] != '+' && p.input[p.pos] != '-') {
+			return left, nil
+		}
+		op := p.input[p.pos]
+		p.pos++
+		right, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		left = formulaBinary{op: op, left: left, right: right}
+	}
+}
+
+func (p *formulaParser) parseTerm() (formulaNode, error) {
+	left, err := p.parseFactor()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		p.skipSpaces()
+		if p.pos >= len(p.input) || (p.input[p.pos] != '*' && p.input[p.pos] != '/') {
+			return left, nil
+		}
+		op := p.input[p.pos]
+		p.pos++
+		right, err := p.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+		left = formulaBinary{op: op, left: left, right: right}
+	}
+}
+
+func (p *formulaParser) parseFactor() (formulaNode, error) {
+	p.skipSpaces()
+	if p.pos >= len(p.input) {
+		return nil, fmt.Errorf("unexpected end of formula")
+	}
+
+	c := p.input[p.pos]
+	if c == '-' || c == '(' {
+		if p.depth == maxFormulaDepth {
+			return nil, fmt.Errorf("formula is nested deeper than %d levels", maxFormulaDepth)
+		}
+		p.depth++
+		defer func() { p.depth-- }()
+	}
+	switch {
+	case c == '-':
+		p.pos++
+		operand, err := p.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+		return formulaUnary{operand: operand}, nil
+	case c == '(':
+		p.pos++
+		node, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpaces()
+		if p.pos >= len(p.input) || p.input[p.pos] != ')' {
+			return nil, fmt.Errorf("missing closing parenthesis")
+		}
+		p.pos++
+		return node, nil
+	case c == '.' || (c >= '0' && c <= '9'):
+		start := p.pos
+		for p.pos < len(p.input) && (p.input[p.pos] == '.' || (p.input[p.pos] >= '0' && p.input[p.pos] <= '9')) {
+			p.pos++
+		}
+		value, err := strconv.ParseFloat(p.input[start:p.pos], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", p.input[start:p.pos])
+		}
+		return formulaNumber(value), nil
+	case c == '_' || unicode.IsLetter(rune(c)):
+		start := p.pos
+		for p.pos < len(p.input) && (p.input[p.pos] == '_' || p.input[p.pos] == '.' ||
+			unicode.IsLetter(rune(p.input[p.pos])) || unicode.IsDigit(rune(p.input[p.pos]))) {
+			p.pos++
+		}
+		ref := p.input[start:p.pos]
+		p.refs[ref] = true
+		return formulaRef(ref), nil
+	default:
+		return nil, fmt.Errorf("unexpected %q at position %d", c, p.pos)
+	}
+}
+
+// evaluateFormula computes a formula point by point over the referenced
+// series. Series are matched by their label set; a reference that resolved
+// to a single unlabelled series is broadcast against every label set.
+func evaluateFormula(formula string, byRef map[string][]MetricSeries) ([]MetricSeries, error) {
+	node, refs, err := parseFormula(formula)
+	if err != nil {
+		return nil, err
+	}
+	if len(refs) == 0 {
+		return nil, fmt.Errorf("formula does not reference any query")
+	}
+
+	// Index every referenced series by label set and timestamp
+	type seriesIndex map[string]map[int64]float64
+	indexes := make(map[string]seriesIndex)
+	labelSets := make(map[string]map[string]string)
+	var labelOrder []string
+	for _, ref := range refs {
+		series, ok := byRef[ref]
+		if !ok {
+			return nil, fmt.Errorf("unknown reference %q", ref)
+		}
+		idx := make(seriesIndex)
+		for _, ser := range series {
+			key := labelKey(ser.Labels)
+			points := make(map[int64]float64, len(ser.DataPoints))
+			for _, dp := range ser.DataPoints {
+				points[dp.Timestamp.UnixNano()] = dp.Value
+			}
+			idx[key] = points
+			if _, seen := labelSets[key]; !seen {
+				labelSets[key] = ser.Labels
+				labelOrder = append(labelOrder, key)
+			}
+		}
+		indexes[ref] = idx
+	}
+
+	var results []MetricSeries
+	for _, key := range labelOrder {
+		// Resolve the points each reference contributes to this label set
+		pointsByRef := make(map[string]map[int64]float64, len(refs))
+		complete := true
+		for _, ref := range refs {
+			idx := indexes[ref]
+			points, ok := idx[key]
+			if !ok {
+				if unlabelled, single := idx[""]; single && len(idx) == 1 {
+					points, ok = unlabelled, true
+				}
+			}
+			if !ok {
+				complete = false
+				break
+			}
+			pointsByRef[ref] = points
+		}
+		if !complete {
+			continue
+		}
+
+		// Evaluate at every timestamp present in all references
+		var timestamps []int64
+		for ts := range pointsByRef[refs[0]] {
+			timestamps = append(timestamps, ts)
+		}
+		sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+
+		series := MetricSeries{Labels: labelSets[key], DataPoints: []DataPoint{}}
+		vars := make(map[string]float64, len(refs))
+		for _, ts := range timestamps {
+			present := true
+			for _, ref := range refs {
+				v, ok := pointsByRef[ref][ts]
+				if !ok {
+					present = false
+					break
+				}
+				vars[ref] = v
+			}
+			if !present {
+				continue
+			}
+			value := node.eval(vars)
+			if math.IsNaN(value) || math.IsInf(value, 0) {
+				continue
+			}
+			series.DataPoints = append(series.DataPoints, DataPoint{
+				Timestamp: time.Unix(0, ts).UTC(),
+				Value:     value,
+			})
+		}
+		results = append(results, series)
+	}
+
+	return results, nil
+}
+
+// labelKey builds a stable identity string for a label set
+func labelKey(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, k := range keys {
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(labels[k])
+		b.WriteByte(',')
+	}
+	return b.String()
+}
@@ -0,0 +1,184 @@
+package store
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseFormula(t *testing.T) {
+	tests := []struct {
+		name    string
+		formula string
+		vars    map[string]float64
+		want    float64
+		refs    []string
+	}{
+		{"number", "42", nil, 42, nil},
+		{"fraction", ".5", nil, 0.5, nil},
+		{"reference", "a", map[string]float64{"a": 3}, 3, []string{"a"}},
+		{"precedence", "a + b * 2", map[string]float64{"a": 1, "b": 3}, 7, []string{"a", "b"}},
+		{"left associative", "a - b - c", map[string]float64{"a": 10, "b": 3, "c": 2}, 5, []string{"a", "b", "c"}},
+		{"parentheses", "(a + b) * 2", map[string]float64{"a": 1, "b": 3}, 8, []string{"a", "b"}},
+		{"negation", "-a * -2", map[string]float64{"a": 4}, 8, []string{"a"}},
+		{"dotted reference", "errors.5xx / requests * 100", map[string]float64{"errors.5xx": 5, "requests": 50}, 10, []string{"errors.5xx", "requests"}},
+		{"repeated reference", "a / a", map[string]float64{"a": 2}, 1, []string{"a"}},
+		{"spaces", "  a  *  2  ", map[string]float64{"a": 2}, 4, []string{"a"}},
+		{"nested at the limit", strings.Repeat("(", maxFormulaDepth) + "1" + strings.Repeat(")", maxFormulaDepth), nil, 1, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node, refs, err := parseFormula(tt.formula)
+			if err != nil {
+				t.Fatalf("parseFormula(%q): %v", tt.formula, err)
+			}
+			if got := node.eval(tt.vars); got != tt.want {
+				t.Errorf("eval = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(refs, tt.refs) {
+				t.Errorf("refs = %v, want %v", refs, tt.refs)
+			}
+		})
+	}
+}
+
+func TestParseFormulaErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		formula string
+		wantErr string
+	}{
+		{"empty", "", "unexpected end of formula"},
+		{"trailing operator", "a +", "unexpected end of formula"},
+		{"missing operand", "a * / b", "unexpected '/'"},
+		{"unclosed parenthesis", "(a + b", "missing closing parenthesis"},
+		{"stray parenthesis", "a + b)", "unexpected ')'"},
+		{"adjacent operands", "a b", "unexpected 'b'"},
+		{"invalid number", "1..2", "invalid number"},
+		{"unknown character", "a % b", "unexpected '%'"},
+		{"too long", strings.Repeat("a+", maxFormulaLength/2) + "a", "longer than"},
+		{"nested too deep", strings.Repeat("(", maxFormulaDepth+1) + "1" + strings.Repeat(")", maxFormulaDepth+1), "nested deeper than"},
+		{"negated too deep", strings.Repeat("-", maxFormulaDepth+1) + "1", "nested deeper than"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := parseFormula(tt.formula)
+			if err == nil {
+				t.Fatalf("parseFormula(%q) succeeded, want error containing %q", tt.formula, tt.wantErr)
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("parseFormula(%q) = %v, want error containing %q", tt.formula, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFormulaDivisionByZero(t *testing.T) {
+	node, _, err := parseFormula("a / b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := node.eval(map[string]float64{"a": 1, "b": 0}); !math.IsNaN(got) {
+		t.Errorf("eval = %v, want NaN", got)
+	}
+}
+
+func TestEvaluateFormula(t *testing.T) {
+	t0 := time.Unix(1700000000, 0).UTC()
+	t1 := t0.Add(time.Minute)
+	points := func(values ...float64) []DataPoint {
+		dps := make([]DataPoint, len(values))
+		for i, v := range values {
+			dps[i] = DataPoint{Timestamp: t0.Add(time.Duration(i) * time.Minute), Value: v}
+		}
+		return dps
+	}
+	web := map[string]string{"service": "web"}
+	api := map[string]string{"service": "api"}
+
+	tests := []struct {
+		name    string
+		formula string
+		byRef   map[string][]MetricSeries
+		want    []MetricSeries
+	}{
+		{
+			name:    "matched by labels",
+			formula: "errors / requests * 100",
+			byRef: map[string][]MetricSeries{
+				"errors":   {{Labels: web, DataPoints: points(1, 2)}, {Labels: api, DataPoints: points(5)}},
+				"requests": {{Labels: api, DataPoints: points(10)}, {Labels: web, DataPoints: points(10, 20)}},
+			},
+			want: []MetricSeries{
+				{Labels: web, DataPoints: []DataPoint{{Timestamp: t0, Value: 10}, {Timestamp: t1, Value: 10}}},
+				{Labels: api, DataPoints: []DataPoint{{Timestamp: t0, Value: 50}}},
+			},
+		},
+		{
+			name:    "unlabelled series broadcast",
+			formula: "cpu / total",
+			byRef: map[string][]MetricSeries{
+				"cpu":   {{Labels: web, DataPoints: points(2)}, {Labels: api, DataPoints: points(4)}},
+				"total": {{DataPoints: points(8)}},
+			},
+			want: []MetricSeries{
+				{Labels: web, DataPoints: []DataPoint{{Timestamp: t0, Value: 0.25}}},
+				{Labels: api, DataPoints: []DataPoint{{Timestamp: t0, Value: 0.5}}},
+			},
+		},
+		{
+			name:    "label set missing from a reference",
+			formula: "a + b",
+			byRef: map[string][]MetricSeries{
+				"a": {{Labels: web, DataPoints: points(1)}, {Labels: api, DataPoints: points(1)}},
+				"b": {{Labels: web, DataPoints: points(1)}},
+			},
+			want: []MetricSeries{
+				{Labels: web, DataPoints: []DataPoint{{Timestamp: t0, Value: 2}}},
+			},
+		},
+		{
+			name:    "points without a value in every reference and division by zero skipped",
+			formula: "a / b",
+			byRef: map[string][]MetricSeries{
+				"a": {{DataPoints: points(1, 2)}},
+				"b": {{DataPoints: points(0)}},
+			},
+			want: []MetricSeries{{DataPoints: []DataPoint{}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := evaluateFormula(tt.formula, tt.byRef)
+			if err != nil {
+				t.Fatalf("evaluateFormula: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("evaluateFormula = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvaluateFormulaErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		formula string
+		wantErr string
+	}{
+		{"no reference", "1 + 2", "does not reference any query"},
+		{"unknown reference", "a + missing", `unknown reference "missing"`},
+		{"malformed", "a +", "unexpected end of formula"},
+	}
+	byRef := map[string][]MetricSeries{"a": {{DataPoints: []DataPoint{}}}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := evaluateFormula(tt.formula, byRef)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("evaluateFormula(%q) = %v, want error containing %q", tt.formula, err, tt.wantErr)
+			}
+		})
+	}
+}
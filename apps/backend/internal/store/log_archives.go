@@ -0,0 +1,355 @@
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+)
+
+// Log archive formats
+const (
+	LogArchiveNDJSON  = "ndjson"  // gzip-compressed JSON lines
+	LogArchiveParquet = "parquet" // Parquet, compressed per column
+)
+
+// logArchiveVersion is the version of the manifest layout
+const logArchiveVersion = 1
+
+// logArchiveColumns are the logs_v1 columns archived, with the types they
+// are archived as. Rehydration reads archives with the same structure, so
+// columns may be added here but not removed.
+var logArchiveColumns = [][2]string{
+	{"Timestamp", "DateTime64(9)"},
+	{"AccountId", "UInt64"},
+	{"LogId", "String"},
+	{"HostId", "String"},
+	{"HostName", "String"},
+	{"HostIP", "String"},
+	{"HostArch", "String"},
+	{"NodeName", "String"},
+	{"ClusterName", "String"},
+	{"AgentName", "String"},
+	{"AgentVersion", "String"},
+	{"Env", "String"},
+	{"ServiceName", "String"},
+	{"ServiceVersion", "String"},
+	{"Namespace", "String"},
+	{"Pod", "String"},
+	{"Container", "String"},
+	{"ContainerId", "String"},
+	{"Source", "String"},
+	{"SeverityNumber", "Int32"},
+	{"SeverityText", "String"},
+	{"Body", "String"},
+	{"TraceId", "String"},
+	{"SpanId", "String"},
+	{"TraceFlags", "UInt64"},
+	{"LogAttributes", "Map(String, String)"},
+	{"ResourceAttributes", "Map(String, String)"},
+	{"PatternHash", "String"},
+	{"BodyHash", "String"},
+	{"Bytes", "UInt64"},
+}
+
+// logArchiveColumnList returns the archived column names, comma-separated
+func logArchiveColumnList() string {
+	names := make([]string, len(logArchiveColumns))
+	for i, c := range logArchiveColumns {
+		names[i] = c[0]
+	}
+	return strings.Join(names, ", ")
+}
+
+// logArchiveStructure returns the archived columns as an s3() structure
+func logArchiveStructure() string {
+	parts := make([]string, len(logArchiveColumns))
+	for i, c := range logArchiveColumns {
+		parts[i] = c[0] + " " + c[1]
+	}
+	return strings.Join(parts, ", ")
+}
+
+// ValidLogArchiveFormat reports whether format is an archive format
+func ValidLogArchiveFormat(format string) bool {
+	return format == LogArchiveNDJSON || format == LogArchiveParquet
+}
+
+// LogArchiveTarget is the S3-compatible bucket archives are written to.
+// ClickHouse reads and writes the objects itself, so the URL must be
+// reachable from the ClickHouse server.
+type LogArchiveTarget struct {
+	URL             string // bucket URL with an optional key prefix, e.g. http://minio:9000/logs/archive
+	AccessKeyId     string // empty to use the server's own credentials
+	SecretAccessKey string
+}
+
+// s3 returns an s3() table function reading or writing the object at key.
+// Arguments are bound, so credentials and keys are quoted as values.
+func (t LogArchiveTarget) s3(key, format string, structure bool) (string, []interface{}) {
+	args := []interface{}{strings.TrimSuffix(t.URL, "/") + "/" + key}
+	if t.AccessKeyId != "" {
+		args = append(args, t.AccessKeyId, t.SecretAccessKey)
+	}
+	args = append(args, format)
+	if structure {
+		args = append(args, logArchiveStructure())
+	}
+	return "s3(" + strings.TrimSuffix(strings.Repeat("?, ", len(args)), ", ") + ")", args
+}
+
+// LogArchive is an archived day of a service's logs
+type LogArchive struct {
+	AccountId    uint64    `json:"account_id"`
+	Date         time.Time `json:"date"` // UTC day
+	ServiceName  string    `json:"service_name"`
+	Format       string    `json:"format"`
+	Key          string    `json:"key"`          // object key of the logs
+	ManifestKey  string    `json:"manifest_key"` // object key of the day's manifest
+	Rows         uint64    `json:"rows"`
+	Bytes        uint64    `json:"bytes"` // uncompressed size of the logs
+	MinTimestamp time.Time `json:"min_timestamp"`
+	MaxTimestamp time.Time `json:"max_timestamp"`
+	ArchivedAt   time.Time `json:"archived_at"`
+}
+
+// LogArchiveManifest describes the objects of an archived day. It is
+// written next to them, so archives can be found and read without the
+// catalog.
+type LogArchiveManifest struct {
+	Version   int              `json:"version"`
+	AccountId uint64           `json:"account_id"`
+	Date      string           `json:"date"` // YYYY-MM-DD, UTC
+	Format    string           `json:"format"`
+	Structure string           `json:"structure"` // columns and types of the objects
+	Rows      uint64           `json:"rows"`
+	Files     []LogArchiveFile `json:"files"`
+	CreatedAt time.Time        `json:"created_at"`
+}
+
+// LogArchiveFile is one service's logs in a manifest
+type LogArchiveFile struct {
+	ServiceName  string    `json:"service_name"`
+	Key          string    `json:"key"`
+	Rows         uint64    `json:"rows"`
+	Bytes        uint64    `json:"bytes"`
+	MinTimestamp time.Time `json:"min_timestamp"`
+	MaxTimestamp time.Time `json:"max_timestamp"`
+}
+
+// LogArchiveDay is an account's logs of a UTC day
+type LogArchiveDay struct {
+	AccountId uint64
+	Date      time.Time
+}
+
+// logArchiveKeyPart makes a value safe to use in an object key. Values
+// that had to be changed get a hash suffix, so they do not collide.
+func logArchiveKeyPart(value string) string {
+	var b strings.Builder
+	for _, r := range value {
+		if r < 0x80 && (r == '-' || r == '_' || r == '.' || 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9') {
+			b.WriteRune(r)
+		} else {
+			b.WriteRune('_')
+		}
+	}
+	part := b.String()
+	if part != value || part == "" || strings.Trim(part, ".") == "" {
+		sum := sha256.Sum256([]byte(value))
+		part += "-" + hex.EncodeToString(sum[:4])
+	}
+	return part
+}
+
+// logArchivePrefix is where an account's archives of a day are kept
+func logArchivePrefix(accountId uint64, day time.Time) string {
+	return fmt.Sprintf("account=%d/date=%s", accountId, day.Format("2006-01-02"))
+}
+
+// GetPendingLogArchiveDays returns the days in [from, to) with logs that
+// have not been archived, oldest first. from and to are UTC midnights.
+func (s *Store) GetPendingLogArchiveDays(ctx context.Context, from, to time.Time) ([]LogArchiveDay, error) {
+	query := `
+		SELECT AccountId, toDate(Timestamp, 'UTC') as day
+		FROM logs.logs_v1
+		WHERE Timestamp >= fromUnixTimestamp64Nano(?) AND Timestamp < fromUnixTimestamp64Nano(?)
+		GROUP BY AccountId, day
+		HAVING (AccountId, day) NOT IN (SELECT AccountId, Date FROM logs.log_archives FINAL)
+		ORDER BY day, AccountId
+	`
+	rows, err := s.conn.Query(ctx, query, from.UnixNano(), to.UnixNano())
+	if err != nil {
+		return nil, fmt.Errorf("failed to query days to archive: %w", err)
+	}
+	defer rows.Close()
+
+	var days []LogArchiveDay
+	for rows.Next() {
+		var d LogArchiveDay
+		if err := rows.Scan(&d.AccountId, &d.Date); err != nil {
+			return nil, err
+		}
+		d.Date = time.Date(d.Date.Year(), d.Date.Month(), d.Date.Day(), 0, 0, 0, 0, time.UTC)
+		days = append(days, d)
+	}
+	return days, rows.Err()
+}
+
+// ArchiveLogDay exports an account's logs of a UTC day to the target, one
+// object per service, then writes the day's manifest and records the
+// archives in the catalog. Archiving a day again replaces its objects.
+// Logs arriving for the day after it was archived are not archived.
+func (s *Store) ArchiveLogDay(ctx context.Context, target LogArchiveTarget, format string, accountId uint64, day time.Time) ([]LogArchive, error) {
+	if !ValidLogArchiveFormat(format) {
+		return nil, fmt.Errorf("archive format must be %s or %s", LogArchiveNDJSON, LogArchiveParquet)
+	}
+	day = time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.UTC)
+	from, to := day.UnixNano(), day.AddDate(0, 0, 1).UnixNano()
+
+	statsQuery := `
+		SELECT ServiceName, count(), sum(Bytes), min(Timestamp), max(Timestamp)
+		FROM logs.logs_v1
+		WHERE AccountId = ? AND Timestamp >= fromUnixTimestamp64Nano(?) AND Timestamp < fromUnixTimestamp64Nano(?)
+		GROUP BY ServiceName
+		ORDER BY ServiceName
+	`
+	rows, err := s.conn.Query(ctx, statsQuery, accountId, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query logs to archive: %w", err)
+	}
+	var files []LogArchiveFile
+	for rows.Next() {
+		var f LogArchiveFile
+		if err := rows.Scan(&f.ServiceName, &f.Rows, &f.Bytes, &f.MinTimestamp, &f.MaxTimestamp); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, nil
+	}
+
+	// Timestamps are written in UTC ISO 8601, so reading them back does
+	// not depend on the server's timezone
+	ctx = clickhouse.Context(ctx, clickhouse.WithSettings(clickhouse.Settings{
+		"s3_truncate_on_insert":   1,
+		"date_time_output_format": "iso",
+	}))
+
+	prefix := logArchivePrefix(accountId, day)
+	chFormat, ext := "JSONEachRow", "ndjson.gz" // compressed by extension
+	if format == LogArchiveParquet {
+		chFormat, ext = "Parquet", "parquet"
+	}
+	manifest := LogArchiveManifest{
+		Version:   logArchiveVersion,
+		AccountId: accountId,
+		Date:      day.Format("2006-01-02"),
+		Format:    format,
+		Structure: logArchiveStructure(),
+		CreatedAt: time.Now().UTC(),
+	}
+	for i := range files {
+		f := &files[i]
+		f.Key = fmt.Sprintf("%s/service=%s/logs.%s", prefix, logArchiveKeyPart(f.ServiceName), ext)
+		fn, fnArgs := target.s3(f.Key, chFormat, true)
+		query := fmt.Sprintf(`
+			INSERT INTO FUNCTION %s
+			SELECT %s
+			FROM logs.logs_v1
+			WHERE AccountId = ? AND ServiceName = ?
+			  AND Timestamp >= fromUnixTimestamp64Nano(?) AND Timestamp < fromUnixTimestamp64Nano(?)
+			ORDER BY Timestamp
+		`, fn, logArchiveColumnList())
+		args := append(fnArgs, accountId, f.ServiceName, from, to)
+		if err := s.conn.Exec(ctx, query, args...); err != nil {
+			return nil, fmt.Errorf("failed to archive logs of %s: %w", f.ServiceName, err)
+		}
+		manifest.Rows += f.Rows
+	}
+	manifest.Files = files
+
+	// The manifest goes last: a day with a manifest is complete
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	manifestKey := prefix + "/manifest.json"
+	fn, fnArgs := target.s3(manifestKey, "RawBLOB", false)
+	if err := s.conn.Exec(ctx, "INSERT INTO FUNCTION "+fn+" SELECT ?", append(fnArgs, string(data))...); err != nil {
+		return nil, fmt.Errorf("failed to write archive manifest: %w", err)
+	}
+
+	archives := make([]LogArchive, len(files))
+	batch, err := s.conn.PrepareBatch(ctx, "INSERT INTO logs.log_archives")
+	if err != nil {
+		return nil, err
+	}
+	for i, f := range files {
+		archives[i] = LogArchive{
+			AccountId:    accountId,
+			Date:         day,
+			ServiceName:  f.ServiceName,
+			Format:       format,
+			Key:          f.Key,
+			ManifestKey:  manifestKey,
+			Rows:         f.Rows,
+			Bytes:        f.Bytes,
+			MinTimestamp: f.MinTimestamp,
+			MaxTimestamp: f.MaxTimestamp,
+			ArchivedAt:   manifest.CreatedAt,
+		}
+		a := archives[i]
+		if err := batch.Append(a.AccountId, a.Date, a.ServiceName, a.Format, a.Key, a.ManifestKey, a.Rows, a.Bytes, a.MinTimestamp, a.MaxTimestamp, a.ArchivedAt); err != nil {
+			return nil, err
+		}
+	}
+	if err := batch.Send(); err != nil {
+		return nil, fmt.Errorf("failed to record log archives: %w", err)
+	}
+	return archives, nil
+}
+
+// ListLogArchives returns an account's archives of the UTC days from from
+// to to, optionally of one service, oldest first
+func (s *Store) ListLogArchives(ctx context.Context, accountId uint64, from, to time.Time, service string) ([]LogArchive, error) {
+	whereClause := "WHERE AccountId = ? AND Date >= toDate(?, 'UTC') AND Date <= toDate(?, 'UTC')"
+	args := []interface{}{accountId, from.UTC().Format("2006-01-02"), to.UTC().Format("2006-01-02")}
+	if service != "" {
+		whereClause += " AND ServiceName = ?"
+		args = append(args, service)
+	}
+
+	query := fmt.Sprintf(`
+		SELECT AccountId, Date, ServiceName, Format, DataKey, ManifestKey, Rows, Bytes, MinTimestamp, MaxTimestamp, ArchivedAt
+		FROM logs.log_archives FINAL
+		%s
+		ORDER BY Date, ServiceName
+	`, whereClause)
+	rows, err := s.conn.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query log archives: %w", err)
+	}
+	defer rows.Close()
+
+	archives := []LogArchive{}
+	for rows.Next() {
+		var a LogArchive
+		if err := rows.Scan(&a.AccountId, &a.Date, &a.ServiceName, &a.Format, &a.Key, &a.ManifestKey, &a.Rows, &a.Bytes, &a.MinTimestamp, &a.MaxTimestamp, &a.ArchivedAt); err != nil {
+			return nil, err
+		}
+		archives = append(archives, a)
+	}
+	return archives, rows.Err()
+}
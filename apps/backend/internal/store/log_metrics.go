@@ -0,0 +1,527 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Log metric kinds
+const (
+	LogMetricCount        = "count"        // lines matching the filter
+	LogMetricDistribution = "distribution" // histogram of a numeric attribute
+)
+
+// Bounds on log metrics
+const (
+	maxLogMetricBuckets       = 30
+	maxLogMetricGroupBy       = 5
+	maxLogMetricSeries        = 1000 // series written per window; the rest are dropped
+	defaultLogMetricRetention = 90
+	maxLogMetricRetention     = 730
+)
+
+// logMetricColumns are the log columns a log metric can group by, keyed by
+// label name. Attributes are grouped by as attributes.<key>.
+var logMetricColumns = map[string]string{
+	"service_name":    "ServiceName",
+	"service_version": "ServiceVersion",
+	"severity":        "SeverityText",
+	"pattern":         "PatternHash",
+	"host_name":       "HostName",
+	"env":             "Env",
+	"namespace":       "Namespace",
+	"pod":             "Pod",
+	"container":       "Container",
+	"source":          "Source",
+}
+
+// logMetricResourceAttrs maps grouped-by columns to the resource attributes
+// InsertMetrics reads them from
+var logMetricResourceAttrs = map[string]string{
+	"service_version": "service.version",
+	"host_name":       "host.name",
+	"env":             "deployment.environment",
+	"namespace":       "k8s.namespace",
+	"pod":             "k8s.pod.name",
+	"container":       "container.name",
+}
+
+const logMetricAttributePrefix = "attributes."
+
+// LogMetric derives a metric from logs. Every interval, the logs of the
+// last window that match the filter are counted, or the values of a
+// numeric attribute are bucketed into a histogram, and the result is
+// written into metrics_v1, where it outlives the logs.
+type LogMetric struct {
+	MetricId      string          `json:"metric_id"`
+	AccountId     uint64          `json:"account_id"`
+	Name          string          `json:"name"` // MetricName of the written series
+	Description   string          `json:"description"`
+	Kind          string          `json:"kind"`    // count or distribution
+	Field         string          `json:"field"`   // distribution: numeric LogAttributes key
+	Buckets       []float64       `json:"buckets"` // distribution: upper bounds of the histogram buckets
+	Filter        LogMetricFilter `json:"filter"`
+	GroupBy       []string        `json:"group_by"`       // columns such as service_name or pattern, or attributes.<key>
+	Interval      string          `json:"interval"`       // e.g., "1m", "5m"
+	RetentionDays uint16          `json:"retention_days"` // of the written series
+	Paused        bool            `json:"paused"`
+	CreatedAt     time.Time       `json:"created_at"`
+	UpdatedAt     time.Time       `json:"updated_at"`
+	Status        *LogMetricState `json:"status,omitempty"`
+}
+
+// LogMetricFilter selects the logs a metric is derived from
+type LogMetricFilter struct {
+	Services   []string          `json:"services"`
+	Severities []string          `json:"severities"`
+	Search     string            `json:"search"`     // case-insensitive substring of the body
+	Attributes map[string]string `json:"attributes"` // exact attribute values
+}
+
+// LogMetricState is where a metric's evaluation stands
+type LogMetricState struct {
+	MetricId       string    `json:"-"`
+	AccountId      uint64    `json:"-"`
+	WindowEnd      time.Time `json:"window_end"` // end of the last window written
+	EvaluatedAt    time.Time `json:"evaluated_at"`
+	SamplesWritten uint64    `json:"samples_written"` // by the last evaluation
+	LastError      string    `json:"last_error"`
+}
+
+// Validate checks that a log metric can be evaluated, filling in defaults
+func (m *LogMetric) Validate() error {
+	if !recordedMetricName.MatchString(m.Name) {
+		return fmt.Errorf("name must be a valid metric name")
+	}
+	switch m.Kind {
+	case LogMetricCount:
+		if m.Field != "" || len(m.Buckets) > 0 {
+			return fmt.Errorf("count metrics take no field or buckets")
+		}
+	case LogMetricDistribution:
+		if m.Field == "" {
+			return fmt.Errorf("distribution metrics need a field")
+		}
+		if len(m.Buckets) == 0 || len(m.Buckets) > maxLogMetricBuckets {
+			return fmt.Errorf("distribution metrics need 1 to %d buckets", maxLogMetricBuckets)
+		}
+		for i, b := range m.Buckets {
+			if math.IsNaN(b) || math.IsInf(b, 0) || (i > 0 && b <= m.Buckets[i-1]) {
+				return fmt.Errorf("buckets must be finite and increasing")
+			}
+		}
+	default:
+		return fmt.Errorf("kind must be %s or %s", LogMetricCount, LogMetricDistribution)
+	}
+
+	if len(m.GroupBy) > maxLogMetricGroupBy {
+		return fmt.Errorf("at most %d group by fields are allowed", maxLogMetricGroupBy)
+	}
+	for _, g := range m.GroupBy {
+		if _, ok := logMetricColumns[g]; ok {
+			continue
+		}
+		key, ok := strings.CutPrefix(g, logMetricAttributePrefix)
+		if !ok || key == "" {
+			return fmt.Errorf("cannot group by %q", g)
+		}
+		if key == "le" {
+			return fmt.Errorf("le is reserved for histogram buckets")
+		}
+	}
+
+	if m.Interval == "" {
+		m.Interval = "1m"
+	}
+	if m.IntervalDuration() < time.Minute {
+		return fmt.Errorf("interval must be at least 1m")
+	}
+	if m.RetentionDays == 0 {
+		m.RetentionDays = defaultLogMetricRetention
+	}
+	if m.RetentionDays > maxLogMetricRetention {
+		return fmt.Errorf("retention_days must be at most %d", maxLogMetricRetention)
+	}
+	return nil
+}
+
+// IntervalDuration returns the evaluation interval of the metric
+func (m *LogMetric) IntervalDuration() time.Duration {
+	return time.Duration(parseInterval(m.Interval)) * time.Second
+}
+
+// ========== LOG METRIC CRUD OPERATIONS ==========
+
+// SaveLogMetric creates or replaces a log metric
+func (s *Store) SaveLogMetric(ctx context.Context, m *LogMetric) error {
+	definition, err := json.Marshal(logMetricDefinition{m.Kind, m.Field, m.Buckets, m.Filter, m.GroupBy})
+	if err != nil {
+		return fmt.Errorf("failed to encode log metric: %w", err)
+	}
+
+	if m.CreatedAt.IsZero() {
+		m.CreatedAt = time.Now()
+	}
+	m.UpdatedAt = time.Now()
+
+	query := `
+		INSERT INTO metrics.log_metrics
+		(MetricId, AccountId, Name, Description, Definition, Interval, RetentionDays, Paused, CreatedAt, UpdatedAt)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	err = s.conn.Exec(ctx, query,
+		m.MetricId,
+		m.AccountId,
+		m.Name,
+		m.Description,
+		string(definition),
+		m.Interval,
+		m.RetentionDays,
+		m.Paused,
+		m.CreatedAt,
+		m.UpdatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save log metric: %w", err)
+	}
+	return nil
+}
+
+// logMetricDefinition is the stored form of what a log metric computes
+type logMetricDefinition struct {
+	Kind    string          `json:"kind"`
+	Field   string          `json:"field"`
+	Buckets []float64       `json:"buckets"`
+	Filter  LogMetricFilter `json:"filter"`
+	GroupBy []string        `json:"group_by"`
+}
+
+const logMetricColumnList = `MetricId, AccountId, Name, Description, Definition, Interval, RetentionDays, Paused, CreatedAt, UpdatedAt`
+
+func scanLogMetric(row rowScanner) (*LogMetric, error) {
+	var m LogMetric
+	var definition string
+	if err := row.Scan(
+		&m.MetricId,
+		&m.AccountId,
+		&m.Name,
+		&m.Description,
+		&definition,
+		&m.Interval,
+		&m.RetentionDays,
+		&m.Paused,
+		&m.CreatedAt,
+		&m.UpdatedAt,
+	); err != nil {
+		return nil, err
+	}
+	var def logMetricDefinition
+	if err := json.Unmarshal([]byte(definition), &def); err != nil {
+		return nil, fmt.Errorf("failed to decode log metric %s: %w", m.MetricId, err)
+	}
+	m.Kind, m.Field, m.Buckets, m.Filter, m.GroupBy = def.Kind, def.Field, def.Buckets, def.Filter, def.GroupBy
+	return &m, nil
+}
+
+// GetLogMetric retrieves a log metric by ID
+func (s *Store) GetLogMetric(ctx context.Context, accountId uint64, metricId string) (*LogMetric, error) {
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM metrics.log_metrics FINAL
+		WHERE AccountId = ? AND MetricId = ?
+	`, logMetricColumnList)
+
+	m, err := scanLogMetric(s.conn.QueryRow(ctx, query, accountId, metricId))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get log metric: %w", err)
+	}
+	return m, nil
+}
+
+// ListLogMetrics returns all log metrics of an account
+func (s *Store) ListLogMetrics(ctx context.Context, accountId uint64) ([]LogMetric, error) {
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM metrics.log_metrics FINAL
+		WHERE AccountId = ?
+		ORDER BY Name
+	`, logMetricColumnList)
+
+	return s.queryLogMetrics(ctx, query, accountId)
+}
+
+// ListActiveLogMetrics returns the unpaused log metrics of every account
+func (s *Store) ListActiveLogMetrics(ctx context.Context) ([]LogMetric, error) {
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM metrics.log_metrics FINAL
+		WHERE Paused = 0
+		ORDER BY AccountId, MetricId
+	`, logMetricColumnList)
+
+	return s.queryLogMetrics(ctx, query)
+}
+
+func (s *Store) queryLogMetrics(ctx context.Context, query string, args ...interface{}) ([]LogMetric, error) {
+	rows, err := s.conn.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list log metrics: %w", err)
+	}
+	defer rows.Close()
+
+	metrics := []LogMetric{}
+	for rows.Next() {
+		m, err := scanLogMetric(rows)
+		if err != nil {
+			return nil, err
+		}
+		metrics = append(metrics, *m)
+	}
+	return metrics, rows.Err()
+}
+
+// DeleteLogMetric deletes a log metric and its evaluation state. Series
+// already written stay until their retention ends.
+func (s *Store) DeleteLogMetric(ctx context.Context, accountId uint64, metricId string) error {
+	for _, table := range []string{"metrics.log_metrics", "metrics.log_metric_state"} {
+		query := fmt.Sprintf(`
+			ALTER TABLE %s
+			DELETE WHERE AccountId = ? AND MetricId = ?
+		`, table)
+		if err := s.conn.Exec(ctx, query, accountId, metricId); err != nil {
+			return fmt.Errorf("failed to delete log metric: %w", err)
+		}
+	}
+	return nil
+}
+
+// ========== LOG METRIC EVALUATION ==========
+
+// SaveLogMetricState records where a metric's evaluation stands
+func (s *Store) SaveLogMetricState(ctx context.Context, st LogMetricState) error {
+	query := `
+		INSERT INTO metrics.log_metric_state
+		(MetricId, AccountId, WindowEnd, EvaluatedAt, SamplesWritten, LastError)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`
+	err := s.conn.Exec(ctx, query, st.MetricId, st.AccountId, st.WindowEnd, st.EvaluatedAt, st.SamplesWritten, st.LastError)
+	if err != nil {
+		return fmt.Errorf("failed to save log metric state: %w", err)
+	}
+	return nil
+}
+
+// GetLogMetricStates returns the evaluation state of each log metric of
+// an account by metric ID, or of every account when accountId is 0
+func (s *Store) GetLogMetricStates(ctx context.Context, accountId uint64) (map[string]LogMetricState, error) {
+	whereClause := ""
+	var args []interface{}
+	if accountId != 0 {
+		whereClause = "WHERE AccountId = ?"
+		args = append(args, accountId)
+	}
+	query := fmt.Sprintf(`
+		SELECT MetricId, AccountId, WindowEnd, EvaluatedAt, SamplesWritten, LastError
+		FROM metrics.log_metric_state FINAL
+		%s
+	`, whereClause)
+
+	rows, err := s.conn.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query log metric states: %w", err)
+	}
+	defer rows.Close()
+
+	states := make(map[string]LogMetricState)
+	for rows.Next() {
+		var st LogMetricState
+		if err := rows.Scan(&st.MetricId, &st.AccountId, &st.WindowEnd, &st.EvaluatedAt, &st.SamplesWritten, &st.LastError); err != nil {
+			return nil, err
+		}
+		states[st.MetricId] = st
+	}
+	return states, rows.Err()
+}
+
+// EvaluateLogMetric computes a log metric over the logs of [from, to) and
+// writes the result into metrics_v1, stamped with the window start. It
+// returns the number of samples written. A window whose samples were
+// already written is not written again, so evaluating it twice is safe.
+func (s *Store) EvaluateLogMetric(ctx context.Context, m *LogMetric, from, to time.Time) (int, error) {
+	// A window's samples are written in one insert, so any sample means
+	// all of them were
+	var written uint64
+	err := s.conn.QueryRow(ctx, `
+		SELECT count()
+		FROM metrics.metrics_v1
+		WHERE AccountId = ?
+		  AND Timestamp = fromUnixTimestamp64Nano(?)
+		  AND ResourceAttributes['log_metric'] = ?
+	`, m.AccountId, from.UnixNano(), m.MetricId).Scan(&written)
+	if err != nil {
+		return 0, fmt.Errorf("failed to check log metric window: %w", err)
+	}
+	if written > 0 {
+		return 0, nil
+	}
+
+	// Group values are collected as an array of strings, in GroupBy order
+	var selectArgs []interface{}
+	groupExprs := make([]string, len(m.GroupBy))
+	for i, g := range m.GroupBy {
+		if col, ok := logMetricColumns[g]; ok {
+			groupExprs[i] = "toString(" + col + ")"
+		} else {
+			groupExprs[i] = "LogAttributes[?]"
+			selectArgs = append(selectArgs, strings.TrimPrefix(g, logMetricAttributePrefix))
+		}
+	}
+	labels := "CAST([] AS Array(String))"
+	if len(groupExprs) > 0 {
+		labels = "[" + strings.Join(groupExprs, ", ") + "]"
+	}
+
+	whereClause := `WHERE AccountId = ?
+			  AND Timestamp >= fromUnixTimestamp64Nano(?)
+			  AND Timestamp < fromUnixTimestamp64Nano(?)`
+	whereArgs := []interface{}{m.AccountId, from.UnixNano(), to.UnixNano()}
+	if len(m.Filter.Services) > 0 {
+		whereClause += " AND has(?, ServiceName)"
+		whereArgs = append(whereArgs, m.Filter.Services)
+	}
+	if len(m.Filter.Severities) > 0 {
+		severities := make([]string, len(m.Filter.Severities))
+		for i, sev := range m.Filter.Severities {
+			severities[i] = strings.ToUpper(sev)
+		}
+		whereClause += " AND has(?, upper(SeverityText))"
+		whereArgs = append(whereArgs, severities)
+	}
+	if m.Filter.Search != "" {
+		whereClause += " AND positionCaseInsensitive(Body, ?) > 0"
+		whereArgs = append(whereArgs, m.Filter.Search)
+	}
+	for k, v := range m.Filter.Attributes {
+		whereClause += " AND LogAttributes[?] = ?"
+		whereArgs = append(whereArgs, k, v)
+	}
+
+	// Distributions count the lines with a numeric value in each bucket,
+	// cumulatively like Prometheus histograms
+	value := "toFloat64(0)"
+	aggregates := "toFloat64(0), CAST([] AS Array(UInt64))"
+	var aggregateArgs []interface{}
+	if m.Kind == LogMetricDistribution {
+		value = "toFloat64OrNull(LogAttributes[?])"
+		selectArgs = append([]interface{}{m.Field}, selectArgs...)
+		whereClause += " AND isNotNull(value)"
+
+		buckets := make([]string, len(m.Buckets))
+		for i, b := range m.Buckets {
+			buckets[i] = "countIf(value <= ?)"
+			aggregateArgs = append(aggregateArgs, b)
+		}
+		aggregates = fmt.Sprintf("ifNull(sum(value), 0), [%s]", strings.Join(buckets, ", "))
+	}
+
+	query := fmt.Sprintf(`
+		SELECT labels, count(), %s
+		FROM (
+			SELECT %s AS value, %s AS labels
+			FROM logs.logs_v1
+			%s
+		)
+		GROUP BY labels
+		ORDER BY count() DESC
+		LIMIT ?
+	`, aggregates, value, labels, whereClause)
+	queryArgs := append(aggregateArgs, selectArgs...)
+	queryArgs = append(queryArgs, whereArgs...)
+	queryArgs = append(queryArgs, maxLogMetricSeries)
+
+	rows, err := s.conn.Query(ctx, query, queryArgs...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to evaluate log metric: %w", err)
+	}
+	defer rows.Close()
+
+	var metrics []Metric
+	for rows.Next() {
+		var values []string
+		var count uint64
+		var sum float64
+		var buckets []uint64
+		if err := rows.Scan(&values, &count, &sum, &buckets); err != nil {
+			return 0, err
+		}
+
+		serviceName := ""
+		labels := make(map[string]string)
+		resourceAttrs := map[string]string{"log_metric": m.MetricId}
+		for i, g := range m.GroupBy {
+			switch {
+			case g == "service_name":
+				serviceName = values[i]
+			case logMetricResourceAttrs[g] != "":
+				resourceAttrs[logMetricResourceAttrs[g]] = values[i]
+				labels[g] = values[i]
+			default:
+				labels[strings.TrimPrefix(g, logMetricAttributePrefix)] = values[i]
+			}
+		}
+
+		metric := func(name, metricType string, value float64, extra map[string]string) Metric {
+			seriesLabels := labels
+			if extra != nil {
+				seriesLabels = make(map[string]string, len(labels)+len(extra))
+				for k, v := range labels {
+					seriesLabels[k] = v
+				}
+				for k, v := range extra {
+					seriesLabels[k] = v
+				}
+			}
+			return Metric{
+				Timestamp:          from,
+				AccountId:          m.AccountId,
+				ServiceName:        serviceName,
+				MetricName:         name,
+				MetricType:         metricType,
+				Value:              value,
+				Labels:             seriesLabels,
+				ResourceAttributes: resourceAttrs,
+				RetentionDays:      m.RetentionDays,
+			}
+		}
+
+		if m.Kind == LogMetricCount {
+			metrics = append(metrics, metric(m.Name, "counter", float64(count), nil))
+			continue
+		}
+		for i, b := range m.Buckets {
+			le := strconv.FormatFloat(b, 'g', -1, 64)
+			metrics = append(metrics, metric(m.Name+"_bucket", "histogram", float64(buckets[i]), map[string]string{"le": le}))
+		}
+		metrics = append(metrics,
+			metric(m.Name+"_bucket", "histogram", float64(count), map[string]string{"le": "+Inf"}),
+			metric(m.Name+"_count", "histogram", float64(count), nil),
+			metric(m.Name+"_sum", "histogram", sum, nil),
+		)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	if len(metrics) == 0 {
+		return 0, nil
+	}
+	if err := s.InsertMetrics(ctx, metrics); err != nil {
+		return 0, fmt.Errorf("failed to write log metric: %w", err)
+	}
+	return len(metrics), nil
+}
@@ -0,0 +1,340 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+)
+
+// Rehydration statuses
+const (
+	LogRehydrationLoading = "loading"
+	LogRehydrationReady   = "ready"
+	LogRehydrationFailed  = "failed"
+	LogRehydrationExpired = "expired" // the table has been dropped
+)
+
+// LogRehydration is a time range of archived logs loaded back into a
+// table of their own, queryable until it expires
+type LogRehydration struct {
+	RehydrationId string    `json:"rehydration_id"`
+	AccountId     uint64    `json:"account_id"`
+	ServiceName   string    `json:"service_name,omitempty"` // empty for all services
+	From          time.Time `json:"from"`
+	To            time.Time `json:"to"`
+	TableName     string    `json:"-"`
+	Status        string    `json:"status"`
+	Rows          uint64    `json:"rows"`
+	Error         string    `json:"error,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+	ExpiresAt     time.Time `json:"expires_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+const (
+	// MaxLogRehydrationSpan bounds the time range of a rehydration
+	MaxLogRehydrationSpan = 31 * 24 * time.Hour
+	// MaxLogRehydrationTTL bounds how long a rehydration is kept
+	MaxLogRehydrationTTL = 7 * 24 * time.Hour
+)
+
+// Validate checks the time range and expiry of a new rehydration
+func (r *LogRehydration) Validate() error {
+	if r.From.IsZero() || r.To.IsZero() {
+		return fmt.Errorf("from and to are required")
+	}
+	if !r.From.Before(r.To) {
+		return fmt.Errorf("from must be before to")
+	}
+	if r.To.Sub(r.From) > MaxLogRehydrationSpan {
+		return fmt.Errorf("time range must be at most %d days", int(MaxLogRehydrationSpan.Hours()/24))
+	}
+	ttl := time.Until(r.ExpiresAt)
+	if ttl <= 0 || ttl > MaxLogRehydrationTTL+time.Minute {
+		return fmt.Errorf("expiry must be within %d days", int(MaxLogRehydrationTTL.Hours()/24))
+	}
+	return nil
+}
+
+// ========== LOG REHYDRATION CRUD OPERATIONS ==========
+
+// SaveLogRehydration creates or replaces a rehydration
+func (s *Store) SaveLogRehydration(ctx context.Context, r *LogRehydration) error {
+	if r.CreatedAt.IsZero() {
+		r.CreatedAt = time.Now()
+	}
+	r.UpdatedAt = time.Now()
+
+	query := `
+		INSERT INTO logs.log_rehydrations
+		(RehydrationId, AccountId, ServiceName, From, To, TableName, Status, Rows, Error, CreatedAt, ExpiresAt, UpdatedAt)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	err := s.conn.Exec(ctx, query,
+		r.RehydrationId,
+		r.AccountId,
+		r.ServiceName,
+		r.From,
+		r.To,
+		r.TableName,
+		r.Status,
+		r.Rows,
+		r.Error,
+		r.CreatedAt,
+		r.ExpiresAt,
+		r.UpdatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save log rehydration: %w", err)
+	}
+	return nil
+}
+
+const logRehydrationColumnList = `RehydrationId, AccountId, ServiceName, From, To, TableName, Status, Rows, Error, CreatedAt, ExpiresAt, UpdatedAt`
+
+func scanLogRehydration(row rowScanner) (*LogRehydration, error) {
+	var r LogRehydration
+	if err := row.Scan(
+		&r.RehydrationId,
+		&r.AccountId,
+		&r.ServiceName,
+		&r.From,
+		&r.To,
+		&r.TableName,
+		&r.Status,
+		&r.Rows,
+		&r.Error,
+		&r.CreatedAt,
+		&r.ExpiresAt,
+		&r.UpdatedAt,
+	); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// GetLogRehydration retrieves a rehydration by ID
+func (s *Store) GetLogRehydration(ctx context.Context, accountId uint64, rehydrationId string) (*LogRehydration, error) {
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM logs.log_rehydrations FINAL
+		WHERE AccountId = ? AND RehydrationId = ?
+	`, logRehydrationColumnList)
+
+	r, err := scanLogRehydration(s.conn.QueryRow(ctx, query, accountId, rehydrationId))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get log rehydration: %w", err)
+	}
+	return r, nil
+}
+
+// ListLogRehydrations returns the rehydrations of an account, newest first
+func (s *Store) ListLogRehydrations(ctx context.Context, accountId uint64) ([]LogRehydration, error) {
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM logs.log_rehydrations FINAL
+		WHERE AccountId = ?
+		ORDER BY CreatedAt DESC
+	`, logRehydrationColumnList)
+
+	return s.queryLogRehydrations(ctx, query, accountId)
+}
+
+// ListExpiredLogRehydrations returns the rehydrations of every account
+// past their expiry whose tables have not been dropped yet
+func (s *Store) ListExpiredLogRehydrations(ctx context.Context) ([]LogRehydration, error) {
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM logs.log_rehydrations FINAL
+		WHERE Status != ? AND ExpiresAt < now64(3)
+		ORDER BY ExpiresAt
+	`, logRehydrationColumnList)
+
+	return s.queryLogRehydrations(ctx, query, LogRehydrationExpired)
+}
+
+func (s *Store) queryLogRehydrations(ctx context.Context, query string, args ...interface{}) ([]LogRehydration, error) {
+	rows, err := s.conn.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list log rehydrations: %w", err)
+	}
+	defer rows.Close()
+
+	rehydrations := []LogRehydration{}
+	for rows.Next() {
+		r, err := scanLogRehydration(rows)
+		if err != nil {
+			return nil, err
+		}
+		rehydrations = append(rehydrations, *r)
+	}
+	return rehydrations, rows.Err()
+}
+
+// ========== LOG REHYDRATION TABLES ==========
+
+// LogRehydrationTable returns the table name of a rehydration
+func LogRehydrationTable(rehydrationId string) string {
+	return "log_rehydration_" + strings.ReplaceAll(rehydrationId, "-", "")
+}
+
+// validRehydrationTable reports whether a name is one made by
+// LogRehydrationTable, as table names cannot be bound
+func validRehydrationTable(name string) bool {
+	suffix, ok := strings.CutPrefix(name, "log_rehydration_")
+	if !ok || suffix == "" {
+		return false
+	}
+	for _, r := range suffix {
+		if !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// LoadLogRehydration creates the rehydration's table and loads the logs
+// of its time range and service from the archives into it. It returns the
+// number of logs loaded. The table has the columns of logs_v1 but none of
+// its TTL, so logs older than the retention stay until it is dropped.
+func (s *Store) LoadLogRehydration(ctx context.Context, target LogArchiveTarget, r *LogRehydration) (uint64, error) {
+	if !validRehydrationTable(r.TableName) {
+		return 0, fmt.Errorf("invalid rehydration table %q", r.TableName)
+	}
+	archives, err := s.ListLogArchives(ctx, r.AccountId, r.From, r.To, r.ServiceName)
+	if err != nil {
+		return 0, err
+	}
+
+	create := fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS logs.%s AS logs.logs_v1
+		ENGINE = MergeTree
+		ORDER BY (ServiceName, Timestamp)
+	`, r.TableName)
+	if err := s.conn.Exec(ctx, create); err != nil {
+		return 0, fmt.Errorf("failed to create rehydration table: %w", err)
+	}
+
+	ctx = clickhouse.Context(ctx, clickhouse.WithSettings(clickhouse.Settings{
+		"date_time_input_format": "best_effort",
+	}))
+	columns := logArchiveColumnList()
+	for _, a := range archives {
+		if a.MaxTimestamp.Before(r.From) || !a.MinTimestamp.Before(r.To) {
+			continue
+		}
+		chFormat := "JSONEachRow"
+		if a.Format == LogArchiveParquet {
+			chFormat = "Parquet"
+		}
+		fn, args := target.s3(a.Key, chFormat, true)
+		query := fmt.Sprintf(`
+			INSERT INTO logs.%s (%s)
+			SELECT %s
+			FROM %s
+			WHERE Timestamp >= fromUnixTimestamp64Nano(?) AND Timestamp < fromUnixTimestamp64Nano(?)
+		`, r.TableName, columns, columns, fn)
+		args = append(args, r.From.UnixNano(), r.To.UnixNano())
+		if err := s.conn.Exec(ctx, query, args...); err != nil {
+			return 0, fmt.Errorf("failed to load archive %s: %w", a.Key, err)
+		}
+	}
+
+	var count uint64
+	if err := s.conn.QueryRow(ctx, fmt.Sprintf("SELECT count() FROM logs.%s", r.TableName)).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count rehydrated logs: %w", err)
+	}
+	return count, nil
+}
+
+// DropLogRehydrationTable drops the rehydration's table, if there is one
+func (s *Store) DropLogRehydrationTable(ctx context.Context, r *LogRehydration) error {
+	if !validRehydrationTable(r.TableName) {
+		return fmt.Errorf("invalid rehydration table %q", r.TableName)
+	}
+	if err := s.conn.Exec(ctx, fmt.Sprintf("DROP TABLE IF EXISTS logs.%s", r.TableName)); err != nil {
+		return fmt.Errorf("failed to drop rehydration table: %w", err)
+	}
+	return nil
+}
+
+// GetRehydratedLogs returns a page of a ready rehydration's logs matching
+// the request's filters, newest first. The request's time range is not
+// used; the rehydration's is.
+func (s *Store) GetRehydratedLogs(ctx context.Context, r *LogRehydration, req LogsListRequest) (*LogsListResponse, error) {
+	if r.Status != LogRehydrationReady {
+		return nil, fmt.Errorf("rehydration is %s, not %s", r.Status, LogRehydrationReady)
+	}
+	if !validRehydrationTable(r.TableName) {
+		return nil, fmt.Errorf("invalid rehydration table %q", r.TableName)
+	}
+
+	whereClause, args := req.filter()
+	query := fmt.Sprintf(`
+		SELECT
+			LogId,
+			Timestamp,
+			AccountId,
+			HostName,
+			ServiceName,
+			Namespace,
+			Pod,
+			SeverityText,
+			Body,
+			TraceId,
+			SpanId
+		FROM logs.%s
+		%s
+		ORDER BY Timestamp DESC, LogId DESC
+		LIMIT ? OFFSET ?
+	`, r.TableName, whereClause)
+	queryArgs := append(append([]interface{}{}, args...), req.PageSize, (req.Page-1)*req.PageSize)
+
+	rows, err := s.conn.Query(ctx, query, queryArgs...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query rehydrated logs: %w", err)
+	}
+	defer rows.Close()
+
+	logs := []LogEntry{}
+	for rows.Next() {
+		var log LogEntry
+		err := rows.Scan(
+			&log.LogId,
+			&log.Timestamp,
+			&log.AccountId,
+			&log.HostName,
+			&log.ServiceName,
+			&log.Namespace,
+			&log.Pod,
+			&log.SeverityText,
+			&log.Body,
+			&log.TraceId,
+			&log.SpanId,
+		)
+		if err != nil {
+			return nil, err
+		}
+		logs = append(logs, log)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var total uint64
+	countQuery := fmt.Sprintf("SELECT count() FROM logs.%s %s", r.TableName, whereClause)
+	if err := s.conn.QueryRow(ctx, countQuery, args...).Scan(&total); err != nil {
+		return nil, fmt.Errorf("failed to count rehydrated logs: %w", err)
+	}
+	totalCount := int(total)
+
+	return &LogsListResponse{
+		Logs:       logs,
+		TotalCount: &totalCount,
+		Page:       req.Page,
+		PageSize:   req.PageSize,
+	}, nil
+}
@@ -0,0 +1,279 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Outputs of log aggregations
+const (
+	LogAggregationTimeseries = "timeseries" // one point per interval
+	LogAggregationTop        = "top"        // one point per group over the whole window, largest first
+)
+
+const (
+	defaultLogAggregationLimit = 10
+	maxLogAggregationLimit     = 100
+)
+
+// logAggregationFuncs are the aggregations of log queries, applied to the
+// field as text (uniq) or as a number (the others)
+var logAggregationFuncs = map[string]string{
+	"count": "count()",
+	"uniq":  "uniq(%s)",
+	"sum":   "sum(%s)",
+	"avg":   "avg(%s)",
+	"min":   "min(%s)",
+	"max":   "max(%s)",
+	"p50":   "quantile(0.50)(%s)",
+	"p95":   "quantile(0.95)(%s)",
+	"p99":   "quantile(0.99)(%s)",
+}
+
+// LogAggregation makes a MetricQuery aggregate logs from logs_v1 instead
+// of a metric. The query's Aggregation (count by default, uniq, sum, avg,
+// min, max, p50, p95 or p99) applies to Field; GroupBy and Filters name
+// log fields as in log queries: columns such as service or host, or
+// LogAttributes keys.
+type LogAggregation struct {
+	Query  string `json:"query,omitempty"`  // log query selecting the logs, see LogQuery
+	Field  string `json:"field,omitempty"`  // aggregated field, required except for count
+	Output string `json:"output,omitempty"` // timeseries (default) or top
+	Limit  int    `json:"limit,omitempty"`  // groups kept, the largest first; default 10, up to 100
+}
+
+// validateLogQuery checks the log aggregation of a query
+func validateLogQuery(q MetricQuery) error {
+	if q.MetricName != "" || q.Formula != "" {
+		return fmt.Errorf("log queries take no metric_name or formula")
+	}
+	agg := q.Aggregation
+	if agg == "" {
+		agg = "count"
+	}
+	if _, ok := logAggregationFuncs[agg]; !ok {
+		return fmt.Errorf("unknown log aggregation %q", q.Aggregation)
+	}
+	if agg != "count" && q.Logs.Field == "" {
+		return fmt.Errorf("%s needs a field", agg)
+	}
+	switch q.Logs.Output {
+	case "", LogAggregationTimeseries, LogAggregationTop:
+	default:
+		return fmt.Errorf("output must be %s or %s", LogAggregationTimeseries, LogAggregationTop)
+	}
+	if q.Logs.Limit < 0 || q.Logs.Limit > maxLogAggregationLimit {
+		return fmt.Errorf("limit must be between 0 and %d", maxLogAggregationLimit)
+	}
+	names := append(append([]string{q.Logs.Field}, q.GroupBy...), filterKeys(q)...)
+	for _, name := range names {
+		if name == "" {
+			continue
+		}
+		if _, err := resolveLogQueryField(name); err != nil {
+			return err
+		}
+	}
+	if _, err := ParseLogQuery(q.Logs.Query); err != nil {
+		return err
+	}
+	return nil
+}
+
+// filterKeys returns the keys a query filters on
+func filterKeys(q MetricQuery) []string {
+	keys := make([]string, 0, len(q.Filters)+len(q.multiFilters))
+	for key := range q.Filters {
+		keys = append(keys, key)
+	}
+	for key := range q.multiFilters {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// queryLogSeries runs a log aggregation over [from, to). Time series are
+// bucketed by interval seconds, with one series per group; top tables have
+// one series per group holding a single point at from.
+func (s *Store) queryLogSeries(ctx context.Context, accountId uint64, q MetricQuery, from, to time.Time, interval int) ([]MetricSeries, error) {
+	if err := validateLogQuery(q); err != nil {
+		return nil, err
+	}
+	logQuery, _ := ParseLogQuery(q.Logs.Query)
+
+	agg := q.Aggregation
+	if agg == "" {
+		agg = "count"
+	}
+	limit := q.Logs.Limit
+	if limit == 0 {
+		limit = defaultLogAggregationLimit
+	}
+
+	whereClause := "WHERE AccountId = ? AND Timestamp >= fromUnixTimestamp64Nano(?) AND Timestamp < fromUnixTimestamp64Nano(?)"
+	whereArgs := []interface{}{accountId, from.UnixNano(), to.UnixNano()}
+	for key, value := range q.Filters {
+		field, _ := resolveLogQueryField(key)
+		cond, err := field.equals(value)
+		if err != nil {
+			return nil, err
+		}
+		whereClause += " AND " + cond.sql
+		whereArgs = append(whereArgs, cond.args...)
+	}
+	for key, values := range q.multiFilters {
+		field, _ := resolveLogQueryField(key)
+		expr, args := field.text()
+		whereClause += " AND has(?, " + expr + ")"
+		whereArgs = append(append(whereArgs, values), args...)
+	}
+	if logQuery != nil {
+		cond, args := logQuery.condition()
+		whereClause += " AND " + cond
+		whereArgs = append(whereArgs, args...)
+	}
+
+	// Logs without a value for the field are left out of its aggregation
+	valueExpr := "toFloat64(count())"
+	var valueArgs []interface{}
+	if agg != "count" {
+		field, _ := resolveLogQueryField(q.Logs.Field)
+		var expr string
+		if agg == "uniq" {
+			expr, valueArgs = field.text()
+			whereClause += " AND " + expr + " != ''"
+		} else {
+			var number string
+			number, valueArgs = field.number()
+			expr = "assumeNotNull(" + number + ")"
+			whereClause += " AND " + number + " IS NOT NULL"
+		}
+		whereArgs = append(whereArgs, valueArgs...)
+		valueExpr = "toFloat64(" + fmt.Sprintf(logAggregationFuncs[agg], expr) + ")"
+	}
+
+	var labelExprs, labelNames []string
+	var labelArgs []interface{}
+	for i, key := range q.GroupBy {
+		field, _ := resolveLogQueryField(key)
+		expr, args := field.text()
+		labelExprs = append(labelExprs, expr)
+		labelNames = append(labelNames, fmt.Sprintf("label_%d", i))
+		labelArgs = append(labelArgs, args...)
+	}
+	selectLabels := ""
+	for i, expr := range labelExprs {
+		selectLabels += fmt.Sprintf(", %s as %s", expr, labelNames[i])
+	}
+
+	var query string
+	var args []interface{}
+	if q.Logs.Output == LogAggregationTop {
+		groupBy := ""
+		if len(labelNames) > 0 {
+			groupBy = "GROUP BY " + strings.Join(labelNames, ", ")
+		}
+		query = fmt.Sprintf(`
+			SELECT
+				%s as value
+				%s
+			FROM logs.logs_v1
+			%s
+			%s
+			ORDER BY value DESC
+			LIMIT ?
+		`, valueExpr, selectLabels, whereClause, groupBy)
+		args = append(append(append(append([]interface{}{}, valueArgs...), labelArgs...), whereArgs...), limit)
+	} else {
+		// Only the largest groups over the whole window get a series
+		groupBy, groupFilter := "", ""
+		var groupArgs []interface{}
+		if len(labelNames) > 0 {
+			groupBy = ", " + strings.Join(labelNames, ", ")
+			groupFilter = fmt.Sprintf(`
+			  AND (%s) IN (
+				SELECT %s
+				FROM logs.logs_v1
+				%s
+				GROUP BY %s
+				ORDER BY %s DESC
+				LIMIT ?
+			  )`, strings.Join(labelExprs, ", "), strings.Join(labelExprs, ", "), whereClause,
+				strings.Join(labelExprs, ", "), valueExpr)
+			groupArgs = append(append(append(append(append([]interface{}{}, labelArgs...), labelArgs...), whereArgs...), labelArgs...), valueArgs...)
+			groupArgs = append(groupArgs, limit)
+		}
+		query = fmt.Sprintf(`
+			SELECT
+				toStartOfInterval(Timestamp, INTERVAL %d SECOND) as time_bucket,
+				%s as value
+				%s
+			FROM logs.logs_v1
+			%s%s
+			GROUP BY time_bucket%s
+			ORDER BY time_bucket
+		`, interval, valueExpr, selectLabels, whereClause, groupFilter, groupBy)
+		args = append(append(append(append([]interface{}{}, valueArgs...), labelArgs...), whereArgs...), groupArgs...)
+	}
+
+	rows, err := s.conn.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query log aggregation: %w", err)
+	}
+	defer rows.Close()
+
+	name := q.Alias
+	if name == "" {
+		name = agg
+		if agg != "count" {
+			name = fmt.Sprintf("%s(%s)", agg, q.Logs.Field)
+		}
+	}
+
+	seriesMap := make(map[string]*MetricSeries)
+	var seriesOrder []string
+	for rows.Next() {
+		var timestamp time.Time
+		var value float64
+		scanDest := []interface{}{&value}
+		if q.Logs.Output != LogAggregationTop {
+			scanDest = []interface{}{&timestamp, &value}
+		}
+		labelValues := make([]string, len(q.GroupBy))
+		for i := range labelValues {
+			scanDest = append(scanDest, &labelValues[i])
+		}
+		if err := rows.Scan(scanDest...); err != nil {
+			return nil, err
+		}
+		if q.Logs.Output == LogAggregationTop {
+			timestamp = from
+		}
+
+		labels := make(map[string]string, len(q.GroupBy))
+		for i, key := range q.GroupBy {
+			labels[key] = labelValues[i]
+		}
+		seriesKey := strings.Join(labelValues, "\x00")
+		series, ok := seriesMap[seriesKey]
+		if !ok {
+			series = &MetricSeries{Name: name, Labels: labels, DataPoints: []DataPoint{}}
+			seriesMap[seriesKey] = series
+			seriesOrder = append(seriesOrder, seriesKey)
+		}
+		series.DataPoints = append(series.DataPoints, DataPoint{Timestamp: timestamp, Value: value})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	results := make([]MetricSeries, 0, len(seriesOrder))
+	for _, key := range seriesOrder {
+		series := seriesMap[key]
+		calculateStats(series)
+		results = append(results, *series)
+	}
+	return results, nil
+}
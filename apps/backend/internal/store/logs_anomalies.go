@@ -0,0 +1,298 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Log pattern anomaly kinds
+const (
+	LogAnomalyNewPattern   = "new_pattern"    // first time the service logged the pattern
+	LogAnomalyNewInVersion = "new_in_version" // logged by a version after the service had stopped logging it
+	LogAnomalySpike        = "spike"          // logged far more often than its baseline
+	LogAnomalyDrop         = "drop"           // logged far less often than its baseline
+)
+
+var knownLogAnomalyKinds = map[string]bool{
+	LogAnomalyNewPattern: true, LogAnomalyNewInVersion: true, LogAnomalySpike: true, LogAnomalyDrop: true,
+}
+
+// ValidLogAnomalyKind reports whether kind is a log pattern anomaly kind
+func ValidLogAnomalyKind(kind string) bool {
+	return knownLogAnomalyKinds[kind]
+}
+
+// LogPatternAnomaly is a pattern that is new to a service or version, or
+// whose frequency moved sharply away from its baseline
+type LogPatternAnomaly struct {
+	AnomalyId      string    `json:"anomaly_id"`
+	AccountId      uint64    `json:"account_id"`
+	Kind           string    `json:"kind"`
+	ServiceName    string    `json:"service_name"`
+	ServiceVersion string    `json:"service_version"`
+	PatternHash    string    `json:"pattern_hash"`
+	Template       string    `json:"template"`
+	Severity       string    `json:"severity"`
+	Count          uint64    `json:"count"`    // lines in the detection window
+	Expected       float64   `json:"expected"` // lines the baseline predicts for the window, 0 for new patterns
+	Score          float64   `json:"score"`    // deviation from the baseline in standard deviations, 0 for new patterns
+	FirstSeen      time.Time `json:"first_seen"`
+	DetectedAt     time.Time `json:"detected_at"`
+	Alerted        bool      `json:"alerted"`
+}
+
+// LogAnomalyFilter selects anomalies detected after From
+type LogAnomalyFilter struct {
+	AccountId uint64 // 0 for every account
+	From      time.Time
+	Services  []string
+	Kinds     []string
+	Limit     int
+}
+
+// LogPatternVersionStats is when a version of a service logged a pattern
+type LogPatternVersionStats struct {
+	AccountId      uint64
+	ServiceName    string
+	PatternHash    string
+	ServiceVersion string
+	Severity       string
+	Count          uint64
+	FirstSeen      time.Time
+	LastSeen       time.Time
+}
+
+// LogPatternRate is how often a service logged a pattern in a window
+type LogPatternRate struct {
+	AccountId      uint64
+	ServiceName    string
+	PatternHash    string
+	ServiceVersion string // most recent version logging it
+	Severity       string
+	Count          uint64
+	FirstSeen      time.Time // of the pattern within the baseline range, for baselines
+}
+
+const logAnomalyColumns = `AnomalyId, AccountId, Kind, ServiceName, ServiceVersion, PatternHash, Template,
+		Severity, Count, Expected, Score, FirstSeen, DetectedAt, Alerted`
+
+// SaveLogPatternAnomaly records an anomaly. Anomalies have deterministic
+// IDs, so saving one again replaces it.
+func (s *Store) SaveLogPatternAnomaly(ctx context.Context, a *LogPatternAnomaly) error {
+	query := fmt.Sprintf(`
+		INSERT INTO logs.log_pattern_anomalies
+		(%s)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, logAnomalyColumns)
+
+	err := s.conn.Exec(ctx, query,
+		a.AnomalyId,
+		a.AccountId,
+		a.Kind,
+		a.ServiceName,
+		a.ServiceVersion,
+		a.PatternHash,
+		a.Template,
+		a.Severity,
+		a.Count,
+		a.Expected,
+		a.Score,
+		a.FirstSeen,
+		a.DetectedAt,
+		a.Alerted,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save log anomaly: %w", err)
+	}
+	return nil
+}
+
+// GetLogPatternAnomalies returns the anomalies matching the filter, newest
+// first
+func (s *Store) GetLogPatternAnomalies(ctx context.Context, f LogAnomalyFilter) ([]LogPatternAnomaly, error) {
+	whereClause := "WHERE DetectedAt >= ?"
+	args := []interface{}{f.From}
+	if f.AccountId != 0 {
+		whereClause += " AND AccountId = ?"
+		args = append(args, f.AccountId)
+	}
+	if len(f.Services) > 0 {
+		whereClause += " AND has(?, ServiceName)"
+		args = append(args, f.Services)
+	}
+	if len(f.Kinds) > 0 {
+		whereClause += " AND has(?, Kind)"
+		args = append(args, f.Kinds)
+	}
+	if f.Limit <= 0 {
+		f.Limit = 100
+	}
+	args = append(args, f.Limit)
+
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM logs.log_pattern_anomalies FINAL
+		%s
+		ORDER BY DetectedAt DESC
+		LIMIT ?
+	`, logAnomalyColumns, whereClause)
+
+	rows, err := s.conn.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query log anomalies: %w", err)
+	}
+	defer rows.Close()
+
+	anomalies := []LogPatternAnomaly{}
+	for rows.Next() {
+		var a LogPatternAnomaly
+		if err := rows.Scan(
+			&a.AnomalyId,
+			&a.AccountId,
+			&a.Kind,
+			&a.ServiceName,
+			&a.ServiceVersion,
+			&a.PatternHash,
+			&a.Template,
+			&a.Severity,
+			&a.Count,
+			&a.Expected,
+			&a.Score,
+			&a.FirstSeen,
+			&a.DetectedAt,
+			&a.Alerted,
+		); err != nil {
+			return nil, err
+		}
+		anomalies = append(anomalies, a)
+	}
+	return anomalies, rows.Err()
+}
+
+// GetLogPatternVersionStats returns, for every pattern a version of a
+// service started logging in an hour since the given time, when each
+// version of that service logged the pattern. The versions that logged it
+// before tell whether the pattern is new.
+func (s *Store) GetLogPatternVersionStats(ctx context.Context, since time.Time) ([]LogPatternVersionStats, error) {
+	query := `
+		SELECT
+			AccountId,
+			ServiceName,
+			PatternHash,
+			ServiceVersion,
+			anyLast(Severity),
+			sum(Count),
+			min(FirstSeen),
+			max(LastSeen)
+		FROM logs.log_pattern_stats
+		WHERE (AccountId, ServiceName, PatternHash) IN (
+			SELECT DISTINCT AccountId, ServiceName, PatternHash
+			FROM logs.log_pattern_stats
+			WHERE Hour >= toStartOfHour(fromUnixTimestamp64Nano(?))
+			  AND FirstSeen > fromUnixTimestamp64Nano(?)
+		)
+		GROUP BY AccountId, ServiceName, PatternHash, ServiceVersion
+	`
+	rows, err := s.conn.Query(ctx, query, since.UnixNano(), since.UnixNano())
+	if err != nil {
+		return nil, fmt.Errorf("failed to query log pattern versions: %w", err)
+	}
+	defer rows.Close()
+
+	var stats []LogPatternVersionStats
+	for rows.Next() {
+		var st LogPatternVersionStats
+		if err := rows.Scan(&st.AccountId, &st.ServiceName, &st.PatternHash, &st.ServiceVersion, &st.Severity, &st.Count, &st.FirstSeen, &st.LastSeen); err != nil {
+			return nil, err
+		}
+		stats = append(stats, st)
+	}
+	return stats, rows.Err()
+}
+
+// GetLogPatternServiceStarts returns when each of the services first
+// logged a pattern, in every account
+func (s *Store) GetLogPatternServiceStarts(ctx context.Context, services []string) ([]LogPatternVersionStats, error) {
+	query := `
+		SELECT AccountId, ServiceName, min(FirstSeen)
+		FROM logs.log_pattern_stats
+		WHERE has(?, ServiceName)
+		GROUP BY AccountId, ServiceName
+	`
+	rows, err := s.conn.Query(ctx, query, services)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query log pattern service starts: %w", err)
+	}
+	defer rows.Close()
+
+	var starts []LogPatternVersionStats
+	for rows.Next() {
+		var st LogPatternVersionStats
+		if err := rows.Scan(&st.AccountId, &st.ServiceName, &st.FirstSeen); err != nil {
+			return nil, err
+		}
+		starts = append(starts, st)
+	}
+	return starts, rows.Err()
+}
+
+// GetLogPatternRates counts the lines of every pattern logged in the last
+// window
+func (s *Store) GetLogPatternRates(ctx context.Context, window time.Duration) ([]LogPatternRate, error) {
+	query := `
+		SELECT
+			AccountId,
+			ServiceName,
+			PatternHash,
+			argMax(ServiceVersion, Timestamp),
+			topK(1)(SeverityText)[1],
+			count(),
+			min(Timestamp)
+		FROM logs.logs_v1
+		WHERE Timestamp > now() - INTERVAL ? SECOND
+		  AND PatternHash != ''
+		GROUP BY AccountId, ServiceName, PatternHash
+	`
+	return s.queryLogPatternRates(ctx, query, int(window.Seconds()))
+}
+
+// GetLogPatternBaselines counts the lines of every pattern logged in the
+// whole hours of [from, to)
+func (s *Store) GetLogPatternBaselines(ctx context.Context, from, to time.Time) ([]LogPatternRate, error) {
+	query := `
+		SELECT
+			AccountId,
+			ServiceName,
+			PatternHash,
+			'',
+			anyLast(Severity),
+			sum(Count),
+			min(FirstSeen)
+		FROM logs.log_pattern_stats
+		WHERE Hour >= toStartOfHour(fromUnixTimestamp64Nano(?))
+		  AND Hour < toStartOfHour(fromUnixTimestamp64Nano(?))
+		GROUP BY AccountId, ServiceName, PatternHash
+	`
+	return s.queryLogPatternRates(ctx, query, from.UnixNano(), to.UnixNano())
+}
+
+func (s *Store) queryLogPatternRates(ctx context.Context, query string, args ...interface{}) ([]LogPatternRate, error) {
+	rows, err := s.conn.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query log pattern rates: %w", err)
+	}
+	defer rows.Close()
+
+	var rates []LogPatternRate
+	for rows.Next() {
+		var r LogPatternRate
+		if err := rows.Scan(&r.AccountId, &r.ServiceName, &r.PatternHash, &r.ServiceVersion, &r.Severity, &r.Count, &r.FirstSeen); err != nil {
+			return nil, err
+		}
+		r.Severity = strings.ToUpper(r.Severity)
+		rates = append(rates, r)
+	}
+	return rates, rows.Err()
+}
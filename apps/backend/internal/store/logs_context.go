@@ -0,0 +1,155 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// contextWindow bounds how far from the anchor context lines are looked for,
+// keeping the read to a narrow slice of the (AccountId, ServiceName,
+// Timestamp) order
+const contextWindow = time.Hour
+
+// LogContextRequest identifies the log to show context for and how many
+// lines to return on each side
+type LogContextRequest struct {
+	AccountId uint64
+	Log       LogRef
+	Before    int
+	After     int
+}
+
+// LogContextResponse holds the anchor log with the lines around it from the
+// same source, oldest first
+type LogContextResponse struct {
+	Log    LogEntry   `json:"log"`
+	Scope  string     `json:"scope"` // container_id, pod, host_name or service_name
+	Before []LogEntry `json:"before"`
+	After  []LogEntry `json:"after"`
+}
+
+// GetLogContext returns the lines logged right before and after a log by
+// the same container, or the same pod or host when the container is not
+// known. It returns sql.ErrNoRows when the log does not exist.
+func (s *Store) GetLogContext(ctx context.Context, req LogContextRequest) (*LogContextResponse, error) {
+	refWhere, refArgs := req.Log.filter()
+	anchors, err := s.queryLogContext(ctx, "WHERE AccountId = ?"+refWhere+" ORDER BY LogId LIMIT 1", append([]interface{}{req.AccountId}, refArgs...))
+	if err != nil {
+		return nil, err
+	}
+	if len(anchors) == 0 {
+		return nil, fmt.Errorf("failed to get log context: %w", sql.ErrNoRows)
+	}
+	anchor := anchors[0]
+
+	resp := &LogContextResponse{Log: anchor, Before: []LogEntry{}, After: []LogEntry{}}
+	scopeWhere := "WHERE AccountId = ? AND ServiceName = ?"
+	scopeArgs := []interface{}{req.AccountId, anchor.ServiceName}
+	switch {
+	case anchor.ContainerId != "":
+		resp.Scope = "container_id"
+		scopeWhere += " AND ContainerId = ?"
+		scopeArgs = append(scopeArgs, anchor.ContainerId)
+	case anchor.Pod != "":
+		resp.Scope = "pod"
+		scopeWhere += " AND Pod = ?"
+		scopeArgs = append(scopeArgs, anchor.Pod)
+	case anchor.HostName != "":
+		resp.Scope = "host_name"
+		scopeWhere += " AND HostName = ?"
+		scopeArgs = append(scopeArgs, anchor.HostName)
+	default:
+		resp.Scope = "service_name"
+	}
+	at := anchor.Timestamp.UnixNano()
+
+	if req.Before > 0 {
+		where := scopeWhere + `
+			  AND Timestamp >= fromUnixTimestamp64Nano(?)
+			  AND Timestamp <= fromUnixTimestamp64Nano(?)
+			  AND (Timestamp < fromUnixTimestamp64Nano(?) OR LogId < ?)
+			ORDER BY Timestamp DESC, LogId DESC
+			LIMIT ?`
+		args := append(slices.Clone(scopeArgs), anchor.Timestamp.Add(-contextWindow).UnixNano(), at, at, anchor.LogId, req.Before)
+		before, err := s.queryLogContext(ctx, where, args)
+		if err != nil {
+			return nil, err
+		}
+		slices.Reverse(before)
+		resp.Before = before
+	}
+
+	if req.After > 0 {
+		where := scopeWhere + `
+			  AND Timestamp >= fromUnixTimestamp64Nano(?)
+			  AND Timestamp <= fromUnixTimestamp64Nano(?)
+			  AND (Timestamp > fromUnixTimestamp64Nano(?) OR LogId > ?)
+			ORDER BY Timestamp ASC, LogId ASC
+			LIMIT ?`
+		args := append(slices.Clone(scopeArgs), at, anchor.Timestamp.Add(contextWindow).UnixNano(), at, anchor.LogId, req.After)
+		after, err := s.queryLogContext(ctx, where, args)
+		if err != nil {
+			return nil, err
+		}
+		resp.After = after
+	}
+
+	return resp, nil
+}
+
+// queryLogContext reads logs for the given WHERE clause and ordering
+func (s *Store) queryLogContext(ctx context.Context, clause string, args []interface{}) ([]LogEntry, error) {
+	query := fmt.Sprintf(`
+		SELECT
+			LogId,
+			Timestamp,
+			AccountId,
+			HostName,
+			ServiceName,
+			Namespace,
+			Pod,
+			Container,
+			ContainerId,
+			SeverityNumber,
+			SeverityText,
+			substring(Body, 1, %d) as BodyPreview,
+			TraceId,
+			SpanId
+		FROM logs.logs_v1
+		%s
+	`, tailBodyLength, clause)
+
+	rows, err := s.conn.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query log context: %w", err)
+	}
+	defer rows.Close()
+
+	logs := []LogEntry{}
+	for rows.Next() {
+		var log LogEntry
+		if err := rows.Scan(
+			&log.LogId,
+			&log.Timestamp,
+			&log.AccountId,
+			&log.HostName,
+			&log.ServiceName,
+			&log.Namespace,
+			&log.Pod,
+			&log.Container,
+			&log.ContainerId,
+			&log.SeverityNumber,
+			&log.SeverityText,
+			&log.Body,
+			&log.TraceId,
+			&log.SpanId,
+		); err != nil {
+			return nil, err
+		}
+		logs = append(logs, log)
+	}
+	return logs, rows.Err()
+}
@@ -0,0 +1,85 @@
+package store
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Count modes for log lists
+const (
+	LogCountExact  = "exact"  // count every matching log
+	LogCountApprox = "approx" // count up to approxCountLimit, then report a lower bound
+	LogCountNone   = "none"   // skip counting
+)
+
+// approxCountLimit is where approximate counts stop
+const approxCountLimit = 10000
+
+const (
+	cursorNext = "next" // towards older logs
+	cursorPrev = "prev" // towards newer logs
+)
+
+// ErrInvalidCursor is returned for cursors this server did not issue
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// logCursor is the position a page of logs starts after, sent to clients as
+// an opaque string
+type logCursor struct {
+	Timestamp int64  `json:"t"` // unix nanoseconds of the row the cursor points past
+	LogId     string `json:"k"` // ID of that row, ordering rows sharing the timestamp
+	Direction string `json:"d"`
+	From      int64  `json:"f"` // start of the time window, unix nanoseconds
+}
+
+func (c logCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeLogCursor(s string) (*logCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var c logCursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	if c.Direction != cursorNext && c.Direction != cursorPrev {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+// ValidLogCount reports whether mode is a count mode, or empty for the default
+func ValidLogCount(mode string) bool {
+	switch mode {
+	case "", LogCountExact, LogCountApprox, LogCountNone:
+		return true
+	}
+	return false
+}
+
+// countLogs counts the logs matching whereClause. Approximate counts stop
+// at approxCountLimit and report whether they did, which keeps them cheap
+// on large windows.
+func (s *Store) countLogs(ctx context.Context, whereClause string, args []interface{}, mode string) (int, bool, error) {
+	query := fmt.Sprintf("SELECT count() FROM logs.logs_v1 %s", whereClause)
+	if mode == LogCountApprox {
+		query = fmt.Sprintf("SELECT count() FROM (SELECT 1 FROM logs.logs_v1 %s LIMIT ?)", whereClause)
+		args = append(args, approxCountLimit+1)
+	}
+
+	var total uint64
+	if err := s.conn.QueryRow(ctx, query, args...).Scan(&total); err != nil {
+		return 0, false, fmt.Errorf("failed to count logs: %w", err)
+	}
+	if mode == LogCountApprox && total > approxCountLimit {
+		return approxCountLimit, true, nil
+	}
+	return int(total), false, nil
+}
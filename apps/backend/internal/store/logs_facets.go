@@ -0,0 +1,253 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// logFacetColumns maps the built-in facet fields, named like the logs
+// filters, to their columns
+var logFacetColumns = map[string]string{
+	"service":   "ServiceName",
+	"host":      "HostName",
+	"severity":  "SeverityText",
+	"env":       "Env",
+	"namespace": "Namespace",
+	"pod":       "Pod",
+	"container": "Container",
+	"source":    "Source",
+}
+
+// DefaultLogFacetFields are the built-in fields faceted when none are asked for
+var DefaultLogFacetFields = []string{"service", "host", "severity", "namespace", "pod"}
+
+// LogFacetAttributePrefix starts the facet fields of LogAttributes keys
+const LogFacetAttributePrefix = "attributes."
+
+// LogFacetsRequest selects the fields to facet under a set of log filters
+type LogFacetsRequest struct {
+	LogsListRequest
+	Fields        []string // built-in fields and attributes.<key>; defaults to DefaultLogFacetFields
+	Limit         int      // top values per field
+	AttributeKeys int      // most common LogAttributes keys added to the fields, 0 for none
+}
+
+// LogFacetValue is how many matching logs have a value
+type LogFacetValue struct {
+	Value string `json:"value"`
+	Count uint64 `json:"count"`
+}
+
+// LogFacet is the distribution of a field's values over the matching logs
+type LogFacet struct {
+	Field       string          `json:"field"`
+	Values      []LogFacetValue `json:"values"`      // most common first
+	Count       uint64          `json:"count"`       // logs with a value for the field
+	Other       uint64          `json:"other"`       // logs with a value not in values
+	Cardinality uint64          `json:"cardinality"` // estimated distinct values
+}
+
+// LogFacetsResponse holds the facets of the logs matching a request
+type LogFacetsResponse struct {
+	Total  int        `json:"total"` // matching logs
+	Facets []LogFacet `json:"facets"`
+}
+
+// ValidateLogFacetField checks that a field is a built-in facet field or
+// an attribute key
+func ValidateLogFacetField(field string) error {
+	if _, ok := logFacetColumns[field]; ok {
+		return nil
+	}
+	if key, ok := strings.CutPrefix(field, LogFacetAttributePrefix); ok && key != "" {
+		return nil
+	}
+	fields := make([]string, 0, len(logFacetColumns))
+	for f := range logFacetColumns {
+		fields = append(fields, f)
+	}
+	slices.Sort(fields)
+	return fmt.Errorf("unknown facet field %q; fields are %s or %s<key>", field, strings.Join(fields, ", "), LogFacetAttributePrefix)
+}
+
+// GetLogFacets returns the top values and estimated cardinality of each
+// field over the logs matching the request's filters. Logs without a
+// value for a field are not counted in its facet.
+func (s *Store) GetLogFacets(ctx context.Context, req LogFacetsRequest) (*LogFacetsResponse, error) {
+	whereClause, args := req.filter()
+	whereClause += " AND Timestamp > now() - INTERVAL ? MINUTE"
+	args = append(args, req.TimeRangeMin)
+
+	fields := req.Fields
+	if len(fields) == 0 {
+		fields = DefaultLogFacetFields
+	}
+	var columns, keys []string
+	for _, field := range fields {
+		if err := ValidateLogFacetField(field); err != nil {
+			return nil, err
+		}
+		if key, ok := strings.CutPrefix(field, LogFacetAttributePrefix); ok {
+			if !slices.Contains(keys, key) {
+				keys = append(keys, key)
+			}
+		} else if !slices.Contains(columns, field) {
+			columns = append(columns, field)
+		}
+	}
+	if req.AttributeKeys > 0 {
+		common, err := s.commonLogAttributeKeys(ctx, whereClause, args, req.AttributeKeys)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range common {
+			if !slices.Contains(keys, key) {
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	// Built-in fields come first, then attributes, each in the order asked
+	order := make([]string, 0, len(columns)+len(keys))
+	order = append(order, columns...)
+	for _, key := range keys {
+		order = append(order, LogFacetAttributePrefix+key)
+	}
+	facets := make(map[string]*LogFacet, len(order))
+	for _, field := range order {
+		facets[field] = &LogFacet{Field: field, Values: []LogFacetValue{}}
+	}
+
+	total, _, err := s.countLogs(ctx, whereClause, args, LogCountExact)
+	if err != nil {
+		return nil, err
+	}
+	if total > 0 {
+		if err := s.queryLogFacets(ctx, facets, columns, keys, whereClause, args, req.Limit); err != nil {
+			return nil, err
+		}
+	}
+
+	resp := &LogFacetsResponse{Total: total, Facets: make([]LogFacet, 0, len(order))}
+	for _, field := range order {
+		resp.Facets = append(resp.Facets, *facets[field])
+	}
+	return resp, nil
+}
+
+// queryLogFacets fills in the counts and top values of the facets
+func (s *Store) queryLogFacets(ctx context.Context, facets map[string]*LogFacet, columns, keys []string, whereClause string, args []interface{}, limit int) error {
+	// Each log is expanded into a (field, value) pair per faceted field it
+	// has a value for. Column names come from logFacetColumns; attribute
+	// keys are bound.
+	pairs := make([]string, 0, len(columns))
+	for _, field := range columns {
+		pairs = append(pairs, fmt.Sprintf("('%s', toString(%s))", field, logFacetColumns[field]))
+	}
+	expr := "[" + strings.Join(pairs, ", ") + "]"
+	exprArgs := []interface{}{}
+	if len(keys) > 0 {
+		expr = fmt.Sprintf("arrayConcat(%s, arrayMap(k -> ('%s' || k, LogAttributes[k]), ?))", expr, LogFacetAttributePrefix)
+		exprArgs = append(exprArgs, keys)
+	}
+	if len(columns) == 0 {
+		// An empty literal has no tuple type to concatenate with
+		expr = fmt.Sprintf("arrayMap(k -> ('%s' || k, LogAttributes[k]), ?)", LogFacetAttributePrefix)
+	}
+	expr = "arrayFilter(f -> f.2 != '', " + expr + ")"
+
+	statsQuery := fmt.Sprintf(`
+		SELECT
+			f.1 as field,
+			count() as present,
+			uniq(f.2) as cardinality
+		FROM logs.logs_v1
+		ARRAY JOIN %s AS f
+		%s
+		GROUP BY field
+	`, expr, whereClause)
+	rows, err := s.conn.Query(ctx, statsQuery, append(append([]interface{}{}, exprArgs...), args...)...)
+	if err != nil {
+		return fmt.Errorf("failed to query log facet stats: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var field string
+		var present, cardinality uint64
+		if err := rows.Scan(&field, &present, &cardinality); err != nil {
+			return err
+		}
+		if f, ok := facets[field]; ok {
+			f.Count = present
+			f.Other = present
+			f.Cardinality = cardinality
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	valuesQuery := fmt.Sprintf(`
+		SELECT
+			f.1 as field,
+			f.2 as value,
+			count() as c
+		FROM logs.logs_v1
+		ARRAY JOIN %s AS f
+		%s
+		GROUP BY field, value
+		ORDER BY field, c DESC, value
+		LIMIT ? BY field
+	`, expr, whereClause)
+	queryArgs := append(append(append([]interface{}{}, exprArgs...), args...), limit)
+	valueRows, err := s.conn.Query(ctx, valuesQuery, queryArgs...)
+	if err != nil {
+		return fmt.Errorf("failed to query log facet values: %w", err)
+	}
+	defer valueRows.Close()
+	for valueRows.Next() {
+		var field string
+		var v LogFacetValue
+		if err := valueRows.Scan(&field, &v.Value, &v.Count); err != nil {
+			return err
+		}
+		if f, ok := facets[field]; ok {
+			f.Values = append(f.Values, v)
+			f.Other -= min(v.Count, f.Other)
+		}
+	}
+	return valueRows.Err()
+}
+
+// commonLogAttributeKeys returns the LogAttributes keys set on the most
+// matching logs
+func (s *Store) commonLogAttributeKeys(ctx context.Context, whereClause string, args []interface{}, limit int) ([]string, error) {
+	query := fmt.Sprintf(`
+		SELECT
+			arrayJoin(mapKeys(LogAttributes)) as key,
+			count() as c
+		FROM logs.logs_v1
+		%s
+		GROUP BY key
+		ORDER BY c DESC, key
+		LIMIT ?
+	`, whereClause)
+	rows, err := s.conn.Query(ctx, query, append(append([]interface{}{}, args...), limit)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query log attribute keys: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var key string
+		var count uint64
+		if err := rows.Scan(&key, &count); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
@@ -0,0 +1,134 @@
+package store
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// logHistogramSteps are the bucket widths histograms pick from, in seconds
+var logHistogramSteps = []int{
+	1, 5, 10, 15, 30,
+	60, 2 * 60, 5 * 60, 10 * 60, 15 * 60, 30 * 60,
+	3600, 2 * 3600, 3 * 3600, 6 * 3600, 12 * 3600, 86400,
+}
+
+// logSeverityOrder orders the usual severities from least to most severe.
+// Others follow in alphabetical order.
+var logSeverityOrder = []string{"TRACE", "DEBUG", "INFO", "WARN", "WARNING", "ERROR", "FATAL", "CRITICAL"}
+
+// LogHistogramBucket is how many matching logs of each severity fall in a
+// time bucket
+type LogHistogramBucket struct {
+	Time   time.Time         `json:"time"` // start of the bucket
+	Total  uint64            `json:"total"`
+	Counts map[string]uint64 `json:"counts"` // by severity, absent when zero
+}
+
+// LogHistogramResponse holds the counts of the logs matching a request
+// over its time range
+type LogHistogramResponse struct {
+	Start      time.Time            `json:"start"` // start of the first bucket
+	Step       int                  `json:"step"`  // seconds per bucket
+	Severities []string             `json:"severities"`
+	Buckets    []LogHistogramBucket `json:"buckets"` // oldest first, including empty ones
+	Total      uint64               `json:"total"`
+}
+
+// LogHistogramStep returns the narrowest bucket width, in seconds, that
+// splits a time range into at most about the given number of buckets
+func LogHistogramStep(minutes, buckets int) int {
+	target := minutes * 60 / max(buckets, 1)
+	for _, step := range logHistogramSteps {
+		if step >= target {
+			return step
+		}
+	}
+	return (target + 86399) / 86400 * 86400
+}
+
+// GetLogHistogram counts the logs matching the request's filters per
+// severity and bucket of step seconds. Logs without a severity are counted
+// as UNKNOWN.
+func (s *Store) GetLogHistogram(ctx context.Context, req LogsListRequest, step int) (*LogHistogramResponse, error) {
+	whereClause, args := req.filter()
+	whereClause += " AND Timestamp > now() - INTERVAL ? MINUTE"
+	args = append(args, req.TimeRangeMin)
+
+	// Buckets line up with toStartOfInterval, which counts from the epoch.
+	// There is one more bucket than the range holds, as the range rarely
+	// starts on a bucket boundary.
+	steps := req.TimeRangeMin*60/step + 1
+	start := time.Now().Add(-time.Duration(req.TimeRangeMin) * time.Minute).Unix()
+	start -= start % int64(step)
+
+	resp := &LogHistogramResponse{
+		Start:      time.Unix(start, 0).UTC(),
+		Step:       step,
+		Severities: []string{},
+		Buckets:    make([]LogHistogramBucket, steps),
+	}
+	for i := range resp.Buckets {
+		resp.Buckets[i] = LogHistogramBucket{
+			Time:   time.Unix(start+int64(i*step), 0).UTC(),
+			Counts: map[string]uint64{},
+		}
+	}
+
+	query := fmt.Sprintf(`
+		SELECT
+			toUnixTimestamp(toStartOfInterval(Timestamp, INTERVAL ? SECOND)) as bucket,
+			if(SeverityText = '', 'UNKNOWN', upper(toString(SeverityText))) as severity,
+			count()
+		FROM logs.logs_v1
+		%s
+		GROUP BY bucket, severity
+	`, whereClause)
+	rows, err := s.conn.Query(ctx, query, append([]interface{}{step}, args...)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query log histogram: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var bucket uint32
+		var severity string
+		var count uint64
+		if err := rows.Scan(&bucket, &severity, &count); err != nil {
+			return nil, err
+		}
+		idx := (int64(bucket) - start) / int64(step)
+		if idx < 0 || idx >= int64(steps) {
+			continue
+		}
+		b := &resp.Buckets[idx]
+		b.Counts[severity] += count
+		b.Total += count
+		resp.Total += count
+		if !slices.Contains(resp.Severities, severity) {
+			resp.Severities = append(resp.Severities, severity)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	slices.SortFunc(resp.Severities, compareLogSeverities)
+	return resp, nil
+}
+
+// compareLogSeverities orders severities by logSeverityOrder
+func compareLogSeverities(a, b string) int {
+	ra, rb := slices.Index(logSeverityOrder, a), slices.Index(logSeverityOrder, b)
+	switch {
+	case ra >= 0 && rb >= 0:
+		return ra - rb
+	case ra >= 0:
+		return -1
+	case rb >= 0:
+		return 1
+	}
+	return cmp.Compare(a, b)
+}
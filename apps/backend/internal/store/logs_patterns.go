@@ -0,0 +1,259 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// patternTrendBuckets is about the number of points in a pattern's trend
+const patternTrendBuckets = 30
+
+// LogPatternTemplate is a pattern catalog entry: the template mined for a
+// PatternHash, with a few example lines
+type LogPatternTemplate struct {
+	AccountId   uint64    `json:"account_id"`
+	ServiceName string    `json:"service_name"`
+	PatternHash string    `json:"pattern_hash"`
+	Template    string    `json:"template"`
+	Examples    []string  `json:"examples"`
+	FirstSeen   time.Time `json:"first_seen"`
+}
+
+// LogPatternStats describes how often a pattern was logged in a window,
+// compared with the window before it
+type LogPatternStats struct {
+	PatternHash   string    `json:"pattern_hash"`
+	ServiceName   string    `json:"service_name"`
+	Template      string    `json:"template"`
+	Examples      []string  `json:"examples"`
+	Severity      string    `json:"severity"` // most common severity
+	Count         uint64    `json:"count"`
+	PreviousCount uint64    `json:"previous_count"`
+	ChangePct     *float64  `json:"change_pct"` // null when the pattern was not logged in the previous window
+	Percentage    float64   `json:"percentage"` // share of the matching logs
+	Trend         []uint64  `json:"trend"`      // counts per step, oldest first
+	FirstSeen     time.Time `json:"first_seen"`
+}
+
+// LogPatternsResponse holds the top patterns of a window
+type LogPatternsResponse struct {
+	Patterns []LogPatternStats `json:"patterns"`
+	Total    int               `json:"total"` // matching logs with a pattern in the window
+	Start    time.Time         `json:"start"` // start of the first trend step
+	Step     int               `json:"step"`  // seconds per trend step
+}
+
+// SaveLogPatternTemplates writes catalog entries, replacing earlier
+// versions of the same patterns
+func (s *Store) SaveLogPatternTemplates(ctx context.Context, templates []LogPatternTemplate) error {
+	batch, err := s.conn.PrepareBatch(ctx, "INSERT INTO logs.log_patterns")
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, t := range templates {
+		examples := t.Examples
+		if examples == nil {
+			examples = []string{}
+		}
+		if err := batch.Append(
+			t.AccountId,
+			t.ServiceName,
+			t.PatternHash,
+			t.Template,
+			examples,
+			t.FirstSeen,
+			now,
+		); err != nil {
+			return err
+		}
+	}
+	return batch.Send()
+}
+
+// GetLogPatternTemplates returns the whole pattern catalog
+func (s *Store) GetLogPatternTemplates(ctx context.Context) ([]LogPatternTemplate, error) {
+	return s.queryLogPatternTemplates(ctx, "", nil)
+}
+
+// GetLogPatternTemplatesByHash returns the catalog entries of an account's
+// patterns by hash
+func (s *Store) GetLogPatternTemplatesByHash(ctx context.Context, accountId uint64, hashes []string) (map[string]LogPatternTemplate, error) {
+	templates, err := s.queryLogPatternTemplates(ctx, "WHERE AccountId = ? AND has(?, PatternHash)", []interface{}{accountId, hashes})
+	if err != nil {
+		return nil, err
+	}
+	byHash := make(map[string]LogPatternTemplate, len(templates))
+	for _, t := range templates {
+		byHash[t.PatternHash] = t
+	}
+	return byHash, nil
+}
+
+func (s *Store) queryLogPatternTemplates(ctx context.Context, whereClause string, args []interface{}) ([]LogPatternTemplate, error) {
+	query := fmt.Sprintf(`
+		SELECT AccountId, ServiceName, PatternHash, Template, Examples, FirstSeen
+		FROM logs.log_patterns FINAL
+		%s
+	`, whereClause)
+	rows, err := s.conn.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query log patterns: %w", err)
+	}
+	defer rows.Close()
+
+	var templates []LogPatternTemplate
+	for rows.Next() {
+		var t LogPatternTemplate
+		if err := rows.Scan(&t.AccountId, &t.ServiceName, &t.PatternHash, &t.Template, &t.Examples, &t.FirstSeen); err != nil {
+			return nil, err
+		}
+		templates = append(templates, t)
+	}
+	return templates, rows.Err()
+}
+
+// GetTopLogPatterns returns the patterns logged most in the request's time
+// range, with their trend and the change from the range before it. Logs
+// ingested before pattern mining have no pattern and are left out.
+func (s *Store) GetTopLogPatterns(ctx context.Context, req LogsListRequest, limit int) (*LogPatternsResponse, error) {
+	whereClause, args := req.filter()
+	whereClause += " AND PatternHash != ''"
+	window := time.Duration(req.TimeRangeMin) * time.Minute
+
+	query := fmt.Sprintf(`
+		SELECT
+			PatternHash,
+			any(ServiceName) as service,
+			countIf(Timestamp > now() - INTERVAL ? MINUTE) as current_count,
+			countIf(Timestamp <= now() - INTERVAL ? MINUTE) as previous_count,
+			topK(1)(SeverityText)[1] as severity,
+			any(substring(Body, 1, 200)) as sample
+		FROM logs.logs_v1
+		%s
+		  AND Timestamp > now() - INTERVAL ? MINUTE
+		GROUP BY PatternHash
+		HAVING current_count > 0
+		ORDER BY current_count DESC
+		LIMIT ?
+	`, whereClause)
+	queryArgs := append([]interface{}{req.TimeRangeMin, req.TimeRangeMin}, args...)
+	queryArgs = append(queryArgs, req.TimeRangeMin*2, limit)
+
+	rows, err := s.conn.Query(ctx, query, queryArgs...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query log patterns: %w", err)
+	}
+	defer rows.Close()
+
+	patterns := []LogPatternStats{}
+	var hashes []string
+	for rows.Next() {
+		var p LogPatternStats
+		var sample string
+		if err := rows.Scan(&p.PatternHash, &p.ServiceName, &p.Count, &p.PreviousCount, &p.Severity, &sample); err != nil {
+			return nil, err
+		}
+		if p.PreviousCount > 0 {
+			change := (float64(p.Count) - float64(p.PreviousCount)) / float64(p.PreviousCount) * 100
+			p.ChangePct = &change
+		}
+		p.Template = sample
+		p.Examples = []string{sample}
+		patterns = append(patterns, p)
+		hashes = append(hashes, p.PatternHash)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Steps line up with toStartOfInterval, which counts from the epoch.
+	// There is one more step than the window holds, as the window rarely
+	// starts on a step boundary.
+	step := max(int(window.Seconds())/patternTrendBuckets, 60)
+	steps := int(window.Seconds())/step + 1
+	start := time.Now().Add(-window).Unix()
+	resp := &LogPatternsResponse{
+		Patterns: patterns,
+		Start:    time.Unix(start-start%int64(step), 0).UTC(),
+		Step:     step,
+	}
+	if len(patterns) == 0 {
+		return resp, nil
+	}
+
+	countWhere := whereClause + " AND Timestamp > now() - INTERVAL ? MINUTE"
+	countArgs := append(append([]interface{}{}, args...), req.TimeRangeMin)
+	resp.Total, _, err = s.countLogs(ctx, countWhere, countArgs, LogCountExact)
+	if err != nil {
+		return nil, err
+	}
+
+	templates, err := s.GetLogPatternTemplatesByHash(ctx, req.AccountId, hashes)
+	if err != nil {
+		return nil, err
+	}
+	trends, err := s.logPatternTrends(ctx, countWhere, countArgs, hashes, resp.Start, step, steps)
+	if err != nil {
+		return nil, err
+	}
+	for i := range patterns {
+		p := &patterns[i]
+		if t, ok := templates[p.PatternHash]; ok {
+			p.Template = t.Template
+			p.Examples = t.Examples
+			p.FirstSeen = t.FirstSeen
+		}
+		if resp.Total > 0 {
+			p.Percentage = float64(p.Count) / float64(resp.Total) * 100
+		}
+		p.Trend = trends[p.PatternHash]
+		if p.Trend == nil {
+			p.Trend = make([]uint64, steps)
+		}
+	}
+	return resp, nil
+}
+
+// logPatternTrends counts the logs of each pattern per step from start
+func (s *Store) logPatternTrends(ctx context.Context, whereClause string, args []interface{}, hashes []string, start time.Time, step, steps int) (map[string][]uint64, error) {
+	query := fmt.Sprintf(`
+		SELECT
+			PatternHash,
+			toUnixTimestamp(toStartOfInterval(Timestamp, INTERVAL ? SECOND)) as bucket,
+			count()
+		FROM logs.logs_v1
+		%s
+		  AND has(?, PatternHash)
+		GROUP BY PatternHash, bucket
+	`, whereClause)
+	queryArgs := append([]interface{}{step}, args...)
+	queryArgs = append(queryArgs, hashes)
+
+	rows, err := s.conn.Query(ctx, query, queryArgs...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query log pattern trends: %w", err)
+	}
+	defer rows.Close()
+
+	trends := make(map[string][]uint64)
+	for rows.Next() {
+		var hash string
+		var bucket uint32
+		var count uint64
+		if err := rows.Scan(&hash, &bucket, &count); err != nil {
+			return nil, err
+		}
+		idx := (int64(bucket) - start.Unix()) / int64(step)
+		if idx < 0 || idx >= int64(steps) {
+			continue
+		}
+		if trends[hash] == nil {
+			trends[hash] = make([]uint64, steps)
+		}
+		trends[hash][idx] += count
+	}
+	return trends, rows.Err()
+}
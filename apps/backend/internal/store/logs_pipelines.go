@@ -0,0 +1,185 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Log pipeline step types
+const (
+	PipelineStepJSON      = "json"      // parse a JSON object into attributes
+	PipelineStepLogfmt    = "logfmt"    // parse key=value pairs into attributes
+	PipelineStepRegex     = "regex"     // named groups of a regex become attributes
+	PipelineStepGrok      = "grok"      // named captures of a grok pattern become attributes
+	PipelineStepSeverity  = "severity"  // remap an attribute to SeverityText/SeverityNumber
+	PipelineStepTimestamp = "timestamp" // parse an attribute into the log's Timestamp
+	PipelineStepRename    = "rename"    // rename attributes
+	PipelineStepDrop      = "drop"      // remove attributes
+	PipelineStepTrace     = "trace"     // move trace context attributes to TraceId/SpanId
+)
+
+// LogPipeline processes the logs of an account as they are ingested. The
+// enabled pipelines of an account run in Position order, each applying its
+// steps in turn to the logs of the services it covers.
+type LogPipeline struct {
+	PipelineId  string            `json:"pipeline_id"`
+	AccountId   uint64            `json:"account_id"`
+	Name        string            `json:"name"`
+	Description string            `json:"description"`
+	Services    []string          `json:"services"` // services whose logs are processed, empty for all
+	Steps       []LogPipelineStep `json:"steps"`
+	Position    int32             `json:"position"`
+	Enabled     bool              `json:"enabled"`
+	CreatedAt   time.Time         `json:"created_at"`
+	UpdatedAt   time.Time         `json:"updated_at"`
+}
+
+// LogPipelineStep is one processing step. Which fields apply depends on
+// the step type.
+type LogPipelineStep struct {
+	Type    string            `json:"type"`
+	Source  string            `json:"source,omitempty"`  // "body" or an attribute; parsers default to body
+	Pattern string            `json:"pattern,omitempty"` // regex and grok
+	Prefix  string            `json:"prefix,omitempty"`  // prepended to the keys of parsed attributes
+	Mapping map[string]string `json:"mapping,omitempty"` // severity: value -> level; rename: from -> to
+	Layout  string            `json:"layout,omitempty"`  // timestamp: rfc3339, unix, unix_ms, unix_us, unix_ns or a Go layout
+	Fields  []string          `json:"fields,omitempty"`  // drop: attributes removed; trace: trace and span ID attributes
+	Keep    bool              `json:"keep,omitempty"`    // severity, timestamp and trace: keep the source attribute
+}
+
+// ========== LOG PIPELINE CRUD OPERATIONS ==========
+
+// SaveLogPipeline creates or replaces a log pipeline
+func (s *Store) SaveLogPipeline(ctx context.Context, p *LogPipeline) error {
+	steps, err := json.Marshal(p.Steps)
+	if err != nil {
+		return fmt.Errorf("failed to encode pipeline steps: %w", err)
+	}
+
+	if p.CreatedAt.IsZero() {
+		p.CreatedAt = time.Now()
+	}
+	p.UpdatedAt = time.Now()
+	if p.Services == nil {
+		p.Services = []string{}
+	}
+
+	query := `
+		INSERT INTO logs.log_pipelines
+		(PipelineId, AccountId, Name, Description, Services, Steps, Position, Enabled, CreatedAt, UpdatedAt)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	err = s.conn.Exec(ctx, query,
+		p.PipelineId,
+		p.AccountId,
+		p.Name,
+		p.Description,
+		p.Services,
+		string(steps),
+		p.Position,
+		p.Enabled,
+		p.CreatedAt,
+		p.UpdatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save log pipeline: %w", err)
+	}
+	return nil
+}
+
+const logPipelineColumns = `PipelineId, AccountId, Name, Description, Services, Steps, Position, Enabled, CreatedAt, UpdatedAt`
+
+func scanLogPipeline(row rowScanner) (*LogPipeline, error) {
+	var p LogPipeline
+	var steps string
+	if err := row.Scan(
+		&p.PipelineId,
+		&p.AccountId,
+		&p.Name,
+		&p.Description,
+		&p.Services,
+		&steps,
+		&p.Position,
+		&p.Enabled,
+		&p.CreatedAt,
+		&p.UpdatedAt,
+	); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(steps), &p.Steps); err != nil {
+		return nil, fmt.Errorf("failed to decode steps of pipeline %s: %w", p.PipelineId, err)
+	}
+	return &p, nil
+}
+
+// GetLogPipeline retrieves a log pipeline by ID
+func (s *Store) GetLogPipeline(ctx context.Context, accountId uint64, pipelineId string) (*LogPipeline, error) {
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM logs.log_pipelines FINAL
+		WHERE AccountId = ? AND PipelineId = ?
+	`, logPipelineColumns)
+
+	p, err := scanLogPipeline(s.conn.QueryRow(ctx, query, accountId, pipelineId))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get log pipeline: %w", err)
+	}
+	return p, nil
+}
+
+// ListLogPipelines returns the pipelines of an account in the order they run
+func (s *Store) ListLogPipelines(ctx context.Context, accountId uint64) ([]LogPipeline, error) {
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM logs.log_pipelines FINAL
+		WHERE AccountId = ?
+		ORDER BY Position, Name
+	`, logPipelineColumns)
+
+	return s.queryLogPipelines(ctx, query, accountId)
+}
+
+// ListEnabledLogPipelines returns the enabled pipelines of every account,
+// in the order they run
+func (s *Store) ListEnabledLogPipelines(ctx context.Context) ([]LogPipeline, error) {
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM logs.log_pipelines FINAL
+		WHERE Enabled
+		ORDER BY AccountId, Position, Name
+	`, logPipelineColumns)
+
+	return s.queryLogPipelines(ctx, query)
+}
+
+func (s *Store) queryLogPipelines(ctx context.Context, query string, args ...interface{}) ([]LogPipeline, error) {
+	rows, err := s.conn.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list log pipelines: %w", err)
+	}
+	defer rows.Close()
+
+	pipelines := []LogPipeline{}
+	for rows.Next() {
+		p, err := scanLogPipeline(rows)
+		if err != nil {
+			return nil, err
+		}
+		pipelines = append(pipelines, *p)
+	}
+	return pipelines, rows.Err()
+}
+
+// DeleteLogPipeline deletes a log pipeline
+func (s *Store) DeleteLogPipeline(ctx context.Context, accountId uint64, pipelineId string) error {
+	query := `
+		ALTER TABLE logs.log_pipelines
+		DELETE WHERE AccountId = ? AND PipelineId = ?
+	`
+	if err := s.conn.Exec(ctx, query, accountId, pipelineId); err != nil {
+		return fmt.Errorf("failed to delete log pipeline: %w", err)
+	}
+	return nil
+}
@@ -3,12 +3,14 @@ package store
 import (
 	"context"
 	"fmt"
+	"slices"
 	"strings"
 	"time"
 )
 
 // LogEntry represents a log entry
 type LogEntry struct {
+	LogId              string            `json:"log_id"`
 	Timestamp          time.Time         `json:"timestamp"`
 	AccountId          uint64            `json:"account_id"`
 	HostId             string            `json:"host_id"`
@@ -50,22 +52,29 @@ type LogsListRequest struct {
 	Environment  string
 	Namespace    string
 	Pod          string
-	Search       string // search in body
+	Search       string    // search in body
+	Query        *LogQuery // from ParseLogQuery
 	TraceId      string
 	Page         int
 	PageSize     int
+	Cursor       string // from a previous response; pages by key instead of by Page
+	Count        string // exact, approx or none; defaults to exact for pages and approx for cursors
 }
 
 // LogsListResponse represents paginated logs response
 type LogsListResponse struct {
-	Logs       []LogEntry `json:"logs"`
-	TotalCount int        `json:"total_count"`
-	Page       int        `json:"page"`
-	PageSize   int        `json:"page_size"`
+	Logs             []LogEntry `json:"logs"`
+	TotalCount       *int       `json:"total_count,omitempty"`        // omitted when not counted
+	TotalCountApprox bool       `json:"total_count_approx,omitempty"` // the count is a lower bound
+	Page             int        `json:"page,omitempty"`
+	PageSize         int        `json:"page_size"`
+	NextCursor       string     `json:"next_cursor,omitempty"` // older logs
+	PrevCursor       string     `json:"prev_cursor,omitempty"` // newer logs
 }
 
-func (s *Store) GetLogsList(ctx context.Context, req LogsListRequest) (*LogsListResponse, error) {
-	// Build WHERE clause based on filters
+// filter builds the WHERE clause for the request's filters, without the
+// time range
+func (req LogsListRequest) filter() (string, []interface{}) {
 	whereClause := "WHERE AccountId = ?"
 	args := []interface{}{req.AccountId}
 
@@ -109,9 +118,59 @@ func (s *Store) GetLogsList(ctx context.Context, req LogsListRequest) (*LogsList
 		args = append(args, req.Search)
 	}
 
-	// Main query
+	if req.Query != nil {
+		cond, condArgs := req.Query.condition()
+		whereClause += " AND " + cond
+		args = append(args, condArgs...)
+	}
+
+	return whereClause, args
+}
+
+// GetLogsList returns a page of logs, newest first. With a cursor the page
+// is found by key, which stays fast and stable on deep pages while logs are
+// arriving; otherwise Page is used as an offset.
+func (s *Store) GetLogsList(ctx context.Context, req LogsListRequest) (*LogsListResponse, error) {
+	var cursor *logCursor
+	if req.Cursor != "" {
+		parsed, err := decodeLogCursor(req.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		cursor = parsed
+	}
+
+	// Build WHERE clause based on filters. A cursor keeps the window its
+	// first page started with, so pages do not shift as time passes.
+	whereClause, args := req.filter()
+	if cursor != nil {
+		whereClause += " AND Timestamp > fromUnixTimestamp64Nano(?)"
+		args = append(args, cursor.From)
+	} else {
+		whereClause += " AND Timestamp > now() - INTERVAL ? MINUTE"
+		args = append(args, req.TimeRangeMin)
+	}
+	countWhere, countArgs := whereClause, append([]interface{}{}, args...)
+
+	order := "DESC"
+	pageClause := "LIMIT ? OFFSET ?"
+	pageArgs := []interface{}{req.PageSize + 1, (req.Page - 1) * req.PageSize}
+	if cursor != nil {
+		pageClause = "LIMIT ?"
+		pageArgs = []interface{}{req.PageSize + 1}
+		if cursor.Direction == cursorPrev {
+			order = "ASC"
+			whereClause += " AND Timestamp >= fromUnixTimestamp64Nano(?) AND (Timestamp > fromUnixTimestamp64Nano(?) OR LogId > ?)"
+		} else {
+			whereClause += " AND Timestamp <= fromUnixTimestamp64Nano(?) AND (Timestamp < fromUnixTimestamp64Nano(?) OR LogId < ?)"
+		}
+		args = append(args, cursor.Timestamp, cursor.Timestamp, cursor.LogId)
+	}
+
+	// Main query, reading one extra row to know whether there is more
 	query := fmt.Sprintf(`
 		SELECT
+			LogId,
 			Timestamp,
 			AccountId,
 			HostName,
@@ -124,14 +183,10 @@ func (s *Store) GetLogsList(ctx context.Context, req LogsListRequest) (*LogsList
 			SpanId
 		FROM logs.logs_v1
 		%s
-		  AND Timestamp > now() - INTERVAL ? MINUTE
-		ORDER BY Timestamp DESC
-		LIMIT ? OFFSET ?
-	`, whereClause)
-
-	args = append(args, req.TimeRangeMin)
-	offset := (req.Page - 1) * req.PageSize
-	args = append(args, req.PageSize, offset)
+		ORDER BY Timestamp %s, LogId %s
+		%s
+	`, whereClause, order, order, pageClause)
+	args = append(args, pageArgs...)
 
 	rows, err := s.conn.Query(ctx, query, args...)
 	if err != nil {
@@ -139,10 +194,11 @@ func (s *Store) GetLogsList(ctx context.Context, req LogsListRequest) (*LogsList
 	}
 	defer rows.Close()
 
-	var logs []LogEntry
+	logs := []LogEntry{}
 	for rows.Next() {
 		var log LogEntry
 		err := rows.Scan(
+			&log.LogId,
 			&log.Timestamp,
 			&log.AccountId,
 			&log.HostName,
@@ -159,33 +215,99 @@ func (s *Store) GetLogsList(ctx context.Context, req LogsListRequest) (*LogsList
 		}
 		logs = append(logs, log)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 
-	// Get total count
-	countQuery := fmt.Sprintf(`
-		SELECT count()
-		FROM logs.logs_v1
-		%s
-		  AND Timestamp > now() - INTERVAL ? MINUTE
-	`, whereClause)
+	more := len(logs) > req.PageSize
+	if more {
+		logs = logs[:req.PageSize]
+	}
+	if cursor != nil && cursor.Direction == cursorPrev {
+		slices.Reverse(logs)
+	}
 
-	var totalCount int
-	countArgs := append(args[:len(args)-2], req.TimeRangeMin)
-	err = s.conn.QueryRow(ctx, countQuery, countArgs...).Scan(&totalCount)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get total count: %w", err)
+	resp := &LogsListResponse{
+		Logs:     logs,
+		PageSize: req.PageSize,
+	}
+	if cursor == nil {
+		resp.Page = req.Page
 	}
 
-	return &LogsListResponse{
-		Logs:       logs,
-		TotalCount: totalCount,
-		Page:       req.Page,
-		PageSize:   req.PageSize,
-	}, nil
+	// Cursors point past the first and last rows. Older logs exist when a
+	// read of older logs had more, or when paging back towards newer ones;
+	// newer logs may always arrive.
+	from := time.Now().Add(-time.Duration(req.TimeRangeMin) * time.Minute).UnixNano()
+	if cursor != nil {
+		from = cursor.From
+	}
+	if len(logs) > 0 {
+		first, last := logs[0], logs[len(logs)-1]
+		resp.PrevCursor = logCursor{Timestamp: first.Timestamp.UnixNano(), LogId: first.LogId, Direction: cursorPrev, From: from}.encode()
+		if more || cursor != nil && cursor.Direction == cursorPrev {
+			resp.NextCursor = logCursor{Timestamp: last.Timestamp.UnixNano(), LogId: last.LogId, Direction: cursorNext, From: from}.encode()
+		}
+	} else if cursor != nil && cursor.Direction == cursorPrev {
+		// Nothing newer yet; the same cursor picks up logs that arrive later
+		resp.PrevCursor = req.Cursor
+	}
+
+	countMode := req.Count
+	if countMode == "" {
+		countMode = LogCountExact
+		if cursor != nil {
+			countMode = LogCountApprox
+		}
+	}
+	if countMode != LogCountNone {
+		total, approx, err := s.countLogs(ctx, countWhere, countArgs, countMode)
+		if err != nil {
+			return nil, err
+		}
+		resp.TotalCount = &total
+		resp.TotalCountApprox = approx
+	}
+
+	return resp, nil
+}
+
+// LogRef identifies a log. The ID alone is enough; the service and
+// timestamp, when known, let the lookup use the table's order. Without an
+// ID the first log at the timestamp is used, as links made before IDs did.
+type LogRef struct {
+	LogId       string
+	ServiceName string
+	Timestamp   time.Time
+}
+
+// filter builds the conditions matching the referenced log, to append to
+// a WHERE clause
+func (ref LogRef) filter() (string, []interface{}) {
+	var clause string
+	var args []interface{}
+	if ref.ServiceName != "" {
+		clause += " AND ServiceName = ?"
+		args = append(args, ref.ServiceName)
+	}
+	if !ref.Timestamp.IsZero() {
+		clause += " AND Timestamp = fromUnixTimestamp64Nano(?)"
+		args = append(args, ref.Timestamp.UnixNano())
+	}
+	if ref.LogId != "" {
+		clause += " AND LogId = ?"
+		args = append(args, ref.LogId)
+	}
+	return clause, args
 }
 
-func (s *Store) GetLogDetail(ctx context.Context, accountId uint64, timestamp time.Time, serviceName string) (*LogEntry, error) {
+// GetLogDetail returns the referenced log with all its fields, or an error
+// wrapping sql.ErrNoRows when there is none
+func (s *Store) GetLogDetail(ctx context.Context, accountId uint64, ref LogRef) (*LogEntry, error) {
+	refWhere, refArgs := ref.filter()
 	query := `
 		SELECT
+			LogId,
 			Timestamp,
 			AccountId,
 			HostId,
@@ -216,14 +338,14 @@ func (s *Store) GetLogDetail(ctx context.Context, accountId uint64, timestamp ti
 			BodyHash,
 			Bytes
 		FROM logs.logs_v1
-		WHERE AccountId = ?
-		  AND ServiceName = ?
-		  AND Timestamp = ?
+		WHERE AccountId = ?` + refWhere + `
+		ORDER BY LogId
 		LIMIT 1
 	`
 
 	var log LogEntry
-	err := s.conn.QueryRow(ctx, query, accountId, serviceName, timestamp).Scan(
+	err := s.conn.QueryRow(ctx, query, append([]interface{}{accountId}, refArgs...)...).Scan(
+		&log.LogId,
 		&log.Timestamp,
 		&log.AccountId,
 		&log.HostId,
@@ -0,0 +1,513 @@
+package store
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Limits of log queries, which keep the SQL they compile to small
+const (
+	maxLogQueryLength = 4096
+	maxLogQueryTerms  = 64
+	maxLogQueryDepth  = 16
+)
+
+// logQueryColumns maps the fields of log queries to columns. Fields are
+// matched case-insensitively, and columns can also be named directly.
+var logQueryColumns = map[string]string{
+	"service":   "ServiceName",
+	"version":   "ServiceVersion",
+	"host":      "HostName",
+	"node":      "NodeName",
+	"cluster":   "ClusterName",
+	"agent":     "AgentName",
+	"severity":  "SeverityText",
+	"level":     "SeverityText",
+	"pattern":   "PatternHash",
+	"trace":     "TraceId",
+	"span":      "SpanId",
+	"env":       "Env",
+	"namespace": "Namespace",
+	"pod":       "Pod",
+	"container": "Container",
+	"source":    "Source",
+	"body":      "Body",
+}
+
+// logQueryColumnNames are the logs_v1 columns log queries can use
+var logQueryColumnNames = []string{
+	"LogId", "HostId", "HostName", "HostIP", "HostArch", "NodeName", "ClusterName",
+	"AgentName", "AgentVersion", "Env", "ServiceName", "ServiceVersion",
+	"Namespace", "Pod", "Container", "ContainerId", "Source",
+	"SeverityNumber", "SeverityText", "Body", "TraceId", "SpanId", "TraceFlags",
+	"PatternHash", "BodyHash", "Bytes",
+}
+
+// logQueryNumericColumns are compared as numbers rather than text
+var logQueryNumericColumns = map[string]bool{"SeverityNumber": true, "TraceFlags": true, "Bytes": true}
+
+func init() {
+	for _, column := range logQueryColumnNames {
+		logQueryColumns[strings.ToLower(column)] = column
+	}
+	for _, column := range []string{"service_name", "service_version", "host_name", "host_id", "host_ip", "host_arch",
+		"node_name", "cluster_name", "agent_name", "agent_version", "container_id",
+		"severity_number", "severity_text", "trace_id", "span_id", "trace_flags", "pattern_hash", "body_hash", "log_id"} {
+		logQueryColumns[column] = logQueryColumns[strings.ReplaceAll(column, "_", "")]
+	}
+}
+
+// LogQuery is a parsed log query, compiled to a parameterized condition on
+// logs.logs_v1.
+//
+// Terms are combined with AND (or just spaces), OR and NOT (or a leading
+// -), and grouped with parentheses; AND binds tighter than OR. A term is
+// one of:
+//
+//	timeout             logs whose body contains the word, ignoring case
+//	time*out            body matching the wildcard, ignoring case
+//	"connection reset"  body containing the phrase, as whole words and
+//	                    matching case; uses the token index on Body
+//	/time(d)?out/       body matching the regex
+//	field:value         field equal to value; field:"a value" for values
+//	                    with spaces
+//	field:val*          field matching the wildcard
+//	field:/regex/       field matching the regex
+//	field:*             field set (not empty)
+//	field:>10           field compared as a number, also >=, < and <=
+//
+// A field is a column (service, host, severity, env, namespace, pod, or
+// any column by name, such as ServiceVersion or trace_id), attributes.key
+// or @key for a LogAttributes key, or resource.key for a
+// ResourceAttributes key. Other names are LogAttributes keys. Keywords are
+// uppercase; quote them, or escape characters with \, to search for them.
+type LogQuery struct {
+	Text  string
+	where string
+	args  []interface{}
+}
+
+// condition returns the compiled condition and its arguments
+func (q *LogQuery) condition() (string, []interface{}) {
+	return q.where, append([]interface{}{}, q.args...)
+}
+
+// ParseLogQuery parses and compiles a log query. A blank query returns
+// nil, which matches every log.
+func ParseLogQuery(text string) (*LogQuery, error) {
+	if strings.TrimSpace(text) == "" {
+		return nil, nil
+	}
+	if len(text) > maxLogQueryLength {
+		return nil, fmt.Errorf("query is longer than %d characters", maxLogQueryLength)
+	}
+
+	p := &logQueryParser{input: []rune(text)}
+	cond, err := p.parseOr(0)
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpaces()
+	if p.pos < len(p.input) {
+		return nil, p.errorf("unexpected %q", p.input[p.pos])
+	}
+	return &LogQuery{Text: text, where: cond.sql, args: cond.args}, nil
+}
+
+// logQueryCond is a compiled part of a query. Arguments are in the order
+// of their placeholders.
+type logQueryCond struct {
+	sql  string
+	args []interface{}
+}
+
+func joinLogQueryConds(op string, conds []logQueryCond) logQueryCond {
+	if len(conds) == 1 {
+		return conds[0]
+	}
+	parts := make([]string, len(conds))
+	var args []interface{}
+	for i, c := range conds {
+		parts[i] = c.sql
+		args = append(args, c.args...)
+	}
+	return logQueryCond{sql: "(" + strings.Join(parts, " "+op+" ") + ")", args: args}
+}
+
+type logQueryParser struct {
+	input []rune
+	pos   int
+	terms int
+}
+
+func (p *logQueryParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("query: "+format+" at position %d", append(args, p.pos)...)
+}
+
+func (p *logQueryParser) skipSpaces() {
+	for p.pos < len(p.input) && unicode.IsSpace(p.input[p.pos]) {
+		p.pos++
+	}
+}
+
+// keyword consumes the keyword at the current position, if there is one
+func (p *logQueryParser) keyword(word string) bool {
+	end := p.pos + len(word)
+	if end > len(p.input) || string(p.input[p.pos:end]) != word {
+		return false
+	}
+	if end < len(p.input) && !unicode.IsSpace(p.input[end]) && p.input[end] != '(' {
+		return false
+	}
+	p.pos = end
+	return true
+}
+
+func (p *logQueryParser) parseOr(depth int) (logQueryCond, error) {
+	var conds []logQueryCond
+	for {
+		cond, err := p.parseAnd(depth)
+		if err != nil {
+			return logQueryCond{}, err
+		}
+		conds = append(conds, cond)
+		p.skipSpaces()
+		if !p.keyword("OR") {
+			return joinLogQueryConds("OR", conds), nil
+		}
+	}
+}
+
+func (p *logQueryParser) parseAnd(depth int) (logQueryCond, error) {
+	var conds []logQueryCond
+	for {
+		cond, err := p.parseUnary(depth)
+		if err != nil {
+			return logQueryCond{}, err
+		}
+		conds = append(conds, cond)
+
+		p.skipSpaces()
+		if p.pos >= len(p.input) || p.input[p.pos] == ')' {
+			break
+		}
+		start := p.pos
+		if p.keyword("OR") {
+			p.pos = start
+			break
+		}
+		p.keyword("AND")
+	}
+	return joinLogQueryConds("AND", conds), nil
+}
+
+// parseUnary parses a term, which parentheses and negation nest in; the
+// depth counts both
+func (p *logQueryParser) parseUnary(depth int) (logQueryCond, error) {
+	if depth > maxLogQueryDepth {
+		return logQueryCond{}, p.errorf("nesting deeper than %d", maxLogQueryDepth)
+	}
+	p.skipSpaces()
+	negate := p.keyword("NOT")
+	if !negate && p.pos+1 < len(p.input) && p.input[p.pos] == '-' && !unicode.IsSpace(p.input[p.pos+1]) {
+		p.pos++
+		negate = true
+	}
+	if negate {
+		cond, err := p.parseUnary(depth + 1)
+		if err != nil {
+			return logQueryCond{}, err
+		}
+		return logQueryCond{sql: "NOT (" + cond.sql + ")", args: cond.args}, nil
+	}
+	return p.parsePrimary(depth)
+}
+
+func (p *logQueryParser) parsePrimary(depth int) (logQueryCond, error) {
+	p.skipSpaces()
+	if p.pos >= len(p.input) {
+		return logQueryCond{}, p.errorf("missing term")
+	}
+
+	switch p.input[p.pos] {
+	case '(':
+		p.pos++
+		cond, err := p.parseOr(depth + 1)
+		if err != nil {
+			return logQueryCond{}, err
+		}
+		p.skipSpaces()
+		if p.pos >= len(p.input) || p.input[p.pos] != ')' {
+			return logQueryCond{}, p.errorf("missing )")
+		}
+		p.pos++
+		return cond, nil
+	case ')':
+		return logQueryCond{}, p.errorf("unexpected )")
+	}
+
+	p.terms++
+	if p.terms > maxLogQueryTerms {
+		return logQueryCond{}, p.errorf("more than %d terms", maxLogQueryTerms)
+	}
+
+	field := logQueryField{column: "Body"}
+	if name, ok := p.fieldName(); ok {
+		resolved, err := resolveLogQueryField(name)
+		if err != nil {
+			return logQueryCond{}, p.errorf("%v", err)
+		}
+		field = resolved
+	}
+	return p.parseValue(field)
+}
+
+// fieldName consumes a field name followed by a colon, if there is one
+func (p *logQueryParser) fieldName() (string, bool) {
+	if p.input[p.pos] == '/' {
+		return "", false // a regex
+	}
+	end := p.pos
+	for end < len(p.input) && isLogQueryFieldRune(p.input[end]) {
+		end++
+	}
+	if end == p.pos || end >= len(p.input) || p.input[end] != ':' {
+		return "", false
+	}
+	name := string(p.input[p.pos:end])
+	p.pos = end + 1
+	return name, true
+}
+
+func isLogQueryFieldRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("_.-@/", r)
+}
+
+// parseValue parses what a field is matched against
+func (p *logQueryParser) parseValue(field logQueryField) (logQueryCond, error) {
+	if p.pos >= len(p.input) || unicode.IsSpace(p.input[p.pos]) {
+		return logQueryCond{}, p.errorf("missing value")
+	}
+
+	switch p.input[p.pos] {
+	case '"':
+		value, err := p.quoted('"')
+		if err != nil {
+			return logQueryCond{}, err
+		}
+		if value == "" {
+			return logQueryCond{}, p.errorf("empty phrase")
+		}
+		if field.column == "Body" {
+			return phraseCond(value), nil
+		}
+		return field.equals(value)
+	case '/':
+		pattern, err := p.quoted('/')
+		if err != nil {
+			return logQueryCond{}, err
+		}
+		if _, err := regexp.Compile(pattern); err != nil {
+			return logQueryCond{}, p.errorf("invalid regex: %v", err)
+		}
+		expr, args := field.text()
+		return logQueryCond{sql: "match(" + expr + ", ?)", args: append(args, pattern)}, nil
+	case '>', '<':
+		op := string(p.input[p.pos])
+		p.pos++
+		if p.pos < len(p.input) && p.input[p.pos] == '=' {
+			op += "="
+			p.pos++
+		}
+		word, _, err := p.word()
+		if err != nil {
+			return logQueryCond{}, err
+		}
+		number, err := strconv.ParseFloat(word, 64)
+		if err != nil {
+			return logQueryCond{}, p.errorf("%s needs a number, not %q", op, word)
+		}
+		expr, args := field.number()
+		return logQueryCond{sql: expr + " " + op + " ?", args: append(args, number)}, nil
+	}
+
+	word, pattern, err := p.word()
+	if err != nil {
+		return logQueryCond{}, err
+	}
+	switch {
+	case pattern == "%":
+		return field.exists(), nil
+	case field.column == "Body" && pattern != "":
+		// Like words, wildcards match anywhere in the body
+		return logQueryCond{sql: "Body ILIKE ?", args: []interface{}{"%" + pattern + "%"}}, nil
+	case field.column == "Body":
+		return logQueryCond{sql: "positionCaseInsensitive(Body, ?) > 0", args: []interface{}{word}}, nil
+	case pattern != "":
+		expr, args := field.text()
+		return logQueryCond{sql: expr + " LIKE ?", args: append(args, pattern)}, nil
+	}
+	return field.equals(word)
+}
+
+// quoted reads text up to the closing delimiter. A backslash escapes the
+// delimiter; in regexes other escapes are kept for the regex.
+func (p *logQueryParser) quoted(delim rune) (string, error) {
+	start := p.pos
+	p.pos++
+	var b strings.Builder
+	for p.pos < len(p.input) {
+		r := p.input[p.pos]
+		p.pos++
+		switch {
+		case r == delim:
+			return b.String(), nil
+		case r == '\\' && p.pos < len(p.input):
+			next := p.input[p.pos]
+			p.pos++
+			if delim == '/' && next != '/' {
+				b.WriteRune('\\')
+			}
+			b.WriteRune(next)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	p.pos = start
+	return "", p.errorf("unterminated %c", delim)
+}
+
+// word reads an unquoted value. When it has unescaped * wildcards, the
+// LIKE pattern matching it is returned too.
+func (p *logQueryParser) word() (string, string, error) {
+	var value, like strings.Builder
+	wildcard := false
+	for p.pos < len(p.input) {
+		r := p.input[p.pos]
+		if unicode.IsSpace(r) || r == '(' || r == ')' {
+			break
+		}
+		p.pos++
+		if r == '\\' && p.pos < len(p.input) {
+			r = p.input[p.pos]
+			p.pos++
+		} else if r == '*' {
+			wildcard = true
+			like.WriteRune('%')
+			continue
+		}
+		value.WriteRune(r)
+		if r == '%' || r == '_' || r == '\\' {
+			like.WriteRune('\\')
+		}
+		like.WriteRune(r)
+	}
+	if value.Len() == 0 && !wildcard {
+		return "", "", p.errorf("missing value")
+	}
+	if !wildcard {
+		return value.String(), "", nil
+	}
+	pattern := like.String()
+	if strings.Trim(pattern, "%") == "" {
+		pattern = "%"
+	}
+	return value.String(), pattern, nil
+}
+
+// phraseCond matches a phrase in Body. hasToken on each of its words lets
+// the tokenbf_v1 index on Body skip granules without them; the phrase
+// itself is then checked as a substring.
+func phraseCond(phrase string) logQueryCond {
+	var conds []logQueryCond
+	for _, token := range bodyTokens(phrase) {
+		conds = append(conds, logQueryCond{sql: "hasToken(Body, ?)", args: []interface{}{token}})
+	}
+	if len(conds) != 1 || conds[0].args[0] != phrase {
+		conds = append(conds, logQueryCond{sql: "position(Body, ?) > 0", args: []interface{}{phrase}})
+	}
+	return joinLogQueryConds("AND", conds)
+}
+
+// bodyTokens splits text into tokens the way tokenbf_v1 does: runs of
+// ASCII letters and digits, and of non-ASCII bytes
+func bodyTokens(text string) []string {
+	return strings.FieldsFunc(text, func(r rune) bool {
+		return r < 0x80 && !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9')
+	})
+}
+
+// logQueryField is what a query term matches: a column, or a key of
+// LogAttributes or ResourceAttributes
+type logQueryField struct {
+	column string // the column, or the map column for keys
+	key    string // the map key, empty for columns
+}
+
+// resolveLogQueryField finds the column or attribute a field name refers to
+func resolveLogQueryField(name string) (logQueryField, error) {
+	for _, prefix := range []string{"attributes.", "@"} {
+		if key, ok := strings.CutPrefix(name, prefix); ok {
+			if key == "" {
+				return logQueryField{}, fmt.Errorf("missing attribute key in %q", name)
+			}
+			return logQueryField{column: "LogAttributes", key: key}, nil
+		}
+	}
+	if key, ok := strings.CutPrefix(name, "resource."); ok && key != "" {
+		return logQueryField{column: "ResourceAttributes", key: key}, nil
+	}
+	if column, ok := logQueryColumns[strings.ToLower(name)]; ok {
+		return logQueryField{column: column}, nil
+	}
+	return logQueryField{column: "LogAttributes", key: name}, nil
+}
+
+// text is the field as a string
+func (f logQueryField) text() (string, []interface{}) {
+	switch {
+	case f.key != "":
+		return f.column + "[?]", []interface{}{f.key}
+	case logQueryNumericColumns[f.column]:
+		return "toString(" + f.column + ")", nil
+	}
+	return f.column, nil
+}
+
+// number is the field as a number, NULL for attributes that are not one
+func (f logQueryField) number() (string, []interface{}) {
+	if logQueryNumericColumns[f.column] {
+		return f.column, nil
+	}
+	expr, args := f.text()
+	return "toFloat64OrNull(" + expr + ")", args
+}
+
+func (f logQueryField) equals(value string) (logQueryCond, error) {
+	if logQueryNumericColumns[f.column] {
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return logQueryCond{}, fmt.Errorf("query: %s needs a number, not %q", f.column, value)
+		}
+		return logQueryCond{sql: f.column + " = ?", args: []interface{}{number}}, nil
+	}
+	if f.column == "SeverityText" {
+		value = strings.ToUpper(value)
+	}
+	expr, args := f.text()
+	return logQueryCond{sql: expr + " = ?", args: append(args, value)}, nil
+}
+
+// exists matches logs that have a value for the field
+func (f logQueryField) exists() logQueryCond {
+	if f.key != "" {
+		return logQueryCond{sql: "mapContains(" + f.column + ", ?)", args: []interface{}{f.key}}
+	}
+	if logQueryNumericColumns[f.column] {
+		return logQueryCond{sql: f.column + " != 0"}
+	}
+	return logQueryCond{sql: f.column + " != ''"}
+}
@@ -0,0 +1,136 @@
+package store
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseLogQuery(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		sql   string
+		args  []interface{}
+	}{
+		{"word", "timeout", "positionCaseInsensitive(Body, ?) > 0", []interface{}{"timeout"}},
+		{"wildcard", "time*out", "Body ILIKE ?", []interface{}{"%time%out%"}},
+		{"phrase", `"connection reset"`, "(hasToken(Body, ?) AND hasToken(Body, ?) AND position(Body, ?) > 0)", []interface{}{"connection", "reset", "connection reset"}},
+		{"phrase of one token", `"timeout"`, "hasToken(Body, ?)", []interface{}{"timeout"}},
+		{"regex", `/time(d)?out/`, "match(Body, ?)", []interface{}{"time(d)?out"}},
+		{"escaped regex delimiter", `/a\/b\d/`, "match(Body, ?)", []interface{}{`a/b\d`}},
+		{"column", "service:api", "ServiceName = ?", []interface{}{"api"}},
+		{"column by name", "ServiceVersion:1.2", "ServiceVersion = ?", []interface{}{"1.2"}},
+		{"severity is uppercased", "level:error", "SeverityText = ?", []interface{}{"ERROR"}},
+		{"quoted attribute value", `@user.id:"a b"`, "LogAttributes[?] = ?", []interface{}{"user.id", "a b"}},
+		{"plain attribute", "region:eu", "LogAttributes[?] = ?", []interface{}{"region", "eu"}},
+		{"resource wildcard", "resource.k8s.pod:web*", "ResourceAttributes[?] LIKE ?", []interface{}{"k8s.pod", "web%"}},
+		{"wildcard escapes LIKE characters", `file:a_b%*`, "LogAttributes[?] LIKE ?", []interface{}{"file", `a\_b\%%`}},
+		{"column set", "pod:*", "Pod != ''", nil},
+		{"attribute set", "attributes.user:*", "mapContains(LogAttributes, ?)", []interface{}{"user"}},
+		{"numeric column set", "bytes:*", "Bytes != 0", nil},
+		{"numeric comparison", "bytes:>=1024", "Bytes >= ?", []interface{}{1024.0}},
+		{"attribute comparison", "duration_ms:<5", "toFloat64OrNull(LogAttributes[?]) < ?", []interface{}{"duration_ms", 5.0}},
+		{"numeric equality", "trace_flags:1", "TraceFlags = ?", []interface{}{1.0}},
+		{"numeric column regex", "severity_number:/^1/", "match(toString(SeverityNumber), ?)", []interface{}{"^1"}},
+		{"implicit and", "service:api timeout", "(ServiceName = ? AND positionCaseInsensitive(Body, ?) > 0)", []interface{}{"api", "timeout"}},
+		{
+			"and binds tighter than or", "service:api error OR warn",
+			"((ServiceName = ? AND positionCaseInsensitive(Body, ?) > 0) OR positionCaseInsensitive(Body, ?) > 0)",
+			[]interface{}{"api", "error", "warn"},
+		},
+		{
+			"parentheses", "service:api AND (level:error OR level:warn)",
+			"(ServiceName = ? AND (SeverityText = ? OR SeverityText = ?))",
+			[]interface{}{"api", "ERROR", "WARN"},
+		},
+		{
+			"negation", "NOT host:db1 -timeout",
+			"(NOT (HostName = ?) AND NOT (positionCaseInsensitive(Body, ?) > 0))",
+			[]interface{}{"db1", "timeout"},
+		},
+		{"negated group", "NOT(a)", "NOT (positionCaseInsensitive(Body, ?) > 0)", []interface{}{"a"}},
+		{
+			"lowercase keywords are words", "error or warn",
+			"(positionCaseInsensitive(Body, ?) > 0 AND positionCaseInsensitive(Body, ?) > 0 AND positionCaseInsensitive(Body, ?) > 0)",
+			[]interface{}{"error", "or", "warn"},
+		},
+		{"escaped keyword", `\OR`, "positionCaseInsensitive(Body, ?) > 0", []interface{}{"OR"}},
+		{"lone dash", "-", "positionCaseInsensitive(Body, ?) > 0", []interface{}{"-"}},
+		{"terms at the limit", strings.TrimSpace(strings.Repeat("a OR ", maxLogQueryTerms-1) + "a"), "(" + strings.Repeat("positionCaseInsensitive(Body, ?) > 0 OR ", maxLogQueryTerms-1) + "positionCaseInsensitive(Body, ?) > 0)", repeatArgs("a", maxLogQueryTerms)},
+		{"nested at the limit", strings.Repeat("(", maxLogQueryDepth) + "a" + strings.Repeat(")", maxLogQueryDepth), "positionCaseInsensitive(Body, ?) > 0", []interface{}{"a"}},
+		{"negated at the limit", strings.Repeat("NOT ", maxLogQueryDepth) + "a", strings.Repeat("NOT (", maxLogQueryDepth) + "positionCaseInsensitive(Body, ?) > 0" + strings.Repeat(")", maxLogQueryDepth), []interface{}{"a"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := ParseLogQuery(tt.query)
+			if err != nil {
+				t.Fatalf("ParseLogQuery(%q): %v", tt.query, err)
+			}
+			sql, args := q.condition()
+			if sql != tt.sql {
+				t.Errorf("sql = %s, want %s", sql, tt.sql)
+			}
+			if !reflect.DeepEqual(args, append([]interface{}{}, tt.args...)) {
+				t.Errorf("args = %#v, want %#v", args, tt.args)
+			}
+		})
+	}
+}
+
+func repeatArgs(arg interface{}, n int) []interface{} {
+	args := make([]interface{}, n)
+	for i := range args {
+		args[i] = arg
+	}
+	return args
+}
+
+func TestParseLogQueryBlank(t *testing.T) {
+	for _, query := range []string{"", "  \t"} {
+		if q, err := ParseLogQuery(query); q != nil || err != nil {
+			t.Errorf("ParseLogQuery(%q) = %v, %v, want nil, nil", query, q, err)
+		}
+	}
+}
+
+func TestParseLogQueryErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		wantErr string
+	}{
+		{"missing closing parenthesis", "(a", "missing )"},
+		{"stray closing parenthesis", "a)", "unexpected ')'"},
+		{"leading closing parenthesis", ")", "unexpected )"},
+		{"empty group", "()", "unexpected )"},
+		{"field without value", "service:", "missing value"},
+		{"space after field", "service: api", "missing value"},
+		{"unterminated phrase", `"connection reset`, `unterminated "`},
+		{"unterminated regex", "/abc", "unterminated /"},
+		{"invalid regex", "/(/", "invalid regex"},
+		{"empty phrase", `""`, "empty phrase"},
+		{"comparison without number", "bytes:>x", "> needs a number"},
+		{"numeric column with text", "bytes:abc", "Bytes needs a number"},
+		{"attribute without key", "@:x", "missing attribute key"},
+		{"dangling or", "a OR", "missing term"},
+		{"dangling and", "a AND", "missing term"},
+		{"dangling not", "NOT", "missing term"},
+		{"too long", strings.Repeat("a", maxLogQueryLength+1), "longer than"},
+		{"too many terms", strings.Repeat("a ", maxLogQueryTerms+1), "more than"},
+		{"nested too deep", strings.Repeat("(", maxLogQueryDepth+1) + "a" + strings.Repeat(")", maxLogQueryDepth+1), "nesting deeper than"},
+		{"negated too deep", strings.Repeat("NOT ", maxLogQueryDepth+1) + "a", "nesting deeper than"},
+		{"dashes too deep", strings.Repeat("-", maxLogQueryDepth+1) + "a", "nesting deeper than"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseLogQuery(tt.query)
+			if err == nil {
+				t.Fatalf("ParseLogQuery(%q) succeeded, want error containing %q", tt.query, tt.wantErr)
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ParseLogQuery(%q) = %v, want error containing %q", tt.query, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// tailBodyLength caps the body sent for each tailed line
+const tailBodyLength = 2000
+
+// TailLogs returns logs matching the request's filters with a timestamp in
+// (after, until], or [after, until] when inclusive is set, oldest first.
+// The request's time range and paging are ignored.
+func (s *Store) TailLogs(ctx context.Context, req LogsListRequest, after, until time.Time, inclusive bool, limit int) ([]LogEntry, error) {
+	whereClause, args := req.filter()
+
+	lower := ">"
+	if inclusive {
+		lower = ">="
+	}
+	query := fmt.Sprintf(`
+		SELECT
+			LogId,
+			Timestamp,
+			AccountId,
+			HostName,
+			ServiceName,
+			Namespace,
+			Pod,
+			Container,
+			ContainerId,
+			SeverityText,
+			substring(Body, 1, %d) as BodyPreview,
+			TraceId,
+			SpanId
+		FROM logs.logs_v1
+		%s
+		  AND Timestamp %s fromUnixTimestamp64Nano(?)
+		  AND Timestamp <= fromUnixTimestamp64Nano(?)
+		ORDER BY Timestamp ASC, LogId ASC
+		LIMIT ?
+	`, tailBodyLength, whereClause, lower)
+	// Bound as nanoseconds, time.Time arguments are bound in whole seconds
+	args = append(args, after.UnixNano(), until.UnixNano(), limit)
+
+	rows, err := s.conn.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to tail logs: %w", err)
+	}
+	defer rows.Close()
+
+	var logs []LogEntry
+	for rows.Next() {
+		var log LogEntry
+		if err := rows.Scan(
+			&log.LogId,
+			&log.Timestamp,
+			&log.AccountId,
+			&log.HostName,
+			&log.ServiceName,
+			&log.Namespace,
+			&log.Pod,
+			&log.Container,
+			&log.ContainerId,
+			&log.SeverityText,
+			&log.Body,
+			&log.TraceId,
+			&log.SpanId,
+		); err != nil {
+			return nil, err
+		}
+		logs = append(logs, log)
+	}
+	return logs, rows.Err()
+}
@@ -2,8 +2,14 @@ package store
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/google/uuid"
 )
 
 // DashboardSummary represents the top summary panel
@@ -261,16 +267,21 @@ type LogPattern struct {
 	Count  uint64 `json:"count"`
 }
 
+// GetLogPatterns returns the most frequent patterns, showing each by its
+// mined template. Logs ingested before pattern mining are grouped by the
+// start of their body.
 func (s *Store) GetLogPatterns(ctx context.Context, accountId uint64, minutesAgo int) ([]LogPattern, error) {
 	query := `
 		SELECT
-			substring(Body, 1, 50) as sample,
+			if(PatternHash = '', substring(Body, 1, 50), PatternHash) as pattern,
+			any(PatternHash) as hash,
+			any(substring(Body, 1, 50)) as sample,
 			SeverityText as level,
 			count() as count
 		FROM logs.logs_v1
 		WHERE AccountId = ?
 		  AND Timestamp > now() - INTERVAL ? MINUTE
-		GROUP BY sample, level
+		GROUP BY pattern, level
 		ORDER BY count DESC
 		LIMIT 5
 	`
@@ -281,12 +292,34 @@ func (s *Store) GetLogPatterns(ctx context.Context, accountId uint64, minutesAgo
 	defer rows.Close()
 
 	var results []LogPattern
+	var hashes, resultHashes []string
 	for rows.Next() {
 		var p LogPattern
-		if err := rows.Scan(&p.Sample, &p.Level, &p.Count); err != nil {
+		var pattern, hash string
+		if err := rows.Scan(&pattern, &hash, &p.Sample, &p.Level, &p.Count); err != nil {
 			return nil, err
 		}
 		results = append(results, p)
+		resultHashes = append(resultHashes, hash)
+		if hash != "" {
+			hashes = append(hashes, hash)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(hashes) == 0 {
+		return results, nil
+	}
+
+	templates, err := s.GetLogPatternTemplatesByHash(ctx, accountId, hashes)
+	if err != nil {
+		return nil, err
+	}
+	for i := range results {
+		if t, ok := templates[resultHashes[i]]; ok {
+			results[i].Sample = t.Template
+		}
 	}
 	return results, nil
 }
@@ -785,141 +818,527 @@ func (s *Store) QueryMetrics(ctx context.Context, req MetricQueryRequest) (*Metr
 		return nil, fmt.Errorf("no metrics specified")
 	}
 
+	// Substitute dashboard variables into the queries
+	if req.DashboardId != "" {
+		dashboard, err := s.GetDashboard(ctx, req.AccountId, req.DashboardId)
+		if err != nil {
+			return nil, err
+		}
+		scope, err := s.buildVariableScope(ctx, req.AccountId, dashboard.Variables, req.Variables)
+		if err != nil {
+			return nil, err
+		}
+		if req.Metrics, err = scope.applyToQueries(req.Metrics); err != nil {
+			return nil, err
+		}
+		verr := &ValidationError{}
+		req.TimeRange = scope.interpolateSingle(verr, "time_range", req.TimeRange)
+		req.Interval = scope.interpolateSingle(verr, "interval", req.Interval)
+		if len(verr.Errors) > 0 {
+			return nil, verr
+		}
+	}
+
 	// Parse time range
 	minutesAgo := parseTimeRange(req.TimeRange)
 	interval := parseInterval(req.Interval)
 
+	to := time.Now()
+	from := to.Add(-time.Duration(minutesAgo) * time.Minute)
+
+	results, err := s.runMetricQueries(ctx, req.AccountId, req.Metrics, from, to, interval)
+	if err != nil {
+		return nil, err
+	}
+
 	var allSeries []MetricSeries
+	for _, series := range results {
+		allSeries = append(allSeries, series...)
+	}
+
+	response := &MetricQueryResponse{
+		Series: allSeries,
+	}
+	if req.IncludeAnnotations {
+		services, hosts := queriedServicesAndHosts(req.Metrics)
+		response.Annotations, err = s.ListAnnotations(ctx, AnnotationFilter{
+			AccountId: req.AccountId,
+			From:      from,
+			To:        to,
+			Services:  services,
+			Hosts:     hosts,
+			Tags:      req.AnnotationTags,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return response, nil
+}
 
-	for _, metricQuery := range req.Metrics {
-		// Build aggregation function
-		aggFunc := buildAggregationFunc(metricQuery.Aggregation, "Value")
-
-		// Build group by clause
-		groupByClause := ""
-		selectLabels := ""
-		if len(metricQuery.GroupBy) > 0 {
-			var groupByCols []string
-			var selectCols []string
-			for _, labelKey := range metricQuery.GroupBy {
-				groupByCols = append(groupByCols, fmt.Sprintf("Labels['%s']", labelKey))
-				selectCols = append(selectCols, fmt.Sprintf("Labels['%s'] as label_%s", labelKey, labelKey))
+// queriedServicesAndHosts collects the service and host filters of the
+// queries. If any query is unfiltered, annotations of every service (or
+// host) are relevant and nil is returned.
+func queriedServicesAndHosts(queries []MetricQuery) (services, hosts []string) {
+	collect := func(key string) []string {
+		var values []string
+		for _, q := range queries {
+			if q.MetricName == "" {
+				continue // formulas inherit the filters of their operands
+			}
+			switch {
+			case q.Filters[key] != "":
+				values = append(values, q.Filters[key])
+			case len(q.multiFilters[key]) > 0:
+				values = append(values, q.multiFilters[key]...)
+			default:
+				return nil
 			}
-			groupByClause = ", " + joinStrings(groupByCols, ", ")
-			selectLabels = ", " + joinStrings(selectCols, ", ")
 		}
+		return values
+	}
+	return collect("service_name"), collect("host_name")
+}
 
-		// Build filter clause
-		filterClause := ""
-		if len(metricQuery.Filters) > 0 {
-			var filters []string
-			for key, value := range metricQuery.Filters {
-				filters = append(filters, fmt.Sprintf("Labels['%s'] = '%s'", key, value))
+// runMetricQueries evaluates a list of metric queries over [from, to) and
+// returns the series of each query in order. Queries with a Formula and no
+// MetricName are computed from the series of the queries before them,
+// referenced by alias (or metric name).
+func (s *Store) runMetricQueries(ctx context.Context, accountId uint64, queries []MetricQuery, from, to time.Time, interval int) ([][]MetricSeries, error) {
+	return composeMetricQueries(queries, func(metricQuery MetricQuery) ([]MetricSeries, error) {
+		return s.queryMetricSeries(ctx, accountId, metricQuery, from, to, interval)
+	})
+}
+
+// composeMetricQueries evaluates formulas over the series that fetch returns
+// for every other query, keeping query order
+func composeMetricQueries(queries []MetricQuery, fetch func(MetricQuery) ([]MetricSeries, error)) ([][]MetricSeries, error) {
+	results := make([][]MetricSeries, 0, len(queries))
+	byRef := make(map[string][]MetricSeries)
+
+	for _, metricQuery := range queries {
+		var series []MetricSeries
+		var err error
+		if metricQuery.Formula != "" && metricQuery.MetricName == "" {
+			series, err = evaluateFormula(metricQuery.Formula, byRef)
+			if err != nil {
+				return nil, fmt.Errorf("failed to evaluate formula %q: %w", metricQuery.Formula, err)
+			}
+			name := metricQuery.Formula
+			if metricQuery.Alias != "" {
+				name = metricQuery.Alias
 			}
-			filterClause = " AND " + joinStrings(filters, " AND ")
+			for i := range series {
+				series[i].Name = name
+				calculateStats(&series[i])
+			}
+		} else {
+			series, err = fetch(metricQuery)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		ref := metricQuery.MetricName
+		if metricQuery.Alias != "" {
+			ref = metricQuery.Alias
 		}
+		byRef[ref] = series
+		results = append(results, series)
+	}
 
-		// Build time bucket
-		timeBucket := fmt.Sprintf("toStartOfInterval(Timestamp, INTERVAL %d SECOND)", interval)
+	return results, nil
+}
 
-		query := fmt.Sprintf(`
-			SELECT
-				%s as time_bucket,
-				%s as value
-				%s
-			FROM metrics.metrics_v1
-			WHERE AccountId = ?
-			  AND MetricName = ?
-			  AND Timestamp > now() - INTERVAL %d MINUTE
-			  %s
-			GROUP BY time_bucket%s
-			ORDER BY time_bucket
-		`, timeBucket, aggFunc, selectLabels, minutesAgo, filterClause, groupByClause)
+// queryMetricSeries runs a single metric query over [from, to), bucketed by
+// interval seconds, and returns one series per group-by label combination.
+// Queries with Logs aggregate logs instead.
+func (s *Store) queryMetricSeries(ctx context.Context, accountId uint64, metricQuery MetricQuery, from, to time.Time, interval int) ([]MetricSeries, error) {
+	if metricQuery.Logs != nil {
+		return s.queryLogSeries(ctx, accountId, metricQuery, from, to, interval)
+	}
 
-		rows, err := s.conn.Query(ctx, query, req.AccountId, metricQuery.MetricName)
-		if err != nil {
-			return nil, fmt.Errorf("failed to query metric %s: %w", metricQuery.MetricName, err)
+	// Build aggregation function
+	aggFunc := buildAggregationFunc(metricQuery.Aggregation, "Value")
+
+	// Build group by clause
+	var selectArgs []interface{}
+	groupByClause := ""
+	selectLabels := ""
+	if len(metricQuery.GroupBy) > 0 {
+		var groupByCols []string
+		for i, labelKey := range metricQuery.GroupBy {
+			expr, exprArgs := metricLabelExpr(labelKey)
+			selectLabels += fmt.Sprintf(", %s as label_%d", expr, i)
+			selectArgs = append(selectArgs, exprArgs...)
+			groupByCols = append(groupByCols, fmt.Sprintf("label_%d", i))
 		}
-		defer rows.Close()
+		groupByClause = ", " + joinStrings(groupByCols, ", ")
+	}
 
-		// Group by label combinations
-		seriesMap := make(map[string]*MetricSeries)
+	// Build filter clause
+	filterClause := ""
+	var filterArgs []interface{}
+	if len(metricQuery.Filters) > 0 || len(metricQuery.multiFilters) > 0 {
+		var filters []string
+		for key, value := range metricQuery.Filters {
+			expr, exprArgs := metricLabelExpr(key)
+			filters = append(filters, expr+" = ?")
+			filterArgs = append(filterArgs, exprArgs...)
+			filterArgs = append(filterArgs, value)
+		}
+		for key, values := range metricQuery.multiFilters {
+			expr, exprArgs := metricLabelExpr(key)
+			filters = append(filters, fmt.Sprintf("has(?, %s)", expr))
+			filterArgs = append(filterArgs, values)
+			filterArgs = append(filterArgs, exprArgs...)
+		}
+		filterClause = " AND " + joinStrings(filters, " AND ")
+	}
 
-		for rows.Next() {
-			var timestamp time.Time
-			var value float64
-
-			// Prepare scan destinations
-			scanDest := []interface{}{&timestamp, &value}
-			labels := make(map[string]string)
-
-			if len(metricQuery.GroupBy) > 0 {
-				for _, labelKey := range metricQuery.GroupBy {
-					var labelValue string
-					scanDest = append(scanDest, &labelValue)
-					labels[labelKey] = labelValue
-				}
-			}
+	// Build time bucket
+	timeBucket := fmt.Sprintf("toStartOfInterval(Timestamp, INTERVAL %d SECOND)", interval)
 
-			if err := rows.Scan(scanDest...); err != nil {
-				return nil, err
-			}
+	query := fmt.Sprintf(`
+		SELECT
+			%s as time_bucket,
+			%s as value
+			%s
+		FROM metrics.metrics_v1
+		WHERE AccountId = ?
+		  AND MetricName = ?
+		  AND Timestamp >= ?
+		  AND Timestamp < ?
+		  %s
+		GROUP BY time_bucket%s
+		ORDER BY time_bucket
+	`, timeBucket, aggFunc, selectLabels, filterClause, groupByClause)
 
-			// Create series key from labels
-			seriesKey := metricQuery.MetricName
-			if len(labels) > 0 {
-				seriesKey = fmt.Sprintf("%s{%v}", metricQuery.MetricName, labels)
-			}
+	args := append(selectArgs, accountId, metricQuery.MetricName, from, to)
+	args = append(args, filterArgs...)
 
-			series, exists := seriesMap[seriesKey]
-			if !exists {
-				name := metricQuery.MetricName
-				if metricQuery.Alias != "" {
-					name = metricQuery.Alias
-				}
-				series = &MetricSeries{
-					Name:       name,
-					Labels:     labels,
-					DataPoints: []DataPoint{},
-					Stats:      SeriesStats{},
-				}
-				seriesMap[seriesKey] = series
-			}
+	rows, err := s.conn.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query metric %s: %w", metricQuery.MetricName, err)
+	}
+	defer rows.Close()
+
+	// Group by label combinations
+	seriesMap := make(map[string]*MetricSeries)
+	var seriesOrder []string
+
+	for rows.Next() {
+		var timestamp time.Time
+		var value float64
+
+		// Prepare scan destinations
+		scanDest := []interface{}{&timestamp, &value}
+		labelValues := make([]string, len(metricQuery.GroupBy))
+		for i := range labelValues {
+			scanDest = append(scanDest, &labelValues[i])
+		}
+
+		if err := rows.Scan(scanDest...); err != nil {
+			return nil, err
+		}
+
+		labels := make(map[string]string)
+		for i, labelKey := range metricQuery.GroupBy {
+			labels[labelKey] = labelValues[i]
+		}
 
-			series.DataPoints = append(series.DataPoints, DataPoint{
-				Timestamp: timestamp,
-				Value:     value,
-			})
+		// Create series key from labels
+		seriesKey := metricQuery.MetricName
+		if len(labels) > 0 {
+			seriesKey = fmt.Sprintf("%s{%v}", metricQuery.MetricName, labels)
 		}
 
-		// Calculate stats and add to results
-		for _, series := range seriesMap {
-			calculateStats(series)
-			allSeries = append(allSeries, *series)
+		series, exists := seriesMap[seriesKey]
+		if !exists {
+			name := metricQuery.MetricName
+			if metricQuery.Alias != "" {
+				name = metricQuery.Alias
+			}
+			series = &MetricSeries{
+				Name:       name,
+				Labels:     labels,
+				DataPoints: []DataPoint{},
+				Stats:      SeriesStats{},
+			}
+			seriesMap[seriesKey] = series
+			seriesOrder = append(seriesOrder, seriesKey)
 		}
+
+		series.DataPoints = append(series.DataPoints, DataPoint{
+			Timestamp: timestamp,
+			Value:     value,
+		})
 	}
 
-	return &MetricQueryResponse{
-		Series: allSeries,
-	}, nil
+	// Calculate stats and add to results
+	var results []MetricSeries
+	for _, key := range seriesOrder {
+		series := seriesMap[key]
+		calculateStats(series)
+		results = append(results, *series)
+	}
+	return results, nil
 }
 
 // ========== DASHBOARD CRUD OPERATIONS ==========
 
-// SaveDashboard saves or updates a dashboard configuration
+const dashboardColumns = `DashboardId, AccountId, Name, Description, Config, CreatedAt, UpdatedAt, Version, Author, ChangeMessage, Slug, Source, SourcePath, FolderId, Tags, OwnerId`
+
+func scanDashboard(row rowScanner) (*Dashboard, error) {
+	var d Dashboard
+	if err := row.Scan(
+		&d.DashboardId,
+		&d.AccountId,
+		&d.Name,
+		&d.Description,
+		&d.Config,
+		&d.CreatedAt,
+		&d.UpdatedAt,
+		&d.Version,
+		&d.Author,
+		&d.ChangeMessage,
+		&d.Slug,
+		&d.Source,
+		&d.SourcePath,
+		&d.FolderId,
+		&d.Tags,
+		&d.OwnerId,
+	); err != nil {
+		return nil, err
+	}
+	d.ReadOnly = d.Source == DashboardSourceFile
+	// Configs saved before the widget schema was enforced may not decode;
+	// such dashboards are still listed, just without widgets, and keep
+	// their config until a save replaces it with widgets.
+	if config, err := DecodeDashboardConfig(d.Config); err == nil {
+		d.Widgets = config.Widgets
+		d.Variables = config.Variables
+	} else {
+		d.undecodedConfig = d.Config
+	}
+	return &d, nil
+}
+
+// DecodeDashboardConfig parses a stored config, accepting both the
+// {"widgets": [...]} object and a bare widget array. Widgets in the layout
+// the dashboard editor saved before widgets had a schema are translated.
+func DecodeDashboardConfig(raw string) (DashboardConfig, error) {
+	var config DashboardConfig
+	if strings.TrimSpace(raw) == "" {
+		return config, nil
+	}
+	var stored struct {
+		Widgets   []json.RawMessage   `json:"widgets"`
+		Variables []DashboardVariable `json:"variables"`
+	}
+	if err := json.Unmarshal([]byte(raw), &stored); err != nil {
+		if err := json.Unmarshal([]byte(raw), &stored.Widgets); err != nil {
+			return config, fmt.Errorf("failed to decode dashboard config: %w", err)
+		}
+	}
+	config.Variables = stored.Variables
+	for _, rawWidget := range stored.Widgets {
+		widget, err := decodeDashboardWidget(rawWidget)
+		if err != nil {
+			return config, err
+		}
+		config.Widgets = append(config.Widgets, widget)
+	}
+	return config, nil
+}
+
+// layoutWidget is a widget as the dashboard editor saved it before widgets
+// had a schema: its grid position next to its editor settings
+type layoutWidget struct {
+	I      string `json:"i"`
+	X      int    `json:"x"`
+	Y      int    `json:"y"`
+	W      int    `json:"w"`
+	H      int    `json:"h"`
+	Type   string `json:"type"`
+	Config struct {
+		Title           string            `json:"title"`
+		MetricName      string            `json:"metricName"`
+		Aggregation     string            `json:"aggregation"`
+		GroupBy         []string          `json:"groupBy"`
+		Filters         map[string]string `json:"filters"`
+		ChartType       string            `json:"chartType"`
+		Colors          []string          `json:"colors"`
+		Unit            string            `json:"unit"`
+		TimeRange       string            `json:"timeRange"`
+		RefreshInterval string            `json:"refreshInterval"`
+		Decimals        int               `json:"decimals"`
+	} `json:"config"`
+}
+
+// decodeDashboardWidget parses a widget, translating the editor's layout
+func decodeDashboardWidget(raw json.RawMessage) (DashboardWidget, error) {
+	var widget DashboardWidget
+	var probe struct {
+		I        *string `json:"i"`
+		WidgetId *string `json:"widget_id"`
+	}
+	if err := json.Unmarshal(raw, &probe); err != nil {
+		return widget, fmt.Errorf("failed to decode dashboard widget: %w", err)
+	}
+	if probe.I == nil || probe.WidgetId != nil {
+		if err := json.Unmarshal(raw, &widget); err != nil {
+			return widget, fmt.Errorf("failed to decode dashboard widget: %w", err)
+		}
+		return widget, nil
+	}
+
+	var lw layoutWidget
+	if err := json.Unmarshal(raw, &lw); err != nil {
+		return widget, fmt.Errorf("failed to decode dashboard widget: %w", err)
+	}
+	widget = DashboardWidget{
+		WidgetId:   lw.I,
+		WidgetType: lw.Type,
+		Title:      lw.Config.Title,
+		Visualization: VisualizationConfig{
+			ChartType: lw.Config.ChartType,
+			Colors:    lw.Config.Colors,
+			Unit:      lw.Config.Unit,
+			Decimals:  lw.Config.Decimals,
+		},
+		Layout:     WidgetLayout{X: lw.X, Y: lw.Y, W: lw.W, H: lw.H},
+		TimeConfig: TimeConfig{TimeRange: lw.Config.TimeRange, RefreshInterval: lw.Config.RefreshInterval},
+	}
+	if lw.Config.MetricName != "" {
+		widget.Queries = []MetricQuery{{
+			MetricName:  lw.Config.MetricName,
+			Aggregation: lw.Config.Aggregation,
+			GroupBy:     lw.Config.GroupBy,
+			Filters:     lw.Config.Filters,
+			Alias:       lw.Config.Title,
+		}}
+	}
+	return widget, nil
+}
+
+// maxDashboardSaveAttempts bounds how often a save retries after losing
+// its version to a concurrent save
+const maxDashboardSaveAttempts = 5
+
+// SaveDashboard stores a new version of a dashboard. Every save appends a
+// row with the next version number; earlier versions are kept as history.
+// A save that loses its version to a concurrent one retries with the next.
 func (s *Store) SaveDashboard(ctx context.Context, dashboard *Dashboard) error {
-	query := `
-		INSERT INTO metrics.dashboards
-		(DashboardId, AccountId, Name, Description, Config, CreatedAt, UpdatedAt)
-		VALUES (?, ?, ?, ?, ?, ?, ?)
-	`
+	for attempt := 1; ; attempt++ {
+		err := s.saveDashboard(ctx, dashboard, nil)
+		var conflict *VersionConflictError
+		if !errors.As(err, &conflict) || attempt == maxDashboardSaveAttempts {
+			return err
+		}
+	}
+}
+
+// SaveDashboardIfVersion saves a dashboard only if its latest version is
+// still expectedVersion, returning a *VersionConflictError otherwise
+func (s *Store) SaveDashboardIfVersion(ctx context.Context, dashboard *Dashboard, expectedVersion uint32) error {
+	return s.saveDashboard(ctx, dashboard, &expectedVersion)
+}
+
+// saveDashboard appends the next version of a dashboard. A version after
+// the first is claimed with an insert deduplicated on the dashboard, the
+// version and the row it follows, so of two saves racing for a version only
+// the first is stored, whichever backend process they run in. Reading the
+// version back tells the other that it lost, which it reports as a
+// *VersionConflictError. Keying on the row followed means a dashboard
+// deleted and created again under the same ID never matches the tokens of
+// its earlier rows.
+//
+// Deduplication is done by the ClickHouse server within the table's
+// non_replicated_deduplication_window, so it only holds while every save
+// goes to the same server. Two saves creating the same new dashboard ID at
+// once are only told apart by the read back, which can let both through.
+func (s *Store) saveDashboard(ctx context.Context, dashboard *Dashboard, expectedVersion *uint32) error {
+	if dashboard.Widgets == nil {
+		dashboard.Widgets = []DashboardWidget{}
+	}
+	config, err := json.Marshal(DashboardConfig{Widgets: dashboard.Widgets, Variables: dashboard.Variables})
+	if err != nil {
+		return fmt.Errorf("failed to encode dashboard config: %w", err)
+	}
+	dashboard.Config = string(config)
+
+	// Find the current version and keep the original creation time, slug
+	// and owner
+	var versions uint64
+	var latest uint32
+	var createdAt time.Time
+	var slug string
+	var ownerId uint64
+	var latestConfig string
+	var latestSaveId string
+	err = s.conn.QueryRow(ctx, `
+		SELECT count(), max(Version), min(CreatedAt), argMax(Slug, Version), argMax(OwnerId, Version), argMax(Config, Version),
+			argMax(SaveId, (Version, UpdatedAt))
+		FROM metrics.dashboards
+		WHERE AccountId = ? AND DashboardId = ?
+	`, dashboard.AccountId, dashboard.DashboardId).Scan(&versions, &latest, &createdAt, &slug, &ownerId, &latestConfig, &latestSaveId)
+	if err != nil {
+		return fmt.Errorf("failed to get dashboard version: %w", err)
+	}
+	if expectedVersion != nil && *expectedVersion != latest {
+		return &VersionConflictError{Expected: *expectedVersion, Current: latest}
+	}
+
+	// A save without widgets keeps a config that does not decode, restored
+	// or current, rather than overwriting the widgets it could not show
+	if len(dashboard.Widgets) == 0 && len(dashboard.Variables) == 0 {
+		if dashboard.undecodedConfig != "" {
+			dashboard.Config = dashboard.undecodedConfig
+		} else if _, err := DecodeDashboardConfig(latestConfig); err != nil {
+			dashboard.Config = latestConfig
+		}
+	}
 
-	if dashboard.CreatedAt.IsZero() {
+	if dashboard.Slug == "" {
+		dashboard.Slug = slug
+	}
+	if dashboard.OwnerId == 0 {
+		dashboard.OwnerId = ownerId
+	}
+	dashboard.Tags = NormalizeTags(dashboard.Tags)
+	if dashboard.Slug == "" {
+		if dashboard.Slug, err = s.uniqueDashboardSlug(ctx, dashboard.AccountId, Slugify(dashboard.Name)); err != nil {
+			return err
+		}
+	}
+	dashboard.ReadOnly = dashboard.Source == DashboardSourceFile
+
+	dashboard.Version = latest + 1
+	if versions > 0 {
+		dashboard.CreatedAt = createdAt
+	} else if dashboard.CreatedAt.IsZero() {
 		dashboard.CreatedAt = time.Now()
 	}
 	dashboard.UpdatedAt = time.Now()
 
-	err := s.conn.Exec(ctx, query,
+	saveId := uuid.NewString()
+	token := "dashboard:" + saveId
+	if versions > 0 {
+		token = fmt.Sprintf("dashboard:%d:%s:%d:%s", dashboard.AccountId, dashboard.DashboardId, dashboard.Version, latestSaveId)
+	}
+	claimCtx := clickhouse.Context(ctx, clickhouse.WithSettings(clickhouse.Settings{
+		"insert_deduplicate":         1,
+		"insert_deduplication_token": token,
+	}))
+
+	query := fmt.Sprintf(`
+		INSERT INTO metrics.dashboards
+		(%s, SaveId)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, dashboardColumns)
+
+	err = s.conn.Exec(claimCtx, query,
 		dashboard.DashboardId,
 		dashboard.AccountId,
 		dashboard.Name,
@@ -927,49 +1346,101 @@ func (s *Store) SaveDashboard(ctx context.Context, dashboard *Dashboard) error {
 		dashboard.Config,
 		dashboard.CreatedAt,
 		dashboard.UpdatedAt,
+		dashboard.Version,
+		dashboard.Author,
+		dashboard.ChangeMessage,
+		dashboard.Slug,
+		dashboard.Source,
+		dashboard.SourcePath,
+		dashboard.FolderId,
+		dashboard.Tags,
+		dashboard.OwnerId,
+		saveId,
 	)
-
 	if err != nil {
 		return fmt.Errorf("failed to save dashboard: %w", err)
 	}
+
+	// Readers take the last saved row of a version, so that is the one
+	// that claimed it
+	var claimed bool
+	err = s.conn.QueryRow(ctx, `
+		SELECT argMax(SaveId, UpdatedAt) = ?
+		FROM metrics.dashboards
+		WHERE AccountId = ? AND DashboardId = ? AND Version = ?
+	`, saveId, dashboard.AccountId, dashboard.DashboardId, dashboard.Version).Scan(&claimed)
+	if err != nil {
+		return fmt.Errorf("failed to check dashboard version: %w", err)
+	}
+	if !claimed {
+		expected := latest
+		if expectedVersion != nil {
+			expected = *expectedVersion
+		}
+		return &VersionConflictError{Expected: expected, Current: dashboard.Version}
+	}
 	return nil
 }
 
-// GetDashboard retrieves a dashboard by ID
+// GetDashboard retrieves the latest version of a dashboard by ID
 func (s *Store) GetDashboard(ctx context.Context, accountId uint64, dashboardId string) (*Dashboard, error) {
-	query := `
-		SELECT DashboardId, AccountId, Name, Description, Config, CreatedAt, UpdatedAt
+	query := fmt.Sprintf(`
+		SELECT %s
 		FROM metrics.dashboards
 		WHERE AccountId = ? AND DashboardId = ?
-		ORDER BY UpdatedAt DESC
+		ORDER BY Version DESC, UpdatedAt DESC
 		LIMIT 1
-	`
-
-	var dashboard Dashboard
-	err := s.conn.QueryRow(ctx, query, accountId, dashboardId).Scan(
-		&dashboard.DashboardId,
-		&dashboard.AccountId,
-		&dashboard.Name,
-		&dashboard.Description,
-		&dashboard.Config,
-		&dashboard.CreatedAt,
-		&dashboard.UpdatedAt,
-	)
+	`, dashboardColumns)
 
+	dashboard, err := scanDashboard(s.conn.QueryRow(ctx, query, accountId, dashboardId))
 	if err != nil {
 		return nil, fmt.Errorf("failed to get dashboard: %w", err)
 	}
-	return &dashboard, nil
+	return dashboard, nil
 }
 
-// ListDashboards returns all dashboards for an account
+// GetDashboardBySlug retrieves the latest version of the dashboard whose
+// current slug matches. It returns nil without error if there is none.
+func (s *Store) GetDashboardBySlug(ctx context.Context, accountId uint64, slug string) (*Dashboard, error) {
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM (
+			SELECT *
+			FROM metrics.dashboards
+			WHERE AccountId = ?
+			ORDER BY Version DESC, UpdatedAt DESC
+			LIMIT 1 BY DashboardId
+		)
+		WHERE Slug = ?
+		ORDER BY UpdatedAt DESC
+		LIMIT 1
+	`, dashboardColumns)
+
+	rows, err := s.conn.Query(ctx, query, accountId, slug)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get dashboard by slug: %w", err)
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+	return scanDashboard(rows)
+}
+
+// ListDashboards returns the latest version of every dashboard for an account
 func (s *Store) ListDashboards(ctx context.Context, accountId uint64) ([]Dashboard, error) {
-	query := `
-		SELECT DashboardId, AccountId, Name, Description, Config, CreatedAt, UpdatedAt
-		FROM metrics.dashboards
-		WHERE AccountId = ?
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM (
+			SELECT *
+			FROM metrics.dashboards
+			WHERE AccountId = ?
+			ORDER BY Version DESC, UpdatedAt DESC
+			LIMIT 1 BY DashboardId
+		)
 		ORDER BY UpdatedAt DESC
-	`
+	`, dashboardColumns)
 
 	rows, err := s.conn.Query(ctx, query, accountId)
 	if err != nil {
@@ -979,25 +1450,26 @@ func (s *Store) ListDashboards(ctx context.Context, accountId uint64) ([]Dashboa
 
 	var dashboards []Dashboard
 	for rows.Next() {
-		var d Dashboard
-		if err := rows.Scan(&d.DashboardId, &d.AccountId, &d.Name, &d.Description, &d.Config, &d.CreatedAt, &d.UpdatedAt); err != nil {
+		d, err := scanDashboard(rows)
+		if err != nil {
 			return nil, err
 		}
-		dashboards = append(dashboards, d)
+		dashboards = append(dashboards, *d)
 	}
 	return dashboards, nil
 }
 
-// DeleteDashboard deletes a dashboard
+// DeleteDashboard deletes a dashboard, all of its versions, its stars and
+// its permissions
 func (s *Store) DeleteDashboard(ctx context.Context, accountId uint64, dashboardId string) error {
-	query := `
-		ALTER TABLE metrics.dashboards
-		DELETE WHERE AccountId = ? AND DashboardId = ?
-	`
-
-	err := s.conn.Exec(ctx, query, accountId, dashboardId)
-	if err != nil {
-		return fmt.Errorf("failed to delete dashboard: %w", err)
+	for _, table := range []string{"metrics.dashboards", "metrics.dashboard_stars", "metrics.dashboard_permissions"} {
+		query := fmt.Sprintf(`
+			ALTER TABLE %s
+			DELETE WHERE AccountId = ? AND DashboardId = ?
+		`, table)
+		if err := s.conn.Exec(ctx, query, accountId, dashboardId); err != nil {
+			return fmt.Errorf("failed to delete dashboard: %w", err)
+		}
 	}
 	return nil
 }
@@ -1071,6 +1543,25 @@ func buildAggregationFunc(agg, column string) string {
 	}
 }
 
+// metricColumnLabels maps reserved label keys onto metrics_v1 columns so
+// queries can filter and group by them like ordinary labels.
+var metricColumnLabels = map[string]string{
+	"service_name": "ServiceName",
+	"host_name":    "HostName",
+	"namespace":    "Namespace",
+	"pod":          "Pod",
+	"env":          "Env",
+}
+
+// metricLabelExpr returns the SQL expression (and its bind args) that reads a
+// label key, either from a reserved column or from the Labels map.
+func metricLabelExpr(key string) (string, []interface{}) {
+	if column, ok := metricColumnLabels[key]; ok {
+		return column, nil
+	}
+	return "Labels[?]", []interface{}{key}
+}
+
 func joinStrings(strs []string, separator string) string {
 	result := ""
 	for i, s := range strs {
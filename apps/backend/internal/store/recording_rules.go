@@ -0,0 +1,397 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// RecordingRule periodically evaluates metric queries and writes the result
+// back into metrics_v1 as a new metric. The series of the last query in
+// Queries are recorded; use a Formula query last to record a derived value.
+type RecordingRule struct {
+	RuleId    string               `json:"rule_id"`
+	AccountId uint64               `json:"account_id"`
+	Name      string               `json:"name"`
+	Record    string               `json:"record"`   // MetricName of the recorded series
+	Queries   []MetricQuery        `json:"queries"`  // inputs, the last one is recorded
+	Interval  string               `json:"interval"` // e.g., "1m", "5m"
+	Labels    map[string]string    `json:"labels"`   // extra labels on recorded series
+	Paused    bool                 `json:"paused"`
+	CreatedAt time.Time            `json:"created_at"`
+	UpdatedAt time.Time            `json:"updated_at"`
+	Status    *RecordingRuleStatus `json:"status,omitempty"`
+}
+
+// RecordingRuleStatus summarizes recent evaluations of a rule
+type RecordingRuleStatus struct {
+	LastEvaluatedAt  time.Time `json:"last_evaluated_at"`
+	LastSuccessAt    time.Time `json:"last_success_at"`
+	LastWindowEnd    time.Time `json:"last_window_end"`
+	LagSeconds       float64   `json:"lag_seconds"`
+	LastError        string    `json:"last_error"`
+	LastDurationMs   uint32    `json:"last_duration_ms"`
+	FailuresLastHour uint64    `json:"failures_last_hour"`
+	Health           string    `json:"health"` // ok, failing, pending
+}
+
+// RecordingRuleEvaluation is one evaluation of a rule over a window
+type RecordingRuleEvaluation struct {
+	RuleId         string    `json:"rule_id"`
+	AccountId      uint64    `json:"account_id"`
+	EvaluatedAt    time.Time `json:"evaluated_at"`
+	WindowStart    time.Time `json:"window_start"`
+	WindowEnd      time.Time `json:"window_end"`
+	DurationMs     uint32    `json:"duration_ms"`
+	SamplesWritten uint64    `json:"samples_written"`
+	Success        bool      `json:"success"`
+	Error          string    `json:"error"`
+}
+
+var recordedMetricName = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+
+// Validate checks that a rule can be evaluated
+func (r *RecordingRule) Validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if !recordedMetricName.MatchString(r.Record) {
+		return fmt.Errorf("record must be a valid metric name")
+	}
+	if len(r.Queries) == 0 {
+		return fmt.Errorf("at least one query is required")
+	}
+	for i, q := range r.Queries {
+		if q.MetricName == "" && q.Formula == "" {
+			return fmt.Errorf("query %d needs a metric_name or a formula", i)
+		}
+		if q.MetricName == "" {
+			if _, _, err := parseFormula(q.Formula); err != nil {
+				return fmt.Errorf("query %d: invalid formula: %w", i, err)
+			}
+		}
+	}
+	if r.IntervalDuration() < 10*time.Second {
+		return fmt.Errorf("interval must be at least 10s")
+	}
+	return nil
+}
+
+// IntervalDuration returns the evaluation interval of the rule
+func (r *RecordingRule) IntervalDuration() time.Duration {
+	return time.Duration(parseInterval(r.Interval)) * time.Second
+}
+
+// ========== RECORDING RULE CRUD OPERATIONS ==========
+
+// SaveRecordingRule creates or replaces a recording rule
+func (s *Store) SaveRecordingRule(ctx context.Context, rule *RecordingRule) error {
+	queries, err := json.Marshal(rule.Queries)
+	if err != nil {
+		return fmt.Errorf("failed to encode rule queries: %w", err)
+	}
+
+	if rule.CreatedAt.IsZero() {
+		rule.CreatedAt = time.Now()
+	}
+	rule.UpdatedAt = time.Now()
+	if rule.Labels == nil {
+		rule.Labels = map[string]string{}
+	}
+
+	query := `
+		INSERT INTO metrics.recording_rules
+		(RuleId, AccountId, Name, Record, Queries, Interval, Labels, Paused, CreatedAt, UpdatedAt)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	err = s.conn.Exec(ctx, query,
+		rule.RuleId,
+		rule.AccountId,
+		rule.Name,
+		rule.Record,
+		string(queries),
+		rule.Interval,
+		rule.Labels,
+		boolToUInt8(rule.Paused),
+		rule.CreatedAt,
+		rule.UpdatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save recording rule: %w", err)
+	}
+	return nil
+}
+
+const recordingRuleColumns = `RuleId, AccountId, Name, Record, Queries, Interval, Labels, Paused, CreatedAt, UpdatedAt`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanRecordingRule(row rowScanner) (*RecordingRule, error) {
+	var rule RecordingRule
+	var queries string
+	var paused uint8
+	if err := row.Scan(
+		&rule.RuleId,
+		&rule.AccountId,
+		&rule.Name,
+		&rule.Record,
+		&queries,
+		&rule.Interval,
+		&rule.Labels,
+		&paused,
+		&rule.CreatedAt,
+		&rule.UpdatedAt,
+	); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(queries), &rule.Queries); err != nil {
+		return nil, fmt.Errorf("failed to decode queries of rule %s: %w", rule.RuleId, err)
+	}
+	rule.Paused = paused == 1
+	return &rule, nil
+}
+
+// GetRecordingRule retrieves a recording rule by ID, or an error wrapping
+// sql.ErrNoRows when there is none
+func (s *Store) GetRecordingRule(ctx context.Context, accountId uint64, ruleId string) (*RecordingRule, error) {
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM metrics.recording_rules FINAL
+		WHERE AccountId = ? AND RuleId = ?
+	`, recordingRuleColumns)
+
+	rule, err := scanRecordingRule(s.conn.QueryRow(ctx, query, accountId, ruleId))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get recording rule: %w", err)
+	}
+	return rule, nil
+}
+
+// ListRecordingRules returns all recording rules of an account
+func (s *Store) ListRecordingRules(ctx context.Context, accountId uint64) ([]RecordingRule, error) {
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM metrics.recording_rules FINAL
+		WHERE AccountId = ?
+		ORDER BY Name
+	`, recordingRuleColumns)
+
+	return s.queryRecordingRules(ctx, query, accountId)
+}
+
+// ListActiveRecordingRules returns the unpaused rules of every account
+func (s *Store) ListActiveRecordingRules(ctx context.Context) ([]RecordingRule, error) {
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM metrics.recording_rules FINAL
+		WHERE Paused = 0
+		ORDER BY AccountId, RuleId
+	`, recordingRuleColumns)
+
+	return s.queryRecordingRules(ctx, query)
+}
+
+func (s *Store) queryRecordingRules(ctx context.Context, query string, args ...interface{}) ([]RecordingRule, error) {
+	rows, err := s.conn.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list recording rules: %w", err)
+	}
+	defer rows.Close()
+
+	var rules []RecordingRule
+	for rows.Next() {
+		rule, err := scanRecordingRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, *rule)
+	}
+	return rules, nil
+}
+
+// DeleteRecordingRule deletes a recording rule and its evaluation history
+func (s *Store) DeleteRecordingRule(ctx context.Context, accountId uint64, ruleId string) error {
+	for _, table := range []string{"metrics.recording_rules", "metrics.recording_rule_evaluations"} {
+		query := fmt.Sprintf(`
+			ALTER TABLE %s
+			DELETE WHERE AccountId = ? AND RuleId = ?
+		`, table)
+		if err := s.conn.Exec(ctx, query, accountId, ruleId); err != nil {
+			return fmt.Errorf("failed to delete recording rule: %w", err)
+		}
+	}
+	return nil
+}
+
+// ========== RECORDING RULE EVALUATION ==========
+
+// EvaluateRecordingRule evaluates a rule over [from, to) and writes the
+// recorded series into metrics_v1. It returns the number of samples written.
+func (s *Store) EvaluateRecordingRule(ctx context.Context, rule *RecordingRule, from, to time.Time) (int, error) {
+	interval := int(to.Sub(from).Seconds())
+	if interval <= 0 {
+		return 0, fmt.Errorf("empty evaluation window")
+	}
+
+	results, err := s.runMetricQueries(ctx, rule.AccountId, rule.Queries, from, to, interval)
+	if err != nil {
+		return 0, err
+	}
+
+	var metrics []Metric
+	for _, series := range results[len(results)-1] {
+		labels := make(map[string]string)
+		resourceAttrs := map[string]string{"recording_rule": rule.RuleId}
+		serviceName := ""
+		for k, v := range series.Labels {
+			switch k {
+			case "service_name":
+				serviceName = v
+			case "host_name":
+				resourceAttrs["host.name"] = v
+			case "namespace":
+				resourceAttrs["k8s.namespace"] = v
+			case "pod":
+				resourceAttrs["k8s.pod.name"] = v
+			case "env":
+				resourceAttrs["deployment.environment"] = v
+			default:
+				labels[k] = v
+			}
+		}
+		for k, v := range rule.Labels {
+			labels[k] = v
+		}
+
+		for _, dp := range series.DataPoints {
+			metrics = append(metrics, Metric{
+				Timestamp:          dp.Timestamp,
+				AccountId:          rule.AccountId,
+				ServiceName:        serviceName,
+				MetricName:         rule.Record,
+				MetricType:         "gauge",
+				Value:              dp.Value,
+				Labels:             labels,
+				ResourceAttributes: resourceAttrs,
+			})
+		}
+	}
+
+	if len(metrics) == 0 {
+		return 0, nil
+	}
+	if err := s.InsertMetrics(ctx, metrics); err != nil {
+		return 0, fmt.Errorf("failed to write recorded metrics: %w", err)
+	}
+	return len(metrics), nil
+}
+
+// InsertRecordingRuleEvaluation stores the outcome of one evaluation
+func (s *Store) InsertRecordingRuleEvaluation(ctx context.Context, eval RecordingRuleEvaluation) error {
+	query := `
+		INSERT INTO metrics.recording_rule_evaluations
+		(RuleId, AccountId, EvaluatedAt, WindowStart, WindowEnd, DurationMs, SamplesWritten, Success, Error)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	err := s.conn.Exec(ctx, query,
+		eval.RuleId,
+		eval.AccountId,
+		eval.EvaluatedAt,
+		eval.WindowStart,
+		eval.WindowEnd,
+		eval.DurationMs,
+		eval.SamplesWritten,
+		boolToUInt8(eval.Success),
+		eval.Error,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record evaluation: %w", err)
+	}
+	return nil
+}
+
+// GetRecordingRuleEvaluations returns the most recent evaluations of a rule
+func (s *Store) GetRecordingRuleEvaluations(ctx context.Context, accountId uint64, ruleId string, limit int) ([]RecordingRuleEvaluation, error) {
+	query := `
+		SELECT RuleId, AccountId, EvaluatedAt, WindowStart, WindowEnd, DurationMs, SamplesWritten, Success, Error
+		FROM metrics.recording_rule_evaluations
+		WHERE AccountId = ? AND RuleId = ?
+		ORDER BY EvaluatedAt DESC
+		LIMIT ?
+	`
+	rows, err := s.conn.Query(ctx, query, accountId, ruleId, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get rule evaluations: %w", err)
+	}
+	defer rows.Close()
+
+	var results []RecordingRuleEvaluation
+	for rows.Next() {
+		var e RecordingRuleEvaluation
+		var success uint8
+		if err := rows.Scan(&e.RuleId, &e.AccountId, &e.EvaluatedAt, &e.WindowStart, &e.WindowEnd,
+			&e.DurationMs, &e.SamplesWritten, &success, &e.Error); err != nil {
+			return nil, err
+		}
+		e.Success = success == 1
+		results = append(results, e)
+	}
+	return results, nil
+}
+
+// GetRecordingRuleStatuses summarizes evaluation health per rule. An
+// accountId of 0 returns the statuses of every account.
+func (s *Store) GetRecordingRuleStatuses(ctx context.Context, accountId uint64) (map[string]RecordingRuleStatus, error) {
+	query := `
+		SELECT
+			RuleId,
+			max(EvaluatedAt) as last_evaluated,
+			maxIf(EvaluatedAt, Success = 1) as last_success,
+			maxIf(WindowEnd, Success = 1) as last_window_end,
+			argMax(Error, EvaluatedAt) as last_error,
+			argMax(DurationMs, EvaluatedAt) as last_duration,
+			countIf(Success = 0 AND EvaluatedAt > now() - INTERVAL 1 HOUR) as failures
+		FROM metrics.recording_rule_evaluations
+		WHERE (? = 0 OR AccountId = ?)
+		GROUP BY RuleId
+	`
+	rows, err := s.conn.Query(ctx, query, accountId, accountId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get rule statuses: %w", err)
+	}
+	defer rows.Close()
+
+	statuses := make(map[string]RecordingRuleStatus)
+	for rows.Next() {
+		var ruleId string
+		var st RecordingRuleStatus
+		if err := rows.Scan(&ruleId, &st.LastEvaluatedAt, &st.LastSuccessAt, &st.LastWindowEnd,
+			&st.LastError, &st.LastDurationMs, &st.FailuresLastHour); err != nil {
+			return nil, err
+		}
+
+		st.Health = "ok"
+		if st.LastError != "" {
+			st.Health = "failing"
+		}
+		if st.LastWindowEnd.Unix() > 0 {
+			st.LagSeconds = time.Since(st.LastWindowEnd).Seconds()
+		} else {
+			st.LastWindowEnd = time.Time{}
+			st.LastSuccessAt = time.Time{}
+		}
+		statuses[ruleId] = st
+	}
+	return statuses, nil
+}
+
+func boolToUInt8(b bool) uint8 {
+	if b {
+		return 1
+	}
+	return 0
+}
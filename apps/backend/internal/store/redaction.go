@@ -0,0 +1,205 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Built-in redaction detectors
+const (
+	RedactionCreditCard   = "credit_card"
+	RedactionEmail        = "email"
+	RedactionBearerToken  = "bearer_token"
+	RedactionAWSAccessKey = "aws_access_key"
+	RedactionAWSSecretKey = "aws_secret_key"
+)
+
+// RedactionDetectors lists the built-in detectors
+var RedactionDetectors = []string{
+	RedactionCreditCard, RedactionEmail, RedactionBearerToken, RedactionAWSAccessKey, RedactionAWSSecretKey,
+}
+
+// Redaction modes
+const (
+	RedactionMask = "mask" // replace matches with [REDACTED:<rule>]
+	RedactionHash = "hash" // replace matches with a keyed hash, so equal values stay correlatable
+)
+
+// Signals whose data is redacted
+const (
+	RedactionSignalLogs   = "logs"
+	RedactionSignalTraces = "traces"
+)
+
+// RedactionConfig sets what is redacted from an account's logs and spans
+// at ingestion. Accounts without one get DefaultRedactionConfig.
+type RedactionConfig struct {
+	AccountId uint64          `json:"account_id"`
+	Enabled   bool            `json:"enabled"`
+	Mode      string          `json:"mode"`      // mask or hash
+	Detectors []string        `json:"detectors"` // built-in detectors in use
+	Rules     []RedactionRule `json:"rules"`     // custom rules
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+// RedactionRule is a custom regex rule. When the pattern has groups, only
+// the first group is redacted, so context such as a key name is kept.
+type RedactionRule struct {
+	Name    string `json:"name"`
+	Pattern string `json:"pattern"`
+	Mode    string `json:"mode,omitempty"` // overrides the config's mode
+}
+
+// RedactionCount is how many values a rule redacted from a service
+type RedactionCount struct {
+	ServiceName string `json:"service_name"`
+	Rule        string `json:"rule"`
+	Signal      string `json:"signal"`
+	Count       uint64 `json:"count"`
+}
+
+// DefaultRedactionConfig masks everything the built-in detectors find
+func DefaultRedactionConfig(accountId uint64) *RedactionConfig {
+	return &RedactionConfig{
+		AccountId: accountId,
+		Enabled:   true,
+		Mode:      RedactionMask,
+		Detectors: append([]string{}, RedactionDetectors...),
+		Rules:     []RedactionRule{},
+	}
+}
+
+// SaveRedactionConfig replaces an account's redaction config
+func (s *Store) SaveRedactionConfig(ctx context.Context, cfg *RedactionConfig) error {
+	rules, err := json.Marshal(cfg.Rules)
+	if err != nil {
+		return fmt.Errorf("failed to encode redaction rules: %w", err)
+	}
+	if cfg.Detectors == nil {
+		cfg.Detectors = []string{}
+	}
+	cfg.UpdatedAt = time.Now()
+
+	query := `
+		INSERT INTO logs.redaction_configs
+		(AccountId, Enabled, Mode, Detectors, Rules, UpdatedAt)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`
+	err = s.conn.Exec(ctx, query,
+		cfg.AccountId,
+		cfg.Enabled,
+		cfg.Mode,
+		cfg.Detectors,
+		string(rules),
+		cfg.UpdatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save redaction config: %w", err)
+	}
+	return nil
+}
+
+// GetRedactionConfig returns an account's redaction config, or the default
+// one when the account has not saved any
+func (s *Store) GetRedactionConfig(ctx context.Context, accountId uint64) (*RedactionConfig, error) {
+	configs, err := s.queryRedactionConfigs(ctx, "WHERE AccountId = ?", accountId)
+	if err != nil {
+		return nil, err
+	}
+	if len(configs) == 0 {
+		return DefaultRedactionConfig(accountId), nil
+	}
+	return &configs[0], nil
+}
+
+// ListRedactionConfigs returns the saved redaction configs of every account
+func (s *Store) ListRedactionConfigs(ctx context.Context) ([]RedactionConfig, error) {
+	return s.queryRedactionConfigs(ctx, "")
+}
+
+func (s *Store) queryRedactionConfigs(ctx context.Context, whereClause string, args ...interface{}) ([]RedactionConfig, error) {
+	query := fmt.Sprintf(`
+		SELECT AccountId, Enabled, Mode, Detectors, Rules, UpdatedAt
+		FROM logs.redaction_configs FINAL
+		%s
+	`, whereClause)
+	rows, err := s.conn.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query redaction configs: %w", err)
+	}
+	defer rows.Close()
+
+	var configs []RedactionConfig
+	for rows.Next() {
+		var cfg RedactionConfig
+		var rules string
+		if err := rows.Scan(&cfg.AccountId, &cfg.Enabled, &cfg.Mode, &cfg.Detectors, &rules, &cfg.UpdatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(rules), &cfg.Rules); err != nil {
+			return nil, fmt.Errorf("failed to decode redaction rules of account %d: %w", cfg.AccountId, err)
+		}
+		configs = append(configs, cfg)
+	}
+	return configs, rows.Err()
+}
+
+// RedactionCountSample is a count of redactions in a minute
+type RedactionCountSample struct {
+	AccountId   uint64
+	Minute      time.Time
+	ServiceName string
+	Rule        string
+	Signal      string
+	Count       uint64
+}
+
+// InsertRedactionCounts adds to the redaction counters
+func (s *Store) InsertRedactionCounts(ctx context.Context, samples []RedactionCountSample) error {
+	batch, err := s.conn.PrepareBatch(ctx, "INSERT INTO logs.redaction_counts")
+	if err != nil {
+		return err
+	}
+	for _, c := range samples {
+		if err := batch.Append(c.AccountId, c.Minute, c.ServiceName, c.Rule, c.Signal, c.Count); err != nil {
+			return err
+		}
+	}
+	return batch.Send()
+}
+
+// GetRedactionCounts returns how many values each rule redacted from each
+// service in the last minutes, most redacted first
+func (s *Store) GetRedactionCounts(ctx context.Context, accountId uint64, minutes int, services []string) ([]RedactionCount, error) {
+	whereClause := "WHERE AccountId = ? AND Minute > now() - INTERVAL ? MINUTE"
+	args := []interface{}{accountId, minutes}
+	if len(services) > 0 {
+		whereClause += " AND has(?, ServiceName)"
+		args = append(args, services)
+	}
+
+	query := fmt.Sprintf(`
+		SELECT ServiceName, Rule, Signal, sum(Count) as total
+		FROM logs.redaction_counts
+		%s
+		GROUP BY ServiceName, Rule, Signal
+		ORDER BY total DESC
+	`, whereClause)
+	rows, err := s.conn.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query redaction counts: %w", err)
+	}
+	defer rows.Close()
+
+	counts := []RedactionCount{}
+	for rows.Next() {
+		var c RedactionCount
+		if err := rows.Scan(&c.ServiceName, &c.Rule, &c.Signal, &c.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+	return counts, rows.Err()
+}
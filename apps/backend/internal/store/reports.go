@@ -0,0 +1,394 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"time"
+)
+
+// Report formats
+const (
+	ReportFormatPDF = "pdf"
+	ReportFormatPNG = "png"
+	ReportFormatSVG = "svg"
+)
+
+// Report delivery channels
+const (
+	ReportDeliveryEmail   = "email"
+	ReportDeliveryWebhook = "webhook"
+)
+
+// Report run triggers
+const (
+	ReportTriggerSchedule = "schedule"
+	ReportTriggerManual   = "manual"
+)
+
+// ReportSchedule renders a dashboard on a cron schedule and delivers the
+// result by email or webhook
+type ReportSchedule struct {
+	ReportId    string              `json:"report_id"`
+	AccountId   uint64              `json:"account_id"`
+	DashboardId string              `json:"dashboard_id"`
+	Name        string              `json:"name"`
+	Cron        string              `json:"cron"`       // e.g., "0 8 * * mon", "@daily"
+	Timezone    string              `json:"timezone"`   // IANA name, defaults to UTC
+	TimeRange   string              `json:"time_range"` // window ending at the run time, e.g., "7d"
+	Interval    string              `json:"interval"`   // bucket size, e.g., "1h"
+	Variables   map[string][]string `json:"variables"`  // selected values by variable name
+	Format      string              `json:"format"`     // pdf, png, svg
+	Delivery    ReportDelivery      `json:"delivery"`
+	Paused      bool                `json:"paused"`
+	CreatedBy   string              `json:"created_by"`
+	CreatedAt   time.Time           `json:"created_at"`
+	UpdatedAt   time.Time           `json:"updated_at"`
+	Status      *ReportStatus       `json:"status,omitempty"`
+}
+
+// ReportDelivery says where a report is sent
+type ReportDelivery struct {
+	Type       string            `json:"type"`                  // email, webhook
+	Recipients []string          `json:"recipients,omitempty"`  // email addresses
+	Subject    string            `json:"subject,omitempty"`     // defaults to the report name
+	WebhookURL string            `json:"webhook_url,omitempty"` // receives a JSON payload with the rendered file
+	Headers    map[string]string `json:"headers,omitempty"`     // extra webhook request headers
+}
+
+// ReportStatus summarizes the runs of a report
+type ReportStatus struct {
+	LastRunAt        time.Time `json:"last_run_at"`
+	LastScheduledFor time.Time `json:"last_scheduled_for"`
+	LastSuccessAt    time.Time `json:"last_success_at"`
+	LastError        string    `json:"last_error"`
+	NextRunAt        time.Time `json:"next_run_at,omitempty"`
+	FailuresLastWeek uint64    `json:"failures_last_week"`
+	Health           string    `json:"health"` // ok, failing, pending
+}
+
+// ReportRun is one rendering and delivery of a report
+type ReportRun struct {
+	RunId         string    `json:"run_id"`
+	ReportId      string    `json:"report_id"`
+	AccountId     uint64    `json:"account_id"`
+	Trigger       string    `json:"trigger"`       // schedule, manual
+	ScheduledFor  time.Time `json:"scheduled_for"` // end of the rendered window
+	StartedAt     time.Time `json:"started_at"`
+	DurationMs    uint32    `json:"duration_ms"`
+	Format        string    `json:"format"`
+	DeliveryType  string    `json:"delivery_type"`
+	ArtifactBytes uint64    `json:"artifact_bytes"`
+	WidgetErrors  uint32    `json:"widget_errors"` // widgets rendered as errors
+	Success       bool      `json:"success"`
+	Error         string    `json:"error"`
+}
+
+var knownReportFormats = map[string]bool{ReportFormatPDF: true, ReportFormatPNG: true, ReportFormatSVG: true}
+
+// Validate checks the fields of a report that do not depend on the cron
+// parser and fills in defaults
+func (r *ReportSchedule) Validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if r.DashboardId == "" {
+		return fmt.Errorf("dashboard_id is required")
+	}
+	if r.Cron == "" {
+		return fmt.Errorf("cron is required")
+	}
+	if r.Timezone == "" {
+		r.Timezone = "UTC"
+	}
+	if _, err := time.LoadLocation(r.Timezone); err != nil {
+		return fmt.Errorf("unknown timezone %q", r.Timezone)
+	}
+	if r.TimeRange == "" {
+		r.TimeRange = "24h"
+	}
+	if r.Format == "" {
+		r.Format = ReportFormatPDF
+	}
+	if !knownReportFormats[r.Format] {
+		return fmt.Errorf("unknown format %q, expected pdf, png or svg", r.Format)
+	}
+
+	switch r.Delivery.Type {
+	case ReportDeliveryEmail:
+		if len(r.Delivery.Recipients) == 0 {
+			return fmt.Errorf("email delivery needs at least one recipient")
+		}
+		for _, recipient := range r.Delivery.Recipients {
+			if _, err := mail.ParseAddress(recipient); err != nil {
+				return fmt.Errorf("invalid recipient %q", recipient)
+			}
+		}
+	case ReportDeliveryWebhook:
+		u, err := url.Parse(r.Delivery.WebhookURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("webhook delivery needs an http or https webhook_url")
+		}
+	default:
+		return fmt.Errorf("delivery type must be email or webhook")
+	}
+	return nil
+}
+
+// ========== REPORT SCHEDULE CRUD OPERATIONS ==========
+
+const reportColumns = `ReportId, AccountId, DashboardId, Name, Cron, Timezone, TimeRange, Interval, Variables, Format, Delivery, Paused, CreatedBy, CreatedAt, UpdatedAt`
+
+// SaveReportSchedule creates or replaces a report schedule
+func (s *Store) SaveReportSchedule(ctx context.Context, report *ReportSchedule) error {
+	variables, err := json.Marshal(report.Variables)
+	if err != nil {
+		return fmt.Errorf("failed to encode report variables: %w", err)
+	}
+	delivery, err := json.Marshal(report.Delivery)
+	if err != nil {
+		return fmt.Errorf("failed to encode report delivery: %w", err)
+	}
+
+	if report.CreatedAt.IsZero() {
+		report.CreatedAt = time.Now()
+	}
+	report.UpdatedAt = time.Now()
+
+	query := fmt.Sprintf(`
+		INSERT INTO metrics.report_schedules
+		(%s)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, reportColumns)
+	err = s.conn.Exec(ctx, query,
+		report.ReportId,
+		report.AccountId,
+		report.DashboardId,
+		report.Name,
+		report.Cron,
+		report.Timezone,
+		report.TimeRange,
+		report.Interval,
+		string(variables),
+		report.Format,
+		string(delivery),
+		boolToUInt8(report.Paused),
+		report.CreatedBy,
+		report.CreatedAt,
+		report.UpdatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save report schedule: %w", err)
+	}
+	return nil
+}
+
+func scanReportSchedule(row rowScanner) (*ReportSchedule, error) {
+	var report ReportSchedule
+	var variables, delivery string
+	var paused uint8
+	if err := row.Scan(
+		&report.ReportId,
+		&report.AccountId,
+		&report.DashboardId,
+		&report.Name,
+		&report.Cron,
+		&report.Timezone,
+		&report.TimeRange,
+		&report.Interval,
+		&variables,
+		&report.Format,
+		&delivery,
+		&paused,
+		&report.CreatedBy,
+		&report.CreatedAt,
+		&report.UpdatedAt,
+	); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(variables), &report.Variables); err != nil {
+		return nil, fmt.Errorf("failed to decode variables of report %s: %w", report.ReportId, err)
+	}
+	if err := json.Unmarshal([]byte(delivery), &report.Delivery); err != nil {
+		return nil, fmt.Errorf("failed to decode delivery of report %s: %w", report.ReportId, err)
+	}
+	report.Paused = paused == 1
+	return &report, nil
+}
+
+// GetReportSchedule retrieves a report schedule by ID
+func (s *Store) GetReportSchedule(ctx context.Context, accountId uint64, reportId string) (*ReportSchedule, error) {
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM metrics.report_schedules FINAL
+		WHERE AccountId = ? AND ReportId = ?
+	`, reportColumns)
+
+	report, err := scanReportSchedule(s.conn.QueryRow(ctx, query, accountId, reportId))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get report schedule: %w", err)
+	}
+	return report, nil
+}
+
+// ListReportSchedules returns all report schedules of an account
+func (s *Store) ListReportSchedules(ctx context.Context, accountId uint64) ([]ReportSchedule, error) {
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM metrics.report_schedules FINAL
+		WHERE AccountId = ?
+		ORDER BY Name
+	`, reportColumns)
+
+	return s.queryReportSchedules(ctx, query, accountId)
+}
+
+// ListActiveReportSchedules returns the unpaused reports of every account
+func (s *Store) ListActiveReportSchedules(ctx context.Context) ([]ReportSchedule, error) {
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM metrics.report_schedules FINAL
+		WHERE Paused = 0
+		ORDER BY AccountId, ReportId
+	`, reportColumns)
+
+	return s.queryReportSchedules(ctx, query)
+}
+
+func (s *Store) queryReportSchedules(ctx context.Context, query string, args ...interface{}) ([]ReportSchedule, error) {
+	rows, err := s.conn.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list report schedules: %w", err)
+	}
+	defer rows.Close()
+
+	var reports []ReportSchedule
+	for rows.Next() {
+		report, err := scanReportSchedule(rows)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, *report)
+	}
+	return reports, nil
+}
+
+// DeleteReportSchedule deletes a report schedule and its run history
+func (s *Store) DeleteReportSchedule(ctx context.Context, accountId uint64, reportId string) error {
+	for _, table := range []string{"metrics.report_schedules", "metrics.report_runs"} {
+		query := fmt.Sprintf(`
+			ALTER TABLE %s
+			DELETE WHERE AccountId = ? AND ReportId = ?
+		`, table)
+		if err := s.conn.Exec(ctx, query, accountId, reportId); err != nil {
+			return fmt.Errorf("failed to delete report schedule: %w", err)
+		}
+	}
+	return nil
+}
+
+// ========== REPORT RUN HISTORY ==========
+
+// InsertReportRun stores the outcome of one report run
+func (s *Store) InsertReportRun(ctx context.Context, run ReportRun) error {
+	query := `
+		INSERT INTO metrics.report_runs
+		(RunId, ReportId, AccountId, Trigger, ScheduledFor, StartedAt, DurationMs, Format, DeliveryType, ArtifactBytes, WidgetErrors, Success, Error)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	err := s.conn.Exec(ctx, query,
+		run.RunId,
+		run.ReportId,
+		run.AccountId,
+		run.Trigger,
+		run.ScheduledFor,
+		run.StartedAt,
+		run.DurationMs,
+		run.Format,
+		run.DeliveryType,
+		run.ArtifactBytes,
+		run.WidgetErrors,
+		boolToUInt8(run.Success),
+		run.Error,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record report run: %w", err)
+	}
+	return nil
+}
+
+// GetReportRuns returns the most recent runs of a report
+func (s *Store) GetReportRuns(ctx context.Context, accountId uint64, reportId string, limit int) ([]ReportRun, error) {
+	query := `
+		SELECT RunId, ReportId, AccountId, Trigger, ScheduledFor, StartedAt, DurationMs, Format, DeliveryType, ArtifactBytes, WidgetErrors, Success, Error
+		FROM metrics.report_runs
+		WHERE AccountId = ? AND ReportId = ?
+		ORDER BY StartedAt DESC
+		LIMIT ?
+	`
+	rows, err := s.conn.Query(ctx, query, accountId, reportId, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get report runs: %w", err)
+	}
+	defer rows.Close()
+
+	results := []ReportRun{}
+	for rows.Next() {
+		var run ReportRun
+		var success uint8
+		if err := rows.Scan(&run.RunId, &run.ReportId, &run.AccountId, &run.Trigger, &run.ScheduledFor, &run.StartedAt,
+			&run.DurationMs, &run.Format, &run.DeliveryType, &run.ArtifactBytes, &run.WidgetErrors, &success, &run.Error); err != nil {
+			return nil, err
+		}
+		run.Success = success == 1
+		results = append(results, run)
+	}
+	return results, nil
+}
+
+// GetReportStatuses summarizes the runs of every report. An accountId of 0
+// returns the statuses of every account.
+func (s *Store) GetReportStatuses(ctx context.Context, accountId uint64) (map[string]ReportStatus, error) {
+	query := `
+		SELECT
+			ReportId,
+			max(StartedAt) as last_run,
+			maxIf(ScheduledFor, Trigger = 'schedule') as last_scheduled,
+			maxIf(StartedAt, Success = 1) as last_success,
+			argMax(Error, StartedAt) as last_error,
+			countIf(Success = 0 AND StartedAt > now() - INTERVAL 7 DAY) as failures
+		FROM metrics.report_runs
+		WHERE (? = 0 OR AccountId = ?)
+		GROUP BY ReportId
+	`
+	rows, err := s.conn.Query(ctx, query, accountId, accountId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get report statuses: %w", err)
+	}
+	defer rows.Close()
+
+	statuses := make(map[string]ReportStatus)
+	for rows.Next() {
+		var reportId string
+		var st ReportStatus
+		if err := rows.Scan(&reportId, &st.LastRunAt, &st.LastScheduledFor, &st.LastSuccessAt, &st.LastError, &st.FailuresLastWeek); err != nil {
+			return nil, err
+		}
+
+		st.Health = "ok"
+		if st.LastError != "" {
+			st.Health = "failing"
+		}
+		// maxIf over no rows yields the epoch
+		if st.LastScheduledFor.Unix() <= 0 {
+			st.LastScheduledFor = time.Time{}
+		}
+		if st.LastSuccessAt.Unix() <= 0 {
+			st.LastSuccessAt = time.Time{}
+		}
+		statuses[reportId] = st
+	}
+	return statuses, nil
+}
@@ -8,7 +8,7 @@ import { Plus, Save, RotateCcw, Download, Settings, List } from 'lucide-react';
 import { AdvancedChart } from '../../components/dashboard/AdvancedCharts';
 import { WidgetConfigurator } from '../../components/dashboard/WidgetConfigurator';
 import { MetricWidget } from '../../components/widgets/MetricWidget';
-import { queryMetrics, saveDashboard, listDashboards, getDashboard, type MetricQueryRequest, type MetricSeries } from '../../lib/dashboardApi';
+import { queryMetrics, saveDashboard, listDashboards, getDashboard, type DashboardWidget, type MetricQueryRequest, type MetricSeries } from '../../lib/dashboardApi';
 import 'react-grid-layout/css/styles.css';
 
 interface WidgetConfig {
@@ -50,6 +50,30 @@ const DEFAULT_WIDGET_CONFIG: WidgetConfig = {
     decimals: 2,
 };
 
+const toDashboardWidget = (widget: Widget): DashboardWidget => ({
+    widget_id: widget.i,
+    widget_type: widget.type,
+    title: widget.config.title,
+    queries: widget.config.metricName ? [{
+        metric_name: widget.config.metricName,
+        aggregation: widget.config.aggregation,
+        group_by: widget.config.groupBy,
+        filters: widget.config.filters,
+        alias: widget.config.title,
+    }] : [],
+    visualization: {
+        chart_type: widget.config.chartType,
+        colors: widget.config.colors,
+        unit: widget.config.unit,
+        decimals: widget.config.decimals,
+    },
+    layout: { x: widget.x, y: widget.y, w: widget.w, h: widget.h },
+    time_config: {
+        time_range: widget.config.timeRange,
+        refresh_interval: widget.config.refreshInterval,
+    },
+});
+
 const parseRefreshInterval = (interval: string): number => {
     const match = interval.match(/(\d+)([smh])/);
     if (!match) return 0;
@@ -212,7 +236,7 @@ export default function CustomDashboard() {
                     const dashboard = await saveDashboard({
                         name,
                         description: '',
-                        config,
+                        widgets: widgets.map(toDashboardWidget),
                     });
                     setCurrentDashboardId(dashboard.dashboard_id);
                     setCurrentDashboardName(name);
@@ -58,12 +58,40 @@ export interface MetricQueryResponse {
     series: MetricSeries[];
 }
 
+export interface VisualizationConfig {
+    chart_type: string; // line, bar, area, pie, scatter, heatmap, gauge
+    colors: string[];
+    unit: string;
+    decimals: number;
+}
+
+export interface WidgetLayout {
+    x: number;
+    y: number;
+    w: number;
+    h: number;
+}
+
+export interface DashboardWidget {
+    widget_id: string;
+    widget_type: string; // chart, metric, table
+    title: string;
+    queries: MetricQuery[];
+    visualization: VisualizationConfig;
+    layout: WidgetLayout;
+    time_config: {
+        time_range: string;
+        refresh_interval: string;
+    };
+}
+
 export interface Dashboard {
     dashboard_id: string;
     account_id: number;
     name: string;
     description: string;
-    config: string; // JSON-encoded widget layout
+    widgets: DashboardWidget[];
+    version?: number;
     created_at: string;
     updated_at: string;
 }
@@ -126,7 +154,9 @@ export async function saveDashboard(dashboard: Partial<Dashboard>): Promise<Dash
     return response.json();
 }
 
-export async function updateDashboard(dashboardId: string, dashboard: Partial<Dashboard>): Promise<Dashboard> {
+// Updates must carry the version they are based on; the server rejects
+// updates of a dashboard that changed since with 409
+export async function updateDashboard(dashboardId: string, dashboard: Partial<Dashboard> & { version: number }): Promise<Dashboard> {
     const response = await fetch(`${API_BASE}/api/dashboards/${dashboardId}`, {
         method: 'PUT',
         headers: {
@@ -32,6 +32,7 @@ func main() {
 	accountHandler := api.NewAccountHandler(database)
 	licenseHandler := api.NewLicenseHandler(database)
 	usageHandler := api.NewUsageHandler(database)
+	userHandler := api.NewUserHandler(database)
 
 	// Setup router
 	r := chi.NewRouter()
@@ -61,6 +62,18 @@ func main() {
 		r.Get("/status", licenseHandler.GetLicenseStatus)
 	})
 
+	// User access routes, used by services enforcing resource permissions.
+	// They require the service token shared with those services.
+	serviceToken := os.Getenv("SERVICE_TOKEN")
+	if serviceToken == "" {
+		log.Println("SERVICE_TOKEN not set; user access routes are disabled")
+	}
+	r.Route("/api/users", func(r chi.Router) {
+		r.Use(api.ServiceAuth(serviceToken))
+		r.Post("/authenticate", userHandler.AuthenticateUser)
+		r.Get("/{userId}/access", userHandler.GetUserAccess)
+	})
+
 	// Usage tracking routes
 	r.Route("/api/usage", func(r chi.Router) {
 		r.Post("/ingest", usageHandler.IngestUsage)
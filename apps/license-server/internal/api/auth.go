@@ -0,0 +1,26 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+)
+
+// ServiceAuth only lets through requests carrying the shared service
+// token as a bearer token. Without a token configured every request is
+// refused, so user data is never served unauthenticated.
+func ServiceAuth(token string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if token == "" {
+				http.Error(w, "Service authentication is not configured", http.StatusServiceUnavailable)
+				return
+			}
+			given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || !secureCompare(given, token) {
+				http.Error(w, "Invalid service token", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
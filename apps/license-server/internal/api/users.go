@@ -0,0 +1,148 @@
+package api
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/namlabs/obsfly/license-server/internal/db"
+	"github.com/namlabs/obsfly/license-server/internal/models"
+)
+
+type UserHandler struct {
+	db *db.Database
+}
+
+func NewUserHandler(database *db.Database) *UserHandler {
+	return &UserHandler{db: database}
+}
+
+// GetUserAccess returns a user's role, team memberships and unexpired
+// permission grants
+func (h *UserHandler) GetUserAccess(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "userId"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	access := models.UserAccess{
+		UserID:      userID,
+		Teams:       []int64{},
+		Permissions: []models.ResourcePermission{},
+	}
+
+	var accountID sql.NullInt64
+	err = h.db.QueryRow(`
+		SELECT account_id, role
+		FROM users
+		WHERE user_id = $1
+	`, userID).Scan(&accountID, &access.Role)
+	if err == sql.ErrNoRows {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get user: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if accountID.Valid {
+		access.AccountID = &accountID.Int64
+	}
+
+	teamRows, err := h.db.Query(`
+		SELECT sub_account_id
+		FROM team_members
+		WHERE user_id = $1
+		ORDER BY sub_account_id
+	`, userID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get teams: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer teamRows.Close()
+
+	for teamRows.Next() {
+		var teamID int64
+		if err := teamRows.Scan(&teamID); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to scan team: %v", err), http.StatusInternalServerError)
+			return
+		}
+		access.Teams = append(access.Teams, teamID)
+	}
+
+	permRows, err := h.db.Query(`
+		SELECT resource_type, resource_id, permission
+		FROM permissions
+		WHERE user_id = $1
+		  AND (expires_at IS NULL OR expires_at > NOW())
+		ORDER BY resource_type, resource_id
+	`, userID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get permissions: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer permRows.Close()
+
+	for permRows.Next() {
+		var perm models.ResourcePermission
+		var resourceID sql.NullInt64
+		if err := permRows.Scan(&perm.ResourceType, &resourceID, &perm.Permission); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to scan permission: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if resourceID.Valid {
+			perm.ResourceID = &resourceID.Int64
+		}
+		access.Permissions = append(access.Permissions, perm)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(access)
+}
+
+// AuthenticateUser resolves a session token or API key to the user it
+// belongs to. Only active, unexpired credentials of a user are accepted.
+func (h *UserHandler) AuthenticateUser(w http.ResponseWriter, r *http.Request) {
+	var req models.AuthenticateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	sum := sha256.Sum256([]byte(req.Token))
+	tokenHash := hex.EncodeToString(sum[:])
+
+	var userID int64
+	err := h.db.QueryRow(`
+		SELECT user_id
+		FROM sessions
+		WHERE token_hash = $1
+		  AND is_active
+		  AND expires_at > NOW()
+		UNION ALL
+		SELECT user_id
+		FROM api_keys
+		WHERE key_hash = $1
+		  AND is_active
+		  AND user_id IS NOT NULL
+		  AND (expires_at IS NULL OR expires_at > NOW())
+		LIMIT 1
+	`, tokenHash).Scan(&userID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Invalid or expired token", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to authenticate: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.AuthenticateResponse{UserID: userID})
+}
@@ -173,3 +173,30 @@ type UsageIngestRequest struct {
 	MetricCount  int64  `json:"metric_count"`
 	StorageBytes int64  `json:"storage_bytes"`
 }
+
+// UserAccess describes what a user may access, for services that enforce
+// their own resource permissions
+type UserAccess struct {
+	UserID      int64                `json:"user_id"`
+	AccountID   *int64               `json:"account_id,omitempty"`
+	Role        string               `json:"role"`  // super_admin, admin, owner, member, viewer
+	Teams       []int64              `json:"teams"` // sub_account_ids from team_members
+	Permissions []ResourcePermission `json:"permissions"`
+}
+
+// AuthenticateRequest carries a session token or API key to resolve
+type AuthenticateRequest struct {
+	Token string `json:"token"`
+}
+
+// AuthenticateResponse names the user a credential belongs to
+type AuthenticateResponse struct {
+	UserID int64 `json:"user_id"`
+}
+
+// ResourcePermission is an unexpired grant from the permissions table
+type ResourcePermission struct {
+	ResourceType string `json:"resource_type"` // account, sub_account, product, dashboard
+	ResourceID   *int64 `json:"resource_id,omitempty"`
+	Permission   string `json:"permission"` // read, write, admin, delete
+}
@@ -9,12 +9,106 @@ CREATE TABLE IF NOT EXISTS metrics.dashboards
     Description        String CODEC(ZSTD(1)),
     Config             String CODEC(ZSTD(1)),  -- JSON configuration of widgets and layout
     CreatedAt          DateTime64(3) CODEC(Delta, ZSTD(1)),
-    UpdatedAt          DateTime64(3) CODEC(Delta, ZSTD(1))
+    UpdatedAt          DateTime64(3) CODEC(Delta, ZSTD(1)),
+    Version            UInt32 DEFAULT 1,                 -- incremented on every save, one row per version
+    Author             String CODEC(ZSTD(1)),
+    ChangeMessage      String CODEC(ZSTD(1)),
+    Slug               String CODEC(ZSTD(1)),                -- stable key for dashboards-as-code import/export
+    Source             LowCardinality(String),               -- '' for app edits, 'file' for directory-synced (read-only)
+    SourcePath         String CODEC(ZSTD(1)),
+    FolderId           String CODEC(ZSTD(1)),                -- '' for the root folder
+    Tags               Array(LowCardinality(String)) CODEC(ZSTD(1)),
+    OwnerId            UInt64 CODEC(ZSTD(1)),
+    SaveId             String CODEC(ZSTD(1))                 -- random per save; tells which of two racing saves claimed a version
 )
 ENGINE = MergeTree
 PARTITION BY AccountId
 ORDER BY (AccountId, DashboardId)
+SETTINGS index_granularity = 8192, non_replicated_deduplication_window = 1000;
+
+-- Nested dashboard folders
+CREATE TABLE IF NOT EXISTS metrics.dashboard_folders
+(
+    FolderId           UUID CODEC(ZSTD(1)),
+    AccountId          UInt64 CODEC(ZSTD(1)),
+    ParentId           String CODEC(ZSTD(1)),                -- '' for top-level folders
+    Name               String CODEC(ZSTD(1)),
+    CreatedBy          UInt64 CODEC(ZSTD(1)),
+    CreatedAt          DateTime64(3) CODEC(Delta, ZSTD(1)),
+    UpdatedAt          DateTime64(3) CODEC(Delta, ZSTD(1))
+)
+ENGINE = ReplacingMergeTree(UpdatedAt)
+ORDER BY (AccountId, FolderId)
+SETTINGS index_granularity = 8192;
+
+-- Per-user starred dashboards, unstarring writes Starred = 0
+CREATE TABLE IF NOT EXISTS metrics.dashboard_stars
+(
+    AccountId          UInt64 CODEC(ZSTD(1)),
+    UserId             UInt64 CODEC(ZSTD(1)),
+    DashboardId        UUID CODEC(ZSTD(1)),
+    Starred            UInt8,
+    UpdatedAt          DateTime64(3) CODEC(Delta, ZSTD(1))
+)
+ENGINE = ReplacingMergeTree(UpdatedAt)
+ORDER BY (AccountId, UserId, DashboardId)
+SETTINGS index_granularity = 8192;
+
+-- Dashboard ACL entries for users (users.user_id) and teams
+-- (team_members.sub_account_id) in the license server. Revoking writes
+-- Permission = ''; a dashboard without entries is open to its account.
+CREATE TABLE IF NOT EXISTS metrics.dashboard_permissions
+(
+    AccountId          UInt64 CODEC(ZSTD(1)),
+    DashboardId        UUID CODEC(ZSTD(1)),
+    PrincipalType      LowCardinality(String) CODEC(ZSTD(1)),
+    PrincipalId        UInt64 CODEC(ZSTD(1)),
+    Permission         LowCardinality(String) CODEC(ZSTD(1)),
+    GrantedBy          UInt64 CODEC(ZSTD(1)),
+    UpdatedAt          DateTime64(3) CODEC(Delta, ZSTD(1))
+)
+ENGINE = ReplacingMergeTree(UpdatedAt)
+ORDER BY (AccountId, DashboardId, PrincipalType, PrincipalId)
+SETTINGS index_granularity = 8192;
+
+-- Dashboard views, backing the recently viewed list
+CREATE TABLE IF NOT EXISTS metrics.dashboard_access_log
+(
+    DashboardId        UUID,
+    UserId             UInt64,
+    AccountId          UInt64,
+    AccessedAt         DateTime64(3) DEFAULT now64(3),
+    IPAddress          IPv4,
+    UserAgent          String
+)
+ENGINE = MergeTree
+PARTITION BY toDate(AccessedAt)
+ORDER BY (DashboardId, UserId, AccessedAt)
+TTL toDateTime(AccessedAt) + INTERVAL 90 DAY
 SETTINGS index_granularity = 8192;
 
 -- Create indexes for faster queries
 -- Note: MergeTree doesn't support traditional indexes, ordering is the key
+
+-- Public dashboard snapshots: frozen widget data shared by token
+CREATE TABLE IF NOT EXISTS metrics.dashboard_snapshots
+(
+    SnapshotId         UUID CODEC(ZSTD(1)),
+    TokenHash          String CODEC(ZSTD(1)),                -- SHA-256 of the share token, the token itself is not stored
+    AccountId          UInt64 CODEC(ZSTD(1)),
+    DashboardId        UUID CODEC(ZSTD(1)),
+    DashboardVersion   UInt32,
+    Name               String CODEC(ZSTD(1)),
+    Dashboard          String CODEC(ZSTD(3)),                -- JSON dashboard definition at capture time
+    Data               String CODEC(ZSTD(3)),                -- JSON rendered widget data
+    FromTime           DateTime64(3) CODEC(Delta, ZSTD(1)),
+    ToTime             DateTime64(3) CODEC(Delta, ZSTD(1)),
+    CreatedBy          String CODEC(ZSTD(1)),
+    CreatedAt          DateTime64(3) CODEC(Delta, ZSTD(1)),
+    ExpiresAt          DateTime64(3) CODEC(Delta, ZSTD(1)),
+    INDEX idx_token_hash TokenHash TYPE bloom_filter GRANULARITY 1
+)
+ENGINE = MergeTree
+ORDER BY (AccountId, DashboardId, SnapshotId)
+TTL toDateTime(ExpiresAt) + INTERVAL 1 DAY
+SETTINGS index_granularity = 8192;
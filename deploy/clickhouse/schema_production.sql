@@ -58,7 +58,14 @@ CREATE TABLE IF NOT EXISTS logs.logs_v1 (
     BodyHash            String DEFAULT '',
 
     -- Cost / Usage
-    Bytes               UInt64 CODEC(ZSTD(3))
+    Bytes               UInt64 CODEC(ZSTD(3)),
+
+    -- Identity, assigned at ingestion
+    LogId               String DEFAULT lower(hex(cityHash64(Timestamp, ServiceName, HostName, Pod, ContainerId, Body))) CODEC(ZSTD(3)),
+
+    INDEX idx_log_id LogId TYPE bloom_filter(0.001) GRANULARITY 1,
+    -- Lets phrase searches in log queries skip granules without their words
+    INDEX idx_body Body TYPE tokenbf_v1(32768, 3, 0) GRANULARITY 1
 )
 ENGINE = MergeTree
 PARTITION BY toDate(Timestamp)
@@ -66,6 +73,149 @@ ORDER BY (AccountId, ServiceName, Timestamp)
 TTL toDateTime(Timestamp) + toIntervalDay(RetentionDays)
 SETTINGS index_granularity = 8192;
 
+-- Pattern catalog: the template mined for each PatternHash
+CREATE TABLE IF NOT EXISTS logs.log_patterns (
+    AccountId           UInt64 CODEC(ZSTD(3)),
+    ServiceName         LowCardinality(String),
+    PatternHash         String,
+    Template            String CODEC(ZSTD(3)),
+    Examples            Array(String) CODEC(ZSTD(3)),
+    FirstSeen           DateTime64(9) CODEC(Delta, ZSTD(3)),
+    UpdatedAt           DateTime64(3) CODEC(Delta, ZSTD(3))
+)
+ENGINE = ReplacingMergeTree(UpdatedAt)
+ORDER BY (AccountId, ServiceName, PatternHash);
+
+-- Hourly pattern counts per service version, for anomaly detection
+CREATE TABLE IF NOT EXISTS logs.log_pattern_stats (
+    AccountId           UInt64 CODEC(ZSTD(3)),
+    ServiceName         LowCardinality(String),
+    PatternHash         String,
+    ServiceVersion      LowCardinality(String),
+    Hour                DateTime CODEC(Delta, ZSTD(3)),
+    Severity            SimpleAggregateFunction(anyLast, String),
+    Count               SimpleAggregateFunction(sum, UInt64),
+    FirstSeen           SimpleAggregateFunction(min, DateTime64(9)),
+    LastSeen            SimpleAggregateFunction(max, DateTime64(9))
+)
+ENGINE = AggregatingMergeTree
+ORDER BY (AccountId, ServiceName, PatternHash, ServiceVersion, Hour)
+TTL Hour + INTERVAL 90 DAY;
+
+CREATE MATERIALIZED VIEW IF NOT EXISTS logs.log_pattern_stats_mv TO logs.log_pattern_stats AS
+SELECT
+    AccountId,
+    ServiceName,
+    PatternHash,
+    ServiceVersion,
+    toStartOfHour(Timestamp) AS Hour,
+    anyLast(SeverityText) AS Severity,
+    count() AS Count,
+    min(Timestamp) AS FirstSeen,
+    max(Timestamp) AS LastSeen
+FROM logs.logs_v1
+WHERE PatternHash != ''
+GROUP BY AccountId, ServiceName, PatternHash, ServiceVersion, Hour;
+
+-- New patterns and pattern frequency shifts found by the anomaly detector
+CREATE TABLE IF NOT EXISTS logs.log_pattern_anomalies (
+    AnomalyId           String,
+    AccountId           UInt64 CODEC(ZSTD(3)),
+    Kind                LowCardinality(String),  -- new_pattern, new_in_version, spike, drop
+    ServiceName         LowCardinality(String),
+    ServiceVersion      LowCardinality(String),
+    PatternHash         String,
+    Template            String CODEC(ZSTD(3)),
+    Severity            LowCardinality(String),
+    Count               UInt64,
+    Expected            Float64,
+    Score               Float64,
+    FirstSeen           DateTime64(9) CODEC(Delta, ZSTD(3)),
+    DetectedAt          DateTime64(3) CODEC(Delta, ZSTD(3)),
+    Alerted             Bool
+)
+ENGINE = ReplacingMergeTree(DetectedAt)
+ORDER BY (AccountId, AnomalyId)
+TTL toDateTime(DetectedAt) + INTERVAL 30 DAY;
+
+-- Per-account log processing pipelines, run at ingestion. Steps is JSON.
+CREATE TABLE IF NOT EXISTS logs.log_pipelines (
+    PipelineId          String,
+    AccountId           UInt64 CODEC(ZSTD(3)),
+    Name                String CODEC(ZSTD(3)),
+    Description         String CODEC(ZSTD(3)),
+    Services            Array(String) CODEC(ZSTD(3)),
+    Steps               String CODEC(ZSTD(3)),
+    Position            Int32,
+    Enabled             Bool,
+    CreatedAt           DateTime64(3) CODEC(Delta, ZSTD(3)),
+    UpdatedAt           DateTime64(3) CODEC(Delta, ZSTD(3))
+)
+ENGINE = ReplacingMergeTree(UpdatedAt)
+ORDER BY (AccountId, PipelineId);
+
+-- Per-account redaction of sensitive data at ingestion. Rules is JSON.
+CREATE TABLE IF NOT EXISTS logs.redaction_configs (
+    AccountId           UInt64 CODEC(ZSTD(3)),
+    Enabled             Bool,
+    Mode                LowCardinality(String),  -- mask, hash
+    Detectors           Array(String) CODEC(ZSTD(3)),
+    Rules               String CODEC(ZSTD(3)),
+    UpdatedAt           DateTime64(3) CODEC(Delta, ZSTD(3))
+)
+ENGINE = ReplacingMergeTree(UpdatedAt)
+ORDER BY AccountId;
+
+-- Values redacted per minute, by rule and service
+CREATE TABLE IF NOT EXISTS logs.redaction_counts (
+    AccountId           UInt64 CODEC(ZSTD(3)),
+    Minute              DateTime CODEC(Delta, ZSTD(3)),
+    ServiceName         LowCardinality(String),
+    Rule                LowCardinality(String),
+    Signal              LowCardinality(String),  -- logs, traces
+    Count               UInt64
+)
+ENGINE = SummingMergeTree(Count)
+ORDER BY (AccountId, Minute, ServiceName, Rule, Signal)
+TTL Minute + INTERVAL 90 DAY;
+
+-- Days of logs archived to object storage, per service. Kept as long as
+-- the archives, so no TTL.
+CREATE TABLE IF NOT EXISTS logs.log_archives (
+    AccountId           UInt64 CODEC(ZSTD(3)),
+    Date                Date,
+    ServiceName         LowCardinality(String),
+    Format              LowCardinality(String),  -- ndjson, parquet
+    DataKey             String CODEC(ZSTD(3)),
+    ManifestKey         String CODEC(ZSTD(3)),
+    Rows                UInt64 CODEC(ZSTD(3)),
+    Bytes               UInt64 CODEC(ZSTD(3)),
+    MinTimestamp        DateTime64(9) CODEC(Delta, ZSTD(3)),
+    MaxTimestamp        DateTime64(9) CODEC(Delta, ZSTD(3)),
+    ArchivedAt          DateTime64(3) CODEC(Delta, ZSTD(3))
+)
+ENGINE = ReplacingMergeTree(ArchivedAt)
+ORDER BY (AccountId, Date, ServiceName);
+
+-- Archived logs loaded back into temporary tables for querying
+CREATE TABLE IF NOT EXISTS logs.log_rehydrations (
+    RehydrationId       String,
+    AccountId           UInt64 CODEC(ZSTD(3)),
+    ServiceName         LowCardinality(String),
+    From                DateTime64(9) CODEC(Delta, ZSTD(3)),
+    To                  DateTime64(9) CODEC(Delta, ZSTD(3)),
+    TableName           String,
+    Status              LowCardinality(String),  -- loading, ready, failed, expired
+    Rows                UInt64 CODEC(ZSTD(3)),
+    Error               String CODEC(ZSTD(3)),
+    CreatedAt           DateTime64(3) CODEC(Delta, ZSTD(3)),
+    ExpiresAt           DateTime64(3) CODEC(Delta, ZSTD(3)),
+    UpdatedAt           DateTime64(3) CODEC(Delta, ZSTD(3))
+)
+ENGINE = ReplacingMergeTree(UpdatedAt)
+ORDER BY (AccountId, RehydrationId)
+TTL toDateTime(ExpiresAt) + INTERVAL 30 DAY;
+
 -- ============================================
 -- METRICS DATABASE
 -- ============================================